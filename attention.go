@@ -0,0 +1,391 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// MultiHeadAttention is a scaled dot-product multi-head self-attention Layer.
+// The rows passed to Forward are the positions of a single sequence which
+// attend to each other. Each of the numHeads heads attends over its own
+// size/numHeads wide slice of the query, key and value projections:
+//
+//	Attention(Q, K, V) = softmax(Q*Kᵀ/sqrt(size/numHeads)) * V
+//
+// The head outputs are concatenated and passed through an output projection.
+// The projections are dense layers with an Identity activation.
+type MultiHeadAttention struct {
+	numHeads int
+	// causal prevents positions from attending to later positions.
+	causal bool
+	query  *LayerOptimized
+	key    *LayerOptimized
+	value  *LayerOptimized
+	output *LayerOptimized
+
+	// Buffers of the last forward pass.
+	q, k, v []float64
+	probs   []float64 // Attention weights indexed by [head][position][position].
+	concat  []float64
+	// Buffers of the last backward pass.
+	dq, dk, dv []float64
+	dprobs     []float64
+	dx         []float64
+}
+
+var _ Layer = (*MultiHeadAttention)(nil)
+
+// NewMultiHeadAttention returns a self-attention layer for rows of length size
+// with randomized projection weights. size must be divisible by numHeads.
+// If causal is set each position only attends to itself and preceding positions.
+func NewMultiHeadAttention(size, numHeads int, causal bool, rng *rand.Rand) *MultiHeadAttention {
+	if size <= 0 || numHeads <= 0 || size%numHeads != 0 {
+		panic("attention size must be a positive multiple of the number of heads")
+	}
+	return &MultiHeadAttention{
+		numHeads: numHeads,
+		causal:   causal,
+		query:    NewLayerOptimized(size, size, new(Identity), rng),
+		key:      NewLayerOptimized(size, size, new(Identity), rng),
+		value:    NewLayerOptimized(size, size, new(Identity), rng),
+		output:   NewLayerOptimized(size, size, new(Identity), rng),
+	}
+}
+
+func (mha *MultiHeadAttention) Dims() (numIn, numOut int) { return mha.query.Dims() }
+
+func (mha *MultiHeadAttention) Params() []Param {
+	var params []Param
+	for _, layer := range []*LayerOptimized{mha.query, mha.key, mha.value, mha.output} {
+		params = append(params, layer.Params()...)
+	}
+	return params
+}
+
+func (mha *MultiHeadAttention) Forward(x []float64, mode Mode) []float64 {
+	size, _ := mha.Dims()
+	steps := batchSize(x, size)
+	headSize := size / mha.numHeads
+	scale := 1 / math.Sqrt(float64(headSize))
+	mha.q = mha.query.Forward(x, mode)
+	mha.k = mha.key.Forward(x, mode)
+	mha.v = mha.value.Forward(x, mode)
+	mha.probs = resize(mha.probs, mha.numHeads*steps*steps)
+	mha.concat = resize(mha.concat, steps*size)
+	for i := range mha.concat {
+		mha.concat[i] = 0
+	}
+	for h := 0; h < mha.numHeads; h++ {
+		off := h * headSize
+		for t := 0; t < steps; t++ {
+			q := mha.q[t*size+off : t*size+off+headSize]
+			probs := mha.probs[(h*steps+t)*steps : (h*steps+t+1)*steps]
+			// Scores of position t attending to each position s.
+			maxScore := math.Inf(-1)
+			for s := range probs {
+				if mha.causal && s > t {
+					probs[s] = math.Inf(-1)
+					continue
+				}
+				k := mha.k[s*size+off : s*size+off+headSize]
+				var score float64
+				for j := range q {
+					score += q[j] * k[j]
+				}
+				probs[s] = score * scale
+				maxScore = math.Max(maxScore, probs[s])
+			}
+			// Softmax of the scores.
+			var expSum float64
+			for s, score := range probs {
+				probs[s] = math.Exp(score - maxScore)
+				expSum += probs[s]
+			}
+			out := mha.concat[t*size+off : t*size+off+headSize]
+			for s := range probs {
+				probs[s] /= expSum
+				if probs[s] == 0 {
+					continue
+				}
+				v := mha.v[s*size+off : s*size+off+headSize]
+				for j := range out {
+					out[j] += probs[s] * v[j]
+				}
+			}
+		}
+	}
+	return mha.output.Forward(mha.concat, mode)
+}
+
+func (mha *MultiHeadAttention) Backward(dy []float64) []float64 {
+	size, _ := mha.Dims()
+	dconcat := mha.output.Backward(dy)
+	steps := len(dconcat) / size
+	headSize := size / mha.numHeads
+	scale := 1 / math.Sqrt(float64(headSize))
+	mha.dq = resize(mha.dq, steps*size)
+	mha.dk = resize(mha.dk, steps*size)
+	mha.dv = resize(mha.dv, steps*size)
+	for i := range mha.dq {
+		mha.dq[i] = 0
+		mha.dk[i] = 0
+		mha.dv[i] = 0
+	}
+	mha.dprobs = resize(mha.dprobs, steps)
+	for h := 0; h < mha.numHeads; h++ {
+		off := h * headSize
+		for t := 0; t < steps; t++ {
+			dout := dconcat[t*size+off : t*size+off+headSize]
+			probs := mha.probs[(h*steps+t)*steps : (h*steps+t+1)*steps]
+			// Partial derivatives with respect to the attention weights and values.
+			var dot float64
+			for s, p := range probs {
+				v := mha.v[s*size+off : s*size+off+headSize]
+				dv := mha.dv[s*size+off : s*size+off+headSize]
+				var dp float64
+				for j := range dout {
+					dp += dout[j] * v[j]
+					dv[j] += p * dout[j]
+				}
+				mha.dprobs[s] = dp
+				dot += p * dp
+			}
+			// Backpropagate through the softmax and the scaled dot product.
+			q := mha.q[t*size+off : t*size+off+headSize]
+			dq := mha.dq[t*size+off : t*size+off+headSize]
+			for s, p := range probs {
+				if p == 0 {
+					continue
+				}
+				dscore := p * (mha.dprobs[s] - dot) * scale
+				k := mha.k[s*size+off : s*size+off+headSize]
+				dk := mha.dk[s*size+off : s*size+off+headSize]
+				for j := range q {
+					dq[j] += dscore * k[j]
+					dk[j] += dscore * q[j]
+				}
+			}
+		}
+	}
+	mha.dx = append(mha.dx[:0], mha.query.Backward(mha.dq)...)
+	for _, dx := range [][]float64{mha.key.Backward(mha.dk), mha.value.Backward(mha.dv)} {
+		for i := range dx {
+			mha.dx[i] += dx[i]
+		}
+	}
+	return mha.dx
+}
+
+type attentionSetup struct {
+	NumHeads int             `json:"numHeads"`
+	Causal   bool            `json:"causal,omitempty"`
+	Query    *LayerOptimized `json:"query"`
+	Key      *LayerOptimized `json:"key"`
+	Value    *LayerOptimized `json:"value"`
+	Output   *LayerOptimized `json:"output"`
+}
+
+// MarshalJSON encodes the layer's configuration and projection layers.
+func (mha *MultiHeadAttention) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attentionSetup{
+		NumHeads: mha.numHeads,
+		Causal:   mha.causal,
+		Query:    mha.query,
+		Key:      mha.key,
+		Value:    mha.value,
+		Output:   mha.output,
+	})
+}
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (mha *MultiHeadAttention) UnmarshalJSON(b []byte) error {
+	var setup attentionSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Query == nil || setup.Key == nil || setup.Value == nil || setup.Output == nil {
+		return errors.New("missing attention projection")
+	}
+	size, _ := setup.Query.Dims()
+	for _, layer := range []*LayerOptimized{setup.Query, setup.Key, setup.Value, setup.Output} {
+		numIn, numOut := layer.Dims()
+		if numIn != size || numOut != size {
+			return errors.New("attention projection dimension mismatch")
+		}
+	}
+	if setup.NumHeads <= 0 || size%setup.NumHeads != 0 {
+		return errors.New("attention size must be a positive multiple of the number of heads")
+	}
+	*mha = MultiHeadAttention{
+		numHeads: setup.NumHeads,
+		causal:   setup.Causal,
+		query:    setup.Query,
+		key:      setup.Key,
+		value:    setup.Value,
+		output:   setup.Output,
+	}
+	return nil
+}
+
+// PositionalEncoding is a Layer which adds the sinusoidal encoding of the
+// position of each row to it so that layers such as MultiHeadAttention, which are
+// otherwise invariant to the order of the rows, can make use of it.
+// Row t is added the values
+//
+//	PE(t, 2i)   = sin(t / 10000^(2i/Size))
+//	PE(t, 2i+1) = cos(t / 10000^(2i/Size))
+type PositionalEncoding struct {
+	Size int `json:"size"`
+	out  []float64
+	dx   []float64
+}
+
+var _ Layer = (*PositionalEncoding)(nil)
+
+// NewPositionalEncoding returns a positional encoding layer for rows of length size.
+func NewPositionalEncoding(size int) *PositionalEncoding {
+	return &PositionalEncoding{Size: size}
+}
+
+// UnmarshalJSON decodes the layer's configuration.
+func (pe *PositionalEncoding) UnmarshalJSON(b []byte) error {
+	var setup struct {
+		Size int `json:"size"`
+	}
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Size <= 0 {
+		return errors.New("positional encoding size must be positive")
+	}
+	*pe = PositionalEncoding{Size: setup.Size}
+	return nil
+}
+
+func (pe *PositionalEncoding) Dims() (numIn, numOut int) { return pe.Size, pe.Size }
+
+func (pe *PositionalEncoding) Params() []Param { return nil }
+
+func (pe *PositionalEncoding) Forward(x []float64, mode Mode) []float64 {
+	steps := batchSize(x, pe.Size)
+	pe.out = append(pe.out[:0], x...)
+	for t := 0; t < steps; t++ {
+		row := pe.out[t*pe.Size : (t+1)*pe.Size]
+		for i := range row {
+			freq := math.Pow(10000, -float64(i-i%2)/float64(pe.Size))
+			if i%2 == 0 {
+				row[i] += math.Sin(float64(t) * freq)
+			} else {
+				row[i] += math.Cos(float64(t) * freq)
+			}
+		}
+	}
+	return pe.out
+}
+
+func (pe *PositionalEncoding) Backward(dy []float64) []float64 {
+	pe.dx = append(pe.dx[:0], dy...)
+	return pe.dx
+}
+
+// TransformerEncoder is a transformer encoder block Layer. Its output is
+//
+//	h = LayerNorm(x + MultiHeadAttention(x))
+//	y = LayerNorm(h + FeedForward(h))
+//
+// where the feed forward network is made up of two dense layers, the first with an
+// activation function and the second with a linear output. Like MultiHeadAttention
+// the rows passed to Forward are the positions of a single sequence.
+type TransformerEncoder struct {
+	Attention    *MultiHeadAttention `json:"attention"`
+	Norm1        *LayerNorm          `json:"norm1"`
+	FeedForward1 *LayerOptimized     `json:"feedForward1"`
+	FeedForward2 *LayerOptimized     `json:"feedForward2"`
+	Norm2        *LayerNorm          `json:"norm2"`
+	sum1         []float64
+	sum2         []float64
+	dh           []float64
+	dx           []float64
+}
+
+var _ Layer = (*TransformerEncoder)(nil)
+
+// NewTransformerEncoder returns a transformer encoder block for rows of length size
+// with numHeads attention heads and ffSize hidden nodes in the feed forward network
+// which uses the act activation function.
+func NewTransformerEncoder(size, numHeads, ffSize int, act ActivationFunc, rng *rand.Rand) *TransformerEncoder {
+	return &TransformerEncoder{
+		Attention:    NewMultiHeadAttention(size, numHeads, false, rng),
+		Norm1:        NewLayerNorm(size),
+		FeedForward1: NewLayerOptimized(size, ffSize, act, rng),
+		FeedForward2: NewLayerOptimized(ffSize, size, new(Identity), rng),
+		Norm2:        NewLayerNorm(size),
+	}
+}
+
+func (te *TransformerEncoder) Dims() (numIn, numOut int) { return te.Attention.Dims() }
+
+func (te *TransformerEncoder) layers() []Layer {
+	return []Layer{te.Attention, te.Norm1, te.FeedForward1, te.FeedForward2, te.Norm2}
+}
+
+func (te *TransformerEncoder) Params() []Param {
+	var params []Param
+	for _, layer := range te.layers() {
+		params = append(params, layer.Params()...)
+	}
+	return params
+}
+
+func (te *TransformerEncoder) Forward(x []float64, mode Mode) []float64 {
+	te.sum1 = addInto(te.sum1, x, te.Attention.Forward(x, mode))
+	h := te.Norm1.Forward(te.sum1, mode)
+	ff := te.FeedForward2.Forward(te.FeedForward1.Forward(h, mode), mode)
+	te.sum2 = addInto(te.sum2, h, ff)
+	return te.Norm2.Forward(te.sum2, mode)
+}
+
+func (te *TransformerEncoder) Backward(dy []float64) []float64 {
+	dsum2 := te.Norm2.Backward(dy)
+	// The residual connection adds the gradient through the feed forward network.
+	te.dh = addInto(te.dh, dsum2, te.FeedForward1.Backward(te.FeedForward2.Backward(dsum2)))
+	dsum1 := te.Norm1.Backward(te.dh)
+	te.dx = addInto(te.dx, dsum1, te.Attention.Backward(dsum1))
+	return te.dx
+}
+
+// UnmarshalJSON decodes a layer encoded with encoding/json.
+func (te *TransformerEncoder) UnmarshalJSON(b []byte) error {
+	type encoder TransformerEncoder // Prevent recursion.
+	var setup encoder
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Attention == nil || setup.Norm1 == nil || setup.FeedForward1 == nil || setup.FeedForward2 == nil || setup.Norm2 == nil {
+		return errors.New("missing transformer encoder layer")
+	}
+	decoded := TransformerEncoder(setup)
+	err = checkSequentialDims(decoded.layers())
+	if err != nil {
+		return err
+	}
+	*te = decoded
+	return nil
+}
+
+// addInto stores the element-wise sum of a and b in dst, reusing its capacity.
+func addInto(dst, a, b []float64) []float64 {
+	if len(a) != len(b) {
+		panic("length mismatch")
+	}
+	dst = resize(dst, len(a))
+	for i := range dst {
+		dst[i] = a[i] + b[i]
+	}
+	return dst
+}
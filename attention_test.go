@@ -0,0 +1,58 @@
+package neurus_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func TestTransformerEncoder_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(3, 4, new(neurus.Identity), rng),
+		neurus.NewPositionalEncoding(4),
+		neurus.NewTransformerEncoder(4, 2, 6, new(neurus.Tanh), rng),
+		neurus.NewMultiHeadAttention(4, 2, true, rng),
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+	)
+	model.SetMode(neurus.ModeTrain)
+	checkGradients(t, model, sequenceSteps(randomSequence(rng, 5, 3, 2)))
+}
+
+func TestMultiHeadAttention_causal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mha := neurus.NewMultiHeadAttention(4, 2, true, rng)
+	x := make([]float64, 5*4)
+	for i := range x {
+		x[i] = rng.Float64()
+	}
+	want := append([]float64{}, mha.Forward(x, neurus.ModeInference)...)
+	// Changing the last position must not change the outputs of the previous ones.
+	for i := 4 * 4; i < len(x); i++ {
+		x[i] += 1
+	}
+	got := mha.Forward(x, neurus.ModeInference)
+	for i := 0; i < 4*4; i++ {
+		if got[i] != want[i] {
+			t.Fatalf("output %d changed from %g to %g after modifying a later position", i, want[i], got[i])
+		}
+	}
+	if got[len(got)-1] == want[len(want)-1] {
+		t.Error("last position output did not change")
+	}
+}
+
+func TestTransformerEncoder_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(3, 4, new(neurus.Identity), rng),
+		neurus.NewPositionalEncoding(4),
+		neurus.NewTransformerEncoder(4, 2, 6, new(neurus.Tanh), rng),
+		neurus.NewMultiHeadAttention(4, 1, true, rng),
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+	)
+	data := randomSequence(rng, 5, 3, 2)
+	model.LearnSequences([]neurus.SequenceDataPoint{data}, 0, 0.5, 0, 0)
+	roundTrip(t, model, data.Inputs...)
+}
@@ -0,0 +1,298 @@
+// Package autodiff implements reverse-mode automatic differentiation over
+// tensors of float64s.
+//
+// Operations on tensors are recorded on the Tape the tensors were created on.
+// Calling Backward on a scalar result replays the tape in reverse and accumulates
+// the partial derivatives of the result with respect to every tensor that took
+// part in computing it in their Grad field. New layers and cost functions are
+// therefore written only in terms of their forward computation:
+//
+//	var tape autodiff.Tape
+//	w := tape.Variable(weights)
+//	b := tape.Variable(biases)
+//	x := tape.Variable(input)
+//	y := autodiff.Linear(w, b, x).Sigmoid()
+//	loss := y.Sub(tape.Variable(expected)).Square().Sum()
+//	loss.Backward() // w.Grad and b.Grad now hold the gradient of loss.
+//
+// Binary operations on tensors of different lengths broadcast a tensor of
+// length 1 over the other. A scalar is a tensor of length 1.
+package autodiff
+
+import "math"
+
+// Tape records the operations on its tensors so that gradients can be computed
+// by Backward. The zero value is ready to use. A Tape must not be used concurrently.
+type Tape struct {
+	// backward holds the function which propagates the gradient of
+	// each recorded operation's output to its inputs, in recording order.
+	backward []func()
+	// results holds the output tensor of each recorded operation.
+	results []*Tensor
+}
+
+// Reset forgets all recorded operations so that the tape may be reused.
+// Tensors created before the call must not be used afterwards.
+func (t *Tape) Reset() {
+	for i := range t.backward {
+		t.backward[i] = nil // Release the closures' tensors.
+		t.results[i] = nil
+	}
+	t.backward = t.backward[:0]
+	t.results = t.results[:0]
+}
+
+// Len returns the number of operations recorded on the tape.
+func (t *Tape) Len() int { return len(t.backward) }
+
+// Variable returns a tensor holding value on the tape. The tensor shares memory
+// with value so that parameters may be updated in place between tapes.
+func (t *Tape) Variable(value []float64) *Tensor {
+	return &Tensor{Value: value, Grad: make([]float64, len(value)), tape: t, op: len(t.backward)}
+}
+
+// Scalar returns a tensor of length 1 holding v on the tape.
+func (t *Tape) Scalar(v float64) *Tensor {
+	return t.Variable([]float64{v})
+}
+
+// Tensor is a vector of values recorded on a Tape along with the partial
+// derivatives of the last Backward call's result with respect to each value.
+type Tensor struct {
+	Value []float64
+	Grad  []float64
+	tape  *Tape
+	// op is the number of operations recorded on the tape when the tensor was created.
+	op int
+}
+
+// Len returns the number of values of the tensor.
+func (x *Tensor) Len() int { return len(x.Value) }
+
+// Item returns the value of a tensor of length 1.
+func (x *Tensor) Item() float64 {
+	if len(x.Value) != 1 {
+		panic("autodiff: Item of non-scalar tensor")
+	}
+	return x.Value[0]
+}
+
+// Backward computes the partial derivatives of the scalar x with respect to every
+// tensor x depends on and adds them to the tensors' Grad. The gradients of
+// the results of operations recorded up to x are zeroed first, so Backward may be
+// called more than once on a tape. The gradients of variables are not zeroed and
+// accumulate over calls, as when summing the gradients of several results.
+func (x *Tensor) Backward() {
+	if len(x.Value) != 1 {
+		panic("autodiff: Backward of non-scalar tensor")
+	}
+	for _, result := range x.tape.results[:x.op] {
+		for i := range result.Grad {
+			result.Grad[i] = 0
+		}
+	}
+	x.Grad[0] = 1
+	// Operations recorded after x was created do not contribute to x.
+	for i := x.op - 1; i >= 0; i-- {
+		x.tape.backward[i]()
+	}
+}
+
+// result returns a new tensor of length n on the tape of x which is the output of
+// an operation whose gradient is propagated by backward.
+func (x *Tensor) result(n int, backward func(out *Tensor)) *Tensor {
+	t := x.tape
+	out := &Tensor{Value: make([]float64, n), Grad: make([]float64, n), tape: t}
+	t.backward = append(t.backward, func() { backward(out) })
+	t.results = append(t.results, out)
+	out.op = len(t.backward)
+	return out
+}
+
+// broadcast returns the length of the result of a binary operation on x and y.
+func broadcast(x, y *Tensor) int {
+	if x.tape != y.tape {
+		panic("autodiff: tensors recorded on different tapes")
+	}
+	switch {
+	case len(x.Value) == len(y.Value), len(y.Value) == 1:
+		return len(x.Value)
+	case len(x.Value) == 1:
+		return len(y.Value)
+	}
+	panic("autodiff: tensor length mismatch")
+}
+
+// binary returns the result of an elementwise operation on x and y. f returns the
+// output for a pair of values and backward propagates the output gradient g of a
+// pair of values a and b whose output is c, returning the derivative of each input.
+func binary(x, y *Tensor, f func(a, b float64) float64, backward func(g, a, b, c float64) (da, db float64)) *Tensor {
+	n := broadcast(x, y)
+	nx, ny := len(x.Value), len(y.Value)
+	out := x.result(n, func(out *Tensor) {
+		for i, g := range out.Grad {
+			da, db := backward(g, x.Value[i%nx], y.Value[i%ny], out.Value[i])
+			x.Grad[i%nx] += da
+			y.Grad[i%ny] += db
+		}
+	})
+	for i := range out.Value {
+		out.Value[i] = f(x.Value[i%nx], y.Value[i%ny])
+	}
+	return out
+}
+
+// unary returns the result of an elementwise operation on x. backward returns the
+// derivative of the input a with output c given the output gradient g.
+func (x *Tensor) unary(f func(a float64) float64, backward func(g, a, c float64) float64) *Tensor {
+	out := x.result(len(x.Value), func(out *Tensor) {
+		for i, g := range out.Grad {
+			x.Grad[i] += backward(g, x.Value[i], out.Value[i])
+		}
+	})
+	for i, a := range x.Value {
+		out.Value[i] = f(a)
+	}
+	return out
+}
+
+// Add returns x + y.
+func (x *Tensor) Add(y *Tensor) *Tensor {
+	return binary(x, y, func(a, b float64) float64 { return a + b },
+		func(g, a, b, c float64) (float64, float64) { return g, g })
+}
+
+// Sub returns x - y.
+func (x *Tensor) Sub(y *Tensor) *Tensor {
+	return binary(x, y, func(a, b float64) float64 { return a - b },
+		func(g, a, b, c float64) (float64, float64) { return g, -g })
+}
+
+// Mul returns the elementwise product of x and y.
+func (x *Tensor) Mul(y *Tensor) *Tensor {
+	return binary(x, y, func(a, b float64) float64 { return a * b },
+		func(g, a, b, c float64) (float64, float64) { return g * b, g * a })
+}
+
+// Div returns the elementwise quotient of x and y.
+func (x *Tensor) Div(y *Tensor) *Tensor {
+	return binary(x, y, func(a, b float64) float64 { return a / b },
+		func(g, a, b, c float64) (float64, float64) { return g / b, -g * c / b })
+}
+
+// Scale returns c*x.
+func (x *Tensor) Scale(c float64) *Tensor {
+	return x.unary(func(a float64) float64 { return c * a },
+		func(g, a, out float64) float64 { return g * c })
+}
+
+// Neg returns -x.
+func (x *Tensor) Neg() *Tensor { return x.Scale(-1) }
+
+// Square returns the elementwise square of x.
+func (x *Tensor) Square() *Tensor {
+	return x.unary(func(a float64) float64 { return a * a },
+		func(g, a, out float64) float64 { return g * 2 * a })
+}
+
+// Exp returns the elementwise exponential of x.
+func (x *Tensor) Exp() *Tensor {
+	return x.unary(math.Exp, func(g, a, out float64) float64 { return g * out })
+}
+
+// Log returns the elementwise natural logarithm of x.
+func (x *Tensor) Log() *Tensor {
+	return x.unary(math.Log, func(g, a, out float64) float64 { return g / a })
+}
+
+// Sigmoid returns the elementwise logistic function 1/(1+exp(-x)) of x.
+func (x *Tensor) Sigmoid() *Tensor {
+	return x.unary(func(a float64) float64 { return 1.0 / (1 + math.Exp(-a)) },
+		func(g, a, s float64) float64 { return g * (s * (1 - s)) })
+}
+
+// Tanh returns the elementwise hyperbolic tangent of x.
+func (x *Tensor) Tanh() *Tensor {
+	return x.unary(math.Tanh, func(g, a, th float64) float64 { return g * (1 - th*th) })
+}
+
+// ReLU returns the elementwise maximum of x and 0.
+func (x *Tensor) ReLU() *Tensor {
+	return x.unary(func(a float64) float64 { return math.Max(0, a) },
+		func(g, a, out float64) float64 {
+			if a > 0 {
+				return g
+			}
+			return 0
+		})
+}
+
+// Sum returns the sum of the values of x as a scalar.
+func (x *Tensor) Sum() *Tensor {
+	out := x.result(1, func(out *Tensor) {
+		g := out.Grad[0]
+		for i := range x.Grad {
+			x.Grad[i] += g
+		}
+	})
+	for _, a := range x.Value {
+		out.Value[0] += a
+	}
+	return out
+}
+
+// Mean returns the mean of the values of x as a scalar.
+func (x *Tensor) Mean() *Tensor {
+	return x.Sum().Scale(1 / float64(len(x.Value)))
+}
+
+// Dot returns the dot product of x and y as a scalar.
+func (x *Tensor) Dot(y *Tensor) *Tensor {
+	if x.tape != y.tape || len(x.Value) != len(y.Value) {
+		panic("autodiff: Dot of mismatched tensors")
+	}
+	return x.Mul(y).Sum()
+}
+
+// Softmax returns exp(x) normalized to sum to 1. x is shifted by its maximum
+// before exponentiation, which does not change the result, to avoid overflow.
+func (x *Tensor) Softmax() *Tensor {
+	maxValue := math.Inf(-1)
+	for _, a := range x.Value {
+		maxValue = math.Max(maxValue, a)
+	}
+	e := x.Sub(x.tape.Scalar(maxValue)).Exp()
+	return e.Div(e.Sum())
+}
+
+// Linear returns the affine transformation b + w·x where w is a row-major matrix
+// with a row of len(x.Value) weights for each of the len(b.Value) outputs.
+// Each output is the bias plus the weighted inputs in order.
+func Linear(w, b, x *Tensor) *Tensor {
+	numIn, numOut := len(x.Value), len(b.Value)
+	if w.tape != x.tape || b.tape != x.tape {
+		panic("autodiff: tensors recorded on different tapes")
+	}
+	if len(w.Value) != numIn*numOut {
+		panic("autodiff: weight matrix size mismatches input and output length")
+	}
+	out := x.result(numOut, func(out *Tensor) {
+		for o, g := range out.Grad {
+			b.Grad[o] += g
+			row := o * numIn
+			for i := 0; i < numIn; i++ {
+				w.Grad[row+i] += x.Value[i] * g
+				x.Grad[i] += w.Value[row+i] * g
+			}
+		}
+	})
+	for o := range out.Value {
+		weightedInput := b.Value[o]
+		row := w.Value[o*numIn : (o+1)*numIn]
+		for i, input := range x.Value {
+			weightedInput += input * row[i]
+		}
+		out.Value[o] = weightedInput
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package autodiff_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus/autodiff"
+)
+
+// checkGradient compares the gradients of f's result with respect to each of
+// the inputs against central finite differences.
+func checkGradient(t *testing.T, name string, f func(tape *autodiff.Tape, inputs []*autodiff.Tensor) *autodiff.Tensor, inputs ...[]float64) {
+	t.Helper()
+	record := func() (*autodiff.Tensor, []*autodiff.Tensor) {
+		var tape autodiff.Tape
+		vars := make([]*autodiff.Tensor, len(inputs))
+		for i := range inputs {
+			vars[i] = tape.Variable(inputs[i])
+		}
+		return f(&tape, vars), vars
+	}
+	y, vars := record()
+	y.Backward()
+	const h = 1e-6
+	for k, input := range inputs {
+		for i := range input {
+			orig := input[i]
+			input[i] = orig + h
+			plus, _ := record()
+			input[i] = orig - h
+			minus, _ := record()
+			input[i] = orig
+			numeric := (plus.Item() - minus.Item()) / (2 * h)
+			analytic := vars[k].Grad[i]
+			if math.Abs(numeric-analytic) > 1e-6*math.Max(1, math.Abs(numeric)) {
+				t.Errorf("%s: input %d[%d]: analytic %g, numeric %g", name, k, i, analytic, numeric)
+			}
+		}
+	}
+}
+
+func randomValues(rng *rand.Rand, n int, min float64) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = min + rng.Float64()
+	}
+	return v
+}
+
+func TestTensor_gradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	type inputs = []*autodiff.Tensor
+	for _, test := range []struct {
+		name string
+		f    func(*autodiff.Tape, inputs) *autodiff.Tensor
+		lens []int
+	}{
+		{"add", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Add(x[1]).Square().Sum() }, []int{4, 4}},
+		{"sub", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Sub(x[1]).Square().Sum() }, []int{4, 4}},
+		{"mul", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Mul(x[1]).Sum() }, []int{4, 4}},
+		{"div", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Div(x[1]).Sum() }, []int{4, 4}},
+		{"broadcast", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Mul(x[1]).Sub(x[1]).Square().Sum() }, []int{4, 1}},
+		{"broadcastLeft", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[1].Div(x[0]).Sum() }, []int{4, 1}},
+		{"scale", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Scale(3).Neg().Square().Sum() }, []int{3}},
+		{"exp", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Exp().Sum() }, []int{3}},
+		{"log", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Log().Sum() }, []int{3}},
+		{"sigmoid", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Sigmoid().Square().Sum() }, []int{3}},
+		{"tanh", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Tanh().Square().Sum() }, []int{3}},
+		{"relu", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Sub(x[1]).ReLU().Square().Sum() }, []int{5, 1}},
+		{"mean", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Square().Mean() }, []int{5}},
+		{"dot", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Dot(x[1]).Square() }, []int{5, 5}},
+		{"softmax", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor { return x[0].Softmax().Mul(x[1]).Sum() }, []int{4, 4}},
+		{"linear", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor {
+			return autodiff.Linear(x[0], x[1], x[2]).Tanh().Sum()
+		}, []int{6, 2, 3}},
+		{"reuse", func(_ *autodiff.Tape, x inputs) *autodiff.Tensor {
+			// x is used by more than one operation so its gradients add up.
+			y := x[0].Mul(x[0])
+			return y.Add(x[0]).Mul(y).Sum()
+		}, []int{3}},
+		{"crossentropy", func(tape *autodiff.Tape, x inputs) *autodiff.Tensor {
+			// A loss defined only by its forward computation.
+			p := x[0].Sigmoid()
+			one := tape.Scalar(1)
+			return x[1].Mul(p.Log()).Add(one.Sub(x[1]).Mul(one.Sub(p).Log())).Sum().Neg()
+		}, []int{4, 4}},
+	} {
+		inputs := make([][]float64, len(test.lens))
+		for i, n := range test.lens {
+			// Positive values keep Log and Div well defined.
+			inputs[i] = randomValues(rng, n, 0.5)
+		}
+		checkGradient(t, test.name, test.f, inputs...)
+	}
+}
+
+func TestTensor_values(t *testing.T) {
+	var tape autodiff.Tape
+	x := tape.Variable([]float64{1, 2, 3})
+	if got := x.Add(tape.Scalar(1)).Mul(x).Sum().Item(); got != 2+6+12 {
+		t.Errorf("sum((x+1)*x) = %g, want 20", got)
+	}
+	var sum float64
+	for _, v := range x.Softmax().Value {
+		sum += v
+	}
+	if math.Abs(sum-1) > 1e-15 {
+		t.Errorf("softmax sums to %g", sum)
+	}
+	w := tape.Variable([]float64{1, 2, 3, 4, 5, 6})
+	b := tape.Variable([]float64{-1, 1})
+	got := autodiff.Linear(w, b, x).Value
+	if got[0] != 13 || got[1] != 33 {
+		t.Errorf("Linear = %v, want [13 33]", got)
+	}
+}
+
+func TestTensor_Backward(t *testing.T) {
+	var tape autodiff.Tape
+	x := tape.Variable([]float64{2})
+	y := x.Square()
+	// Operations recorded after y do not contribute to its gradient.
+	x.Scale(100).Sum()
+	y.Backward()
+	if x.Grad[0] != 4 {
+		t.Errorf("d(x²)/dx = %g, want 4", x.Grad[0])
+	}
+	tape.Reset()
+	if tape.Len() != 0 {
+		t.Error("tape not empty after Reset")
+	}
+}
+
+// TestTensor_BackwardTwice checks a second Backward call adds the same
+// gradient to variables instead of also propagating the gradients left in
+// intermediate results by the first call.
+func TestTensor_BackwardTwice(t *testing.T) {
+	var tape autodiff.Tape
+	x := tape.Variable([]float64{1, 2})
+	y := x.Square().Sigmoid().Sum()
+	y.Backward()
+	first := append([]float64{}, x.Grad...)
+	y.Backward()
+	for i := range first {
+		if x.Grad[i] != 2*first[i] {
+			t.Errorf("gradient %d after second Backward is %g, want %g", i, x.Grad[i], 2*first[i])
+		}
+	}
+}
+
+func TestTensor_lengthMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	var tape autodiff.Tape
+	tape.Variable([]float64{1, 2}).Add(tape.Variable([]float64{1, 2, 3}))
+}
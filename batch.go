@@ -0,0 +1,141 @@
+package neurus
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// predictBlockSize is the number of rows passed through a network at once by
+// batch prediction. Layers process a whole block before moving on to the next
+// layer so their weights are reused while in cache.
+const predictBlockSize = 32
+
+// numPredictWorkers returns the number of goroutines used to predict n rows.
+func numPredictWorkers(n int) int {
+	numBlocks := (n + predictBlockSize - 1) / predictBlockSize
+	workers := runtime.GOMAXPROCS(0)
+	if numBlocks < workers {
+		workers = numBlocks
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// parallelBlocks splits the rows [0, n) into blocks of predictBlockSize rows and
+// calls work for each block from numWorkers goroutines. Each goroutine passes its
+// index in [0, numWorkers) to work so that it may use its own buffers.
+func parallelBlocks(n, numWorkers int, work func(worker, start, end int)) {
+	parallelRanges(n, predictBlockSize, numWorkers, work)
+}
+
+// parallelRanges is like parallelBlocks for blocks of blockSize indices.
+func parallelRanges(n, blockSize, numWorkers int, work func(worker, start, end int)) {
+	if numWorkers <= 1 {
+		for start := 0; start < n; start += blockSize {
+			end := start + blockSize
+			if end > n {
+				end = n
+			}
+			work(0, start, end)
+		}
+		return
+	}
+	// The calling goroutine is the first worker.
+	r := &ranges{n: n, blockSize: blockSize, work: work}
+	r.wg.Add(numWorkers - 1)
+	for w := 1; w < numWorkers; w++ {
+		go r.run(w)
+	}
+	r.worker(0)
+	r.wg.Wait()
+}
+
+// ranges holds the state shared by the goroutines of parallelRanges.
+type ranges struct {
+	next         int64
+	n, blockSize int
+	work         func(worker, start, end int)
+	wg           sync.WaitGroup
+}
+
+func (r *ranges) run(w int) {
+	defer r.wg.Done()
+	r.worker(w)
+}
+
+// worker calls work for the next block until all blocks are taken.
+func (r *ranges) worker(w int) {
+	for {
+		start := int(atomic.AddInt64(&r.next, int64(r.blockSize))) - r.blockSize
+		if start >= r.n {
+			return
+		}
+		end := start + r.blockSize
+		if end > r.n {
+			end = r.n
+		}
+		r.work(w, start, end)
+	}
+}
+
+// checkBatch panics if the rows of inputs and outputs do not have the
+// lengths numIn and numOut or if their number of rows differ.
+func checkBatch[T any](outputs, inputs [][]T, numIn, numOut int) {
+	if len(outputs) != len(inputs) {
+		panic("number of output rows mismatches number of input rows")
+	}
+	for s := range inputs {
+		if len(inputs[s]) != numIn {
+			panic("length of input row mismatches network input length")
+		}
+		if len(outputs[s]) != numOut {
+			panic("length of output row mismatches network output length")
+		}
+	}
+}
+
+// checkMatrix panics if inputs is not a row-major matrix of rows of length numIn
+// or if outputs can't hold a row of length numOut for each row of inputs.
+// It returns the number of rows.
+func checkMatrix[T any](outputs, inputs []T, numIn, numOut int) int {
+	n := batchSize(inputs, numIn)
+	if len(outputs) != n*numOut {
+		panic("length of outputs mismatches number of input rows")
+	}
+	return n
+}
+
+// levelLayer is a layer of the NetworkLvl networks.
+type levelLayer interface {
+	Dims() (numIn, numOut int)
+	// storeOutputs stores the activations of the layer for inputs in activations.
+	storeOutputs(inputs, activations []float64)
+}
+
+// predictLevel passes the rows of a batch through the layers of a NetworkLvl
+// network in parallel. input and output return the input and output row s.
+func predictLevel[L levelLayer](layers []L, n int, input, output func(s int) []float64) {
+	numWorkers := numPredictWorkers(n)
+	// Each worker stores the activations of every layer in its own buffers.
+	buffers := make([][][]float64, numWorkers)
+	for w := range buffers {
+		buffers[w] = make([][]float64, len(layers))
+		for i, layer := range layers {
+			_, numOut := layer.Dims()
+			buffers[w][i] = make([]float64, numOut)
+		}
+	}
+	parallelBlocks(n, numWorkers, func(w, start, end int) {
+		for s := start; s < end; s++ {
+			x := input(s)
+			for i, layer := range layers {
+				layer.storeOutputs(x, buffers[w][i])
+				x = buffers[w][i]
+			}
+			copy(output(s), x)
+		}
+	})
+}
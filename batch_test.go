@@ -0,0 +1,194 @@
+package neurus_test
+
+import (
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/soypat/neurus"
+	"github.com/soypat/neurus/mnist"
+)
+
+type batchPredictor interface {
+	Dims() (numIn, numOut int)
+	PredictBatch(outputs, inputs [][]float64)
+	PredictMatrix(outputs, inputs []float64)
+}
+
+func TestPredictBatch(t *testing.T) {
+	const numRows = 101 // Not a multiple of the block size.
+	rng := rand.New(rand.NewSource(1))
+	sigmoid := func() neurus.ActivationFunc { return new(neurus.Sigmd) }
+	optimized := neurus.NewNetworkOptimized([]int{5, 8, 6, 3}, sigmoid, &neurus.MeanSquaredError{}, rand.NewSource(1))
+	optimized.SetNormalization(0, neurus.NewBatchNorm(8))
+	optimized.SetNormalization(1, neurus.NewLayerNorm(6))
+	optimized.Learn(randomDataPoints(rng, 10, 5, 3), 0.1, 0, 0.9)
+	unregistered := neurus.NewNetworkOptimized([]int{5, 4, 3},
+		func() neurus.ActivationFunc { return new(unregisteredActivation) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	lvl0 := neurus.NewNetworkLvl0(neurus.Sigmoid, 5, 4, 3)
+	lvl1 := neurus.NewNetworkLvl1(neurus.Sigmoid, 5, 4, 3)
+	lvl2 := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 5, 4, 3)
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(5, 4, new(neurus.Tanh), rng),
+		neurus.NewLayerNorm(4),
+		neurus.NewLayerOptimized(4, 3, new(neurus.Sigmd), rng),
+	)
+	graph := newTestGraph(rng)
+
+	for _, test := range []struct {
+		name    string
+		model   batchPredictor
+		predict func(input []float64) []float64
+	}{
+		{name: "optimized", model: optimized, predict: optimized.StoreOutputs},
+		{name: "unregistered", model: unregistered, predict: unregistered.StoreOutputs},
+		{name: "lvl0", model: lvl0, predict: lvl0.CalculateOutputs},
+		{name: "lvl1", model: lvl1, predict: lvl1.CalculateOutputs},
+		{name: "lvl2", model: lvl2, predict: lvl2.CalculateOutputs},
+		{name: "sequential", model: seq, predict: seq.StoreOutputs},
+		{name: "graph", model: graph, predict: graph.StoreOutputs},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			numIn, numOut := test.model.Dims()
+			data := randomDataPoints(rng, numRows, numIn, numOut)
+			inputs := make([][]float64, numRows)
+			matrix := make([]float64, 0, numRows*numIn)
+			want := make([][]float64, numRows)
+			for s := range data {
+				inputs[s] = data[s].Input
+				matrix = append(matrix, data[s].Input...)
+				want[s] = append([]float64{}, test.predict(data[s].Input)...)
+			}
+			outputs := make([][]float64, numRows)
+			for s := range outputs {
+				outputs[s] = make([]float64, numOut)
+			}
+			test.model.PredictBatch(outputs, inputs)
+			flat := make([]float64, numRows*numOut)
+			test.model.PredictMatrix(flat, matrix)
+			for s := range want {
+				for j := range want[s] {
+					if outputs[s][j] != want[s][j] {
+						t.Fatalf("PredictBatch row %d: got %v, want %v", s, outputs[s], want[s])
+					}
+					if flat[s*numOut+j] != want[s][j] {
+						t.Fatalf("PredictMatrix row %d: got %v, want %v", s, flat[s*numOut:(s+1)*numOut], want[s])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPredictBatch_float32(t *testing.T) {
+	_, test, _ := mnist.Load()
+	test = test[:100]
+	nn := neurus.NewNetworkOptimizedOf[float32]([]int{mnist.PixelCount, 16, 10},
+		func() neurus.ActivationFuncOf[float32] { return new(neurus.SigmdOf[float32]) },
+		&neurus.MeanSquaredErrorOf[float32]{}, rand.NewSource(1))
+	inputs := make([][]float32, len(test))
+	outputs := make([][]float32, len(test))
+	for i := range test {
+		inputs[i] = test[i].Data[:]
+		outputs[i] = make([]float32, 10)
+	}
+	nn.PredictBatch(outputs, inputs)
+	for i := range inputs {
+		_, want := nn.Classify(inputs[i])
+		for j := range want {
+			if outputs[i][j] != want[j] {
+				t.Fatalf("row %d: got %v, want %v", i, outputs[i], want)
+			}
+		}
+	}
+}
+
+// allocsPerRun is like testing.AllocsPerRun with GOMAXPROCS set to procs
+// instead of 1 so that the allocations of parallel code paths are counted.
+func allocsPerRun(procs, runs int, f func()) float64 {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	f() // Warm up.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < runs; i++ {
+		f()
+	}
+	runtime.ReadMemStats(&after)
+	return float64(after.Mallocs-before.Mallocs) / float64(runs)
+}
+
+// TestNetworkOptimized_predictAllocs checks batch prediction reuses its
+// predictors and that they are replaced when the layers change.
+func TestNetworkOptimized_predictAllocs(t *testing.T) {
+	const (
+		numRows = 200
+		procs   = 4
+	)
+	rng := rand.New(rand.NewSource(1))
+	nn := neurus.NewNetworkOptimized([]int{5, 8, 3},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	data := randomDataPoints(rng, numRows, 5, 3)
+	inputs := make([][]float64, numRows)
+	outputs := make([][]float64, numRows)
+	matrix := make([]float64, 0, numRows*5)
+	for s, dp := range data {
+		inputs[s] = dp.Input
+		outputs[s] = make([]float64, 3)
+		matrix = append(matrix, dp.Input...)
+	}
+	flat := make([]float64, numRows*3)
+	for _, procs := range []int{1, procs} {
+		// Only the goroutines of each call may allocate, a few times each.
+		maxAllocs := float64(4*procs + 4)
+		if procs == 1 {
+			maxAllocs = 0
+		}
+		if allocs := allocsPerRun(procs, 100, func() { nn.PredictMatrix(flat, matrix) }); allocs > maxAllocs {
+			t.Errorf("PredictMatrix with GOMAXPROCS=%d allocated %v times per call", procs, allocs)
+		}
+		if allocs := allocsPerRun(procs, 100, func() { nn.PredictBatch(outputs, inputs) }); allocs > maxAllocs {
+			t.Errorf("PredictBatch with GOMAXPROCS=%d allocated %v times per call", procs, allocs)
+		}
+	}
+	nn.SetNormalization(0, neurus.NewLayerNorm(8))
+	nn.PredictMatrix(flat, matrix)
+	for s, input := range inputs {
+		if want := nn.StoreOutputs(input); !equalFloats(flat[s*3:(s+1)*3], want) {
+			t.Fatalf("row %d: got %v after SetNormalization, want %v", s, flat[s*3:(s+1)*3], want)
+		}
+	}
+}
+
+func BenchmarkNetworkOptimized_PredictMatrix(b *testing.B) {
+	_, test, _ := mnist.Load64()
+	inputs := make([]float64, 0, len(test)*mnist.PixelCount)
+	for i := range test {
+		inputs = append(inputs, test[i].Data[:]...)
+	}
+	outputs := make([]float64, len(test)*10)
+	nn := neurus.NewNetworkOptimized([]int{mnist.PixelCount, 32, 10},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Score the whole MNIST test set in a single call.
+		nn.PredictMatrix(outputs, inputs)
+	}
+}
+
+func BenchmarkNetworkOptimized_classifyLoop(b *testing.B) {
+	_, test, _ := mnist.Load64()
+	nn := neurus.NewNetworkOptimized([]int{mnist.PixelCount, 32, 10},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range test {
+			nn.Classify(test[j].Data[:])
+		}
+	}
+}
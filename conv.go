@@ -0,0 +1,507 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// Shape describes the dimensions of an image-like row of data. Rows are stored
+// channel first so that the value at channel c, row y and column x is found at
+//
+//	row[(c*Height+y)*Width+x]
+//
+// A single channel 28x28 MNIST image is therefore Shape{Channels: 1, Height: 28, Width: 28}.
+type Shape struct {
+	Channels int `json:"channels"`
+	Height   int `json:"height"`
+	Width    int `json:"width"`
+}
+
+// Len returns the length of a row with shape s.
+func (s Shape) Len() int { return s.Channels * s.Height * s.Width }
+
+func (s Shape) idx(c, y, x int) int { return (c*s.Height+y)*s.Width + x }
+
+func (s Shape) valid() bool { return s.Channels > 0 && s.Height > 0 && s.Width > 0 }
+
+// checkWindow returns an error if a kernelSize x kernelSize window sliding with
+// the given stride over inputs of shape in with zero padding has no valid position.
+func checkWindow(in Shape, kernelSize, stride, padding int) error {
+	switch {
+	case !in.valid():
+		return errors.New("invalid input shape")
+	case kernelSize <= 0 || stride <= 0 || padding < 0:
+		return errors.New("kernel size and stride must be positive and padding not negative")
+	case kernelSize > in.Height+2*padding || kernelSize > in.Width+2*padding:
+		return errors.New("kernel larger than padded input")
+	}
+	return nil
+}
+
+// convOutputSize returns the output length along a dimension of a sliding
+// window operation.
+func convOutputSize(inputSize, kernelSize, stride, padding int) int {
+	if kernelSize <= 0 || stride <= 0 || padding < 0 {
+		panic("kernel size and stride must be positive and padding not negative")
+	}
+	n := inputSize + 2*padding - kernelSize
+	if n < 0 {
+		panic("kernel larger than padded input")
+	}
+	return n/stride + 1
+}
+
+// Conv2D is a 2D convolution Layer followed by an activation function.
+// Each output channel is the result of sliding a KernelSize x KernelSize kernel
+// over all input channels with the given stride over the zero padded input.
+type Conv2D struct {
+	in          Shape
+	out         Shape
+	kernelSize  int
+	stride      int
+	padding     int
+	act         ActivationFunc
+	weights     []float64 // Indexed by [outChannel][inChannel][ky][kx].
+	biases      []float64 // One per output channel.
+	gradW       []float64
+	gradB       []float64
+	x           []float64 // Inputs of last forward batch.
+	outputs     []float64
+	derivatives []float64 // Activation derivatives of last forward batch.
+	dx          []float64
+}
+
+var _ Layer = (*Conv2D)(nil)
+
+// NewConv2D returns a convolution layer with randomized weights for inputs of shape in.
+// The output has outChannels channels and a height and width of
+//
+//	(in.Height + 2*padding - kernelSize)/stride + 1
+//	(in.Width + 2*padding - kernelSize)/stride + 1
+func NewConv2D(in Shape, outChannels, kernelSize, stride, padding int, act ActivationFunc, rng *rand.Rand) *Conv2D {
+	if !in.valid() || outChannels <= 0 {
+		panic("invalid convolution shape")
+	}
+	conv := &Conv2D{
+		in: in,
+		out: Shape{
+			Channels: outChannels,
+			Height:   convOutputSize(in.Height, kernelSize, stride, padding),
+			Width:    convOutputSize(in.Width, kernelSize, stride, padding),
+		},
+		kernelSize: kernelSize,
+		stride:     stride,
+		padding:    padding,
+		act:        act,
+	}
+	fanIn := in.Channels * kernelSize * kernelSize
+	invSqrtFanIn := 1 / math.Sqrt(float64(fanIn))
+	conv.weights = randomSlice[float64](outChannels*fanIn, 2*invSqrtFanIn, -invSqrtFanIn, rng)
+	conv.biases = make([]float64, outChannels)
+	conv.gradW = make([]float64, len(conv.weights))
+	conv.gradB = make([]float64, outChannels)
+	return conv
+}
+
+// InputShape returns the shape of the layer's input rows.
+func (conv *Conv2D) InputShape() Shape { return conv.in }
+
+// OutputShape returns the shape of the layer's output rows.
+func (conv *Conv2D) OutputShape() Shape { return conv.out }
+
+func (conv *Conv2D) Dims() (numIn, numOut int) { return conv.in.Len(), conv.out.Len() }
+
+func (conv *Conv2D) Params() []Param {
+	return []Param{
+		{Value: conv.weights, Grad: conv.gradW, Decay: true},
+		{Value: conv.biases, Grad: conv.gradB},
+	}
+}
+
+func (conv *Conv2D) weightIdx(outC, inC, ky, kx int) int {
+	k := conv.kernelSize
+	return ((outC*conv.in.Channels+inC)*k+ky)*k + kx
+}
+
+func (conv *Conv2D) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := conv.Dims()
+	n := batchSize(x, numIn)
+	conv.x = append(conv.x[:0], x...)
+	conv.outputs = resize(conv.outputs, n*numOut)
+	conv.derivatives = resize(conv.derivatives, n*numOut)
+	for s := 0; s < n; s++ {
+		input := x[s*numIn : (s+1)*numIn]
+		output := conv.outputs[s*numOut : (s+1)*numOut]
+		for oc := 0; oc < conv.out.Channels; oc++ {
+			for oy := 0; oy < conv.out.Height; oy++ {
+				for ox := 0; ox < conv.out.Width; ox++ {
+					weightedInput := conv.biases[oc]
+					for ky := 0; ky < conv.kernelSize; ky++ {
+						iy := oy*conv.stride + ky - conv.padding
+						if iy < 0 || iy >= conv.in.Height {
+							continue // Zero padding.
+						}
+						for kx := 0; kx < conv.kernelSize; kx++ {
+							ix := ox*conv.stride + kx - conv.padding
+							if ix < 0 || ix >= conv.in.Width {
+								continue
+							}
+							for ic := 0; ic < conv.in.Channels; ic++ {
+								weightedInput += input[conv.in.idx(ic, iy, ix)] * conv.weights[conv.weightIdx(oc, ic, ky, kx)]
+							}
+						}
+					}
+					output[conv.out.idx(oc, oy, ox)] = weightedInput
+				}
+			}
+		}
+		// Apply activation function over the whole output row.
+		derivatives := conv.derivatives[s*numOut : (s+1)*numOut]
+		conv.act.CalculateFromInputs(output, 1)
+		for i := range output {
+			output[i] = conv.act.Activate(i)
+			derivatives[i] = conv.act.Derivative(i)
+		}
+	}
+	return conv.outputs
+}
+
+func (conv *Conv2D) Backward(dy []float64) []float64 {
+	numIn, numOut := conv.Dims()
+	if len(dy) != len(conv.outputs) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	n := len(dy) / numOut
+	conv.dx = resize(conv.dx, n*numIn)
+	for i := range conv.dx {
+		conv.dx[i] = 0
+	}
+	for s := 0; s < n; s++ {
+		input := conv.x[s*numIn : (s+1)*numIn]
+		dx := conv.dx[s*numIn : (s+1)*numIn]
+		for oc := 0; oc < conv.out.Channels; oc++ {
+			for oy := 0; oy < conv.out.Height; oy++ {
+				for ox := 0; ox < conv.out.Width; ox++ {
+					i := s*numOut + conv.out.idx(oc, oy, ox)
+					// Node value: partial derivative of the cost with respect to the weighted input.
+					nodeValue := dy[i] * conv.derivatives[i]
+					if nodeValue == 0 {
+						continue
+					}
+					conv.gradB[oc] += nodeValue
+					for ky := 0; ky < conv.kernelSize; ky++ {
+						iy := oy*conv.stride + ky - conv.padding
+						if iy < 0 || iy >= conv.in.Height {
+							continue
+						}
+						for kx := 0; kx < conv.kernelSize; kx++ {
+							ix := ox*conv.stride + kx - conv.padding
+							if ix < 0 || ix >= conv.in.Width {
+								continue
+							}
+							for ic := 0; ic < conv.in.Channels; ic++ {
+								wi := conv.weightIdx(oc, ic, ky, kx)
+								xi := conv.in.idx(ic, iy, ix)
+								conv.gradW[wi] += input[xi] * nodeValue
+								dx[xi] += conv.weights[wi] * nodeValue
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return conv.dx
+}
+
+type conv2DSetup struct {
+	Input       Shape            `json:"input"`
+	OutChannels int              `json:"outChannels"`
+	KernelSize  int              `json:"kernelSize"`
+	Stride      int              `json:"stride"`
+	Padding     int              `json:"padding"`
+	Weights     []float64        `json:"weights"`
+	Biases      []float64        `json:"biases"`
+	Activation  *ActivationSetup `json:"activation"`
+}
+
+// MarshalJSON encodes the layer's configuration and parameters. The activation
+// function must be registered with RegisterActivation.
+func (conv *Conv2D) MarshalJSON() ([]byte, error) {
+	act, err := marshalActivation(conv.act)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(conv2DSetup{
+		Input:       conv.in,
+		OutChannels: conv.out.Channels,
+		KernelSize:  conv.kernelSize,
+		Stride:      conv.stride,
+		Padding:     conv.padding,
+		Weights:     conv.weights,
+		Biases:      conv.biases,
+		Activation:  act,
+	})
+}
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (conv *Conv2D) UnmarshalJSON(b []byte) error {
+	var setup conv2DSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.OutChannels <= 0 {
+		return errors.New("convolution output channels must be positive")
+	}
+	if err := checkWindow(setup.Input, setup.KernelSize, setup.Stride, setup.Padding); err != nil {
+		return err
+	}
+	if setup.Activation == nil {
+		return errors.New("missing convolution activation")
+	}
+	act, err := unmarshalActivation[float64](*setup.Activation)
+	if err != nil {
+		return err
+	}
+	*conv = *NewConv2D(setup.Input, setup.OutChannels, setup.KernelSize, setup.Stride, setup.Padding, act, rand.New(rand.NewSource(1)))
+	if len(setup.Weights) != len(conv.weights) || len(setup.Biases) != len(conv.biases) {
+		return errors.New("convolution parameter length mismatch")
+	}
+	copy(conv.weights, setup.Weights)
+	copy(conv.biases, setup.Biases)
+	return nil
+}
+
+// pool2D holds the configuration shared by the pooling layers. Pooling
+// is applied to each channel independently.
+type pool2D struct {
+	Input  Shape `json:"input"`
+	Size   int   `json:"size"`
+	Stride int   `json:"stride"`
+	dx     []float64
+	out    []float64
+}
+
+func newPool2D(in Shape, size, stride int) pool2D {
+	p := pool2D{Input: in, Size: size, Stride: stride}
+	if !in.valid() {
+		panic("invalid pooling shape")
+	}
+	p.OutputShape() // Validate window.
+	return p
+}
+
+// OutputShape returns the shape of the layer's output rows.
+func (p *pool2D) OutputShape() Shape {
+	return Shape{
+		Channels: p.Input.Channels,
+		Height:   convOutputSize(p.Input.Height, p.Size, p.Stride, 0),
+		Width:    convOutputSize(p.Input.Width, p.Size, p.Stride, 0),
+	}
+}
+
+func (p *pool2D) Dims() (numIn, numOut int) { return p.Input.Len(), p.OutputShape().Len() }
+
+func (p *pool2D) Params() []Param { return nil }
+
+// unmarshalPool2D decodes the configuration of a pooling layer.
+func unmarshalPool2D(b []byte) (pool2D, error) {
+	var p pool2D
+	err := json.Unmarshal(b, &p)
+	if err != nil {
+		return pool2D{}, err
+	}
+	if err := checkWindow(p.Input, p.Size, p.Stride, 0); err != nil {
+		return pool2D{}, err
+	}
+	return p, nil
+}
+
+// forEachWindow calls fn with the input and output index of each
+// input element of every pooling window for a batch of n rows.
+func (p *pool2D) forEachWindow(n int, fn func(inIdx, outIdx int)) {
+	numIn, numOut := p.Dims()
+	out := p.OutputShape()
+	for s := 0; s < n; s++ {
+		for c := 0; c < out.Channels; c++ {
+			for oy := 0; oy < out.Height; oy++ {
+				for ox := 0; ox < out.Width; ox++ {
+					o := s*numOut + out.idx(c, oy, ox)
+					for ky := 0; ky < p.Size; ky++ {
+						for kx := 0; kx < p.Size; kx++ {
+							fn(s*numIn+p.Input.idx(c, oy*p.Stride+ky, ox*p.Stride+kx), o)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// MaxPool2D is a Layer which outputs the maximum value of each
+// Size x Size window of every input channel.
+type MaxPool2D struct {
+	pool2D
+	// argmax holds the input index of the maximum of each output of the last forward batch.
+	argmax []int
+}
+
+var _ Layer = (*MaxPool2D)(nil)
+
+// NewMaxPool2D returns a max pooling layer for inputs of shape in.
+func NewMaxPool2D(in Shape, size, stride int) *MaxPool2D {
+	return &MaxPool2D{pool2D: newPool2D(in, size, stride)}
+}
+
+// UnmarshalJSON decodes the layer's configuration.
+func (mp *MaxPool2D) UnmarshalJSON(b []byte) error {
+	p, err := unmarshalPool2D(b)
+	if err != nil {
+		return err
+	}
+	*mp = MaxPool2D{pool2D: p}
+	return nil
+}
+
+func (mp *MaxPool2D) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := mp.Dims()
+	n := batchSize(x, numIn)
+	mp.out = resize(mp.out, n*numOut)
+	if cap(mp.argmax) < n*numOut {
+		mp.argmax = make([]int, n*numOut)
+	}
+	mp.argmax = mp.argmax[:n*numOut]
+	for i := range mp.out {
+		mp.out[i] = math.Inf(-1)
+	}
+	mp.forEachWindow(n, func(inIdx, outIdx int) {
+		if x[inIdx] > mp.out[outIdx] {
+			mp.out[outIdx] = x[inIdx]
+			mp.argmax[outIdx] = inIdx
+		}
+	})
+	return mp.out
+}
+
+func (mp *MaxPool2D) Backward(dy []float64) []float64 {
+	numIn, numOut := mp.Dims()
+	if len(dy) != len(mp.out) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	mp.dx = resize(mp.dx, len(dy)/numOut*numIn)
+	for i := range mp.dx {
+		mp.dx[i] = 0
+	}
+	// Only the maximum input of each window affects the output.
+	for o, d := range dy {
+		mp.dx[mp.argmax[o]] += d
+	}
+	return mp.dx
+}
+
+// AvgPool2D is a Layer which outputs the mean value of each
+// Size x Size window of every input channel.
+type AvgPool2D struct {
+	pool2D
+}
+
+var _ Layer = (*AvgPool2D)(nil)
+
+// NewAvgPool2D returns an average pooling layer for inputs of shape in.
+func NewAvgPool2D(in Shape, size, stride int) *AvgPool2D {
+	return &AvgPool2D{pool2D: newPool2D(in, size, stride)}
+}
+
+// UnmarshalJSON decodes the layer's configuration.
+func (ap *AvgPool2D) UnmarshalJSON(b []byte) error {
+	p, err := unmarshalPool2D(b)
+	if err != nil {
+		return err
+	}
+	*ap = AvgPool2D{pool2D: p}
+	return nil
+}
+
+func (ap *AvgPool2D) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := ap.Dims()
+	n := batchSize(x, numIn)
+	ap.out = resize(ap.out, n*numOut)
+	for i := range ap.out {
+		ap.out[i] = 0
+	}
+	scale := 1 / float64(ap.Size*ap.Size)
+	ap.forEachWindow(n, func(inIdx, outIdx int) {
+		ap.out[outIdx] += x[inIdx] * scale
+	})
+	return ap.out
+}
+
+func (ap *AvgPool2D) Backward(dy []float64) []float64 {
+	numIn, numOut := ap.Dims()
+	if len(dy) != len(ap.out) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	n := len(dy) / numOut
+	ap.dx = resize(ap.dx, n*numIn)
+	for i := range ap.dx {
+		ap.dx[i] = 0
+	}
+	scale := 1 / float64(ap.Size*ap.Size)
+	ap.forEachWindow(n, func(inIdx, outIdx int) {
+		ap.dx[inIdx] += dy[outIdx] * scale
+	})
+	return ap.dx
+}
+
+// Flatten is a Layer which discards the shape of image-like rows so they may be
+// fed into fully connected layers. Since rows are always stored flat Flatten
+// does not modify the data and is used to document the model's structure.
+type Flatten struct {
+	Input Shape `json:"input"`
+	out   []float64
+	dx    []float64
+}
+
+var _ Layer = (*Flatten)(nil)
+
+// NewFlatten returns a flatten layer for inputs of shape in.
+func NewFlatten(in Shape) *Flatten {
+	if !in.valid() {
+		panic("invalid flatten shape")
+	}
+	return &Flatten{Input: in}
+}
+
+// UnmarshalJSON decodes the layer's configuration.
+func (f *Flatten) UnmarshalJSON(b []byte) error {
+	var setup struct {
+		Input Shape `json:"input"`
+	}
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if !setup.Input.valid() {
+		return errors.New("invalid flatten shape")
+	}
+	*f = Flatten{Input: setup.Input}
+	return nil
+}
+
+func (f *Flatten) Dims() (numIn, numOut int) { return f.Input.Len(), f.Input.Len() }
+
+func (f *Flatten) Params() []Param { return nil }
+
+func (f *Flatten) Forward(x []float64, mode Mode) []float64 {
+	batchSize(x, f.Input.Len())
+	f.out = append(f.out[:0], x...)
+	return f.out
+}
+
+func (f *Flatten) Backward(dy []float64) []float64 {
+	f.dx = append(f.dx[:0], dy...)
+	return f.dx
+}
@@ -0,0 +1,104 @@
+package neurus_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func TestConv2D_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	in := neurus.Shape{Channels: 2, Height: 8, Width: 7}
+	conv := neurus.NewConv2D(in, 3, 3, 1, 1, new(neurus.Sigmd), rng)
+	maxPool := neurus.NewMaxPool2D(conv.OutputShape(), 2, 2)
+	conv2 := neurus.NewConv2D(maxPool.OutputShape(), 2, 2, 1, 0, new(neurus.Sigmd), rng)
+	avgPool := neurus.NewAvgPool2D(conv2.OutputShape(), 2, 1)
+	flatten := neurus.NewFlatten(avgPool.OutputShape())
+	_, numFlat := flatten.Dims()
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		conv, maxPool, conv2, avgPool, flatten,
+		neurus.NewLayerOptimized(numFlat, 2, new(neurus.Sigmd), rng),
+	)
+	data := make([]neurus.DataPoint, 3)
+	for i := range data {
+		input := make([]float64, in.Len())
+		for j := range input {
+			input[j] = rng.Float64()
+		}
+		data[i] = neurus.DataPoint{Input: input, ExpectedOutput: []float64{rng.Float64(), rng.Float64()}}
+	}
+	checkGradients(t, seq, data)
+}
+
+func TestConv2D_shapes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mnistShape := neurus.Shape{Channels: 1, Height: 28, Width: 28}
+	for _, test := range []struct {
+		kernel, stride, padding int
+		want                    neurus.Shape
+	}{
+		{kernel: 3, stride: 1, padding: 0, want: neurus.Shape{Channels: 4, Height: 26, Width: 26}},
+		{kernel: 3, stride: 1, padding: 1, want: neurus.Shape{Channels: 4, Height: 28, Width: 28}},
+		{kernel: 5, stride: 2, padding: 0, want: neurus.Shape{Channels: 4, Height: 12, Width: 12}},
+		{kernel: 4, stride: 3, padding: 2, want: neurus.Shape{Channels: 4, Height: 10, Width: 10}},
+	} {
+		conv := neurus.NewConv2D(mnistShape, 4, test.kernel, test.stride, test.padding, new(neurus.Sigmd), rng)
+		got := conv.OutputShape()
+		if got != test.want {
+			t.Errorf("kernel=%d stride=%d padding=%d: got shape %+v, want %+v", test.kernel, test.stride, test.padding, got, test.want)
+		}
+		out := conv.Forward(make([]float64, 2*mnistShape.Len()), neurus.ModeInference)
+		if len(out) != 2*got.Len() {
+			t.Errorf("got output length %d, want %d", len(out), 2*got.Len())
+		}
+	}
+}
+
+func TestMaxPool2D(t *testing.T) {
+	pool := neurus.NewMaxPool2D(neurus.Shape{Channels: 1, Height: 4, Width: 4}, 2, 2)
+	x := []float64{
+		1, 2, 5, 0,
+		3, 4, 1, 1,
+		0, 0, 7, 8,
+		9, 0, 6, 1,
+	}
+	got := pool.Forward(x, neurus.ModeInference)
+	want := []float64{4, 5, 9, 8}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	dx := pool.Backward([]float64{1, 2, 3, 4})
+	wantDx := []float64{
+		0, 0, 2, 0,
+		0, 1, 0, 0,
+		0, 0, 0, 4,
+		3, 0, 0, 0,
+	}
+	for i := range wantDx {
+		if dx[i] != wantDx[i] {
+			t.Fatalf("got input gradient %v, want %v", dx, wantDx)
+		}
+	}
+}
+
+func TestConv2D_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	in := neurus.Shape{Channels: 1, Height: 6, Width: 6}
+	conv := neurus.NewConv2D(in, 2, 3, 1, 1, &neurus.Relu{}, rng)
+	pool := neurus.NewAvgPool2D(conv.OutputShape(), 2, 2)
+	maxPool := neurus.NewMaxPool2D(pool.OutputShape(), 2, 1)
+	flatten := neurus.NewFlatten(maxPool.OutputShape())
+	_, numFlat := flatten.Dims()
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		conv, pool, maxPool, flatten,
+		neurus.NewLayerOptimized(numFlat, 2, new(neurus.Sigmd), rng),
+	)
+	input := make([]float64, in.Len())
+	for i := range input {
+		input[i] = rng.Float64()
+	}
+	roundTrip(t, seq, input)
+}
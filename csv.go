@@ -0,0 +1,283 @@
+package neurus
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingValue is wrapped by the CSVError returned for empty fields.
+var ErrMissingValue = errors.New("missing value")
+
+// CSVError is an error in a field of a delimited file.
+type CSVError struct {
+	Line   int // Line of the field, starting at 1.
+	Column int // Column of the field, starting at 0.
+	Err    error
+}
+
+func (e *CSVError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *CSVError) Unwrap() error { return e.Err }
+
+// CSVLoader reads data points from comma, tab or otherwise delimited files.
+// The zero value reads a headerless CSV file whose last column holds class labels.
+//
+// For classification the label column is one-hot encoded using Vocabulary. When
+// Vocabulary is nil Load sets it to the sorted labels found in the file, so the
+// loader used for the training set should be reused to load the test set, and
+// its Vocabulary saved with the model to decode predicted classes.
+type CSVLoader struct {
+	// Comma is the field delimiter. It is ',' if zero. Use '\t' for TSV files.
+	Comma rune
+	// Comment, if not zero, starts lines which are ignored.
+	Comment rune
+	// Header indicates the first line names the columns. Columns may then be
+	// selected by name with FeatureNames and LabelNames.
+	Header bool
+	// Features are the indices of the input columns. If nil all columns
+	// other than the label columns are inputs.
+	Features []int
+	// Labels are the indices of the target columns. If nil the last column
+	// is the target. A column can't be both a feature and a label.
+	Labels []int
+	// FeatureNames and LabelNames select columns by header name in place of
+	// Features and Labels.
+	FeatureNames, LabelNames []string
+	// Regression loads the label columns as numeric expected outputs.
+	// Otherwise there must be a single label column holding class names.
+	Regression bool
+	// Vocabulary holds the class names. Class i is encoded as a one-hot
+	// expected output with a 1 at index i.
+	Vocabulary []string `json:",omitempty"`
+	// Columns holds the column names read from the header by Load.
+	Columns []string `json:"-"`
+}
+
+// Load reads all records of r into data points. Empty fields and fields that
+// are not numbers are reported as a *CSVError with the line of the field, as are
+// class labels missing from a Vocabulary that was set before calling Load.
+func (l *CSVLoader) Load(r io.Reader) (Dataset, error) {
+	c := csv.NewReader(r)
+	if l.Comma != 0 {
+		c.Comma = l.Comma
+	}
+	c.Comment = l.Comment
+	if l.Comma == '\t' {
+		c.LazyQuotes = true // TSV files do not quote fields.
+	}
+	c.ReuseRecord = true
+
+	var features, labels []int
+	fixedVocabulary := l.Vocabulary != nil
+	var classes map[string]int
+	if fixedVocabulary {
+		classes = classIndices(l.Vocabulary)
+	}
+	var classNames []string // Class names of data points until the vocabulary is known.
+	var data Dataset
+	for {
+		record, err := c.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if features == nil {
+			// First record determines the columns. The csv.Reader checks
+			// subsequent records have the same number of fields.
+			if l.Header {
+				l.Columns = append(l.Columns[:0], record...)
+			}
+			features, labels, err = l.columns(len(record))
+			if err != nil {
+				return nil, err
+			}
+			if l.Header {
+				continue
+			}
+		}
+		dp := DataPoint{Input: make([]float64, len(features))}
+		for i, col := range features {
+			dp.Input[i], err = parseField(c, record, col)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if l.Regression {
+			dp.ExpectedOutput = make([]float64, len(labels))
+			for i, col := range labels {
+				dp.ExpectedOutput[i], err = parseField(c, record, col)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			name := strings.TrimSpace(record[labels[0]])
+			if name == "" {
+				return nil, fieldError(c, labels[0], ErrMissingValue)
+			}
+			if fixedVocabulary {
+				class, ok := classes[name]
+				if !ok {
+					return nil, fieldError(c, labels[0], fmt.Errorf("class %q not in vocabulary", name))
+				}
+				dp.ExpectedOutput = make([]float64, len(l.Vocabulary))
+				dp.ExpectedOutput[class] = 1
+			} else {
+				classNames = append(classNames, name)
+			}
+		}
+		data = append(data, dp)
+	}
+	if l.Regression || fixedVocabulary {
+		return data, nil
+	}
+	l.Vocabulary = vocabulary(classNames)
+	classes = classIndices(l.Vocabulary)
+	for i, name := range classNames {
+		data[i].ExpectedOutput = make([]float64, len(l.Vocabulary))
+		data[i].ExpectedOutput[classes[name]] = 1
+	}
+	return data, nil
+}
+
+// columns returns the indices of the feature and label columns of records with
+// numFields fields.
+func (l *CSVLoader) columns(numFields int) (features, labels []int, err error) {
+	features, labels = l.Features, l.Labels
+	if l.FeatureNames != nil || l.LabelNames != nil {
+		if !l.Header {
+			return nil, nil, errors.New("column names require a header")
+		}
+		if l.FeatureNames != nil {
+			features, err = l.columnIndices(l.FeatureNames)
+		}
+		if err == nil && l.LabelNames != nil {
+			labels, err = l.columnIndices(l.LabelNames)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if labels == nil {
+		labels = []int{numFields - 1}
+	}
+	if features == nil {
+		features = []int{}
+		for col := 0; col < numFields; col++ {
+			if indexOf(labels, col) < 0 {
+				features = append(features, col)
+			}
+		}
+	}
+	switch {
+	case len(labels) == 0:
+		return nil, nil, errors.New("no label columns")
+	case !l.Regression && len(labels) != 1:
+		return nil, nil, errors.New("classification requires a single label column")
+	}
+	for _, col := range append(append([]int{}, features...), labels...) {
+		if col < 0 || col >= numFields {
+			return nil, nil, fmt.Errorf("column %d out of range for %d fields", col, numFields)
+		}
+	}
+	for _, col := range features {
+		if indexOf(labels, col) >= 0 {
+			return nil, nil, fmt.Errorf("column %d is both a feature and a label", col)
+		}
+	}
+	return features, labels, nil
+}
+
+// classIndices returns the class of each name of vocabulary. Repeated names
+// take the class of their first occurrence.
+func classIndices(vocabulary []string) map[string]int {
+	classes := make(map[string]int, len(vocabulary))
+	for class, name := range vocabulary {
+		if _, ok := classes[name]; !ok {
+			classes[name] = class
+		}
+	}
+	return classes
+}
+
+func (l *CSVLoader) columnIndices(names []string) ([]int, error) {
+	indices := make([]int, len(names))
+	for i, name := range names {
+		indices[i] = indexOf(l.Columns, name)
+		if indices[i] < 0 {
+			return nil, fmt.Errorf("column %q not in header", name)
+		}
+	}
+	return indices, nil
+}
+
+// parseField parses the number in column col of the last record read by c.
+func parseField(c *csv.Reader, record []string, col int) (float64, error) {
+	field := strings.TrimSpace(record[col])
+	if field == "" {
+		return 0, fieldError(c, col, ErrMissingValue)
+	}
+	v, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, fieldError(c, col, err)
+	}
+	return v, nil
+}
+
+func fieldError(c *csv.Reader, col int, err error) error {
+	line, _ := c.FieldPos(col)
+	return &CSVError{Line: line, Column: col, Err: err}
+}
+
+// vocabulary returns the distinct class names sorted numerically if all are
+// numbers, so that labels 0 to 10 encode as classes 0 to 10, or lexically otherwise.
+func vocabulary(classNames []string) []string {
+	var vocab []string
+	seen := make(map[string]bool)
+	for _, name := range classNames {
+		if !seen[name] {
+			seen[name] = true
+			vocab = append(vocab, name)
+		}
+	}
+	numeric := make([]float64, len(vocab))
+	for i, name := range vocab {
+		v, err := strconv.ParseFloat(name, 64)
+		if err != nil {
+			sort.Strings(vocab)
+			return vocab
+		}
+		numeric[i] = v
+	}
+	sort.Sort(byNumber{names: vocab, values: numeric})
+	return vocab
+}
+
+type byNumber struct {
+	names  []string
+	values []float64
+}
+
+func (b byNumber) Len() int           { return len(b.names) }
+func (b byNumber) Less(i, j int) bool { return b.values[i] < b.values[j] }
+func (b byNumber) Swap(i, j int) {
+	b.names[i], b.names[j] = b.names[j], b.names[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
+
+func indexOf[T comparable](s []T, v T) int {
+	for i := range s {
+		if s[i] == v {
+			return i
+		}
+	}
+	return -1
+}
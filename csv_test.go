@@ -0,0 +1,139 @@
+package neurus_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+const irisCSV = `sepal length,sepal width,petal length,petal width,species
+5.1,3.5,1.4,0.2,setosa
+7.0,3.2,4.7,1.4,versicolor
+6.3,3.3,6.0,2.5,virginica
+4.9,3.0,1.4,0.2,setosa
+`
+
+func TestCSVLoader_classification(t *testing.T) {
+	loader := neurus.CSVLoader{Header: true, FeatureNames: []string{"petal width", "sepal length"}}
+	data, err := loader.Load(strings.NewReader(irisCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(loader.Vocabulary, ","); got != "setosa,versicolor,virginica" {
+		t.Errorf("got vocabulary %s", got)
+	}
+	want := []neurus.DataPoint{
+		{Input: []float64{0.2, 5.1}, ExpectedOutput: []float64{1, 0, 0}},
+		{Input: []float64{1.4, 7.0}, ExpectedOutput: []float64{0, 1, 0}},
+		{Input: []float64{2.5, 6.3}, ExpectedOutput: []float64{0, 0, 1}},
+		{Input: []float64{0.2, 4.9}, ExpectedOutput: []float64{1, 0, 0}},
+	}
+	if len(data) != len(want) {
+		t.Fatalf("got %d data points, want %d", len(data), len(want))
+	}
+	for i := range want {
+		if !equalFloats(data[i].Input, want[i].Input) || !equalFloats(data[i].ExpectedOutput, want[i].ExpectedOutput) {
+			t.Errorf("data point %d: got %v, want %v", i, data[i], want[i])
+		}
+	}
+
+	// Reusing the loader keeps the vocabulary so classes encode identically
+	// even if the file lacks some or lists them in another order.
+	test, err := loader.Load(strings.NewReader("sepal length,sepal width,petal length,petal width,species\n6.0,2.2,5.0,1.5,virginica\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalFloats(test[0].ExpectedOutput, []float64{0, 0, 1}) {
+		t.Errorf("got expected output %v for class virginica", test[0].ExpectedOutput)
+	}
+	_, err = loader.Load(strings.NewReader("sepal length,sepal width,petal length,petal width,species\n6.0,2.2,5.0,1.5,virginica\n5.0,2.0,3.5,1.0,setosa-x\n"))
+	var csvErr *neurus.CSVError
+	if !errors.As(err, &csvErr) || csvErr.Line != 3 || csvErr.Column != 4 {
+		t.Errorf("got error %v for unknown class on line 3", err)
+	}
+}
+
+func TestCSVLoader_numericVocabulary(t *testing.T) {
+	var loader neurus.CSVLoader
+	data, err := loader.Load(strings.NewReader("0.5,10\n0.25,2\n1,0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(loader.Vocabulary, ","); got != "0,2,10" {
+		t.Errorf("got vocabulary %s, want numeric order", got)
+	}
+	if !equalFloats(data[0].ExpectedOutput, []float64{0, 0, 1}) {
+		t.Errorf("got expected output %v for class 10", data[0].ExpectedOutput)
+	}
+}
+
+func TestCSVLoader_regressionTSV(t *testing.T) {
+	loader := neurus.CSVLoader{
+		Comma:      '\t',
+		Comment:    '#',
+		Features:   []int{2, 0},
+		Labels:     []int{3, 1},
+		Regression: true,
+	}
+	data, err := loader.Load(strings.NewReader("# x\ty\tz\tw\n1\t2\t3\t4\n-1.5\t 2e3\t0\t7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []neurus.DataPoint{
+		{Input: []float64{3, 1}, ExpectedOutput: []float64{4, 2}},
+		{Input: []float64{0, -1.5}, ExpectedOutput: []float64{7, 2000}},
+	}
+	for i := range want {
+		if !equalFloats(data[i].Input, want[i].Input) || !equalFloats(data[i].ExpectedOutput, want[i].ExpectedOutput) {
+			t.Errorf("data point %d: got %v, want %v", i, data[i], want[i])
+		}
+	}
+	if loader.Vocabulary != nil {
+		t.Errorf("regression set vocabulary %v", loader.Vocabulary)
+	}
+}
+
+func TestCSVLoader_errors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		loader     neurus.CSVLoader
+		input      string
+		line, col  int
+		missing    bool
+		fieldError bool
+	}{
+		{name: "missing feature", input: "1,2,a\n3,,b\n", line: 2, col: 1, missing: true, fieldError: true},
+		{name: "missing label", input: "1,2,a\n3,4,\n", line: 2, col: 2, missing: true, fieldError: true},
+		{name: "bad number", input: "1,2,a\n\n3,x4,b\n", line: 3, col: 1, fieldError: true},
+		{name: "missing target", loader: neurus.CSVLoader{Regression: true}, input: "1,2,3\n3,4, \n", line: 2, col: 2, missing: true, fieldError: true},
+		{name: "field count", input: "1,2,a\n3,b\n"},
+		{name: "unknown column", loader: neurus.CSVLoader{Header: true, LabelNames: []string{"label"}}, input: "x,y\n1,2\n"},
+		{name: "names without header", loader: neurus.CSVLoader{LabelNames: []string{"y"}}, input: "1,2\n"},
+		{name: "column out of range", loader: neurus.CSVLoader{Features: []int{0, 5}}, input: "1,2,a\n"},
+		{name: "multiple class columns", loader: neurus.CSVLoader{Labels: []int{1, 2}}, input: "1,2,a\n"},
+		{name: "label as feature", loader: neurus.CSVLoader{Features: []int{0, 2}}, input: "1,2,a\n"},
+		{name: "label name as feature", loader: neurus.CSVLoader{Header: true, FeatureNames: []string{"x", "y"}, LabelNames: []string{"y"}}, input: "x,y\n1,2\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.loader.Load(strings.NewReader(test.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			var csvErr *neurus.CSVError
+			if errors.As(err, &csvErr) != test.fieldError {
+				t.Fatalf("got error %v", err)
+			}
+			if !test.fieldError {
+				return
+			}
+			if csvErr.Line != test.line || csvErr.Column != test.col {
+				t.Errorf("got error %v, want line %d, column %d", err, test.line, test.col)
+			}
+			if errors.Is(err, neurus.ErrMissingValue) != test.missing {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package neurus
+
+import (
+	"math"
+	"math/rand"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Dataset is a set of data points. Since its underlying type is []DataPoint it
+// may be passed to any trainer, as may the batches of its BatchIterator.
+// Splitting functions copy data points but not their Input and ExpectedOutput
+// slices, which are shared with the original Dataset.
+type Dataset = DatasetOf[float64]
+
+// DatasetOf is a Dataset of data points of type T.
+type DatasetOf[T constraints.Float] []DataPointOf[T]
+
+// Shuffle randomly permutes the data points in place.
+func (ds DatasetOf[T]) Shuffle(rng *rand.Rand) {
+	rng.Shuffle(len(ds), func(i, j int) { ds[i], ds[j] = ds[j], ds[i] })
+}
+
+// Class returns the class of data point i, which is the index of its
+// highest expected output as for one-hot encoded labels.
+func (ds DatasetOf[T]) Class(i int) int {
+	return maxIdx(T(math.Inf(-1)), ds[i].ExpectedOutput)
+}
+
+// Split randomly splits the data points into training, validation and test sets
+// of which the validation and test sets hold the given fractions of the data.
+func (ds DatasetOf[T]) Split(validation, test float64, src rand.Source) (trainSet, validationSet, testSet DatasetOf[T]) {
+	return ds.split(validation, test, rand.New(src), false)
+}
+
+// StratifiedSplit is like Split but splits the data points of each class
+// separately so that each set holds classes in the proportions of the whole.
+func (ds DatasetOf[T]) StratifiedSplit(validation, test float64, src rand.Source) (trainSet, validationSet, testSet DatasetOf[T]) {
+	return ds.split(validation, test, rand.New(src), true)
+}
+
+func (ds DatasetOf[T]) split(validation, test float64, rng *rand.Rand, stratified bool) (trainSet, validationSet, testSet DatasetOf[T]) {
+	if validation < 0 || test < 0 || validation+test > 1 {
+		panic("invalid split fractions")
+	}
+	for _, group := range ds.groups(rng, stratified) {
+		numTest := int(math.Round(test * float64(len(group))))
+		numValidation := int(math.Round(validation * float64(len(group))))
+		if numTest+numValidation > len(group) {
+			numValidation = len(group) - numTest
+		}
+		for k, i := range group {
+			switch {
+			case k < numTest:
+				testSet = append(testSet, ds[i])
+			case k < numTest+numValidation:
+				validationSet = append(validationSet, ds[i])
+			default:
+				trainSet = append(trainSet, ds[i])
+			}
+		}
+	}
+	return trainSet, validationSet, testSet
+}
+
+// Fold is a partition of a Dataset for cross-validation.
+type Fold = FoldOf[float64]
+
+// FoldOf is a Fold of data points of type T.
+type FoldOf[T constraints.Float] struct {
+	Train, Validation DatasetOf[T]
+}
+
+// KFold randomly partitions the data points into k parts of nearly equal size and
+// returns k folds, each validating on one part and training on the others.
+func (ds DatasetOf[T]) KFold(k int, src rand.Source) []FoldOf[T] {
+	return ds.kFold(k, rand.New(src), false)
+}
+
+// StratifiedKFold is like KFold but deals out the data points of each class
+// separately so that each part holds classes in the proportions of the whole.
+func (ds DatasetOf[T]) StratifiedKFold(k int, src rand.Source) []FoldOf[T] {
+	return ds.kFold(k, rand.New(src), true)
+}
+
+func (ds DatasetOf[T]) kFold(k int, rng *rand.Rand, stratified bool) []FoldOf[T] {
+	if k < 2 || k > len(ds) {
+		panic("number of folds must be in [2, len(ds)]")
+	}
+	// Deal out data points to parts like cards, continuing from one class to
+	// the next so that part sizes differ by at most one.
+	part := make([]int, len(ds))
+	next := 0
+	for _, group := range ds.groups(rng, stratified) {
+		for _, i := range group {
+			part[i] = next
+			next = (next + 1) % k
+		}
+	}
+	folds := make([]FoldOf[T], k)
+	for i, dp := range ds {
+		for f := range folds {
+			if part[i] == f {
+				folds[f].Validation = append(folds[f].Validation, dp)
+			} else {
+				folds[f].Train = append(folds[f].Train, dp)
+			}
+		}
+	}
+	return folds
+}
+
+// groups returns the indices of the data points in random order, grouped by
+// class if stratified or in a single group otherwise.
+func (ds DatasetOf[T]) groups(rng *rand.Rand, stratified bool) [][]int {
+	perm := rng.Perm(len(ds))
+	if !stratified {
+		return [][]int{perm}
+	}
+	var groups [][]int
+	for _, i := range perm {
+		class := ds.Class(i)
+		if class < 0 {
+			panic("data point without class")
+		}
+		for class >= len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[class] = append(groups[class], i)
+	}
+	return groups
+}
+
+// BatchIterator iterates over a Dataset in mini-batches, visiting every data
+// point once per epoch. Use it like a bufio.Scanner:
+//
+//	it := ds.Batches(32, rand.NewSource(1))
+//	for epoch := 0; epoch < epochs; epoch++ {
+//		for it.Next() {
+//			trainer.Train(nn, it.Batch(), learnRate)
+//		}
+//	}
+type BatchIterator = BatchIteratorOf[float64]
+
+// BatchIteratorOf is a BatchIterator over data points of type T.
+type BatchIteratorOf[T constraints.Float] struct {
+	// DropLast skips the last batch of an epoch if it is smaller than the batch size.
+	DropLast  bool
+	data      DatasetOf[T]
+	batchSize int
+	rng       *rand.Rand
+	batch     DatasetOf[T]
+	// pos is the index of the first data point of the next batch and epoch the
+	// number of the current epoch, starting at 1.
+	pos, epoch int
+}
+
+// Batches returns an iterator over mini-batches of batchSize data points. The data
+// points are shuffled at the start of every epoch with src, or visited in order
+// if src is nil. The data set itself is not modified.
+func (ds DatasetOf[T]) Batches(batchSize int, src rand.Source) *BatchIteratorOf[T] {
+	if batchSize <= 0 {
+		panic("batch size must be positive")
+	}
+	it := &BatchIteratorOf[T]{
+		data:      append(DatasetOf[T]{}, ds...),
+		batchSize: batchSize,
+		pos:       len(ds), // Next starts the first epoch.
+	}
+	if src != nil {
+		it.rng = rand.New(src)
+	}
+	return it
+}
+
+// Next advances the iterator to the next batch, which is then available through
+// Batch. It reports false at the end of each epoch, after which Next starts the
+// next epoch.
+func (it *BatchIteratorOf[T]) Next() bool {
+	if it.batch != nil && it.pos >= len(it.data) {
+		it.batch = nil
+		return false // End of epoch.
+	}
+	if it.batch == nil {
+		it.epoch++
+		it.pos = 0
+		if it.rng != nil {
+			it.data.Shuffle(it.rng)
+		}
+	}
+	end := it.pos + it.batchSize
+	if end > len(it.data) {
+		if it.DropLast || it.pos == len(it.data) {
+			it.batch = nil
+			it.pos = len(it.data)
+			return false
+		}
+		end = len(it.data)
+	}
+	it.batch = it.data[it.pos:end]
+	it.pos = end
+	return true
+}
+
+// Batch returns the current batch. It is valid until the next call to Next.
+func (it *BatchIteratorOf[T]) Batch() DatasetOf[T] { return it.batch }
+
+// Epoch returns the number of the epoch of the current batch, starting at 1.
+func (it *BatchIteratorOf[T]) Epoch() int { return it.epoch }
+
+// NumBatches returns the number of batches in an epoch.
+func (it *BatchIteratorOf[T]) NumBatches() int {
+	if it.DropLast {
+		return len(it.data) / it.batchSize
+	}
+	return (len(it.data) + it.batchSize - 1) / it.batchSize
+}
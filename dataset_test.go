@@ -0,0 +1,208 @@
+package neurus_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// labeledDataset returns n data points of numClasses one-hot classes in which
+// class c holds about c+1 times as many points as class 0. The input of each
+// point is its index.
+func labeledDataset(n, numClasses int) neurus.Dataset {
+	ds := make(neurus.Dataset, n)
+	weightSum := numClasses * (numClasses + 1) / 2
+	for i := range ds {
+		class, bound := 0, 0
+		for c := 0; c < numClasses; c++ {
+			bound += c + 1
+			if i*weightSum < bound*n {
+				class = c
+				break
+			}
+		}
+		expected := make([]float64, numClasses)
+		expected[class] = 1
+		ds[i] = neurus.DataPoint{Input: []float64{float64(i)}, ExpectedOutput: expected}
+	}
+	return ds
+}
+
+func classCounts(ds neurus.Dataset, numClasses int) []int {
+	counts := make([]int, numClasses)
+	for i := range ds {
+		counts[ds.Class(i)]++
+	}
+	return counts
+}
+
+func TestBatchIterator(t *testing.T) {
+	ds := labeledDataset(103, 3)
+	for _, dropLast := range []bool{false, true} {
+		it := ds.Batches(10, rand.NewSource(1))
+		it.DropLast = dropLast
+		var firstEpoch []float64
+		for epoch := 1; epoch <= 3; epoch++ {
+			seen := make(map[float64]bool)
+			var order []float64
+			numBatches := 0
+			for it.Next() {
+				if it.Epoch() != epoch {
+					t.Fatalf("got epoch %d, want %d", it.Epoch(), epoch)
+				}
+				batch := it.Batch()
+				numBatches++
+				if len(batch) != 10 && (dropLast || numBatches != 11 || len(batch) != 3) {
+					t.Fatalf("dropLast=%v: batch %d has %d data points", dropLast, numBatches, len(batch))
+				}
+				for _, dp := range batch {
+					if seen[dp.Input[0]] {
+						t.Fatalf("data point %g visited twice in epoch %d", dp.Input[0], epoch)
+					}
+					seen[dp.Input[0]] = true
+					order = append(order, dp.Input[0])
+				}
+			}
+			if numBatches != it.NumBatches() {
+				t.Errorf("dropLast=%v: got %d batches, want %d", dropLast, numBatches, it.NumBatches())
+			}
+			if !dropLast && len(seen) != len(ds) {
+				t.Errorf("visited %d data points, want %d", len(seen), len(ds))
+			}
+			if epoch == 1 {
+				firstEpoch = order
+			} else if equalFloats(order, firstEpoch) {
+				t.Errorf("epoch %d not reshuffled", epoch)
+			}
+		}
+	}
+	for i, dp := range ds {
+		if dp.Input[0] != float64(i) {
+			t.Fatal("dataset modified by iterator")
+		}
+	}
+
+	// Iterators with the same seed visit data points in the same order.
+	a, b := ds.Batches(7, rand.NewSource(2)), ds.Batches(7, rand.NewSource(2))
+	for a.Next() && b.Next() {
+		if a.Batch()[0].Input[0] != b.Batch()[0].Input[0] {
+			t.Fatal("same seed visited data points in different order")
+		}
+	}
+	// No source visits data points in order.
+	ordered := ds.Batches(7, nil)
+	for k := 0; ordered.Next(); k++ {
+		if got := ordered.Batch()[0].Input[0]; got != float64(7*k) {
+			t.Fatalf("batch %d starts at %g without source", k, got)
+		}
+	}
+}
+
+func TestDataset_StratifiedSplit(t *testing.T) {
+	const numClasses = 3
+	ds := labeledDataset(600, numClasses)
+	total := classCounts(ds, numClasses)
+	train, validation, test := ds.StratifiedSplit(0.2, 0.1, rand.NewSource(1))
+	if len(train)+len(validation)+len(test) != len(ds) {
+		t.Fatalf("split sizes %d+%d+%d do not add up to %d", len(train), len(validation), len(test), len(ds))
+	}
+	seen := make(map[float64]bool)
+	for _, set := range []neurus.Dataset{train, validation, test} {
+		for _, dp := range set {
+			if seen[dp.Input[0]] {
+				t.Fatalf("data point %g in more than one set", dp.Input[0])
+			}
+			seen[dp.Input[0]] = true
+		}
+	}
+	for _, set := range []struct {
+		name     string
+		ds       neurus.Dataset
+		fraction float64
+	}{
+		{"train", train, 0.7}, {"validation", validation, 0.2}, {"test", test, 0.1},
+	} {
+		for class, count := range classCounts(set.ds, numClasses) {
+			want := set.fraction * float64(total[class])
+			if diff := float64(count) - want; diff < -1 || diff > 1 {
+				t.Errorf("%s set has %d data points of class %d, want %g", set.name, count, class, want)
+			}
+		}
+	}
+
+	// Unstratified splits have the requested sizes.
+	train, validation, test = ds.Split(0.25, 0.25, rand.NewSource(1))
+	if len(train) != 300 || len(validation) != 150 || len(test) != 150 {
+		t.Errorf("got split sizes %d, %d, %d", len(train), len(validation), len(test))
+	}
+}
+
+func TestDataset_StratifiedKFold(t *testing.T) {
+	const (
+		numClasses = 3
+		k          = 5
+	)
+	ds := labeledDataset(103, numClasses)
+	total := classCounts(ds, numClasses)
+	for _, test := range []struct {
+		name       string
+		folds      []neurus.Fold
+		stratified bool
+	}{
+		{"random", ds.KFold(k, rand.NewSource(1)), false},
+		{"stratified", ds.StratifiedKFold(k, rand.NewSource(1)), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if len(test.folds) != k {
+				t.Fatalf("got %d folds", len(test.folds))
+			}
+			validated := make(map[float64]int)
+			for f, fold := range test.folds {
+				if len(fold.Train)+len(fold.Validation) != len(ds) {
+					t.Fatalf("fold %d does not partition the data", f)
+				}
+				if n := len(fold.Validation); n != len(ds)/k && n != len(ds)/k+1 {
+					t.Errorf("fold %d validates on %d data points", f, n)
+				}
+				for _, dp := range fold.Validation {
+					validated[dp.Input[0]]++
+				}
+				if !test.stratified {
+					continue
+				}
+				for class, count := range classCounts(fold.Validation, numClasses) {
+					if want := total[class] / k; count < want || count > want+1 {
+						t.Errorf("fold %d validates on %d data points of class %d, want about %d", f, count, class, want)
+					}
+				}
+			}
+			for i := range ds {
+				if validated[float64(i)] != 1 {
+					t.Fatalf("data point %d validated %d times", i, validated[float64(i)])
+				}
+			}
+		})
+	}
+}
+
+// Batches of a Dataset are passed to trainers in place of []DataPoint.
+func ExampleDataset_Batches() {
+	const (
+		epochs    = 10
+		batchSize = 32
+		learnRate = 0.05
+	)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	train, validation, _ := neurus.Dataset(m.Generate2DData(1000)).StratifiedSplit(0.2, 0, rand.NewSource(1))
+	nn := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 2, 8, 2)
+	trainer := neurus.NewTrainerFromNetworkLvl2(nn)
+	batches := train.Batches(batchSize, rand.NewSource(1))
+	for epoch := 0; epoch < epochs; epoch++ {
+		for batches.Next() {
+			trainer.Train(nn, batches.Batch(), learnRate)
+		}
+		fmt.Printf("epoch %d, validation cost: %0.5f\n", epoch+1, nn.Cost(validation))
+	}
+}
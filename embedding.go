@@ -0,0 +1,190 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+)
+
+// EmbeddingColumn describes a categorical input column of an Embedding.
+// Its values are integer IDs in [0, NumCategories) which are mapped
+// to learned vectors of length Dim.
+type EmbeddingColumn struct {
+	NumCategories int `json:"numCategories"`
+	Dim           int `json:"dim"`
+}
+
+// Embedding is a Layer which maps integer IDs to learned vectors. Each input
+// row starts with one ID per categorical column stored as a float64 followed by
+// numDense dense features. The output row is the concatenation of the vectors of
+// each column's ID followed by the unchanged dense features:
+//
+//	input:  [id0, id1, ..., dense...]
+//	output: [table0[id0]..., table1[id1]..., dense...]
+//
+// Only the rows of the tables looked up since the last update receive gradients
+// and are updated, see SparseRows. Use CategoricalInput to build input rows.
+type Embedding struct {
+	columns  []EmbeddingColumn
+	numDense int
+	tables   [][]float64 // Indexed by [column][id*Dim+j].
+	grads    [][]float64
+	sparse   []SparseRows
+	x        []float64
+	out      []float64
+	dx       []float64
+}
+
+var _ Layer = (*Embedding)(nil)
+
+// NewEmbedding returns an Embedding layer for the categorical columns followed by
+// numDense dense features. The vectors are initialized to random values in [-1, 1).
+func NewEmbedding(columns []EmbeddingColumn, numDense int, rng *rand.Rand) *Embedding {
+	if numDense < 0 {
+		panic("negative number of dense features")
+	}
+	e := &Embedding{
+		columns:  append([]EmbeddingColumn{}, columns...),
+		numDense: numDense,
+		tables:   make([][]float64, len(columns)),
+		grads:    make([][]float64, len(columns)),
+		sparse:   make([]SparseRows, len(columns)),
+	}
+	for c, col := range columns {
+		if col.NumCategories <= 0 || col.Dim <= 0 {
+			panic("invalid embedding column dimensions")
+		}
+		e.tables[c] = randomSlice[float64](col.NumCategories*col.Dim, 2, -1, rng)
+		e.grads[c] = make([]float64, col.NumCategories*col.Dim)
+		e.sparse[c].RowSize = col.Dim
+	}
+	return e
+}
+
+// CategoricalInput returns an input row for an Embedding from the IDs of
+// each categorical column and the dense features.
+func CategoricalInput(ids []int, dense []float64) []float64 {
+	row := make([]float64, len(ids)+len(dense))
+	for i, id := range ids {
+		row[i] = float64(id)
+	}
+	copy(row[len(ids):], dense)
+	return row
+}
+
+// Vector returns the learned vector of id in the table of column.
+// The returned slice shares memory with the table.
+func (e *Embedding) Vector(column, id int) []float64 {
+	dim := e.columns[column].Dim
+	return e.tables[column][id*dim : (id+1)*dim]
+}
+
+func (e *Embedding) Dims() (numIn, numOut int) {
+	numIn = len(e.columns) + e.numDense
+	numOut = e.numDense
+	for _, col := range e.columns {
+		numOut += col.Dim
+	}
+	return numIn, numOut
+}
+
+func (e *Embedding) Params() []Param {
+	params := make([]Param, len(e.columns))
+	for c := range e.columns {
+		params[c] = Param{Value: e.tables[c], Grad: e.grads[c], Sparse: &e.sparse[c]}
+	}
+	return params
+}
+
+// id returns the ID of column c of input row.
+func (e *Embedding) id(row []float64, c int) int {
+	id := int(row[c])
+	if float64(id) != row[c] || id < 0 || id >= e.columns[c].NumCategories {
+		panic("embedding ID not an integer in [0, NumCategories)")
+	}
+	return id
+}
+
+func (e *Embedding) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := e.Dims()
+	n := batchSize(x, numIn)
+	e.x = append(e.x[:0], x...)
+	e.out = resize(e.out, n*numOut)
+	for s := 0; s < n; s++ {
+		input := x[s*numIn : (s+1)*numIn]
+		output := e.out[s*numOut : (s+1)*numOut]
+		off := 0
+		for c, col := range e.columns {
+			off += copy(output[off:off+col.Dim], e.Vector(c, e.id(input, c)))
+		}
+		copy(output[off:], input[len(e.columns):])
+	}
+	return e.out
+}
+
+func (e *Embedding) Backward(dy []float64) []float64 {
+	numIn, numOut := e.Dims()
+	if len(dy) != len(e.out) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	n := len(dy) / numOut
+	e.dx = resize(e.dx, n*numIn)
+	for s := 0; s < n; s++ {
+		input := e.x[s*numIn : (s+1)*numIn]
+		outGrad := dy[s*numOut : (s+1)*numOut]
+		dx := e.dx[s*numIn : (s+1)*numIn]
+		off := 0
+		for c, col := range e.columns {
+			id := e.id(input, c)
+			grad := e.grads[c][id*col.Dim : (id+1)*col.Dim]
+			for j := range grad {
+				grad[j] += outGrad[off+j]
+			}
+			e.sparse[c].Mark(id)
+			off += col.Dim
+			dx[c] = 0 // IDs are not differentiable.
+		}
+		copy(dx[len(e.columns):], outGrad[off:])
+	}
+	return e.dx
+}
+
+type embeddingSetup struct {
+	Columns  []EmbeddingColumn `json:"columns"`
+	NumDense int               `json:"numDense"`
+	Tables   [][]float64       `json:"tables"`
+}
+
+// MarshalJSON encodes the layer's columns and learned vectors.
+func (e *Embedding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(embeddingSetup{
+		Columns:  e.columns,
+		NumDense: e.numDense,
+		Tables:   e.tables,
+	})
+}
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (e *Embedding) UnmarshalJSON(b []byte) error {
+	var setup embeddingSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if len(setup.Tables) != len(setup.Columns) || setup.NumDense < 0 || len(setup.Columns)+setup.NumDense == 0 {
+		return errors.New("invalid embedding dimensions")
+	}
+	for c, col := range setup.Columns {
+		if col.NumCategories <= 0 || col.Dim <= 0 {
+			return errors.New("invalid embedding dimensions")
+		}
+		if len(setup.Tables[c]) != col.NumCategories*col.Dim {
+			return errors.New("embedding table length mismatch")
+		}
+	}
+	*e = *NewEmbedding(setup.Columns, setup.NumDense, rand.New(rand.NewSource(1)))
+	for c := range e.tables {
+		copy(e.tables[c], setup.Tables[c])
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package neurus_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func newEmbeddingModel(rng *rand.Rand) (*neurus.Sequential, *neurus.Embedding) {
+	embedding := neurus.NewEmbedding([]neurus.EmbeddingColumn{
+		{NumCategories: 5, Dim: 3},
+		{NumCategories: 3, Dim: 2},
+	}, 2, rng)
+	_, numOut := embedding.Dims()
+	model := neurus.NewSequential(&neurus.MeanSquaredError{},
+		embedding,
+		neurus.NewLayerOptimized(numOut, 4, new(neurus.Sigmd), rng),
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+	)
+	return model, embedding
+}
+
+func TestEmbedding_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model, _ := newEmbeddingModel(rng)
+	data := []neurus.DataPoint{
+		{Input: neurus.CategoricalInput([]int{0, 2}, []float64{0.5, -1}), ExpectedOutput: []float64{1, 0}},
+		{Input: neurus.CategoricalInput([]int{3, 2}, []float64{0.1, 0.3}), ExpectedOutput: []float64{0, 1}},
+		{Input: neurus.CategoricalInput([]int{0, 1}, []float64{-0.2, 0.7}), ExpectedOutput: []float64{0.5, 0.5}},
+	}
+	checkGradients(t, model, data)
+}
+
+func TestEmbedding_sparseUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model, embedding := newEmbeddingModel(rng)
+	untouched := append([]float64{}, embedding.Vector(0, 4)...)
+	// Give ID 1 of the first column momentum before it stops being used.
+	model.Learn([]neurus.DataPoint{
+		{Input: neurus.CategoricalInput([]int{1, 0}, []float64{1, 1}), ExpectedOutput: []float64{1, 0}},
+	}, 0.5, 0.1, 0.9)
+	stale := append([]float64{}, embedding.Vector(0, 1)...)
+	for i := 0; i < 5; i++ {
+		model.Learn([]neurus.DataPoint{
+			{Input: neurus.CategoricalInput([]int{0, 2}, []float64{0.5, -1}), ExpectedOutput: []float64{1, 0}},
+			{Input: neurus.CategoricalInput([]int{2, 2}, []float64{0.1, 0.3}), ExpectedOutput: []float64{0, 1}},
+		}, 0.5, 0.1, 0.9)
+	}
+	for _, test := range []struct {
+		name string
+		want []float64
+		got  []float64
+	}{
+		{name: "never used", want: untouched, got: embedding.Vector(0, 4)},
+		{name: "no longer used", want: stale, got: embedding.Vector(0, 1)},
+	} {
+		for i := range test.want {
+			if test.got[i] != test.want[i] {
+				t.Errorf("%s vector changed from %v to %v", test.name, test.want, test.got)
+				break
+			}
+		}
+	}
+	if got := embedding.Vector(0, 2); got[0] == untouched[0] && got[1] == untouched[1] {
+		t.Error("used vector did not change")
+	}
+}
+
+func TestEmbedding_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model, _ := newEmbeddingModel(rng)
+	input := neurus.CategoricalInput([]int{4, 1}, []float64{0.5, 0.25})
+	model.Learn([]neurus.DataPoint{{Input: input, ExpectedOutput: []float64{1, 0}}}, 0.5, 0, 0)
+	roundTrip(t, model, input)
+}
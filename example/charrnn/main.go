@@ -0,0 +1,124 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/soypat/neurus"
+)
+
+//go:embed corpus.txt
+var corpus string
+
+// This example trains a character level LSTM language model on a small
+// embedded corpus. At each time step the network receives a one-hot encoded
+// character and learns to predict the next one. After training, text is
+// generated by feeding the sampled characters back into the network.
+func main() {
+	const (
+		hiddenSize = 64
+		seqLength  = 32 // Length of training sequences.
+		truncation = 16 // Number of time steps gradients are propagated back.
+		batchSize  = 1  // Sequences per parameter update.
+		learnRate  = 1
+		momentum   = 0.9
+		epochs     = 150
+		genLength  = 200
+	)
+	rng := rand.New(rand.NewSource(1))
+
+	// Build the vocabulary from the characters present in the corpus.
+	var vocab []rune
+	charIdx := make(map[rune]int)
+	for _, c := range corpus {
+		if _, ok := charIdx[c]; !ok {
+			charIdx[c] = len(vocab)
+			vocab = append(vocab, c)
+		}
+	}
+	oneHot := make([][]float64, len(vocab))
+	for i := range oneHot {
+		oneHot[i] = make([]float64, len(vocab))
+		oneHot[i][i] = 1
+	}
+
+	// Split the corpus into overlapping sequences of characters.
+	text := []rune(corpus)
+	var sequences []neurus.SequenceDataPoint
+	for start := 0; start+seqLength+1 <= len(text); start += seqLength / 2 {
+		var seq neurus.SequenceDataPoint
+		for t := start; t < start+seqLength; t++ {
+			seq.Inputs = append(seq.Inputs, oneHot[charIdx[text[t]]])
+			seq.ExpectedOutputs = append(seq.ExpectedOutputs, oneHot[charIdx[text[t+1]]])
+		}
+		sequences = append(sequences, seq)
+	}
+
+	// vocabulary -> LSTM -> vocabulary sized output predicting the next character.
+	model := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLSTM(len(vocab), hiddenSize, new(neurus.Tanh), rng),
+		neurus.NewLayerOptimized(hiddenSize, len(vocab), new(neurus.Sigmd), rng),
+	)
+	fmt.Printf("corpus of %d characters, vocabulary of %d characters, %d training sequences\n", len(text), len(vocab), len(sequences))
+	fmt.Printf("network: %d -> lstm %d -> %d\n\n", len(vocab), hiddenSize, len(vocab))
+
+	for epoch := 0; epoch < epochs; epoch++ {
+		rng.Shuffle(len(sequences), func(i, j int) {
+			sequences[i], sequences[j] = sequences[j], sequences[i]
+		})
+		for i := 0; i < len(sequences); i += batchSize {
+			end := i + batchSize
+			if end > len(sequences) {
+				end = len(sequences)
+			}
+			model.LearnSequences(sequences[i:end], truncation, learnRate, 0, momentum)
+		}
+		if (epoch+1)%30 == 0 {
+			var cost float64
+			for _, seq := range sequences {
+				model.ResetState()
+				for t, input := range seq.Inputs {
+					model.Cost.CalculateFromInputs(model.StoreOutputs(input), seq.ExpectedOutputs[t], 1)
+					cost += model.Cost.TotalCost()
+				}
+			}
+			fmt.Printf("epoch %d: cost %.4f\n", epoch+1, cost/float64(len(sequences)*seqLength))
+		}
+	}
+
+	// Generate text starting from a seed, sampling each following character
+	// in proportion to the network outputs.
+	const seed = "the "
+	var generated strings.Builder
+	generated.WriteString(seed)
+	model.ResetState()
+	var outputs []float64
+	for _, c := range seed {
+		outputs = model.StoreOutputs(oneHot[charIdx[c]])
+	}
+	for i := 0; i < genLength; i++ {
+		next := sample(outputs, rng)
+		generated.WriteRune(vocab[next])
+		outputs = model.StoreOutputs(oneHot[next])
+	}
+	fmt.Printf("\ngenerated text:\n%s\n", generated.String())
+}
+
+// sample returns an index with probability proportional to the cube of its weight,
+// which favors the most likely characters.
+func sample(weights []float64, rng *rand.Rand) int {
+	var total float64
+	for _, w := range weights {
+		total += w * w * w
+	}
+	r := rng.Float64() * total
+	for i, w := range weights {
+		r -= w * w * w
+		if r <= 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/soypat/neurus"
+	"github.com/soypat/neurus/mnist"
+)
+
+// This example trains a small convolutional neural network on the MNIST
+// dataset alongside the 784 -> 100 -> 10 fully connected network of the
+// example in the parent directory. Both see the same mini-batches.
+// The convolutional network makes use of the 28x28 spatial structure
+// of the images and reaches a higher accuracy.
+func main() {
+	const (
+		batchSize    = 32
+		cnnLearnRate = 0.05
+		momentum     = 0.9
+		mlpLearnRate = 0.1
+		epochs       = 3
+	)
+
+	fmt.Println("loading MNIST dataset...")
+	mnistTrain, mnistTest, _ := mnist.Load64()
+	trainingData := neurus.MNISTToDatapoints(mnistTrain)
+	testData := neurus.MNISTToDatapoints(mnistTest)
+
+	// 1x28x28 image -> 8 3x3 kernels -> 8x26x26 -> 2x2 max pool -> 8x13x13 -> 10 output digits.
+	// The pooled sigmoid activations are all positive so they are batch
+	// normalized before the dense layer to keep its inputs centered.
+	rng := rand.New(rand.NewSource(1))
+	imageShape := neurus.Shape{Channels: 1, Height: 28, Width: 28}
+	conv := neurus.NewConv2D(imageShape, 8, 3, 1, 0, new(neurus.Sigmd), rng)
+	pool := neurus.NewMaxPool2D(conv.OutputShape(), 2, 2)
+	flatten := neurus.NewFlatten(pool.OutputShape())
+	_, numFlat := flatten.Dims()
+	cnn := neurus.NewSequential(&neurus.MeanSquaredError{},
+		conv, pool, flatten, neurus.NewBatchNorm(numFlat),
+		neurus.NewLayerOptimized(numFlat, 10, new(neurus.Sigmd), rng),
+	)
+
+	// 784 input pixels -> 100 hidden nodes -> 10 output digits.
+	mlp := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, mnist.PixelCount, 100, 10)
+	trainer := neurus.NewTrainerFromNetworkLvl2(mlp)
+
+	fmt.Printf("training on %d images, validating on %d images\n", len(trainingData), len(testData))
+	fmt.Printf("cnn: %v -> conv3x3 %v -> maxpool2x2 %v -> batchnorm -> 10\n", imageShape, conv.OutputShape(), pool.OutputShape())
+	fmt.Printf("mlp: %d -> 100 -> 10\n\n", mnist.PixelCount)
+
+	for epoch := 0; epoch < epochs; epoch++ {
+		// Shuffle training data order each epoch.
+		rand.Shuffle(len(trainingData), func(i, j int) {
+			trainingData[i], trainingData[j] = trainingData[j], trainingData[i]
+		})
+
+		// Train in mini-batches.
+		for i := 0; i+batchSize <= len(trainingData); i += batchSize {
+			miniBatch := trainingData[i : i+batchSize]
+			cnn.Learn(miniBatch, cnnLearnRate, 0, momentum)
+			trainer.Train(mlp, miniBatch, mlpLearnRate)
+		}
+
+		// Print accuracy at the end of each epoch.
+		cnnCorrect := countCorrect(func(input []float64) int {
+			class, _ := cnn.Classify(input)
+			return class
+		}, testData)
+		mlpCorrect := countCorrect(func(input []float64) int {
+			class, _ := mlp.Classify(make([]float64, 10), input)
+			return class
+		}, testData)
+		fmt.Printf("epoch %d: cnn accuracy %.2f%%, mlp accuracy %.2f%%\n", epoch+1,
+			100*float64(cnnCorrect)/float64(len(testData)), 100*float64(mlpCorrect)/float64(len(testData)))
+	}
+}
+
+func countCorrect(classify func(input []float64) int, data []neurus.DataPoint) int {
+	correct := 0
+	for _, dp := range data {
+		classification := classify(dp.Input)
+		// Find expected class from one-hot encoding.
+		expected := 0
+		for i, v := range dp.ExpectedOutput {
+			if v == 1 {
+				expected = i
+				break
+			}
+		}
+		if classification == expected {
+			correct++
+		}
+	}
+	return correct
+}
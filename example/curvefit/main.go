@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/soypat/neurus"
+)
+
+// This example fits a 1 -> 4 -> 1 network to noisy samples of a calibration
+// curve with the Levenberg–Marquardt trainer and compares it with gradient
+// descent in TrainerLvl2. Levenberg–Marquardt reaches the noise level of the
+// data in a few dozen iterations while gradient descent is still far from it
+// after thousands of epochs.
+func main() {
+	const (
+		numSamples   = 50
+		noise        = 0.02
+		lmIterations = 50
+		epochs       = 20000
+		learnRate    = 0.5
+	)
+	rng := rand.New(rand.NewSource(1))
+	data := make([]neurus.DataPoint, numSamples)
+	for i := range data {
+		x := float64(i) / (numSamples - 1)
+		y := curve(x) + noise*rng.NormFloat64()
+		data[i] = neurus.DataPoint{Input: []float64{x}, ExpectedOutput: []float64{y}}
+	}
+	fmt.Printf("fitting %d samples with noise variance %.5f\n\n", numSamples, noise*noise)
+
+	// Tanh hidden layer and sigmoid output layer.
+	nn := neurus.NewNetworkOptimized([]int{1, 4, 1}, func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		new(neurus.MeanSquaredError), rand.NewSource(1))
+	setup := nn.Export()
+	setup[0].Activation = &neurus.ActivationSetup{Kind: "tanh"}
+	nn.Import(setup, nil)
+	trainer := neurus.NewTrainerLM(nn)
+	start := time.Now()
+	for i := 0; i < lmIterations; i++ {
+		cost, err := trainer.Train(data)
+		if (i+1)%10 == 0 || errors.Is(err, neurus.ErrNoProgress) {
+			fmt.Printf("levenberg-marquardt iteration %d, mean squared error: %0.5f\n", i+1, 2*cost/numSamples)
+		}
+		if err != nil {
+			break
+		}
+	}
+	fmt.Printf("levenberg-marquardt took %s\n\n", time.Since(start))
+
+	lvl2 := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 1, 4, 1)
+	lvl2Trainer := neurus.NewTrainerFromNetworkLvl2(lvl2)
+	start = time.Now()
+	for epoch := 0; epoch < epochs; epoch++ {
+		lvl2Trainer.Train(lvl2, data, learnRate)
+		if (epoch+1)%(epochs/5) == 0 {
+			fmt.Printf("gradient descent epoch %d, mean squared error: %0.5f\n", epoch+1, lvl2.Cost(data))
+		}
+	}
+	fmt.Printf("gradient descent took %s\n\n", time.Since(start))
+
+	fmt.Println("     x    curve       LM     Lvl2")
+	for x := 0.0; x <= 1; x += 0.125 {
+		lvl2Out := lvl2.CalculateOutputs([]float64{x})
+		fmt.Printf("%6.3f %8.4f %8.4f %8.4f\n", x, curve(x), nn.StoreOutputs([]float64{x})[0], lvl2Out[0])
+	}
+}
+
+// curve is the noiseless calibration curve.
+func curve(x float64) float64 {
+	return 0.5 + 0.35*math.Sin(2*math.Pi*x)
+}
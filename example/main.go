@@ -17,7 +17,7 @@ func main() {
 
 	fmt.Println("loading MNIST dataset...")
 	mnistTrain, mnistTest, _ := mnist.Load64()
-	trainingData := neurus.MNISTToDatapoints(mnistTrain)
+	trainingData := neurus.Dataset(neurus.MNISTToDatapoints(mnistTrain))
 	testData := neurus.MNISTToDatapoints(mnistTest)
 
 	// 784 input pixels -> 100 hidden nodes -> 10 output digits.
@@ -27,16 +27,11 @@ func main() {
 	fmt.Printf("training on %d images, validating on %d images\n", len(trainingData), len(testData))
 	fmt.Printf("network: %d -> 100 -> 10\n\n", mnist.PixelCount)
 
+	// Mini-batches visit the training data in a different order each epoch.
+	batches := trainingData.Batches(batchSize, rand.NewSource(1))
 	for epoch := 0; epoch < epochs; epoch++ {
-		// Shuffle training data order each epoch.
-		rand.Shuffle(len(trainingData), func(i, j int) {
-			trainingData[i], trainingData[j] = trainingData[j], trainingData[i]
-		})
-
-		// Train in mini-batches.
-		for i := 0; i+batchSize <= len(trainingData); i += batchSize {
-			miniBatch := trainingData[i : i+batchSize]
-			trainer.Train(nn, miniBatch, learnRate)
+		for batches.Next() {
+			trainer.Train(nn, batches.Batch(), learnRate)
 		}
 
 		// Print accuracy at the end of each epoch.
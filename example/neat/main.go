@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/soypat/neurus"
+)
+
+// This example evolves the topology and weights of networks with NEAT. Networks
+// start out with their inputs connected directly to their outputs and grow hidden
+// nodes and connections as needed. The exclusive or can't be computed without
+// hidden nodes so the genomes which solve it must have grown some. The network
+// evolved for the basic 2D classifier is drawn to neat.png.
+func main() {
+	xorData := []neurus.DataPoint{
+		{Input: []float64{0, 0}, ExpectedOutput: []float64{0}},
+		{Input: []float64{0, 1}, ExpectedOutput: []float64{1}},
+		{Input: []float64{1, 0}, ExpectedOutput: []float64{1}},
+		{Input: []float64{1, 1}, ExpectedOutput: []float64{0}},
+	}
+	fmt.Println("XOR")
+	xor := neurus.NewNEAT(neurus.NEATConfig{NumInputs: 2, NumOutputs: 1}, rand.NewSource(1))
+	evolve(xor, 300, 10, xorData, func(nn *neurus.NEATNetwork) bool {
+		for _, dp := range xorData {
+			if math.Round(nn.CalculateOutputs(dp.Input)[0]) != dp.ExpectedOutput[0] {
+				return false
+			}
+		}
+		return true
+	})
+
+	fmt.Println("\nbasic 2D classifier")
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	rng := rand.New(rand.NewSource(1))
+	data := make([]neurus.DataPoint, 200)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		expected[basic2DClassifier(x, y)] = 1
+		data[i] = neurus.DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	twoD := neurus.NewNEAT(neurus.NEATConfig{NumInputs: 2, NumOutputs: 2}, rand.NewSource(1))
+	evolve(twoD, 300, 50, data, nil)
+
+	m.Classifier = neurus.PredictorClassifier(twoD.BestNetwork())
+	m.AddScatter(data)
+	fp, err := os.Create("neat.png")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fp.Close()
+	if err := png.Encode(fp, m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// evolve steps the population until solved returns true for the best network
+// or the generation limit is reached, printing the best genome every few generations.
+func evolve(p *neurus.NEAT, generations, printEvery int, data []neurus.DataPoint, solved func(*neurus.NEATNetwork) bool) {
+	objective := func(nn *neurus.NEATNetwork) float64 { return nn.Cost(data) }
+	for p.Generation() < generations {
+		p.Step(objective)
+		done := solved != nil && solved(p.BestNetwork())
+		if p.Generation()%printEvery == 0 || done || p.Generation() == generations {
+			best, cost := p.Best()
+			fmt.Printf("generation %3d, cost: %0.5f, hidden nodes: %d, connections: %2d, species: %d\n",
+				p.Generation(), cost, best.NumHidden(), best.NumEnabled(), p.NumSpecies())
+		}
+		if done {
+			fmt.Println("solved")
+			return
+		}
+	}
+}
+
+func basic2DClassifier(x, y float64) int {
+	if -x*x+0.5 > y {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/soypat/neurus"
+)
+
+// This example trains a single transformer encoder block to sort short
+// sequences of digits. Every position of the input sequence is a one-hot
+// encoded digit and the network outputs the digit found at that position
+// in the sorted sequence. Self-attention lets each position look at the
+// whole sequence, which is needed to work out which digit goes where.
+func main() {
+	const (
+		numDigits  = 5 // Digits are in [0, numDigits).
+		seqLength  = 5
+		modelSize  = 16
+		numHeads   = 2
+		ffSize     = 32
+		batchSize  = 8
+		learnRate  = 0.05
+		momentum   = 0.9
+		epochs     = 30
+		numSamples = 1000
+	)
+	rng := rand.New(rand.NewSource(1))
+	newSequence := func() neurus.SequenceDataPoint {
+		digits := make([]int, seqLength)
+		for i := range digits {
+			digits[i] = rng.Intn(numDigits)
+		}
+		var seq neurus.SequenceDataPoint
+		for _, d := range digits {
+			seq.Inputs = append(seq.Inputs, oneHot(d, numDigits))
+		}
+		sort.Ints(digits)
+		for _, d := range digits {
+			seq.ExpectedOutputs = append(seq.ExpectedOutputs, oneHot(d, numDigits))
+		}
+		return seq
+	}
+	trainData := make([]neurus.SequenceDataPoint, numSamples)
+	testData := make([]neurus.SequenceDataPoint, numSamples/4)
+	for i := range trainData {
+		trainData[i] = newSequence()
+	}
+	for i := range testData {
+		testData[i] = newSequence()
+	}
+
+	// digit -> linear embedding -> positional encoding -> encoder block -> digit.
+	model := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(numDigits, modelSize, new(neurus.Identity), rng),
+		neurus.NewPositionalEncoding(modelSize),
+		neurus.NewTransformerEncoder(modelSize, numHeads, ffSize, new(neurus.Tanh), rng),
+		neurus.NewLayerOptimized(modelSize, numDigits, new(neurus.Sigmd), rng),
+	)
+	fmt.Printf("sorting sequences of %d digits in [0, %d)\n", seqLength, numDigits)
+	fmt.Printf("network: %d -> embedding %d -> transformer encoder (%d heads) -> %d\n\n", numDigits, modelSize, numHeads, numDigits)
+
+	for epoch := 0; epoch < epochs; epoch++ {
+		rng.Shuffle(len(trainData), func(i, j int) {
+			trainData[i], trainData[j] = trainData[j], trainData[i]
+		})
+		for i := 0; i+batchSize <= len(trainData); i += batchSize {
+			model.LearnSequences(trainData[i:i+batchSize], 0, learnRate, 0, momentum)
+		}
+		if (epoch+1)%5 == 0 {
+			digits, sequences := accuracy(model, testData)
+			fmt.Printf("epoch %d: digit accuracy %.2f%%, sorted sequences %.2f%%\n", epoch+1, 100*digits, 100*sequences)
+		}
+	}
+
+	input := testData[0].Inputs
+	fmt.Printf("\nsort(%v) = %v\n", decode(input), decode(predict(model, input)))
+}
+
+// predict runs the whole sequence through the model and returns
+// the output of each position.
+func predict(model *neurus.Sequential, inputs [][]float64) [][]float64 {
+	var x []float64
+	for _, input := range inputs {
+		x = append(x, input...)
+	}
+	out := model.Forward(x)
+	_, numOut := model.Dims()
+	outputs := make([][]float64, len(inputs))
+	for t := range outputs {
+		outputs[t] = out[t*numOut : (t+1)*numOut]
+	}
+	return outputs
+}
+
+// accuracy returns the fraction of digits placed correctly and the
+// fraction of sequences sorted without errors.
+func accuracy(model *neurus.Sequential, data []neurus.SequenceDataPoint) (digits, sequences float64) {
+	var correctDigits, correctSequences int
+	for _, seq := range data {
+		got := decode(predict(model, seq.Inputs))
+		want := decode(seq.ExpectedOutputs)
+		allCorrect := true
+		for i := range want {
+			if got[i] == want[i] {
+				correctDigits++
+			} else {
+				allCorrect = false
+			}
+		}
+		if allCorrect {
+			correctSequences++
+		}
+	}
+	return float64(correctDigits) / float64(len(data)*len(data[0].Inputs)), float64(correctSequences) / float64(len(data))
+}
+
+func oneHot(digit, numDigits int) []float64 {
+	v := make([]float64, numDigits)
+	v[digit] = 1
+	return v
+}
+
+// decode returns the index of the largest value of each vector.
+func decode(vectors [][]float64) []int {
+	digits := make([]int, len(vectors))
+	for i, v := range vectors {
+		for j := range v {
+			if v[j] > v[digits[i]] {
+				digits[i] = j
+			}
+		}
+	}
+	return digits
+}
@@ -0,0 +1,201 @@
+package neurus
+
+import (
+	"math"
+
+	"golang.org/x/exp/constraints"
+)
+
+// GradientModel is a model whose analytic gradients can be checked by GradientCheck.
+// Sequential and Graph implement GradientModel. NetworkOptimized, NetworkLvl2 and
+// NetworkLvl3 are adapted with the GradientModel methods of NetworkOptimized,
+// TrainerLvl2 and TrainerLvl3. A single Layer is checked by wrapping it in a Sequential.
+type GradientModel = GradientModelOf[float64]
+
+// GradientModelOf is a GradientModel computing with floats of type T.
+type GradientModelOf[T constraints.Float] interface {
+	// Params returns the trainable parameters of the model. Every call
+	// must return the same parameters in the same order.
+	Params() []ParamOf[T]
+	// UpdateGradients accumulates the partial derivatives of the total cost of
+	// data with respect to each parameter in its gradient and returns the total cost.
+	UpdateGradients(data []DataPointOf[T]) (totalCost T)
+}
+
+// GradientError compares the analytic and numeric partial derivative of
+// the cost with respect to a single parameter value.
+type GradientError struct {
+	// Param is the index of the parameter in the model's Params and
+	// Index the index of the value within the parameter.
+	Param, Index int
+	Analytic     float64
+	Numeric      float64
+	// AbsErr is the absolute difference between Analytic and Numeric.
+	AbsErr float64
+	// RelErr is AbsErr divided by the larger magnitude of Analytic and Numeric.
+	// It is zero when both are zero.
+	RelErr float64
+}
+
+// Exceeds reports whether both the absolute and relative error exceed tol.
+// Gradients close to zero are compared by absolute error since their numeric
+// estimate is dominated by rounding.
+func (e GradientError) Exceeds(tol float64) bool {
+	return e.AbsErr > tol && e.RelErr > tol
+}
+
+// GradientCheck compares the gradients accumulated by model.UpdateGradients over
+// data with central differences of the total cost
+//
+//	(C(p+h) - C(p-h)) / 2h
+//
+// for every parameter value p and returns the comparison of each value in the order
+// of model.Params. The gradients of the model are zero on return. Models with a
+// ResetState method, such as Sequential, have their state reset before each
+// evaluation of the cost so that data is processed as a single sequence.
+// Dropout must be disabled since a random mask makes the cost non-deterministic.
+func GradientCheck(model GradientModel, data []DataPoint, h float64) []GradientError {
+	return GradientCheckOf[float64](model, data, h)
+}
+
+// GradientCheckOf is the generic counterpart of GradientCheck. A step h of
+// about 1e-2 is needed for float32 models since the cost is computed in float32.
+func GradientCheckOf[T constraints.Float](model GradientModelOf[T], data []DataPointOf[T], h float64) []GradientError {
+	resetter, _ := model.(interface{ ResetState() })
+	params := model.Params()
+	cost := func() float64 {
+		if resetter != nil {
+			resetter.ResetState()
+		}
+		return float64(model.UpdateGradients(data))
+	}
+	zeroParamGrads(params)
+	cost()
+	analytic := make([][]T, len(params))
+	for k, param := range params {
+		analytic[k] = append([]T{}, param.Grad...)
+	}
+
+	var errs []GradientError
+	for k, param := range params {
+		for i := range param.Value {
+			orig := param.Value[i]
+			plus, minus := orig+T(h), orig-T(h)
+			param.Value[i] = plus
+			costPlus := cost()
+			param.Value[i] = minus
+			costMinus := cost()
+			param.Value[i] = orig
+			// Divide by the actual step, which differs from 2h after rounding to T.
+			numeric := (costPlus - costMinus) / (float64(plus) - float64(minus))
+			errs = append(errs, newGradientError(k, i, float64(analytic[k][i]), numeric))
+		}
+	}
+	zeroParamGrads(params)
+	return errs
+}
+
+func newGradientError(param, index int, analytic, numeric float64) GradientError {
+	e := GradientError{Param: param, Index: index, Analytic: analytic, Numeric: numeric}
+	e.AbsErr = math.Abs(analytic - numeric)
+	if scale := math.Max(math.Abs(analytic), math.Abs(numeric)); scale > 0 {
+		e.RelErr = e.AbsErr / scale
+	}
+	return e
+}
+
+// zeroParamGrads zeroes the gradients of params and unmarks their sparse rows.
+func zeroParamGrads[T constraints.Float](params []ParamOf[T]) {
+	for _, param := range params {
+		fillZeros(param.Grad)
+		if param.Sparse != nil {
+			param.Sparse.Reset()
+		}
+	}
+}
+
+// GradientModel returns the network as a GradientModelOf[T]. Its UpdateGradients
+// method runs data through the network as a single batch in the network's mode.
+func (nn *NetworkOptimizedOf[T]) GradientModel() GradientModelOf[T] {
+	return optimizedGradients[T]{nn: nn}
+}
+
+type optimizedGradients[T constraints.Float] struct {
+	nn *NetworkOptimizedOf[T]
+}
+
+func (g optimizedGradients[T]) Params() []ParamOf[T] {
+	var params []ParamOf[T]
+	for i := range g.nn.layers {
+		params = append(params, g.nn.layers[i].Params()...)
+	}
+	return params
+}
+
+func (g optimizedGradients[T]) UpdateGradients(data []DataPointOf[T]) (totalCost T) {
+	return g.nn.updateBatchGradients(data, g.nn.learnData(len(data)))
+}
+
+// GradientModel returns the network and the gradients accumulated by the trainer's
+// UpdateAllGradients as a GradientModel. The cost is that of NetworkLvl2.Classify.
+// Each parameter is a row of weights of the connections of one input node, or the
+// biases of a layer.
+func (tr TrainerLvl2) GradientModel(nn NetworkLvl2) GradientModel {
+	return lvl2Gradients{tr: tr, nn: nn}
+}
+
+type lvl2Gradients struct {
+	tr TrainerLvl2
+	nn NetworkLvl2
+}
+
+func (g lvl2Gradients) Params() []Param {
+	var params []Param
+	for i, layer := range g.nn.layers {
+		for nodeIn := range layer.weights {
+			params = append(params, Param{Value: layer.weights[nodeIn], Grad: g.tr.layers[i].costGradW[nodeIn], Decay: true})
+		}
+		params = append(params, Param{Value: layer.biases, Grad: g.tr.layers[i].costGradB})
+	}
+	return params
+}
+
+func (g lvl2Gradients) UpdateGradients(data []DataPoint) (totalCost float64) {
+	for _, dp := range data {
+		g.tr.UpdateAllGradients(g.nn, dp)
+		_, cost := g.nn.Classify(dp.ExpectedOutput, dp.Input)
+		totalCost += cost
+	}
+	return totalCost
+}
+
+// GradientModel returns the network and the gradients accumulated by the trainer's
+// UpdateAllGradients as a GradientModel. The cost is that of NetworkLvl3.Classify.
+func (tr TrainerLvl3) GradientModel(nn NetworkLvl3) GradientModel {
+	return lvl3Gradients{tr: tr, nn: nn}
+}
+
+type lvl3Gradients struct {
+	tr TrainerLvl3
+	nn NetworkLvl3
+}
+
+func (g lvl3Gradients) Params() []Param {
+	var params []Param
+	for i, layer := range g.nn.layers {
+		params = append(params,
+			Param{Value: layer.weights, Grad: g.tr.layers[i].costGradW, Decay: true},
+			Param{Value: layer.biases, Grad: g.tr.layers[i].costGradB},
+		)
+	}
+	return params
+}
+
+func (g lvl3Gradients) UpdateGradients(data []DataPoint) (totalCost float64) {
+	for _, dp := range data {
+		g.tr.UpdateAllGradients(g.nn, dp)
+		_, cost := g.nn.Classify(dp.ExpectedOutput, dp.Input)
+		totalCost += cost
+	}
+	return totalCost
+}
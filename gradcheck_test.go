@@ -0,0 +1,139 @@
+package neurus_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+var gradientActivations = []struct {
+	name string
+	new  func() neurus.ActivationFunc
+	// unit is set for activations with outputs in (0, 1), as required by CrossEntropy.
+	unit bool
+}{
+	{name: "sigmoid", new: func() neurus.ActivationFunc { return new(neurus.Sigmd) }, unit: true},
+	{name: "softmax", new: func() neurus.ActivationFunc { return new(neurus.SoftMax) }, unit: true},
+	{name: "relu", new: func() neurus.ActivationFunc { return new(neurus.Relu) }},
+	{name: "tanh", new: func() neurus.ActivationFunc { return new(neurus.Tanh) }},
+	{name: "identity", new: func() neurus.ActivationFunc { return new(neurus.Identity) }},
+}
+
+var gradientCosts = []struct {
+	name string
+	new  func() neurus.CostFunc
+	// unit is set for costs only defined for outputs in (0, 1).
+	unit bool
+}{
+	{name: "mse", new: func() neurus.CostFunc { return new(neurus.MeanSquaredError) }},
+	{name: "crossentropy", new: func() neurus.CostFunc { return new(neurus.CrossEntropy) }, unit: true},
+}
+
+func gradientData(rng *rand.Rand, n, numIn, numOut int) []neurus.DataPoint {
+	data := make([]neurus.DataPoint, n)
+	for i := range data {
+		data[i].Input = make([]float64, numIn)
+		data[i].ExpectedOutput = make([]float64, numOut)
+		for j := range data[i].Input {
+			data[i].Input[j] = 2*rng.Float64() - 1
+		}
+		// One-hot expected outputs suit every cost function.
+		data[i].ExpectedOutput[rng.Intn(numOut)] = 1
+	}
+	return data
+}
+
+func TestGradientCheck(t *testing.T) {
+	for _, hidden := range gradientActivations {
+		for _, output := range gradientActivations {
+			for _, cost := range gradientCosts {
+				if cost.unit && !output.unit {
+					continue
+				}
+				name := fmt.Sprintf("%s-%s-%s", hidden.name, output.name, cost.name)
+				t.Run(name+"/optimized", func(t *testing.T) {
+					rng := rand.New(rand.NewSource(1))
+					nn := neurus.NewNetworkOptimized([]int{3, 5, 4}, hidden.new, cost.new(), rand.NewSource(1))
+					// Layer setups name activations by their registered kind.
+					setup := nn.Export()
+					setup[len(setup)-1].Activation = &neurus.ActivationSetup{Kind: output.name}
+					nn.Import(setup, nil)
+					checkGradients(t, nn.GradientModel(), gradientData(rng, 4, 3, 4))
+				})
+				t.Run(name+"/sequential", func(t *testing.T) {
+					rng := rand.New(rand.NewSource(1))
+					seq := neurus.NewSequential(cost.new(),
+						neurus.NewLayerOptimized(3, 5, hidden.new(), rng),
+						neurus.NewLayerOptimized(5, 4, output.new(), rng),
+					)
+					checkGradients(t, seq, gradientData(rng, 4, 3, 4))
+				})
+			}
+		}
+	}
+}
+
+func TestGradientCheck_float32(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	nn := neurus.NewNetworkOptimizedOf[float32]([]int{3, 5, 2},
+		func() neurus.ActivationFuncOf[float32] { return new(neurus.SigmdOf[float32]) },
+		new(neurus.MeanSquaredErrorOf[float32]), rand.NewSource(1))
+	data := make([]neurus.DataPointOf[float32], 4)
+	for i, dp := range gradientData(rng, len(data), 3, 2) {
+		data[i] = neurus.DataPointOf[float32]{Input: toFloat32(dp.Input), ExpectedOutput: toFloat32(dp.ExpectedOutput)}
+	}
+	for _, e := range neurus.GradientCheckOf[float32](nn.GradientModel(), data, 1e-2) {
+		if e.Exceeds(1e-2) {
+			t.Errorf("param %d[%d]: analytic %g, numeric %g", e.Param, e.Index, e.Analytic, e.Numeric)
+		}
+	}
+}
+
+func toFloat32(s []float64) []float32 {
+	f := make([]float32, len(s))
+	for i := range s {
+		f[i] = float32(s[i])
+	}
+	return f
+}
+
+func TestGradientCheck_detectsWrongGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	scale := &scaleLayer{Scale: []float64{0.5, 1.5}}
+	seq := neurus.NewSequential(new(neurus.MeanSquaredError), scale)
+	model := wrongGradients{seq}
+	errs := neurus.GradientCheck(model, gradientData(rng, 3, 2, 2), 1e-6)
+	if len(errs) != 2 {
+		t.Fatalf("got %d results, want one per parameter value", len(errs))
+	}
+	for _, e := range errs {
+		if !e.Exceeds(1e-5) {
+			t.Errorf("param %d[%d]: doubled gradient %g not detected against %g", e.Param, e.Index, e.Analytic, e.Numeric)
+		}
+	}
+	for _, g := range scale.Params()[0].Grad {
+		if g != 0 {
+			t.Fatal("gradients not zeroed after check")
+		}
+	}
+}
+
+// wrongGradients doubles the gradients of a model.
+type wrongGradients struct{ *neurus.Sequential }
+
+func (w wrongGradients) UpdateGradients(data []neurus.DataPoint) float64 {
+	params := w.Params()
+	before := make([][]float64, len(params))
+	for k := range params {
+		before[k] = append([]float64{}, params[k].Grad...)
+	}
+	cost := w.Sequential.UpdateGradients(data)
+	for k, param := range params {
+		for i := range param.Grad {
+			param.Grad[i] += param.Grad[i] - before[k][i]
+		}
+	}
+	return cost
+}
@@ -0,0 +1,510 @@
+package neurus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// NodeID identifies a node of a Graph.
+type NodeID int
+
+// Node operations.
+const (
+	opInput  = "input"
+	opLayer  = "layer"
+	opAdd    = "add"
+	opConcat = "concat"
+)
+
+// Graph is a model whose nodes form a directed acyclic graph. A node is either
+// an input of the model, a Layer applied to another node, the element-wise sum
+// of nodes or the concatenation of nodes. The output of a node may be used by any
+// number of nodes, which allows for residual connections and multi-input networks:
+//
+//	g := NewGraph(&MeanSquaredError{})
+//	x := g.Input(4)
+//	h := g.Layer(NewLayerOptimized(4, 4, new(Sigmd), rng), x)
+//	sum := g.Add(x, h) // Residual connection.
+//	g.SetOutput(g.Layer(NewLayerOptimized(4, 2, new(Sigmd), rng), sum))
+//
+// The inputs of the model are the concatenation of the Input nodes in the order they were added.
+// Data is passed between nodes in batches stored as row-major matrices as described in Layer.
+type Graph struct {
+	nodes  []graphNode
+	order  []NodeID // Topological order of the nodes.
+	output NodeID
+	Cost   CostFunc
+	mode   Mode
+	// optimizer holds the momentum of each parameter set returned by the layers.
+	optimizer momentumSGD
+	// inputs and dy are the batch input and output gradient matrices used during training.
+	// inputs is also used to gather the rows passed to PredictBatch.
+	inputs []float64
+	dy     []float64
+}
+
+type graphNode struct {
+	op     string
+	inputs []NodeID
+	layer  Layer
+	size   int       // Row length of the node's output.
+	out    []float64 // Output of the last forward batch.
+	grad   []float64 // Partial derivatives of the cost with respect to out.
+}
+
+// NewGraph returns an empty graph model. Nodes are added with the Input, Layer,
+// Add and Concat methods and the output node must be set with SetOutput.
+func NewGraph(cost CostFunc) *Graph {
+	return &Graph{Cost: cost, output: -1}
+}
+
+// Input adds an input node with rows of length size to the graph.
+func (g *Graph) Input(size int) NodeID {
+	return g.mustAdd(graphNode{op: opInput, size: size})
+}
+
+// Layer adds a node which passes the output of node in through layer.
+// A layer may only be added once to a graph.
+func (g *Graph) Layer(layer Layer, in NodeID) NodeID {
+	return g.mustAdd(graphNode{op: opLayer, layer: layer, inputs: []NodeID{in}})
+}
+
+// Add adds a node which is the element-wise sum of the outputs of nodes.
+// All nodes must have the same output length.
+func (g *Graph) Add(nodes ...NodeID) NodeID {
+	return g.mustAdd(graphNode{op: opAdd, inputs: nodes})
+}
+
+// Concat adds a node whose output rows are the concatenation of the output rows of nodes.
+func (g *Graph) Concat(nodes ...NodeID) NodeID {
+	return g.mustAdd(graphNode{op: opConcat, inputs: nodes})
+}
+
+// SetOutput sets the node whose output is the output of the model.
+func (g *Graph) SetOutput(node NodeID) {
+	if node < 0 || int(node) >= len(g.nodes) {
+		panic("output node does not exist")
+	}
+	g.output = node
+}
+
+func (g *Graph) mustAdd(node graphNode) NodeID {
+	id, err := g.add(node)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// add checks the node is valid, calculates its output length and adds it to the graph.
+func (g *Graph) add(node graphNode) (NodeID, error) {
+	for _, in := range node.inputs {
+		if in < 0 || int(in) >= len(g.nodes) {
+			return -1, fmt.Errorf("input node %d does not exist", in)
+		}
+	}
+	switch node.op {
+	case opInput:
+		if node.size <= 0 || len(node.inputs) != 0 {
+			return -1, errors.New("input node must have a positive size and no inputs")
+		}
+	case opLayer:
+		if node.layer == nil || len(node.inputs) != 1 {
+			return -1, errors.New("layer node must have a layer and a single input")
+		}
+		for _, other := range g.nodes {
+			if other.layer == node.layer {
+				return -1, errors.New("layer already in graph")
+			}
+		}
+		numIn, numOut := node.layer.Dims()
+		if numIn != g.nodes[node.inputs[0]].size {
+			return -1, fmt.Errorf("layer input length %d mismatches node %d output length %d", numIn, node.inputs[0], g.nodes[node.inputs[0]].size)
+		}
+		node.size = numOut
+	case opAdd:
+		if len(node.inputs) == 0 {
+			return -1, errors.New("add node must have inputs")
+		}
+		node.size = g.nodes[node.inputs[0]].size
+		for _, in := range node.inputs {
+			if g.nodes[in].size != node.size {
+				return -1, fmt.Errorf("add node input %d length %d mismatches length %d", in, g.nodes[in].size, node.size)
+			}
+		}
+	case opConcat:
+		if len(node.inputs) == 0 {
+			return -1, errors.New("concat node must have inputs")
+		}
+		node.size = 0
+		for _, in := range node.inputs {
+			node.size += g.nodes[in].size
+		}
+	default:
+		return -1, errors.New("unknown node operation: " + node.op)
+	}
+	g.nodes = append(g.nodes, node)
+	g.order = nil // Invalidate topological order.
+	return NodeID(len(g.nodes) - 1), nil
+}
+
+// topologicalOrder returns the nodes ordered so that every node comes after its inputs.
+func (g *Graph) topologicalOrder() []NodeID {
+	if len(g.order) == len(g.nodes) {
+		return g.order
+	}
+	// Kahn's algorithm.
+	pending := make([]int, len(g.nodes)) // Number of inputs not yet ordered.
+	users := make([][]NodeID, len(g.nodes))
+	var ready []NodeID
+	for id, node := range g.nodes {
+		pending[id] = len(node.inputs)
+		for _, in := range node.inputs {
+			users[in] = append(users[in], NodeID(id))
+		}
+		if len(node.inputs) == 0 {
+			ready = append(ready, NodeID(id))
+		}
+	}
+	order := make([]NodeID, 0, len(g.nodes))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		for _, user := range users[id] {
+			// A node using the same input twice is listed twice.
+			pending[user]--
+			if pending[user] == 0 {
+				ready = append(ready, user)
+			}
+		}
+	}
+	if len(order) != len(g.nodes) {
+		panic("graph has a cycle")
+	}
+	g.order = order
+	return order
+}
+
+// Layers returns the layers of the graph in topological order.
+func (g *Graph) Layers() []Layer {
+	var layers []Layer
+	for _, id := range g.topologicalOrder() {
+		if g.nodes[id].op == opLayer {
+			layers = append(layers, g.nodes[id].layer)
+		}
+	}
+	return layers
+}
+
+// Params returns the trainable parameters of every layer of the graph.
+func (g *Graph) Params() []Param { return layerParams(g.Layers()) }
+
+// Dims returns the input and output dimension of the model.
+func (g *Graph) Dims() (numIn, numOut int) {
+	if g.output < 0 {
+		panic("graph output not set")
+	}
+	for _, node := range g.nodes {
+		if node.op == opInput {
+			numIn += node.size
+		}
+	}
+	return numIn, g.nodes[g.output].size
+}
+
+// SetMode sets the model mode used by Forward, StoreOutputs and Classify. Learn
+// always runs in ModeTrain and restores the previous mode before returning.
+func (g *Graph) SetMode(mode Mode) {
+	if mode != ModeInference && mode != ModeTrain {
+		panic("invalid mode")
+	}
+	g.mode = mode
+}
+
+// Mode returns the current mode of the model.
+func (g *Graph) Mode() Mode { return g.mode }
+
+// Forward passes a batch of inputs stored as a row-major matrix through the graph
+// in topological order and returns the outputs of the output node. The returned slice
+// is owned by the graph and is valid until the next call to Forward.
+func (g *Graph) Forward(x []float64) []float64 {
+	numIn, _ := g.Dims()
+	n := batchSize(x, numIn)
+	// Split the input rows between the input nodes in the order they were added.
+	inputOffset := 0
+	for id := range g.nodes {
+		node := &g.nodes[id]
+		if node.op != opInput {
+			continue
+		}
+		node.out = resize(node.out, n*node.size)
+		for s := 0; s < n; s++ {
+			copy(node.out[s*node.size:(s+1)*node.size], x[s*numIn+inputOffset:])
+		}
+		inputOffset += node.size
+	}
+	for _, id := range g.topologicalOrder() {
+		node := &g.nodes[id]
+		switch node.op {
+		case opLayer:
+			node.out = node.layer.Forward(g.nodes[node.inputs[0]].out, g.mode)
+		case opAdd:
+			node.out = append(node.out[:0], g.nodes[node.inputs[0]].out...)
+			for _, in := range node.inputs[1:] {
+				for i, v := range g.nodes[in].out {
+					node.out[i] += v
+				}
+			}
+		case opConcat:
+			node.out = resize(node.out, n*node.size)
+			for s := 0; s < n; s++ {
+				off := s * node.size
+				for _, in := range node.inputs {
+					size := g.nodes[in].size
+					off += copy(node.out[off:], g.nodes[in].out[s*size:(s+1)*size])
+				}
+			}
+		}
+	}
+	return g.nodes[g.output].out
+}
+
+// Backward propagates the partial derivatives of the cost with respect to the outputs
+// of the last call to Forward through the graph in reverse topological order,
+// accumulating the gradients of every layer's parameters. The gradients
+// of nodes whose outputs are used by several nodes are summed.
+func (g *Graph) Backward(dy []float64) {
+	for id := range g.nodes {
+		node := &g.nodes[id]
+		node.grad = resize(node.grad, len(node.out))
+		for i := range node.grad {
+			node.grad[i] = 0
+		}
+	}
+	out := g.nodes[g.output]
+	if len(dy) != len(out.grad) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	copy(out.grad, dy)
+	order := g.topologicalOrder()
+	for k := len(order) - 1; k >= 0; k-- {
+		node := &g.nodes[order[k]]
+		switch node.op {
+		case opLayer:
+			addTo(g.nodes[node.inputs[0]].grad, node.layer.Backward(node.grad))
+		case opAdd:
+			for _, in := range node.inputs {
+				addTo(g.nodes[in].grad, node.grad)
+			}
+		case opConcat:
+			n := len(node.grad) / node.size
+			for s := 0; s < n; s++ {
+				off := s * node.size
+				for _, in := range node.inputs {
+					size := g.nodes[in].size
+					addTo(g.nodes[in].grad[s*size:(s+1)*size], node.grad[off:off+size])
+					off += size
+				}
+			}
+		}
+	}
+}
+
+// addTo adds src to dst element-wise.
+func addTo(dst, src []float64) {
+	if len(dst) != len(src) {
+		panic("length mismatch")
+	}
+	for i, v := range src {
+		dst[i] += v
+	}
+}
+
+// ResetState zeroes the hidden state of every Recurrent layer of the model
+// so that the next input is processed as the start of a new sequence.
+func (g *Graph) ResetState() {
+	for _, layer := range g.Layers() {
+		if r, ok := layer.(Recurrent); ok {
+			r.ResetState()
+		}
+	}
+}
+
+// StoreOutputs runs a single input through the model and returns the output values.
+func (g *Graph) StoreOutputs(input []float64) []float64 {
+	numIn, _ := g.Dims()
+	if len(input) != numIn {
+		panic("length of inputs mismatches graph input length")
+	}
+	return g.Forward(input)
+}
+
+// Classify runs the inputs through the model and returns index of output node with highest value.
+func (g *Graph) Classify(inputs []float64) (prediction int, outputs []float64) {
+	outputs = g.StoreOutputs(inputs)
+	index := maxIdx(math.Inf(-1), outputs)
+	return index, outputs
+}
+
+// PredictBatch stores the outputs of the model for each row of inputs in the
+// corresponding row of outputs. The rows are passed through the model as a single
+// batch in ModeInference. Layers keep buffers between calls so unlike
+// NetworkOptimized.PredictBatch the rows are not split among goroutines.
+func (g *Graph) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := g.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	g.inputs = resize(g.inputs, len(inputs)*numIn)
+	for s, input := range inputs {
+		copy(g.inputs[s*numIn:], input)
+	}
+	y := g.predict(g.inputs)
+	for s := range outputs {
+		copy(outputs[s], y[s*numOut:(s+1)*numOut])
+	}
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (g *Graph) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := g.Dims()
+	checkMatrix(outputs, inputs, numIn, numOut)
+	copy(outputs, g.predict(inputs))
+}
+
+// predict passes the batch x through the model in ModeInference.
+func (g *Graph) predict(x []float64) []float64 {
+	prevMode := g.mode
+	g.mode = ModeInference
+	defer func() { g.mode = prevMode }()
+	return g.Forward(x)
+}
+
+// Learn performs a single gradient descent step with momentum over the training data.
+// The learning rate is averaged over the number of data points.
+func (g *Graph) Learn(trainingData []DataPoint, learnRate, regularization, momentum float64) {
+	prevMode := g.mode
+	g.mode = ModeTrain
+	defer func() { g.mode = prevMode }()
+	g.UpdateGradients(trainingData)
+	g.ApplyGradients(learnRate/float64(len(trainingData)), regularization, momentum)
+}
+
+// UpdateGradients runs the data through the model as a single batch and backpropagates
+// the cost, accumulating the gradients of every layer's parameters. It returns the
+// total cost over the batch.
+func (g *Graph) UpdateGradients(data []DataPoint) (totalCost float64) {
+	numIn, numOut := g.Dims()
+	g.inputs = batchInputs(g.inputs, data, numIn)
+	outputs := g.Forward(g.inputs)
+	g.dy, totalCost = costGradients(g.dy, g.Cost, outputs, data, numOut)
+	g.Backward(g.dy)
+	return totalCost
+}
+
+// ApplyGradients updates the parameters of every layer using gradient descent
+// with momentum and L2 regularization of parameters marked for decay.
+// Gradients are zeroed afterwards and the running statistics of batch
+// normalization are updated with those of the last training batch.
+func (g *Graph) ApplyGradients(learnRate, regularization, momentum float64) {
+	layers := g.Layers()
+	g.optimizer.apply(layerParams(layers), learnRate, regularization, momentum)
+	updateLayerRunningStats(layers)
+}
+
+// GraphSpec is the serialized form of a Graph.
+type GraphSpec struct {
+	Nodes  []NodeSpec `json:"nodes"`
+	Output NodeID     `json:"output"`
+}
+
+// NodeSpec is the serialized form of a Graph node. Op is one of "input",
+// "layer", "add" or "concat". Inputs are the IDs of the nodes it uses,
+// which are the indices of the nodes in GraphSpec.Nodes.
+type NodeSpec struct {
+	Op     string     `json:"op"`
+	Inputs []NodeID   `json:"inputs,omitempty"`
+	Size   int        `json:"size,omitempty"`
+	Layer  *LayerSpec `json:"layer,omitempty"`
+}
+
+// Export returns the serialized form of the graph's structure and layers.
+// All layer types must be registered with RegisterLayer.
+func (g *Graph) Export() (GraphSpec, error) {
+	if g.output < 0 {
+		return GraphSpec{}, errors.New("graph output not set")
+	}
+	spec := GraphSpec{Output: g.output, Nodes: make([]NodeSpec, len(g.nodes))}
+	for id, node := range g.nodes {
+		nodeSpec := NodeSpec{Op: node.op, Inputs: node.inputs}
+		switch node.op {
+		case opInput:
+			nodeSpec.Size = node.size
+		case opLayer:
+			layerSpec, err := MarshalLayer(node.layer)
+			if err != nil {
+				return GraphSpec{}, err
+			}
+			nodeSpec.Layer = &layerSpec
+		}
+		spec.Nodes[id] = nodeSpec
+	}
+	return spec, nil
+}
+
+// ImportGraph creates a Graph from its serialized form as returned by Export.
+func ImportGraph(spec GraphSpec, cost CostFunc) (*Graph, error) {
+	g := NewGraph(cost)
+	// Nodes are added once all their inputs have been added so that
+	// they may be listed in any order.
+	ids := make([]NodeID, len(spec.Nodes))
+	for i := range ids {
+		ids[i] = -1
+	}
+	for added := 0; added < len(spec.Nodes); {
+		progress := false
+		for i, nodeSpec := range spec.Nodes {
+			if ids[i] >= 0 || !inputsAdded(ids, nodeSpec.Inputs) {
+				continue
+			}
+			node := graphNode{op: nodeSpec.Op, size: nodeSpec.Size}
+			for _, in := range nodeSpec.Inputs {
+				node.inputs = append(node.inputs, ids[in])
+			}
+			if nodeSpec.Op == opLayer {
+				if nodeSpec.Layer == nil {
+					return nil, fmt.Errorf("node %d: missing layer", i)
+				}
+				layer, err := UnmarshalLayer(*nodeSpec.Layer)
+				if err != nil {
+					return nil, fmt.Errorf("node %d: %w", i, err)
+				}
+				node.layer = layer
+			}
+			id, err := g.add(node)
+			if err != nil {
+				return nil, fmt.Errorf("node %d: %w", i, err)
+			}
+			ids[i] = id
+			added++
+			progress = true
+		}
+		if !progress {
+			return nil, errors.New("graph has a cycle or references missing nodes")
+		}
+	}
+	if spec.Output < 0 || int(spec.Output) >= len(ids) {
+		return nil, errors.New("output node does not exist")
+	}
+	g.output = ids[spec.Output]
+	return g, nil
+}
+
+func inputsAdded(ids []NodeID, inputs []NodeID) bool {
+	for _, in := range inputs {
+		if in < 0 || int(in) >= len(ids) || ids[in] < 0 {
+			return false
+		}
+	}
+	return true
+}
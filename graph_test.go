@@ -0,0 +1,128 @@
+package neurus_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// newTestGraph returns a two input graph with a residual connection,
+// fan out and concatenation:
+//
+//	a(3) -> dense(3) -+-> add -> dense(2) -+-> concat -> dense(2)
+//	  \_______________/                    |
+//	b(2) ---------------> dense(4) --------+
+func newTestGraph(rng *rand.Rand) *neurus.Graph {
+	g := neurus.NewGraph(&neurus.MeanSquaredError{})
+	a := g.Input(3)
+	b := g.Input(2)
+	h := g.Layer(neurus.NewLayerOptimized(3, 3, new(neurus.Tanh), rng), a)
+	residual := g.Add(a, h)
+	h1 := g.Layer(neurus.NewLayerOptimized(3, 2, new(neurus.Sigmd), rng), residual)
+	h2 := g.Layer(neurus.NewLayerOptimized(2, 4, new(neurus.Sigmd), rng), b)
+	cat := g.Concat(h1, h2, a)
+	g.SetOutput(g.Layer(neurus.NewLayerOptimized(9, 2, new(neurus.Sigmd), rng), cat))
+	return g
+}
+
+func randomDataPoints(rng *rand.Rand, n, numIn, numOut int) []neurus.DataPoint {
+	data := make([]neurus.DataPoint, n)
+	for i := range data {
+		data[i].Input = make([]float64, numIn)
+		for j := range data[i].Input {
+			data[i].Input[j] = 2*rng.Float64() - 1
+		}
+		data[i].ExpectedOutput = make([]float64, numOut)
+		for j := range data[i].ExpectedOutput {
+			data[i].ExpectedOutput[j] = rng.Float64()
+		}
+	}
+	return data
+}
+
+func TestGraph_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	g := newTestGraph(rng)
+	numIn, numOut := g.Dims()
+	if numIn != 5 || numOut != 2 {
+		t.Fatalf("got dims %d, %d, want 5, 2", numIn, numOut)
+	}
+	checkGradients(t, g, randomDataPoints(rng, 4, numIn, numOut))
+}
+
+func TestGraph_residual(t *testing.T) {
+	// A residual block whose layer outputs zero is the identity function.
+	rng := rand.New(rand.NewSource(1))
+	layer := neurus.NewLayerOptimized(3, 3, new(neurus.Identity), rng)
+	for _, p := range layer.Params() {
+		for i := range p.Value {
+			p.Value[i] = 0
+		}
+	}
+	g := neurus.NewGraph(&neurus.MeanSquaredError{})
+	x := g.Input(3)
+	g.SetOutput(g.Add(x, g.Layer(layer, x)))
+	input := []float64{1, -2, 3}
+	got := g.StoreOutputs(input)
+	for i := range input {
+		if got[i] != input[i] {
+			t.Fatalf("got %v, want %v", got, input)
+		}
+	}
+}
+
+func TestGraph_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	g := newTestGraph(rng)
+	numIn, numOut := g.Dims()
+	data := randomDataPoints(rng, 8, numIn, numOut)
+	for i := 0; i < 10; i++ {
+		g.Learn(data, 0.5, 0, 0.9)
+	}
+	inputs := make([][]float64, len(data))
+	for i, dp := range data {
+		inputs[i] = dp.Input
+	}
+	roundTrip(t, g, inputs...)
+	spec, err := g.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the first input node depend on the first dense layer, which depends on it.
+	spec.Nodes[0].Inputs = []neurus.NodeID{2}
+	_, err = neurus.ImportGraph(spec, &neurus.MeanSquaredError{})
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+}
+
+// TestImportGraph_malformed checks graph specs which do not describe a valid
+// graph are rejected with an error.
+func TestImportGraph_malformed(t *testing.T) {
+	dense := &neurus.LayerSpec{Kind: "dense", Layer: json.RawMessage(`{"weights":[[1,0],[0,1]],"biases":[0,0],"activation":{"kind":"identity"}}`)}
+	input := func(size int) neurus.NodeSpec { return neurus.NodeSpec{Op: "input", Size: size} }
+	for _, test := range []struct {
+		name string
+		spec neurus.GraphSpec
+	}{
+		{"unknown op", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), {Op: "mul", Inputs: []neurus.NodeID{0}}}, Output: 1}},
+		{"input size", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(0)}}},
+		{"input with inputs", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), {Op: "input", Size: 2, Inputs: []neurus.NodeID{0}}}}},
+		{"missing layer", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), {Op: "layer", Inputs: []neurus.NodeID{0}}}, Output: 1}},
+		{"layer input length", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(3), {Op: "layer", Inputs: []neurus.NodeID{0}, Layer: dense}}, Output: 1}},
+		{"layer inputs", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), input(2), {Op: "layer", Inputs: []neurus.NodeID{0, 1}, Layer: dense}}, Output: 2}},
+		{"add length", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), input(3), {Op: "add", Inputs: []neurus.NodeID{0, 1}}}, Output: 2}},
+		{"empty concat", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), {Op: "concat"}}, Output: 1}},
+		{"missing node", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2), {Op: "add", Inputs: []neurus.NodeID{0, 5}}}, Output: 1}},
+		{"output", neurus.GraphSpec{Nodes: []neurus.NodeSpec{input(2)}, Output: 1}},
+	} {
+		_, err := neurus.ImportGraph(test.spec, &neurus.MeanSquaredError{})
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
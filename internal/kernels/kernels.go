@@ -0,0 +1,159 @@
+// Package kernels implements the vector operations found in the inner loops of
+// dense layers. On amd64 CPUs supporting AVX2 and FMA the operations run on
+// assembly implementations, elsewhere on unrolled Go loops. The choice is made
+// once at program start. Build with the purego tag to always use the Go loops.
+//
+// Only the vector operations Dot, Axpy and ScaledAdd have assembly
+// implementations. The matrix-vector products Gemv and GemvT are plain Go loops
+// composed from Dot and Axpy, one call per matrix row, so they are accelerated
+// row by row rather than by a dedicated kernel.
+//
+// The assembly implementations fuse multiplications and additions and sum in a
+// different order than the Go loops, so results of both may differ in the last
+// bits. Results are deterministic for a given implementation.
+package kernels
+
+import (
+	"unsafe"
+
+	"golang.org/x/exp/constraints"
+)
+
+// useAVX2FMA is true when the assembly implementations are used.
+var useAVX2FMA = hasAVX2FMA()
+
+// Accelerated reports whether the kernels run on SIMD assembly implementations.
+func Accelerated() bool { return useAVX2FMA }
+
+// Dot returns the dot product of x and y. It panics if their lengths differ.
+func Dot[T constraints.Float](x, y []T) T {
+	if len(x) != len(y) {
+		panic("kernels: length mismatch")
+	}
+	if useAVX2FMA {
+		switch unsafe.Sizeof(T(0)) {
+		case 8:
+			return T(dotAVX64(float64s(x), float64s(y)))
+		case 4:
+			return T(dotAVX32(float32s(x), float32s(y)))
+		}
+	}
+	return dotGo(x, y)
+}
+
+// Axpy adds alpha*x to y. It panics if the lengths of x and y differ.
+func Axpy[T constraints.Float](alpha T, x, y []T) {
+	if len(x) != len(y) {
+		panic("kernels: length mismatch")
+	}
+	if useAVX2FMA {
+		switch unsafe.Sizeof(T(0)) {
+		case 8:
+			axpyAVX64(float64(alpha), float64s(x), float64s(y))
+			return
+		case 4:
+			axpyAVX32(float32(alpha), float32s(x), float32s(y))
+			return
+		}
+	}
+	axpyGo(alpha, x, y)
+}
+
+// ScaledAdd stores alpha*x + beta*y in y. It panics if the lengths of x and y differ.
+func ScaledAdd[T constraints.Float](alpha T, x []T, beta T, y []T) {
+	if len(x) != len(y) {
+		panic("kernels: length mismatch")
+	}
+	if useAVX2FMA {
+		switch unsafe.Sizeof(T(0)) {
+		case 8:
+			scaledAddAVX64(float64(alpha), float64s(x), float64(beta), float64s(y))
+			return
+		case 4:
+			scaledAddAVX32(float32(alpha), float32s(x), float32(beta), float32s(y))
+			return
+		}
+	}
+	scaledAddGo(alpha, x, beta, y)
+}
+
+// Gemv adds the product of the matrix a and the vector x to y. a is stored
+// row-major with len(y) rows of len(x) columns. Each row is reduced with Dot,
+// so y[i] ends up equal to y[i] + Dot(a[i*len(x):(i+1)*len(x)], x). There is no
+// assembly implementation of Gemv itself.
+func Gemv[T constraints.Float](a, x, y []T) {
+	n := len(x)
+	if len(a) != n*len(y) {
+		panic("kernels: matrix size mismatches vector lengths")
+	}
+	for i := range y {
+		y[i] += Dot(a[i*n:(i+1)*n], x)
+	}
+}
+
+// GemvT adds the product of the transpose of the matrix a and the vector x to y.
+// a is stored row-major with len(x) rows of len(y) columns. Each row is added to y
+// with Axpy; there is no assembly implementation of GemvT itself.
+func GemvT[T constraints.Float](a, x, y []T) {
+	n := len(y)
+	if len(a) != n*len(x) {
+		panic("kernels: matrix size mismatches vector lengths")
+	}
+	for i, xi := range x {
+		Axpy(xi, a[i*n:(i+1)*n], y)
+	}
+}
+
+// float64s reinterprets s, whose elements are 8 bytes long, as a []float64.
+func float64s[T constraints.Float](s []T) []float64 {
+	return *(*[]float64)(unsafe.Pointer(&s))
+}
+
+// float32s reinterprets s, whose elements are 4 bytes long, as a []float32.
+func float32s[T constraints.Float](s []T) []float32 {
+	return *(*[]float32)(unsafe.Pointer(&s))
+}
+
+func dotGo[T constraints.Float](x, y []T) T {
+	var s0, s1, s2, s3 T
+	y = y[:len(x)]
+	n := len(x) &^ 3
+	for i := 0; i < n; i += 4 {
+		s0 += x[i] * y[i]
+		s1 += x[i+1] * y[i+1]
+		s2 += x[i+2] * y[i+2]
+		s3 += x[i+3] * y[i+3]
+	}
+	for i := n; i < len(x); i++ {
+		s0 += x[i] * y[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func axpyGo[T constraints.Float](alpha T, x, y []T) {
+	y = y[:len(x)]
+	n := len(x) &^ 3
+	for i := 0; i < n; i += 4 {
+		y[i] += alpha * x[i]
+		y[i+1] += alpha * x[i+1]
+		y[i+2] += alpha * x[i+2]
+		y[i+3] += alpha * x[i+3]
+	}
+	for i := n; i < len(x); i++ {
+		y[i] += alpha * x[i]
+	}
+}
+
+func scaledAddGo[T constraints.Float](alpha T, x []T, beta T, y []T) {
+	y = y[:len(x)]
+	n := len(x) &^ 3
+	for i := 0; i < n; i += 4 {
+		y[i] = alpha*x[i] + beta*y[i]
+		y[i+1] = alpha*x[i+1] + beta*y[i+1]
+		y[i+2] = alpha*x[i+2] + beta*y[i+2]
+		y[i+3] = alpha*x[i+3] + beta*y[i+3]
+	}
+	for i := n; i < len(x); i++ {
+		y[i] = alpha*x[i] + beta*y[i]
+	}
+}
@@ -0,0 +1,51 @@
+//go:build amd64 && !purego
+
+package kernels
+
+// hasAVX2FMA reports whether the CPU and operating system support the AVX2 and
+// FMA instructions and the YMM registers.
+func hasAVX2FMA() bool {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	const (
+		fma     = 1 << 12
+		osxsave = 1 << 27
+		avx     = 1 << 28
+	)
+	if ecx1&(fma|osxsave|avx) != fma|osxsave|avx {
+		return false
+	}
+	// The OS must save the XMM (bit 1) and YMM (bit 2) registers on context switches.
+	xcr0, _ := xgetbv()
+	if xcr0&6 != 6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx7&avx2 != 0
+}
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+//go:noescape
+func dotAVX64(x, y []float64) float64
+
+//go:noescape
+func dotAVX32(x, y []float32) float32
+
+//go:noescape
+func axpyAVX64(alpha float64, x, y []float64)
+
+//go:noescape
+func axpyAVX32(alpha float32, x, y []float32)
+
+//go:noescape
+func scaledAddAVX64(alpha float64, x []float64, beta float64, y []float64)
+
+//go:noescape
+func scaledAddAVX32(alpha float32, x []float32, beta float32, y []float32)
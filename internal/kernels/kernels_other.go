@@ -0,0 +1,19 @@
+//go:build !amd64 || purego
+
+package kernels
+
+func hasAVX2FMA() bool { return false }
+
+// The assembly implementations are never called when hasAVX2FMA returns false.
+
+func dotAVX64(x, y []float64) float64 { panic("unreachable") }
+
+func dotAVX32(x, y []float32) float32 { panic("unreachable") }
+
+func axpyAVX64(alpha float64, x, y []float64) { panic("unreachable") }
+
+func axpyAVX32(alpha float32, x, y []float32) { panic("unreachable") }
+
+func scaledAddAVX64(alpha float64, x []float64, beta float64, y []float64) { panic("unreachable") }
+
+func scaledAddAVX32(alpha float32, x []float32, beta float32, y []float32) { panic("unreachable") }
@@ -0,0 +1,155 @@
+package kernels
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/exp/constraints"
+)
+
+// withGo runs f with the assembly implementations disabled.
+func withGo(f func()) {
+	defer func(use bool) { useAVX2FMA = use }(useAVX2FMA)
+	useAVX2FMA = false
+	f()
+}
+
+func randomVector[T constraints.Float](n int, rng *rand.Rand) []T {
+	v := make([]T, n)
+	for i := range v {
+		v[i] = T(2*rng.Float64() - 1)
+	}
+	return v
+}
+
+func clone[T any](s []T) []T { return append([]T{}, s...) }
+
+func agree[T constraints.Float](t *testing.T, name string, got, want []T, tol float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: length %d, want %d", name, len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(float64(got[i]-want[i])) > tol*(1+math.Abs(float64(want[i]))) {
+			t.Fatalf("%s: element %d is %g, want %g", name, i, got[i], want[i])
+		}
+	}
+}
+
+// lengths covers empty vectors and every tail length of the unrolled loops.
+var lengths = []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 15, 16, 17, 31, 32, 33, 63, 64, 65, 100, 784}
+
+func testKernels[T constraints.Float](t *testing.T, tol float64) {
+	if !useAVX2FMA {
+		t.Skip("AVX2/FMA not available")
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range lengths {
+		// Offset the slices by one element so the vectors are not aligned.
+		x := randomVector[T](n+1, rng)[1:]
+		y := randomVector[T](n+1, rng)[1:]
+		alpha, beta := T(rng.Float64()), T(rng.Float64())
+
+		got := Dot(x, y)
+		var want T
+		withGo(func() { want = Dot(x, y) })
+		agree(t, fmt.Sprintf("Dot n=%d", n), []T{got}, []T{want}, tol*float64(n+1))
+
+		gotY, wantY := clone(y), clone(y)
+		Axpy(alpha, x, gotY)
+		withGo(func() { Axpy(alpha, x, wantY) })
+		agree(t, fmt.Sprintf("Axpy n=%d", n), gotY, wantY, tol)
+
+		gotY, wantY = clone(y), clone(y)
+		ScaledAdd(alpha, x, beta, gotY)
+		withGo(func() { ScaledAdd(alpha, x, beta, wantY) })
+		agree(t, fmt.Sprintf("ScaledAdd n=%d", n), gotY, wantY, tol)
+
+		const rows = 3
+		a := randomVector[T](rows*n, rng)
+		b := randomVector[T](rows, rng)
+		gotB, wantB := clone(b), clone(b)
+		Gemv(a, x, gotB)
+		withGo(func() { Gemv(a, x, wantB) })
+		agree(t, fmt.Sprintf("Gemv n=%d", n), gotB, wantB, tol*float64(n+1))
+
+		gotY, wantY = clone(y), clone(y)
+		GemvT(a, b, gotY)
+		withGo(func() { GemvT(a, b, wantY) })
+		agree(t, fmt.Sprintf("GemvT n=%d", n), gotY, wantY, tol*rows)
+	}
+}
+
+func TestKernels_float64(t *testing.T) { testKernels[float64](t, 1e-14) }
+
+func TestKernels_float32(t *testing.T) { testKernels[float32](t, 1e-6) }
+
+func TestKernels_reference(t *testing.T) {
+	test := func(t *testing.T) {
+		x := []float64{1, 2, 3, 4, 5}
+		y := []float64{5, 4, 3, 2, 1}
+		if got := Dot(x, y); got != 35 {
+			t.Errorf("Dot = %g, want 35", got)
+		}
+		z := clone(y)
+		Axpy(2, x, z)
+		agree(t, "Axpy", z, []float64{7, 8, 9, 10, 11}, 0)
+		z = clone(y)
+		ScaledAdd(2, x, -1, z)
+		agree(t, "ScaledAdd", z, []float64{-3, 0, 3, 6, 9}, 0)
+		a := []float64{1, 0, 1, 0, 1, 0, 1, 0, 1, 0} // 2x5
+		b := []float64{1, -1}
+		Gemv(a, x, b)
+		agree(t, "Gemv", b, []float64{10, 5}, 0)
+		z = clone(y)
+		GemvT(a, []float64{1, 2}, z)
+		agree(t, "GemvT", z, []float64{6, 6, 4, 4, 2}, 0)
+	}
+	t.Run("asm", test)
+	withGo(func() { t.Run("go", test) })
+}
+
+func TestKernels_lengthMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	Dot([]float64{1, 2}, []float64{1})
+}
+
+func benchmarkDot[T constraints.Float](b *testing.B, n int) {
+	rng := rand.New(rand.NewSource(1))
+	x, y := randomVector[T](n, rng), randomVector[T](n, rng)
+	for i := 0; i < b.N; i++ {
+		Dot(x, y)
+	}
+}
+
+func BenchmarkDot(b *testing.B) {
+	for _, n := range []int{16, 784} {
+		b.Run(fmt.Sprintf("asm/%d", n), func(b *testing.B) { benchmarkDot[float64](b, n) })
+		b.Run(fmt.Sprintf("go/%d", n), func(b *testing.B) { withGo(func() { benchmarkDot[float64](b, n) }) })
+		b.Run(fmt.Sprintf("asm32/%d", n), func(b *testing.B) { benchmarkDot[float32](b, n) })
+		b.Run(fmt.Sprintf("go32/%d", n), func(b *testing.B) { withGo(func() { benchmarkDot[float32](b, n) }) })
+	}
+}
+
+func BenchmarkAxpy(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	x, y := randomVector[float64](784, rng), randomVector[float64](784, rng)
+	b.Run("asm", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Axpy(1e-3, x, y)
+		}
+	})
+	b.Run("go", func(b *testing.B) {
+		withGo(func() {
+			for i := 0; i < b.N; i++ {
+				Axpy(1e-3, x, y)
+			}
+		})
+	})
+}
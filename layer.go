@@ -0,0 +1,388 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Layer is a differentiable building block of a Sequential model.
+// Data is passed between layers in batches stored as row-major matrices
+// with one row per data point.
+type Layer interface {
+	// Dims returns the length of a single input and output row.
+	Dims() (numIn, numOut int)
+	// Forward passes a batch of inputs through the layer and returns the outputs.
+	// The length of x must be a multiple of the input row length. The returned
+	// slice is owned by the layer and is valid until the next call to Forward.
+	Forward(x []float64, mode Mode) []float64
+	// Backward receives the partial derivatives of the cost with respect to
+	// the outputs of the last call to Forward, accumulates the gradients of the
+	// layer's parameters and returns the partial derivatives of the cost with
+	// respect to the inputs. The returned slice is owned by the layer.
+	Backward(dy []float64) []float64
+	// Params returns the trainable parameters of the layer.
+	Params() []Param
+}
+
+// Param is a set of trainable parameters of a Layer and the accumulated
+// partial derivatives of the cost with respect to each of them.
+type Param = ParamOf[float64]
+
+// ParamOf is a set of trainable parameters of type T.
+type ParamOf[T constraints.Float] struct {
+	Value []T
+	Grad  []T
+	// Decay is set for parameters subject to L2 regularization (weight decay),
+	// typically weights but not biases.
+	Decay bool
+	// Sparse is set for row-major tables of which only a few rows receive
+	// gradients between updates, such as the tables of an Embedding.
+	// Only the rows marked in Sparse are updated by the optimizer.
+	Sparse *SparseRows
+}
+
+// SparseRows keeps track of the rows of a parameter table which have
+// received gradients since the last update.
+type SparseRows struct {
+	// RowSize is the length of a row of the table.
+	RowSize int
+	// Rows lists the marked rows in the order they were marked.
+	Rows   []int
+	marked []bool
+}
+
+// Mark adds row to Rows if it is not already present.
+func (sr *SparseRows) Mark(row int) {
+	if row >= len(sr.marked) {
+		sr.marked = append(sr.marked, make([]bool, row+1-len(sr.marked))...)
+	}
+	if !sr.marked[row] {
+		sr.marked[row] = true
+		sr.Rows = append(sr.Rows, row)
+	}
+}
+
+// Reset unmarks all rows. It is called by the optimizer after updating the marked rows.
+func (sr *SparseRows) Reset() {
+	for _, row := range sr.Rows {
+		sr.marked[row] = false
+	}
+	sr.Rows = sr.Rows[:0]
+}
+
+// NumParams returns the total number of values of params.
+func NumParams[T constraints.Float](params []ParamOf[T]) (n int) {
+	for _, param := range params {
+		n += len(param.Value)
+	}
+	return n
+}
+
+// FlattenParams stores the values of params one after another in dst, reusing
+// its capacity, and returns it. Flattened parameters may be used by optimizers
+// which treat a model as a function of a single vector.
+func FlattenParams[T constraints.Float](dst []float64, params []ParamOf[T]) []float64 {
+	dst = resize(dst, NumParams(params))[:0]
+	for _, param := range params {
+		for _, v := range param.Value {
+			dst = append(dst, float64(v))
+		}
+	}
+	return dst
+}
+
+// FlattenGrads is like FlattenParams for the gradients of params.
+func FlattenGrads[T constraints.Float](dst []float64, params []ParamOf[T]) []float64 {
+	dst = resize(dst, NumParams(params))[:0]
+	for _, param := range params {
+		for _, g := range param.Grad {
+			dst = append(dst, float64(g))
+		}
+	}
+	return dst
+}
+
+// UnflattenParams stores the values of x, flattened as by FlattenParams, in params.
+// It panics if the length of x mismatches the number of parameter values.
+func UnflattenParams[T constraints.Float](params []ParamOf[T], x []float64) {
+	if len(x) != NumParams(params) {
+		panic("length of flattened parameters mismatches number of parameter values")
+	}
+	for _, param := range params {
+		for i := range param.Value {
+			param.Value[i] = T(x[i])
+		}
+		x = x[len(param.Value):]
+	}
+}
+
+// LayerSpec is the serialized form of a Layer. Kind is the name the
+// layer type was registered with using RegisterLayer and Layer holds the
+// JSON encoding of the layer.
+type LayerSpec struct {
+	Kind  string          `json:"kind"`
+	Layer json.RawMessage `json:"layer"`
+}
+
+// ActivationSetup is the serialized form of an ActivationFunc. Kind is the name
+// the activation was registered with using RegisterActivation and Config
+// holds the JSON encoding of the activation's exported fields.
+type ActivationSetup struct {
+	Kind   string          `json:"kind"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+type registry[T any] struct {
+	constructors map[string]func() T
+	kinds        map[reflect.Type]string
+}
+
+func (r *registry[T]) register(kind string, fn func() T) {
+	if r.constructors == nil {
+		r.constructors = make(map[string]func() T)
+		r.kinds = make(map[reflect.Type]string)
+	}
+	if _, ok := r.constructors[kind]; ok {
+		panic("kind already registered: " + kind)
+	}
+	r.constructors[kind] = fn
+	r.kinds[reflect.TypeOf(fn())] = kind
+}
+
+func (r *registry[T]) kind(v T) (string, bool) {
+	kind, ok := r.kinds[reflect.TypeOf(v)]
+	return kind, ok
+}
+
+var (
+	layerRegistry      registry[Layer]
+	activationRegistry registry[ActivationFunc]
+	// activationRegistry32 holds the float32 counterparts of the built-in activations.
+	activationRegistry32 registry[ActivationFuncOf[float32]]
+)
+
+// activationRegistryOf returns the activation registry of networks computing with T.
+func activationRegistryOf[T constraints.Float]() (*registry[ActivationFuncOf[T]], bool) {
+	var r any = &activationRegistry
+	if _, ok := any(T(0)).(float32); ok {
+		r = &activationRegistry32
+	}
+	reg, ok := r.(*registry[ActivationFuncOf[T]])
+	return reg, ok
+}
+
+// registerActivations registers the built-in activations in r.
+func registerActivations[T constraints.Float](r *registry[ActivationFuncOf[T]]) {
+	r.register("sigmoid", func() ActivationFuncOf[T] { return new(SigmdOf[T]) })
+	r.register("relu", func() ActivationFuncOf[T] { return new(ReluOf[T]) })
+	r.register("softmax", func() ActivationFuncOf[T] { return new(SoftMaxOf[T]) })
+	r.register("tanh", func() ActivationFuncOf[T] { return new(TanhOf[T]) })
+	r.register("identity", func() ActivationFuncOf[T] { return new(IdentityOf[T]) })
+}
+
+// RegisterLayer makes a Layer type available for serialization under kind.
+// newLayer must return a pointer to a new zero value layer which can be decoded
+// with encoding/json, i.e: it should implement json.Unmarshaler or export all
+// its fields. RegisterLayer panics if kind is already registered.
+func RegisterLayer(kind string, newLayer func() Layer) {
+	layerRegistry.register(kind, newLayer)
+}
+
+// RegisterActivation makes an ActivationFunc type available for serialization
+// under kind. Exported fields of the activation are serialized with encoding/json.
+// RegisterActivation panics if kind is already registered. Only the built-in
+// activations are available to float32 networks.
+func RegisterActivation(kind string, newActivation func() ActivationFunc) {
+	activationRegistry.register(kind, newActivation)
+}
+
+func init() {
+	registerActivations(&activationRegistry)
+	registerActivations(&activationRegistry32)
+
+	RegisterLayer("dense", func() Layer { return new(LayerOptimized) })
+	RegisterLayer("dropout", func() Layer { return new(Dropout) })
+	RegisterLayer("batchnorm", func() Layer { return new(BatchNorm) })
+	RegisterLayer("layernorm", func() Layer { return new(LayerNorm) })
+	RegisterLayer("conv2d", func() Layer { return new(Conv2D) })
+	RegisterLayer("maxpool2d", func() Layer { return new(MaxPool2D) })
+	RegisterLayer("avgpool2d", func() Layer { return new(AvgPool2D) })
+	RegisterLayer("flatten", func() Layer { return new(Flatten) })
+	RegisterLayer("rnn", func() Layer { return new(RNN) })
+	RegisterLayer("lstm", func() Layer { return new(LSTM) })
+	RegisterLayer("gru", func() Layer { return new(GRU) })
+	RegisterLayer("attention", func() Layer { return new(MultiHeadAttention) })
+	RegisterLayer("posencoding", func() Layer { return new(PositionalEncoding) })
+	RegisterLayer("transformer", func() Layer { return new(TransformerEncoder) })
+	RegisterLayer("embedding", func() Layer { return new(Embedding) })
+	RegisterLayer("standardize", func() Layer { return new(Standardizer) })
+	RegisterLayer("minmax", func() Layer { return new(MinMaxScaler) })
+	RegisterLayer("pcawhitening", func() Layer { return new(PCAWhitening) })
+	RegisterLayer("onehot", func() Layer { return new(OneHot) })
+	RegisterLayer("pipeline", func() Layer { return new(Pipeline) })
+}
+
+// MarshalLayer returns the serialized form of a registered layer.
+func MarshalLayer(layer Layer) (LayerSpec, error) {
+	kind, ok := layerRegistry.kind(layer)
+	if !ok {
+		return LayerSpec{}, errors.New("layer type not registered: " + reflect.TypeOf(layer).String())
+	}
+	b, err := json.Marshal(layer)
+	if err != nil {
+		return LayerSpec{}, err
+	}
+	return LayerSpec{Kind: kind, Layer: b}, nil
+}
+
+// UnmarshalLayer creates a layer from its serialized form.
+func UnmarshalLayer(spec LayerSpec) (Layer, error) {
+	newLayer, ok := layerRegistry.constructors[spec.Kind]
+	if !ok {
+		return nil, errors.New("unknown layer kind: " + spec.Kind)
+	}
+	layer := newLayer()
+	err := json.Unmarshal(spec.Layer, layer)
+	if err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+func marshalActivation[T constraints.Float](act ActivationFuncOf[T]) (*ActivationSetup, error) {
+	reg, ok := activationRegistryOf[T]()
+	var kind string
+	if ok {
+		kind, ok = reg.kind(act)
+	}
+	if !ok {
+		return nil, errors.New("activation type not registered: " + reflect.TypeOf(act).String())
+	}
+	b, err := json.Marshal(act)
+	if err != nil {
+		return nil, err
+	}
+	return &ActivationSetup{Kind: kind, Config: b}, nil
+}
+
+func unmarshalActivation[T constraints.Float](setup ActivationSetup) (ActivationFuncOf[T], error) {
+	reg, ok := activationRegistryOf[T]()
+	var newActivation func() ActivationFuncOf[T]
+	if ok {
+		newActivation, ok = reg.constructors[setup.Kind]
+	}
+	if !ok {
+		return nil, errors.New("unknown activation kind: " + setup.Kind)
+	}
+	act := newActivation()
+	if len(setup.Config) > 0 {
+		err := json.Unmarshal(setup.Config, act)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return act, nil
+}
+
+// batchSize returns the number of rows of length rowLen in x.
+func batchSize[T any](x []T, rowLen int) int {
+	if rowLen == 0 || len(x)%rowLen != 0 {
+		panic("input length is not a multiple of the layer input length")
+	}
+	return len(x) / rowLen
+}
+
+// rowsOf splits the row-major matrix x into rows of length rowLen.
+// The dst slice is reused if it has enough capacity.
+func rowsOf[T any](dst [][]T, x []T, rowLen int) [][]T {
+	n := batchSize(x, rowLen)
+	if cap(dst) < n {
+		dst = make([][]T, n)
+	}
+	dst = dst[:n]
+	for s := range dst {
+		dst[s] = x[s*rowLen : (s+1)*rowLen]
+	}
+	return dst
+}
+
+// resize returns a slice of length n reusing buf if it has enough capacity.
+func resize[T any](buf []T, n int) []T {
+	if cap(buf) < n {
+		return make([]T, n)
+	}
+	return buf[:n]
+}
+
+// Dropout is a Layer which zeroes each of its inputs with probability Rate
+// during training and scales the rest by 1/(1-Rate). It is the identity
+// function during inference.
+type Dropout struct {
+	Size int     `json:"size"`
+	Rate float64 `json:"rate"`
+	rng  *rand.Rand
+	mask []float64
+	out  []float64
+	dx   []float64
+}
+
+var _ Layer = (*Dropout)(nil)
+
+// NewDropout returns a dropout layer for rows of length size.
+func NewDropout(size int, rate float64, rng *rand.Rand) *Dropout {
+	if rate < 0 || rate >= 1 {
+		panic("dropout rate must be in [0, 1)")
+	}
+	return &Dropout{Size: size, Rate: rate, rng: rng}
+}
+
+// UnmarshalJSON decodes the layer's configuration.
+func (d *Dropout) UnmarshalJSON(b []byte) error {
+	var setup struct {
+		Size int     `json:"size"`
+		Rate float64 `json:"rate"`
+	}
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Size <= 0 || setup.Rate < 0 || setup.Rate >= 1 {
+		return errors.New("dropout size must be positive and rate in [0, 1)")
+	}
+	*d = Dropout{Size: setup.Size, Rate: setup.Rate}
+	return nil
+}
+
+func (d *Dropout) Dims() (numIn, numOut int) { return d.Size, d.Size }
+
+func (d *Dropout) Params() []Param { return nil }
+
+func (d *Dropout) Forward(x []float64, mode Mode) []float64 {
+	batchSize(x, d.Size)
+	d.mask = resize(d.mask, len(x))
+	d.out = resize(d.out, len(x))
+	if mode == ModeTrain && d.Rate > 0 {
+		if d.rng == nil {
+			d.rng = rand.New(rand.NewSource(1))
+		}
+		fillDropoutMask(d.mask, d.Rate, d.rng)
+	} else {
+		fillOnes(d.mask)
+	}
+	for i, v := range x {
+		d.out[i] = v * d.mask[i]
+	}
+	return d.out
+}
+
+func (d *Dropout) Backward(dy []float64) []float64 {
+	d.dx = resize(d.dx, len(dy))
+	for i, v := range dy {
+		d.dx[i] = v * d.mask[i]
+	}
+	return d.dx
+}
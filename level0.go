@@ -33,6 +33,7 @@ func NewNetworkLvl0(activationFunction func(float64) float64, layerSizes ...int)
 
 // CalculateOutputs runs the inputs through the network and returns the output values.
 // This is also known as feeding the neural network, or Feedthrough.
+// The returned slice is reused by the network and overwritten by the next call.
 func (nn NetworkLvl0) CalculateOutputs(input []float64) []float64 {
 	for _, layer := range nn.layers {
 		input = layer.CalculateOutputs(input)
@@ -66,10 +67,57 @@ func (nn NetworkLvl0) Dims() (input, output int) {
 	return input, output
 }
 
+// PredictBatch stores the outputs of the network for each row of inputs in the
+// corresponding row of outputs. The rows are split among GOMAXPROCS goroutines.
+func (nn NetworkLvl0) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := nn.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, len(inputs),
+		func(s int) []float64 { return inputs[s] },
+		func(s int) []float64 { return outputs[s] })
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (nn NetworkLvl0) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := nn.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, n,
+		func(s int) []float64 { return inputs[s*numIn : (s+1)*numIn] },
+		func(s int) []float64 { return outputs[s*numOut : (s+1)*numOut] })
+}
+
+// clone returns a copy of the network with its own parameters and buffers.
+func (nn NetworkLvl0) clone() NetworkLvl0 {
+	layers := make([]LayerLvl0, len(nn.layers))
+	for i, layer := range nn.layers {
+		layers[i] = layer
+		layers[i].weights = make([][]float64, len(layer.weights))
+		for nodeIn := range layer.weights {
+			layers[i].weights[nodeIn] = slices.Clone(layer.weights[nodeIn])
+		}
+		layers[i].biases = slices.Clone(layer.biases)
+		layers[i].activations = make([]float64, len(layer.activations))
+	}
+	return NetworkLvl0{layers: layers}
+}
+
+// copyParams copies the weights and biases of src, a network of the same
+// layer sizes, into nn.
+func (nn NetworkLvl0) copyParams(src NetworkLvl0) {
+	for i, layer := range nn.layers {
+		for nodeIn := range layer.weights {
+			copy(layer.weights[nodeIn], src.layers[i].weights[nodeIn])
+		}
+		copy(layer.biases, src.layers[i].biases)
+	}
+}
+
 type LayerLvl0 struct {
 	weights            [][]float64
 	biases             []float64
 	activationFunction func(v float64) float64
+	// activations is the output buffer of CalculateOutputs.
+	activations []float64
 }
 
 func (l LayerLvl0) Dims() (input, output int) {
@@ -79,22 +127,29 @@ func (l LayerLvl0) Dims() (input, output int) {
 func newLayerLvl0(numNodesIn, numNodesOut int, activationFunction func(float64) float64) LayerLvl0 {
 	nn := LayerLvl0{
 		weights:            make([][]float64, numNodesIn),
-		biases:             randomSlice(numNodesOut, 2, -1, defaultRng),
+		biases:             randomSlice[float64](numNodesOut, 2, -1, defaultRng),
 		activationFunction: activationFunction,
+		activations:        make([]float64, numNodesOut),
 	}
 	invSqrtNumNodesIn := 1 / math.Sqrt(float64(numNodesIn))
 	for nodeIn := range nn.weights {
-		nn.weights[nodeIn] = randomSlice(numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
+		nn.weights[nodeIn] = randomSlice[float64](numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
 	}
 	return nn
 }
 
-// CalculateOutputs runs the inputs through the layer and
+// CalculateOutputs runs the inputs through the layer and returns its activations.
+// The returned activations are owned by the layer and overwritten by the next call.
 func (layer LayerLvl0) CalculateOutputs(inputs []float64) (activations []float64) {
+	layer.storeOutputs(inputs, layer.activations)
+	return layer.activations
+}
+
+// storeOutputs stores the activations of the layer for inputs in activations.
+func (layer LayerLvl0) storeOutputs(inputs, activations []float64) {
 	numNodesIn, numNodesOut := layer.Dims()
 	// activations contains the result of the input feedthrough the weights
 	// its elements are commonly called "weighted inputs".
-	activations = make([]float64, numNodesOut)
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
 		weightedInput := layer.biases[nodeOut]
 		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
@@ -102,7 +157,6 @@ func (layer LayerLvl0) CalculateOutputs(inputs []float64) (activations []float64
 		}
 		activations[nodeOut] = layer.activationFunction(weightedInput)
 	}
-	return activations
 }
 
 func (nn *NetworkLvl0) Export() (setup []LayerSetup) {
@@ -118,3 +172,21 @@ func (nn *NetworkLvl0) Export() (setup []LayerSetup) {
 	}
 	return setup
 }
+
+// Import replaces the weights and biases of each layer with those of setup,
+// as returned by Export of a Level 0, 1, 2 or 3 network. Layer dimensions must match.
+func (nn NetworkLvl0) Import(setup []LayerSetup) {
+	if len(setup) != len(nn.layers) {
+		panic("number of layers mismatch")
+	}
+	for i, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		if in, out := setup[i].Dims(); in != numNodesIn || out != numNodesOut {
+			panic("layer dimensions mismatch")
+		}
+		copy(layer.biases, setup[i].Biases)
+		for nodeIn, weights := range setup[i].Weights {
+			copy(layer.weights[nodeIn], weights)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"testing"
 
 	"github.com/soypat/neurus"
 	"github.com/soypat/neurus/mnist"
@@ -109,3 +110,32 @@ func maxf(s []float64) int {
 	}
 	return idx
 }
+
+func TestNetworkLvl0_allocs(t *testing.T) {
+	nn := neurus.NewNetworkLvl0(neurus.Sigmoid, 2, 4, 2)
+	trainer := neurus.NewTrainerFromNetworkLvl0(nn)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	batch := m.Generate2DData(10)
+	dp := batch[0]
+	if allocs := testing.AllocsPerRun(100, func() { nn.Classify(dp.ExpectedOutput, dp.Input) }); allocs != 0 {
+		t.Errorf("Classify allocated %v times per call", allocs)
+	}
+	if allocs := testing.AllocsPerRun(10, func() { trainer.TrainLvl0(nn, batch, 0.0001, 0.05) }); allocs != 0 {
+		t.Errorf("TrainLvl0 allocated %v times per call", allocs)
+	}
+	// Workers perturb clones of nn kept by the trainer so only their goroutines allocate.
+	const procs = 4
+	if allocs := allocsPerRun(procs, 10, func() { trainer.TrainLvl0(nn, batch, 0.0001, 0.05) }); allocs > 2*procs+2 {
+		t.Errorf("TrainLvl0 with GOMAXPROCS=%d allocated %v times per call", procs, allocs)
+	}
+}
+
+func BenchmarkNetworkLvl0_CalculateOutputs(b *testing.B) {
+	nn := neurus.NewNetworkLvl0(neurus.Sigmoid, mnist.PixelCount, 16, 10)
+	input := make([]float64, mnist.PixelCount)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nn.CalculateOutputs(input)
+	}
+}
@@ -1,7 +1,18 @@
 package neurus
 
 type TrainerLvl0 struct {
-	layers []layerTrainerLvl0
+	// CentralDifference computes gradients with central differences
+	// (C(w+h) - C(w-h)) / 2h instead of forward differences (C(w+h) - C(w)) / h.
+	// They are more accurate but cost twice as many passes of the training data.
+	CentralDifference bool
+	// Workers is the maximum number of goroutines computing gradients, each on
+	// its own copy of the network. Defaults to runtime.GOMAXPROCS(0).
+	// The gradients do not depend on the number of workers.
+	Workers int
+	layers  []layerTrainerLvl0
+	// networks are the networks perturbed by each worker. The first is the
+	// trained network and the rest are clones of it kept between calls.
+	networks *[]NetworkLvl0
 }
 
 type layerTrainerLvl0 struct {
@@ -20,6 +31,8 @@ func NewTrainerFromNetworkLvl0(nn NetworkLvl0) (tr TrainerLvl0) {
 			tr.layers[layerIdx].costGradW[i] = make([]float64, len(layer.weights[i]))
 		}
 	}
+	tr.networks = new([]NetworkLvl0)
+	tr.workerNetworks(nn, numGradientWorkers(nn.numParams(), gradientBlockSize, 0))
 	return tr
 }
 
@@ -35,30 +48,71 @@ func (nn NetworkLvl0) Cost(trainingData []DataPoint) (totalCost float64) {
 
 func (tr TrainerLvl0) TrainLvl0(nn NetworkLvl0, trainingData []DataPoint, h, learnRate float64) {
 	originalCost := nn.Cost(trainingData)
+	numParams := nn.numParams()
+	numWorkers := numGradientWorkers(numParams, gradientBlockSize, tr.Workers)
+	if numWorkers == 1 {
+		tr.calculateGradients(nn, trainingData, h, originalCost, 0, numParams)
+	} else {
+		networks := tr.workerNetworks(nn, numWorkers)
+		parallelGradients(numParams, gradientBlockSize, numWorkers, func(w, start, end int) {
+			tr.calculateGradients(networks[w], trainingData, h, originalCost, start, end)
+		})
+	}
+	for i, layer := range nn.layers {
+		trLayer := tr.layers[i]
+		trLayer.applyAllGradients(layer, learnRate)
+	}
+}
+
+// workerNetworks returns the networks perturbed by numWorkers workers, nn
+// followed by clones of it with its parameters copied.
+func (tr TrainerLvl0) workerNetworks(nn NetworkLvl0, numWorkers int) []NetworkLvl0 {
+	networks := *tr.networks
+	if len(networks) == 0 {
+		networks = append(networks, nn)
+	}
+	for len(networks) < numWorkers {
+		networks = append(networks, nn.clone())
+	}
+	*tr.networks = networks
+	networks = networks[:numWorkers]
+	networks[0] = nn
+	for _, clone := range networks[1:] {
+		clone.copyParams(nn)
+	}
+	return networks
+}
+
+// numParams returns the number of weights and biases of the network.
+func (nn NetworkLvl0) numParams() (numParams int) {
+	for _, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		numParams += (numNodesIn + 1) * numNodesOut
+	}
+	return numParams
+}
+
+// calculateGradients stores the cost gradient of the parameters of nn with
+// indices in [start, end) in the trainer. Parameters are indexed layer by
+// layer with the weights of a layer, row by row, before its biases.
+func (tr TrainerLvl0) calculateGradients(nn NetworkLvl0, trainingData []DataPoint, h, originalCost float64, start, end int) {
+	cost := func() float64 { return nn.Cost(trainingData) }
+	offset := 0
 	for layerIdx, layer := range nn.layers {
 		trLayer := tr.layers[layerIdx]
 		numNodesIn, numNodesOut := layer.Dims()
-		// Calculate cost gradient for current weights.
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-				layer.weights[nodeIn][nodeOut] += h
-				costDifference := nn.Cost(trainingData) - originalCost
-				trLayer.costGradW[nodeIn][nodeOut] = costDifference / h
-				layer.weights[nodeIn][nodeOut] -= h // Set the layer weight back to original value.
+		numWeights := numNodesIn * numNodesOut
+		lo, hi := paramRange(offset, numWeights+numNodesOut, start, end)
+		for i := lo; i < hi; i++ {
+			if i < numWeights {
+				nodeIn, nodeOut := i/numNodesOut, i%numNodesOut
+				trLayer.costGradW[nodeIn][nodeOut] = finiteDifference(cost, &layer.weights[nodeIn][nodeOut], h, originalCost, tr.CentralDifference)
+			} else {
+				biasIndex := i - numWeights
+				trLayer.costGradB[biasIndex] = finiteDifference(cost, &layer.biases[biasIndex], h, originalCost, tr.CentralDifference)
 			}
 		}
-
-		// Calculate the cost gradient for the current biases.
-		for biasIndex := range layer.biases {
-			layer.biases[biasIndex] += h
-			costDifference := nn.Cost(trainingData) - originalCost
-			trLayer.costGradB[biasIndex] = costDifference / h
-			layer.biases[biasIndex] -= h // Reset layer bias to original value.
-		}
-	}
-	for i, layer := range nn.layers {
-		trLayer := tr.layers[i]
-		trLayer.applyAllGradients(layer, learnRate)
+		offset += numWeights + numNodesOut
 	}
 }
 
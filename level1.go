@@ -2,15 +2,18 @@ package neurus
 
 import (
 	"math"
+
+	"golang.org/x/exp/slices"
 )
 
 // This file contains a Neural Network
-// as envisioned by Sebastian Lague. This
+// as envisioned by Sebastian Lague. The network is the same as Level 0's,
+// the difference lies in how it is trained, see TrainerLvl1.
 
-// NetworkLvl1 is the most basic functional neural network
-// (for some definition of "most") that may have an arbitrary number
-// of layers or nodes.
-// Directly ported from Sebastian Lague's "How to Create a Neural Network (and Train it to Identify Doodles"
+// NetworkLvl1 is a neural network of the same structure as NetworkLvl0
+// trained by stochastic gradient descent with finite difference gradients
+// computed from cached layer outputs.
+// Based on Sebastian Lague's "How to Create a Neural Network (and Train it to Identify Doodles"
 // https://www.youtube.com/watch?v=hfMk-kjRv4c.
 type NetworkLvl1 struct {
 	layers []LayerLvl1
@@ -28,6 +31,7 @@ func NewNetworkLvl1(activationFunction func(float64) float64, layerSizes ...int)
 
 // CalculateOutputs runs the inputs through the network and returns the output values.
 // This is also known as feeding the neural network, or Feedthrough.
+// The returned slice is reused by the network and overwritten by the next call.
 func (nn NetworkLvl1) CalculateOutputs(input []float64) []float64 {
 	for _, layer := range nn.layers {
 		input = layer.CalculateOutputs(input)
@@ -61,10 +65,31 @@ func (nn NetworkLvl1) Dims() (input, output int) {
 	return input, output
 }
 
+// PredictBatch stores the outputs of the network for each row of inputs in the
+// corresponding row of outputs. The rows are split among GOMAXPROCS goroutines.
+func (nn NetworkLvl1) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := nn.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, len(inputs),
+		func(s int) []float64 { return inputs[s] },
+		func(s int) []float64 { return outputs[s] })
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (nn NetworkLvl1) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := nn.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, n,
+		func(s int) []float64 { return inputs[s*numIn : (s+1)*numIn] },
+		func(s int) []float64 { return outputs[s*numOut : (s+1)*numOut] })
+}
+
 type LayerLvl1 struct {
 	weights            [][]float64
 	biases             []float64
 	activationFunction func(v float64) float64
+	// activations is the output buffer of CalculateOutputs.
+	activations []float64
 }
 
 func (l LayerLvl1) Dims() (input, output int) {
@@ -74,28 +99,71 @@ func (l LayerLvl1) Dims() (input, output int) {
 func newLayerLvl1(numNodesIn, numNodesOut int, activationFunction func(float64) float64) LayerLvl1 {
 	nn := LayerLvl1{
 		weights:            make([][]float64, numNodesIn),
-		biases:             randomSlice(numNodesOut, 2, -1, defaultRng),
+		biases:             randomSlice[float64](numNodesOut, 2, -1, defaultRng),
 		activationFunction: activationFunction,
+		activations:        make([]float64, numNodesOut),
 	}
 	invSqrtNumNodesIn := 1 / math.Sqrt(float64(numNodesIn))
 	for nodeIn := range nn.weights {
-		nn.weights[nodeIn] = randomSlice(numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
+		nn.weights[nodeIn] = randomSlice[float64](numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
 	}
 	return nn
 }
 
-// CalculateOutputs runs the inputs through the layer and
+// CalculateOutputs runs the inputs through the layer and returns its activations.
+// The returned activations are owned by the layer and overwritten by the next call.
 func (layer LayerLvl1) CalculateOutputs(inputs []float64) (activations []float64) {
+	layer.storeOutputs(inputs, layer.activations)
+	return layer.activations
+}
+
+// storeOutputs stores the activations of the layer for inputs in activations.
+func (layer LayerLvl1) storeOutputs(inputs, activations []float64) {
+	layer.storeWeightedInputs(inputs, activations, activations)
+}
+
+// storeWeightedInputs stores the weighted inputs of the layer for inputs in
+// weightedInputs and their activations in activations. They may be the same slice.
+func (layer LayerLvl1) storeWeightedInputs(inputs, weightedInputs, activations []float64) {
 	numNodesIn, numNodesOut := layer.Dims()
-	// activations contains the result of the input feedthrough the weights
-	// its elements are commonly called "weighted inputs".
-	activations = make([]float64, numNodesOut)
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
 		weightedInput := layer.biases[nodeOut]
 		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			weightedInput += inputs[nodeIn] * layer.weights[nodeIn][nodeOut] // Simultaneous access hotspot?
+			weightedInput += inputs[nodeIn] * layer.weights[nodeIn][nodeOut]
 		}
+		weightedInputs[nodeOut] = weightedInput
 		activations[nodeOut] = layer.activationFunction(weightedInput)
 	}
-	return activations
+}
+
+func (nn *NetworkLvl1) Export() (setup []LayerSetup) {
+	for _, layer := range nn.layers {
+		weights := make([][]float64, len(layer.weights))
+		for j := range weights {
+			weights[j] = slices.Clone(layer.weights[j])
+		}
+		setup = append(setup, LayerSetup{
+			Weights: weights,
+			Biases:  slices.Clone(layer.biases),
+		})
+	}
+	return setup
+}
+
+// Import replaces the weights and biases of each layer with those of setup,
+// as returned by Export of a Level 0, 1, 2 or 3 network. Layer dimensions must match.
+func (nn NetworkLvl1) Import(setup []LayerSetup) {
+	if len(setup) != len(nn.layers) {
+		panic("number of layers mismatch")
+	}
+	for i, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		if in, out := setup[i].Dims(); in != numNodesIn || out != numNodesOut {
+			panic("layer dimensions mismatch")
+		}
+		copy(layer.biases, setup[i].Biases)
+		for nodeIn, weights := range setup[i].Weights {
+			copy(layer.weights[nodeIn], weights)
+		}
+	}
 }
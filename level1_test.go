@@ -63,3 +63,22 @@ func TestNetworkLvl1_twoD(t *testing.T) {
 	}
 	t.Logf("initial cost: %f, final cost: %f", initialCost, finalCost)
 }
+
+func TestNetworkLvl1_allocs(t *testing.T) {
+	nn := neurus.NewNetworkLvl1(neurus.Sigmoid, 2, 4, 2)
+	trainer := neurus.NewTrainerFromNetworkLvl1(nn)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	batch := m.Generate2DData(10)
+	if allocs := testing.AllocsPerRun(10, func() { trainer.Train(nn, batch, 0.0001, 0.05) }); allocs != 0 {
+		t.Errorf("Train allocated %v times per call", allocs)
+	}
+	// Workers keep their buffers in the trainer so only the goroutines started
+	// for each data point allocate.
+	const procs = 4
+	nn = neurus.NewNetworkLvl1(neurus.Sigmoid, 2, 64, 64, 2)
+	trainer = neurus.NewTrainerFromNetworkLvl1(nn)
+	maxAllocs := float64(len(batch) * (2*procs + 2))
+	if allocs := allocsPerRun(procs, 10, func() { trainer.Train(nn, batch, 0.0001, 0.05) }); allocs > maxAllocs {
+		t.Errorf("Train with GOMAXPROCS=%d allocated %v times per call", procs, allocs)
+	}
+}
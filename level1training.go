@@ -1,7 +1,24 @@
 package neurus
 
+// TrainerLvl1 trains a NetworkLvl1 with stochastic gradient descent, updating the
+// network after every data point. Gradients are still finite differences as in
+// Level 0, but each is computed from the layer outputs cached by a single forward
+// pass of the data point: perturbing a weight or bias of a node changes only the
+// weighted input of that node, so only the nodes downstream of it are recomputed.
 type TrainerLvl1 struct {
-	layers []layerTrainerLvl1
+	// CentralDifference computes gradients with central differences
+	// (C(w+h) - C(w-h)) / 2h instead of forward differences (C(w+h) - C(w)) / h.
+	// They are more accurate but cost twice as many evaluations.
+	CentralDifference bool
+	// Workers is the maximum number of goroutines computing gradients, each with
+	// its own buffers. Defaults to runtime.GOMAXPROCS(0). Networks of a few
+	// hundred parameters are trained by a single goroutine.
+	// The gradients do not depend on the number of workers.
+	Workers int
+	layers  []layerTrainerLvl1
+	// scratch holds the activations of each layer downstream of a perturbed node
+	// for each worker. It is kept between calls and grown when Workers is raised.
+	scratch *[][][]float64
 }
 
 type layerTrainerLvl1 struct {
@@ -9,6 +26,10 @@ type layerTrainerLvl1 struct {
 	costGradB []float64
 	// Weight cost gradient.
 	costGradW [][]float64
+	// weightedInputs and activations are the outputs of the layer cached by the
+	// last forward pass.
+	weightedInputs []float64
+	activations    []float64
 }
 
 func NewTrainerFromNetworkLvl1(nn NetworkLvl1) (tr TrainerLvl1) {
@@ -19,7 +40,11 @@ func NewTrainerFromNetworkLvl1(nn NetworkLvl1) (tr TrainerLvl1) {
 		for i := range layer.weights {
 			tr.layers[layerIdx].costGradW[i] = make([]float64, len(layer.weights[i]))
 		}
+		tr.layers[layerIdx].weightedInputs = make([]float64, len(layer.biases))
+		tr.layers[layerIdx].activations = make([]float64, len(layer.biases))
 	}
+	tr.scratch = new([][][]float64)
+	tr.workerScratch(numGradientWorkers(nn.numParams(), gradientBlockSizeLvl1, 0))
 	return tr
 }
 
@@ -33,48 +58,142 @@ func (nn NetworkLvl1) Cost(trainingData []DataPoint) (totalCost float64) {
 	return totalCost / float64(len(trainingData))
 }
 
+// Train takes a gradient descent step for each data point in turn. h is the
+// finite difference step and each step moves the parameters by learnRate times
+// the gradient of the cost of the data point.
 func (tr TrainerLvl1) Train(nn NetworkLvl1, trainingData []DataPoint, h, learnRate float64) {
-	originalCost := nn.Cost(trainingData)
+	for _, dp := range trainingData {
+		tr.UpdateAllGradients(nn, dp, h)
+		for i, layer := range nn.layers {
+			tr.layers[i].applyAllGradients(layer, learnRate)
+		}
+	}
+}
+
+// UpdateAllGradients runs dp through the network caching the outputs of every
+// layer, then stores the finite difference gradient of the cost of dp with
+// respect to every weight and bias in the trainer.
+func (tr TrainerLvl1) UpdateAllGradients(nn NetworkLvl1, dp DataPoint, h float64) {
+	input := dp.Input
+	for i, layer := range nn.layers {
+		trLayer := tr.layers[i]
+		layer.storeWeightedInputs(input, trLayer.weightedInputs, trLayer.activations)
+		input = trLayer.activations
+	}
+	originalCost := costLvl1(input, dp.ExpectedOutput)
+
+	numParams := nn.numParams()
+	numWorkers := numGradientWorkers(numParams, gradientBlockSizeLvl1, tr.Workers)
+	scratch := tr.workerScratch(numWorkers)
+	if numWorkers == 1 {
+		tr.calculateGradients(nn, dp, h, originalCost, scratch[0], 0, numParams)
+		return
+	}
+	parallelGradients(numParams, gradientBlockSizeLvl1, numWorkers, func(w, start, end int) {
+		tr.calculateGradients(nn, dp, h, originalCost, scratch[w], start, end)
+	})
+}
+
+// workerScratch returns the scratch buffers of numWorkers workers.
+func (tr TrainerLvl1) workerScratch(numWorkers int) [][][]float64 {
+	for len(*tr.scratch) < numWorkers {
+		*tr.scratch = append(*tr.scratch, tr.newScratch())
+	}
+	return (*tr.scratch)[:numWorkers]
+}
+
+// numParams returns the number of weights and biases of the network.
+func (nn NetworkLvl1) numParams() (numParams int) {
+	for _, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		numParams += (numNodesIn + 1) * numNodesOut
+	}
+	return numParams
+}
+
+// calculateGradients stores the cost gradient of the parameters with indices in
+// [start, end) in the trainer. Parameters are indexed layer by layer with the
+// weights of a layer, row by row, before its biases.
+func (tr TrainerLvl1) calculateGradients(nn NetworkLvl1, dp DataPoint, h, originalCost float64, scratch [][]float64, start, end int) {
+	gradient := func(layerIdx, nodeOut int, dz float64) float64 {
+		if dz == 0 {
+			return 0 // Zero inputs have no effect on the cost.
+		}
+		plus := tr.perturbedCost(nn, layerIdx, nodeOut, dz, dp.ExpectedOutput, scratch)
+		if tr.CentralDifference {
+			return (plus - tr.perturbedCost(nn, layerIdx, nodeOut, -dz, dp.ExpectedOutput, scratch)) / (2 * h)
+		}
+		return (plus - originalCost) / h
+	}
+	offset := 0
+	input := dp.Input
 	for layerIdx, layer := range nn.layers {
 		trLayer := tr.layers[layerIdx]
 		numNodesIn, numNodesOut := layer.Dims()
-		// Calculate cost gradient for current weights.
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-				layer.weights[nodeIn][nodeOut] += h
-				costDifference := nn.Cost(trainingData) - originalCost
-				trLayer.costGradW[nodeIn][nodeOut] = costDifference / h
-				layer.weights[nodeIn][nodeOut] -= h // Set the layer weight back to original value.
+		numWeights := numNodesIn * numNodesOut
+		lo, hi := paramRange(offset, numWeights+numNodesOut, start, end)
+		for i := lo; i < hi; i++ {
+			if i < numWeights {
+				// Perturbing weight nodeIn→nodeOut by h perturbs the weighted input of nodeOut by h*input[nodeIn].
+				nodeIn, nodeOut := i/numNodesOut, i%numNodesOut
+				trLayer.costGradW[nodeIn][nodeOut] = gradient(layerIdx, nodeOut, h*input[nodeIn])
+			} else {
+				biasIndex := i - numWeights
+				trLayer.costGradB[biasIndex] = gradient(layerIdx, biasIndex, h)
 			}
 		}
+		offset += numWeights + numNodesOut
+		input = trLayer.activations
+	}
+}
 
-		// Calculate the cost gradient for the current biases.
-		for biasIndex := range layer.biases {
-			layer.biases[biasIndex] += h
-			costDifference := nn.Cost(trainingData) - originalCost
-			trLayer.costGradB[biasIndex] = costDifference / h
-			layer.biases[biasIndex] -= h // Reset layer bias to original value.
-		}
+// perturbedCost returns the cost of the cached data point when the weighted input
+// of node nodeOut of layer layerIdx is changed by dz. The activations of the
+// layers downstream of the node are stored in scratch.
+func (tr TrainerLvl1) perturbedCost(nn NetworkLvl1, layerIdx, nodeOut int, dz float64, expectedOutput []float64, scratch [][]float64) float64 {
+	trLayer := tr.layers[layerIdx]
+	activation := nn.layers[layerIdx].activationFunction(trLayer.weightedInputs[nodeOut] + dz)
+	outputs := scratch[layerIdx]
+	if layerIdx == len(nn.layers)-1 {
+		copy(outputs, trLayer.activations)
+		outputs[nodeOut] = activation
+		return costLvl1(outputs, expectedOutput)
 	}
-	for i, layer := range nn.layers {
-		trLayer := tr.layers[i]
-		trLayer.applyAllGradients(layer, learnRate)
+	// Only one input of the next layer changed so its weighted inputs are
+	// corrected rather than recomputed.
+	delta := activation - trLayer.activations[nodeOut]
+	next, trNext := nn.layers[layerIdx+1], tr.layers[layerIdx+1]
+	outputs = scratch[layerIdx+1]
+	for j := range outputs {
+		outputs[j] = next.activationFunction(trNext.weightedInputs[j] + next.weights[nodeOut][j]*delta)
+	}
+	for l := layerIdx + 2; l < len(nn.layers); l++ {
+		nn.layers[l].storeOutputs(outputs, scratch[l])
+		outputs = scratch[l]
 	}
+	return costLvl1(outputs, expectedOutput)
 }
 
-func (trl layerTrainerLvl1) applyAllGradients(layer LayerLvl1, learnRate float64) {
-	numNodesIn, numNodesOut := layer.Dims()
-	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-		layer.biases[nodeOut] -= trl.costGradB[nodeOut] * learnRate
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			layer.weights[nodeIn][nodeOut] -= trl.costGradW[nodeIn][nodeOut] * learnRate
-		}
+// newScratch returns buffers for the activations of each layer.
+func (tr TrainerLvl1) newScratch() [][]float64 {
+	scratch := make([][]float64, len(tr.layers))
+	for i, trLayer := range tr.layers {
+		scratch[i] = make([]float64, len(trLayer.activations))
 	}
+	return scratch
 }
 
-func (trl layerTrainerLvl1) UpdateAllGradients(layer LayerLvl1, dp DataPoint, learnRate float64) {
+// costLvl1 returns the cost of a single data point as computed by NetworkLvl1.Classify.
+func costLvl1(outputs, expectedOutput []float64) (cost float64) {
+	for nodeOut, activation := range outputs {
+		err := activation - expectedOutput[nodeOut]
+		cost += err * err
+	}
+	return cost
+}
+
+func (trl layerTrainerLvl1) applyAllGradients(layer LayerLvl1, learnRate float64) {
 	numNodesIn, numNodesOut := layer.Dims()
-	_ = layer.CalculateOutputs(dp.Input)
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
 		layer.biases[nodeOut] -= trl.costGradB[nodeOut] * learnRate
 		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
@@ -2,6 +2,8 @@ package neurus
 
 import (
 	"math"
+
+	"golang.org/x/exp/slices"
 )
 
 // This file contains a Neural Network trained using
@@ -30,6 +32,7 @@ func NewNetworkLvl2(activationFunction, activationDerivative func(float64) float
 
 // CalculateOutputs runs the inputs through the network and returns the output values.
 // This is also known as feeding the neural network, or Feedthrough.
+// The returned slice is reused by the network and overwritten by the next call.
 func (nn NetworkLvl2) CalculateOutputs(input []float64) []float64 {
 	for _, layer := range nn.layers {
 		input = layer.CalculateOutputs(input)
@@ -63,11 +66,32 @@ func (nn NetworkLvl2) Dims() (input, output int) {
 	return input, output
 }
 
+// PredictBatch stores the outputs of the network for each row of inputs in the
+// corresponding row of outputs. The rows are split among GOMAXPROCS goroutines.
+func (nn NetworkLvl2) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := nn.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, len(inputs),
+		func(s int) []float64 { return inputs[s] },
+		func(s int) []float64 { return outputs[s] })
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (nn NetworkLvl2) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := nn.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	predictLevel(nn.layers, n,
+		func(s int) []float64 { return inputs[s*numIn : (s+1)*numIn] },
+		func(s int) []float64 { return outputs[s*numOut : (s+1)*numOut] })
+}
+
 type LayerLvl2 struct {
 	weights              [][]float64
 	biases               []float64
 	activationFunction   func(v float64) float64
 	activationDerivative func(v float64) float64
+	// activations is the output buffer of CalculateOutputs.
+	activations []float64
 }
 
 func (l LayerLvl2) Dims() (input, output int) {
@@ -77,21 +101,28 @@ func (l LayerLvl2) Dims() (input, output int) {
 func newLayerLvl2(numNodesIn, numNodesOut int, activationFunction, activationDerivative func(float64) float64) LayerLvl2 {
 	nn := LayerLvl2{
 		weights:              make([][]float64, numNodesIn),
-		biases:               randomSlice(numNodesOut, 2, -1, defaultRng),
+		biases:               randomSlice[float64](numNodesOut, 2, -1, defaultRng),
 		activationFunction:   activationFunction,
 		activationDerivative: activationDerivative,
+		activations:          make([]float64, numNodesOut),
 	}
 	invSqrtNumNodesIn := 1 / math.Sqrt(float64(numNodesIn))
 	for nodeIn := range nn.weights {
-		nn.weights[nodeIn] = randomSlice(numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
+		nn.weights[nodeIn] = randomSlice[float64](numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
 	}
 	return nn
 }
 
-// CalculateOutputs runs the inputs through the layer.
+// CalculateOutputs runs the inputs through the layer. The returned
+// activations are owned by the layer and overwritten by the next call.
 func (layer LayerLvl2) CalculateOutputs(inputs []float64) (activations []float64) {
+	layer.storeOutputs(inputs, layer.activations)
+	return layer.activations
+}
+
+// storeOutputs stores the activations of the layer for inputs in activations.
+func (layer LayerLvl2) storeOutputs(inputs, activations []float64) {
 	numNodesIn, numNodesOut := layer.Dims()
-	activations = make([]float64, numNodesOut)
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
 		weightedInput := layer.biases[nodeOut]
 		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
@@ -99,7 +130,39 @@ func (layer LayerLvl2) CalculateOutputs(inputs []float64) (activations []float64
 		}
 		activations[nodeOut] = layer.activationFunction(weightedInput)
 	}
-	return activations
+}
+
+// Export returns the weights and biases of each layer.
+func (nn NetworkLvl2) Export() (setup []LayerSetup) {
+	for _, layer := range nn.layers {
+		weights := make([][]float64, len(layer.weights))
+		for j := range weights {
+			weights[j] = slices.Clone(layer.weights[j])
+		}
+		setup = append(setup, LayerSetup{
+			Weights: weights,
+			Biases:  slices.Clone(layer.biases),
+		})
+	}
+	return setup
+}
+
+// Import replaces the weights and biases of each layer with those of setup,
+// as returned by Export of a Level 0, 1, 2 or 3 network. Layer dimensions must match.
+func (nn NetworkLvl2) Import(setup []LayerSetup) {
+	if len(setup) != len(nn.layers) {
+		panic("number of layers mismatch")
+	}
+	for i, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		if in, out := setup[i].Dims(); in != numNodesIn || out != numNodesOut {
+			panic("layer dimensions mismatch")
+		}
+		copy(layer.biases, setup[i].Biases)
+		for nodeIn, weights := range setup[i].Weights {
+			copy(layer.weights[nodeIn], weights)
+		}
+	}
 }
 
 // SigmoidDerivative is the derivative of the Sigmoid activation function.
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/soypat/neurus"
+	"github.com/soypat/neurus/mnist"
 )
 
 func ExampleNetworkLvl2_twoD() {
@@ -42,16 +43,19 @@ func TestNetworkLvl2_twoD(t *testing.T) {
 		epochs    = 2000
 	)
 
-	m := neurus.NewModel2D(2, basic2DClassifier)
-	trainData := m.Generate2DData(400)
-	testData := m.Generate2DData(100)
+	// Weights and data are drawn from rng so that the test does not depend
+	// on the randomly seeded global source.
+	rng := rand.New(rand.NewSource(1))
+	trainData := random2DData(rng, 400)
+	testData := random2DData(rng, 100)
 
 	nn := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 2, 2, 2, 2)
+	nn.Import(randomSetup(rng, 2, 2, 2, 2))
 	initialCost := nn.Cost(testData)
 
 	trainer := neurus.NewTrainerFromNetworkLvl2(nn)
 	for epoch := 0; epoch < epochs; epoch++ {
-		startIdx := rand.Intn(len(trainData) - batchSize)
+		startIdx := rng.Intn(len(trainData) - batchSize)
 		miniBatch := trainData[startIdx : startIdx+batchSize]
 		trainer.Train(nn, miniBatch, learnRate)
 	}
@@ -61,3 +65,46 @@ func TestNetworkLvl2_twoD(t *testing.T) {
 	}
 	t.Logf("initial cost: %f, final cost: %f", initialCost, finalCost)
 }
+
+func TestTrainerLvl2_gradientCheck(t *testing.T) {
+	for _, test := range []struct {
+		name                 string
+		activation, gradient func(float64) float64
+	}{
+		{name: "sigmoid", activation: neurus.Sigmoid, gradient: neurus.SigmoidDerivative},
+		{name: "relu", activation: neurus.ReLU, gradient: neurus.ReLUDerivative},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			nn := neurus.NewNetworkLvl2(test.activation, test.gradient, 3, 5, 2)
+			tr := neurus.NewTrainerFromNetworkLvl2(nn)
+			checkGradients(t, tr.GradientModel(nn), gradientData(rng, 4, 3, 2))
+		})
+	}
+}
+
+func TestNetworkLvl2_allocs(t *testing.T) {
+	nn := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 2, 4, 4, 2)
+	trainer := neurus.NewTrainerFromNetworkLvl2(nn)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	batch := m.Generate2DData(10)
+	dp := batch[0]
+	if allocs := testing.AllocsPerRun(100, func() { nn.Classify(dp.ExpectedOutput, dp.Input) }); allocs != 0 {
+		t.Errorf("Classify allocated %v times per call", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { trainer.Train(nn, batch, 0.05) }); allocs != 0 {
+		t.Errorf("Train allocated %v times per call", allocs)
+	}
+}
+
+func BenchmarkTrainerLvl2_Train(b *testing.B) {
+	nn := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, mnist.PixelCount, 16, 10)
+	trainer := neurus.NewTrainerFromNetworkLvl2(nn)
+	_, test, _ := mnist.Load64()
+	batch := neurus.MNISTToDatapoints(test[:32])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trainer.Train(nn, batch, 0.05)
+	}
+}
@@ -9,6 +9,12 @@ type layerTrainerLvl2 struct {
 	costGradB []float64
 	// Weight cost gradient.
 	costGradW [][]float64
+	// Workspace of UpdateAllGradients holding the values of the layer
+	// for the data point being backpropagated.
+	inputs         []float64
+	weightedInputs []float64
+	activations    []float64
+	nodeValues     []float64
 }
 
 func NewTrainerFromNetworkLvl2(nn NetworkLvl2) (tr TrainerLvl2) {
@@ -19,6 +25,11 @@ func NewTrainerFromNetworkLvl2(nn NetworkLvl2) (tr TrainerLvl2) {
 		for i := range layer.weights {
 			tr.layers[layerIdx].costGradW[i] = make([]float64, len(layer.weights[i]))
 		}
+		numNodesIn, numNodesOut := layer.Dims()
+		tr.layers[layerIdx].inputs = make([]float64, numNodesIn)
+		tr.layers[layerIdx].weightedInputs = make([]float64, numNodesOut)
+		tr.layers[layerIdx].activations = make([]float64, numNodesOut)
+		tr.layers[layerIdx].nodeValues = make([]float64, numNodesOut)
 	}
 	return tr
 }
@@ -71,25 +82,21 @@ func (tr TrainerLvl2) UpdateAllGradients(nn NetworkLvl2, dp DataPoint) {
 	// Feed input through each layer, storing the intermediate values
 	// needed for backpropagation: the inputs to each layer, the weighted
 	// sums (before activation), and the activations (after activation).
-	layerInputs := make([][]float64, numLayers)
-	weightedInputs := make([][]float64, numLayers)
-	layerActivations := make([][]float64, numLayers)
+	// They are stored in the trainer's preallocated workspace.
 	input := dp.Input
 	for i, layer := range nn.layers {
+		trLayer := tr.layers[i]
 		numNodesIn, numNodesOut := layer.Dims()
-		layerInputs[i] = make([]float64, numNodesIn)
-		copy(layerInputs[i], input)
-		weightedInputs[i] = make([]float64, numNodesOut)
-		layerActivations[i] = make([]float64, numNodesOut)
+		copy(trLayer.inputs, input)
 		for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
 			weightedInput := layer.biases[nodeOut]
 			for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
 				weightedInput += input[nodeIn] * layer.weights[nodeIn][nodeOut]
 			}
-			weightedInputs[i][nodeOut] = weightedInput
-			layerActivations[i][nodeOut] = layer.activationFunction(weightedInput)
+			trLayer.weightedInputs[nodeOut] = weightedInput
+			trLayer.activations[nodeOut] = layer.activationFunction(weightedInput)
 		}
-		input = layerActivations[i]
+		input = trLayer.activations
 	}
 
 	// Phase 2: Backward pass.
@@ -98,17 +105,16 @@ func (tr TrainerLvl2) UpdateAllGradients(nn NetworkLvl2, dp DataPoint) {
 	//   nodeValue = dC/dz
 	// Using the chain rule: dC/dz = dC/da * da/dz
 	//   where a = activation(z), so da/dz = activationDerivative(z).
-	nodeValues := make([][]float64, numLayers)
 
 	// Output layer: dC/dz = dC/da * activationDerivative(z)
 	// For squared error cost C = (a - y)^2, the derivative is dC/da = 2*(a - y).
 	outputIdx := numLayers - 1
 	outputLayer := nn.layers[outputIdx]
+	outputTrLayer := tr.layers[outputIdx]
 	_, numOutputs := outputLayer.Dims()
-	nodeValues[outputIdx] = make([]float64, numOutputs)
 	for j := 0; j < numOutputs; j++ {
-		costDerivative := 2 * (layerActivations[outputIdx][j] - dp.ExpectedOutput[j])
-		nodeValues[outputIdx][j] = costDerivative * outputLayer.activationDerivative(weightedInputs[outputIdx][j])
+		costDerivative := 2 * (outputTrLayer.activations[j] - dp.ExpectedOutput[j])
+		outputTrLayer.nodeValues[j] = costDerivative * outputLayer.activationDerivative(outputTrLayer.weightedInputs[j])
 	}
 
 	// Hidden layers: propagate node values backwards through the network.
@@ -120,14 +126,15 @@ func (tr TrainerLvl2) UpdateAllGradients(nn NetworkLvl2, dp DataPoint) {
 		nextLayer := nn.layers[i+1]
 		_, numNodesOut := layer.Dims()
 		_, numNextNodesOut := nextLayer.Dims()
-		nodeValues[i] = make([]float64, numNodesOut)
+		nodeValues := tr.layers[i].nodeValues
+		nextNodeValues := tr.layers[i+1].nodeValues
 		for j := 0; j < numNodesOut; j++ {
 			var sum float64
 			for k := 0; k < numNextNodesOut; k++ {
 				// weights[j][k] connects node j in this layer to node k in the next.
-				sum += nextLayer.weights[j][k] * nodeValues[i+1][k]
+				sum += nextLayer.weights[j][k] * nextNodeValues[k]
 			}
-			nodeValues[i][j] = sum * layer.activationDerivative(weightedInputs[i][j])
+			nodeValues[j] = sum * layer.activationDerivative(tr.layers[i].weightedInputs[j])
 		}
 	}
 
@@ -140,9 +147,9 @@ func (tr TrainerLvl2) UpdateAllGradients(nn NetworkLvl2, dp DataPoint) {
 		trLayer := tr.layers[i]
 		numNodesIn, numNodesOut := nn.layers[i].Dims()
 		for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-			trLayer.costGradB[nodeOut] += nodeValues[i][nodeOut]
+			trLayer.costGradB[nodeOut] += trLayer.nodeValues[nodeOut]
 			for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-				trLayer.costGradW[nodeIn][nodeOut] += layerInputs[i][nodeIn] * nodeValues[i][nodeOut]
+				trLayer.costGradW[nodeIn][nodeOut] += trLayer.inputs[nodeIn] * trLayer.nodeValues[nodeOut]
 			}
 		}
 	}
@@ -0,0 +1,159 @@
+package neurus
+
+import (
+	"math"
+
+	"github.com/soypat/neurus/autodiff"
+)
+
+// This file contains a Neural Network trained using backpropagation
+// computed by reverse-mode automatic differentiation.
+// It builds upon Level 2 by no longer requiring hand-written derivatives:
+// the network only defines how outputs are computed from the inputs and
+// the autodiff package records these operations on a tape which is replayed
+// backwards to obtain the gradients. See the autodiff package.
+
+// ActivationLvl3 is an activation function of a NetworkLvl3 defined in terms of
+// autodiff operations, such as (*autodiff.Tensor).Sigmoid.
+type ActivationLvl3 func(weightedInputs *autodiff.Tensor) *autodiff.Tensor
+
+// NetworkLvl3 is a neural network trained with gradients obtained by automatic
+// differentiation. Given the same parameters it produces the same outputs and
+// gradients as a NetworkLvl2 with the hand-written activation derivative.
+type NetworkLvl3 struct {
+	layers []LayerLvl3
+}
+
+// NewNetworkLvl3 creates a new NetworkLvl3 with randomized layers using math/rand standard library.
+// Parameters are drawn as by NewNetworkLvl2. To vary randomization use rand.Seed().
+func NewNetworkLvl3(activation ActivationLvl3, layerSizes ...int) NetworkLvl3 {
+	layers := make([]LayerLvl3, len(layerSizes)-1)
+	for i := range layers {
+		layers[i] = newLayerLvl3(layerSizes[i], layerSizes[i+1], activation)
+	}
+	return NetworkLvl3{layers: layers}
+}
+
+// CalculateOutputs runs the inputs through the network and returns the output values.
+// The returned slice belongs to the caller.
+func (nn NetworkLvl3) CalculateOutputs(input []float64) []float64 {
+	var tape autodiff.Tape
+	outputs, _ := nn.forward(&tape, tape.Variable(input))
+	return outputs.Value
+}
+
+// forward records passing x through the network on tape and returns the output.
+// params holds the weights and biases tensors of each layer in order.
+func (nn NetworkLvl3) forward(tape *autodiff.Tape, x *autodiff.Tensor) (outputs *autodiff.Tensor, params []*autodiff.Tensor) {
+	for _, layer := range nn.layers {
+		weights := tape.Variable(layer.weights)
+		biases := tape.Variable(layer.biases)
+		x = layer.forward(weights, biases, x)
+		params = append(params, weights, biases)
+	}
+	return x, params
+}
+
+// Classify runs the inputs through the network and returns index of output node with highest value.
+func (nn NetworkLvl3) Classify(expectedOutput, input []float64) (classification int, cost float64) {
+	var tape autodiff.Tape
+	outputs, _ := nn.forward(&tape, tape.Variable(input))
+	maxIdx := 0
+	maxValue := math.Inf(-1) // Start with lowest value looking for maximum
+	for nodeOut, activation := range outputs.Value {
+		if activation > maxValue {
+			maxIdx = nodeOut
+			maxValue = activation
+		}
+	}
+	return maxIdx, costLvl3(outputs, tape.Variable(expectedOutput)).Item()
+}
+
+// costLvl3 is the cost of NetworkLvl3, the sum of squared errors as in Level 2.
+// Unlike Level 2 its derivative needs not be written down.
+func costLvl3(outputs, expectedOutputs *autodiff.Tensor) *autodiff.Tensor {
+	return outputs.Sub(expectedOutputs).Square().Sum()
+}
+
+// Dims returns the input and output dimension of the neural network.
+func (nn NetworkLvl3) Dims() (input, output int) {
+	input, _ = nn.layers[0].Dims()
+	_, output = nn.layers[len(nn.layers)-1].Dims()
+	return input, output
+}
+
+// Export returns the weights and biases of each layer.
+func (nn NetworkLvl3) Export() (setup []LayerSetup) {
+	for _, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		weights := make([][]float64, numNodesIn)
+		for nodeIn := range weights {
+			weights[nodeIn] = make([]float64, numNodesOut)
+			for nodeOut := range weights[nodeIn] {
+				weights[nodeIn][nodeOut] = layer.weights[nodeOut*numNodesIn+nodeIn]
+			}
+		}
+		setup = append(setup, LayerSetup{
+			Weights: weights,
+			Biases:  append([]float64{}, layer.biases...),
+		})
+	}
+	return setup
+}
+
+// Import replaces the weights and biases of each layer with those of setup,
+// as returned by Export of a Level 0, 1, 2 or 3 network. Layer dimensions must match.
+func (nn NetworkLvl3) Import(setup []LayerSetup) {
+	if len(setup) != len(nn.layers) {
+		panic("number of layers mismatch")
+	}
+	for i, layer := range nn.layers {
+		numNodesIn, numNodesOut := layer.Dims()
+		if in, out := setup[i].Dims(); in != numNodesIn || out != numNodesOut {
+			panic("layer dimensions mismatch")
+		}
+		copy(layer.biases, setup[i].Biases)
+		for nodeIn, weights := range setup[i].Weights {
+			for nodeOut, weight := range weights {
+				layer.weights[nodeOut*numNodesIn+nodeIn] = weight
+			}
+		}
+	}
+}
+
+type LayerLvl3 struct {
+	// weights is a row-major matrix with a row of input weights per output node.
+	weights    []float64
+	biases     []float64
+	numNodesIn int
+	activation ActivationLvl3
+}
+
+func (l LayerLvl3) Dims() (input, output int) {
+	return l.numNodesIn, len(l.biases)
+}
+
+func newLayerLvl3(numNodesIn, numNodesOut int, activation ActivationLvl3) LayerLvl3 {
+	layer := LayerLvl3{
+		weights:    make([]float64, numNodesIn*numNodesOut),
+		biases:     randomSlice[float64](numNodesOut, 2, -1, defaultRng),
+		numNodesIn: numNodesIn,
+		activation: activation,
+	}
+	invSqrtNumNodesIn := 1 / math.Sqrt(float64(numNodesIn))
+	for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
+		// Draw weights in the same order as Level 2, which stores them transposed.
+		weights := randomSlice[float64](numNodesOut, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, defaultRng)
+		for nodeOut, weight := range weights {
+			layer.weights[nodeOut*numNodesIn+nodeIn] = weight
+		}
+	}
+	return layer
+}
+
+// forward records passing the inputs through the layer with the given parameter
+// tensors. It is all a layer needs to define: the gradients follow from the
+// recorded operations.
+func (layer LayerLvl3) forward(weights, biases, inputs *autodiff.Tensor) (activations *autodiff.Tensor) {
+	return layer.activation(autodiff.Linear(weights, biases, inputs))
+}
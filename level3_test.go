@@ -0,0 +1,90 @@
+package neurus_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+	"github.com/soypat/neurus/autodiff"
+)
+
+func TestNetworkLvl3_matchesLvl2(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		activation func(float64) float64
+		derivative func(float64) float64
+		autodiff   neurus.ActivationLvl3
+	}{
+		{name: "sigmoid", activation: neurus.Sigmoid, derivative: neurus.SigmoidDerivative, autodiff: (*autodiff.Tensor).Sigmoid},
+		{name: "relu", activation: neurus.ReLU, derivative: neurus.ReLUDerivative, autodiff: (*autodiff.Tensor).ReLU},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			const learnRate = 0.1
+			rng := rand.New(rand.NewSource(1))
+			nn2 := neurus.NewNetworkLvl2(test.activation, test.derivative, 3, 4, 3, 2)
+			nn3 := neurus.NewNetworkLvl3(test.autodiff, 3, 4, 3, 2)
+			nn3.Import(nn2.Export())
+			tr2 := neurus.NewTrainerFromNetworkLvl2(nn2)
+			tr3 := neurus.NewTrainerFromNetworkLvl3(nn3)
+			data := gradientData(rng, 40, 3, 2)
+			for step := 0; step < 20; step++ {
+				batch := data[step%4*10 : step%4*10+10]
+				tr2.Train(nn2, batch, learnRate)
+				tr3.Train(nn3, batch, learnRate)
+			}
+			// Both levels perform the same floating point operations in the same order.
+			for _, dp := range data {
+				class2, cost2 := nn2.Classify(dp.ExpectedOutput, dp.Input)
+				class3, cost3 := nn3.Classify(dp.ExpectedOutput, dp.Input)
+				if class2 != class3 || cost2 != cost3 {
+					t.Fatalf("level 3 classified %d with cost %g, level 2 %d with cost %g", class3, cost3, class2, cost2)
+				}
+			}
+			setup2, setup3 := nn2.Export(), nn3.Export()
+			for i := range setup2 {
+				for j := range setup2[i].Weights {
+					for k, w := range setup2[i].Weights[j] {
+						if setup3[i].Weights[j][k] != w {
+							t.Fatalf("layer %d weight [%d][%d]: level 3 %g, level 2 %g", i, j, k, setup3[i].Weights[j][k], w)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTrainerLvl3_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	nn := neurus.NewNetworkLvl3((*autodiff.Tensor).Tanh, 3, 5, 2)
+	tr := neurus.NewTrainerFromNetworkLvl3(nn)
+	checkGradients(t, tr.GradientModel(nn), gradientData(rng, 4, 3, 2))
+}
+
+func TestNetworkLvl3_twoD(t *testing.T) {
+	const (
+		batchSize = 10
+		learnRate = 0.05
+		epochs    = 2000
+	)
+	rng := rand.New(rand.NewSource(1))
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	trainData := m.Generate2DData(400)
+	testData := m.Generate2DData(100)
+
+	// An activation without a hand-written derivative only needs its forward definition.
+	nn := neurus.NewNetworkLvl3(func(x *autodiff.Tensor) *autodiff.Tensor {
+		return x.Tanh().Add(x.Sigmoid())
+	}, 2, 3, 2)
+	initialCost := nn.Cost(testData)
+	trainer := neurus.NewTrainerFromNetworkLvl3(nn)
+	for epoch := 0; epoch < epochs; epoch++ {
+		startIdx := rng.Intn(len(trainData) - batchSize)
+		trainer.Train(nn, trainData[startIdx:startIdx+batchSize], learnRate)
+	}
+	finalCost := nn.Cost(testData)
+	if finalCost >= initialCost {
+		t.Errorf("training did not reduce cost: initial=%f, final=%f", initialCost, finalCost)
+	}
+	t.Logf("initial cost: %f, final cost: %f", initialCost, finalCost)
+}
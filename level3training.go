@@ -0,0 +1,85 @@
+package neurus
+
+import "github.com/soypat/neurus/autodiff"
+
+type TrainerLvl3 struct {
+	layers []layerTrainerLvl3
+	// tape records the operations of the data point being backpropagated.
+	tape *autodiff.Tape
+}
+
+type layerTrainerLvl3 struct {
+	// Bias cost gradient.
+	costGradB []float64
+	// Weight cost gradient, stored like the layer's weights.
+	costGradW []float64
+}
+
+func NewTrainerFromNetworkLvl3(nn NetworkLvl3) (tr TrainerLvl3) {
+	tr.layers = make([]layerTrainerLvl3, len(nn.layers))
+	for i, layer := range nn.layers {
+		tr.layers[i].costGradB = make([]float64, len(layer.biases))
+		tr.layers[i].costGradW = make([]float64, len(layer.weights))
+	}
+	tr.tape = new(autodiff.Tape)
+	return tr
+}
+
+// Cost calculates the total cost or loss of the training data being
+// passed through the neural network.
+func (nn NetworkLvl3) Cost(trainingData []DataPoint) (totalCost float64) {
+	for _, datapoint := range trainingData {
+		_, cost := nn.Classify(datapoint.ExpectedOutput, datapoint.Input)
+		totalCost += cost
+	}
+	return totalCost / float64(len(trainingData))
+}
+
+// Train performs one training step using backpropagation by automatic
+// differentiation. It is the same training step as that of Level 2.
+func (tr TrainerLvl3) Train(nn NetworkLvl3, trainingData []DataPoint, learnRate float64) {
+	// Zero all gradients before accumulating.
+	for _, trLayer := range tr.layers {
+		fillZeros(trLayer.costGradB)
+		fillZeros(trLayer.costGradW)
+	}
+
+	for _, dp := range trainingData {
+		tr.UpdateAllGradients(nn, dp)
+	}
+
+	// Apply the averaged gradients to update weights and biases.
+	for i, layer := range nn.layers {
+		tr.layers[i].applyAllGradients(layer, learnRate/float64(len(trainingData)))
+	}
+}
+
+// UpdateAllGradients computes and accumulates gradients for a single data point.
+// Where Level 2 spells out the chain rule for its activation and cost, here the
+// forward pass is recorded on a tape and replayed backwards by the autodiff package,
+// which knows the derivative of each elementary operation.
+func (tr TrainerLvl3) UpdateAllGradients(nn NetworkLvl3, dp DataPoint) {
+	tape := tr.tape
+	tape.Reset()
+	outputs, params := nn.forward(tape, tape.Variable(dp.Input))
+	cost := costLvl3(outputs, tape.Variable(dp.ExpectedOutput))
+	cost.Backward()
+	for i, trLayer := range tr.layers {
+		weights, biases := params[2*i], params[2*i+1]
+		for j, grad := range weights.Grad {
+			trLayer.costGradW[j] += grad
+		}
+		for j, grad := range biases.Grad {
+			trLayer.costGradB[j] += grad
+		}
+	}
+}
+
+func (trl layerTrainerLvl3) applyAllGradients(layer LayerLvl3, learnRate float64) {
+	for i := range layer.biases {
+		layer.biases[i] -= trl.costGradB[i] * learnRate
+	}
+	for i := range layer.weights {
+		layer.weights[i] -= trl.costGradW[i] * learnRate
+	}
+}
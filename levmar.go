@@ -0,0 +1,206 @@
+package neurus
+
+import (
+	"errors"
+	"math"
+
+	"golang.org/x/exp/constraints"
+)
+
+// TrainerLM trains a NetworkOptimized with the MeanSquaredError cost using the
+// Levenberg–Marquardt algorithm. Each step solves the damped Gauss–Newton equations
+//
+//	(JᵀJ + λI) δ = -Jᵀr
+//
+// for the parameter update δ, where r holds the residuals of every output of every
+// data point and J is their Jacobian with respect to the parameters. The damping λ
+// is decreased after steps which lower the cost and increased otherwise, moving
+// between Gauss–Newton steps and short gradient descent steps. A step costs a backward pass per
+// output per data point and solving a system of the size of the number of
+// parameters, so the trainer suits tiny networks fitted on small datasets.
+type TrainerLM struct {
+	nn     *NetworkOptimized
+	params []Param
+	// Damping is the damping factor λ of the next step. It is adapted by Train.
+	Damping float64
+	// Buffers reused between steps.
+	jac, residuals, jtj, jtr, a, x, xPrev []float64
+}
+
+// NewTrainerLM returns a Levenberg–Marquardt trainer of nn. It panics if the cost
+// of nn is not MeanSquaredError. Dropout must be disabled and batch normalized
+// layers must not be in training mode since the Jacobian is computed one data
+// point at a time.
+func NewTrainerLM(nn *NetworkOptimized) *TrainerLM {
+	if _, ok := nn.Cost.(*MeanSquaredError); !ok {
+		panic("Levenberg-Marquardt requires MeanSquaredError cost")
+	}
+	return &TrainerLM{nn: nn, params: nn.GradientModel().Params(), Damping: 1e-3}
+}
+
+// Train takes a single Levenberg–Marquardt step over data and returns the total
+// cost of the network after the step. If no damping up to 1e10 lowers the cost
+// the parameters are left unchanged and ErrNoProgress is returned, which usually
+// means a minimum has been found.
+func (tr *TrainerLM) Train(data []DataPoint) (cost float64, err error) {
+	const (
+		dampingFactor = 10
+		maxDamping    = 1e10
+	)
+	numParams := NumParams(tr.params)
+	_, numOut := tr.nn.Dims()
+	numRows := len(data) * numOut
+	tr.jac = resize(tr.jac, numRows*numParams)
+	tr.residuals = resize(tr.residuals, numRows)
+	for k, dp := range data {
+		rows := tr.jac[k*numOut*numParams : (k+1)*numOut*numParams]
+		tr.nn.Jacobian(rows, dp.Input)
+		for j, output := range tr.nn.StoreOutputs(dp.Input) {
+			tr.residuals[k*numOut+j] = output - dp.ExpectedOutput[j]
+		}
+	}
+	cost = tr.cost(data)
+
+	// Normal equations JᵀJ and Jᵀr.
+	tr.jtj = resize(tr.jtj, numParams*numParams)
+	tr.jtr = resize(tr.jtr, numParams)
+	fillZeros(tr.jtj)
+	fillZeros(tr.jtr)
+	for row := 0; row < numRows; row++ {
+		jrow := tr.jac[row*numParams : (row+1)*numParams]
+		r := tr.residuals[row]
+		for p, jp := range jrow {
+			tr.jtr[p] += jp * r
+			if jp == 0 {
+				continue
+			}
+			// Only the upper triangle is needed by choleskySolve.
+			upper := tr.jtj[p*numParams : (p+1)*numParams]
+			for q := p; q < numParams; q++ {
+				upper[q] += jp * jrow[q]
+			}
+		}
+	}
+
+	tr.xPrev = FlattenParams(tr.xPrev, tr.params)
+	tr.a = resize(tr.a, len(tr.jtj))
+	tr.x = resize(tr.x, numParams)
+	for ; tr.Damping <= maxDamping; tr.Damping *= dampingFactor {
+		copy(tr.a, tr.jtj)
+		for p := 0; p < numParams; p++ {
+			tr.a[p*numParams+p] += tr.Damping
+			tr.x[p] = -tr.jtr[p]
+		}
+		if !choleskySolve(tr.a, tr.x) {
+			continue
+		}
+		for p := range tr.x {
+			tr.x[p] += tr.xPrev[p]
+		}
+		UnflattenParams(tr.params, tr.x)
+		if newCost := tr.cost(data); newCost < cost {
+			tr.Damping = math.Max(tr.Damping/dampingFactor, 1e-12)
+			return newCost, nil
+		}
+	}
+	tr.Damping = maxDamping
+	UnflattenParams(tr.params, tr.xPrev)
+	return cost, ErrNoProgress
+}
+
+// ErrNoProgress is returned by TrainerLM.Train when no step lowers the cost.
+var ErrNoProgress = errors.New("no step lowers cost")
+
+// cost returns the total MeanSquaredError cost of the network over data.
+func (tr *TrainerLM) cost(data []DataPoint) (totalCost float64) {
+	for _, dp := range data {
+		outputs := tr.nn.StoreOutputs(dp.Input)
+		tr.nn.Cost.CalculateFromInputs(outputs, dp.ExpectedOutput, 1)
+		totalCost += tr.nn.Cost.TotalCost()
+	}
+	return totalCost
+}
+
+// Jacobian stores the partial derivatives of each network output with respect to
+// each parameter for the given input in dst, reusing its capacity, as a row-major
+// matrix with a row per output and a column per flattened parameter value, in the
+// order of the parameters of GradientModel, and returns it. Each row is computed by
+// backpropagating the derivative of a single output. The gradients of the network
+// are zero on return.
+func (nn *NetworkOptimizedOf[T]) Jacobian(dst []float64, input []T) []float64 {
+	_, numOut := nn.Dims()
+	params := nn.GradientModel().Params()
+	numParams := NumParams(params)
+	dst = resize(dst, numOut*numParams)
+	selector := &outputSelector[T]{}
+	cost := nn.Cost
+	nn.Cost = selector
+	defer func() { nn.Cost = cost }()
+	data := []DataPointOf[T]{{Input: input, ExpectedOutput: make([]T, numOut)}}
+	learnData := nn.learnData(1)
+	for selector.index = 0; selector.index < numOut; selector.index++ {
+		zeroParamGrads(params)
+		nn.updateBatchGradients(data, learnData)
+		FlattenGrads(dst[selector.index*numParams:selector.index*numParams], params)
+	}
+	zeroParamGrads(params)
+	return dst
+}
+
+// outputSelector is a cost function equal to a single network output, so that
+// backpropagation computes the gradient of that output.
+type outputSelector[T constraints.Float] struct {
+	index  int
+	output T
+}
+
+func (s *outputSelector[T]) CalculateFromInputs(predicted, expected []T, stride int) {
+	s.output = predicted[s.index]
+}
+
+func (s *outputSelector[T]) TotalCost() T { return s.output }
+
+func (s *outputSelector[T]) Derivative(index int) T {
+	if index == s.index {
+		return 1
+	}
+	return 0
+}
+
+// choleskySolve solves a·x = b for the symmetric positive definite n×n row-major
+// matrix a of which only the upper triangle is read. x is stored in b and a is
+// overwritten by its Cholesky factor. It reports false if a is not positive definite.
+func choleskySolve(a, b []float64) bool {
+	n := len(b)
+	// Factor a = UᵀU storing U in the upper triangle of a.
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			sum := a[i*n+j]
+			for k := 0; k < i; k++ {
+				sum -= a[k*n+i] * a[k*n+j]
+			}
+			if i == j {
+				if !(sum > 0) {
+					return false
+				}
+				a[i*n+i] = math.Sqrt(sum)
+			} else {
+				a[i*n+j] = sum / a[i*n+i]
+			}
+		}
+	}
+	// Forward substitution Uᵀy = b then back substitution Ux = y.
+	for i := 0; i < n; i++ {
+		for k := 0; k < i; k++ {
+			b[i] -= a[k*n+i] * b[k]
+		}
+		b[i] /= a[i*n+i]
+	}
+	for i := n - 1; i >= 0; i-- {
+		for k := i + 1; k < n; k++ {
+			b[i] -= a[i*n+k] * b[k]
+		}
+		b[i] /= a[i*n+i]
+	}
+	return true
+}
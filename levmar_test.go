@@ -0,0 +1,108 @@
+package neurus_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// calibrationCurve returns n noisy samples of a smooth curve on [0, 1] with
+// values in (0, 1) so that it may be fitted by sigmoid networks.
+func calibrationCurve(rng *rand.Rand, n int, noise float64) []neurus.DataPoint {
+	data := make([]neurus.DataPoint, n)
+	for i := range data {
+		x := float64(i) / float64(n-1)
+		y := 0.5 + 0.35*math.Sin(2*math.Pi*x) + noise*rng.NormFloat64()
+		data[i] = neurus.DataPoint{Input: []float64{x}, ExpectedOutput: []float64{y}}
+	}
+	return data
+}
+
+func TestNetworkOptimized_Jacobian(t *testing.T) {
+	const h = 1e-6
+	nn := neurus.NewNetworkOptimized([]int{2, 3, 2}, func() neurus.ActivationFunc { return new(neurus.Tanh) },
+		new(neurus.MeanSquaredError), rand.NewSource(1))
+	params := nn.GradientModel().Params()
+	input := []float64{0.3, -0.7}
+	jac := nn.Jacobian(nil, input)
+	numParams := neurus.NumParams(params)
+	if len(jac) != 2*numParams {
+		t.Fatalf("got Jacobian of length %d, want %d", len(jac), 2*numParams)
+	}
+	x := neurus.FlattenParams(nil, params)
+	for p := range x {
+		orig := x[p]
+		x[p] = orig + h
+		neurus.UnflattenParams(params, x)
+		plus := append([]float64{}, nn.StoreOutputs(input)...)
+		x[p] = orig - h
+		neurus.UnflattenParams(params, x)
+		minus := nn.StoreOutputs(input)
+		x[p] = orig
+		neurus.UnflattenParams(params, x)
+		for j := range plus {
+			numeric := (plus[j] - minus[j]) / (2 * h)
+			if got := jac[j*numParams+p]; math.Abs(got-numeric) > 1e-7 {
+				t.Errorf("d output %d / d param %d: got %g, want %g", j, p, got, numeric)
+			}
+		}
+	}
+	for _, g := range neurus.FlattenGrads(nil, params) {
+		if g != 0 {
+			t.Fatal("gradients not zeroed")
+		}
+	}
+}
+
+// TestTrainerLM_fit fits a noisy curve with Levenberg–Marquardt in a few dozen
+// iterations and compares it with thousands of TrainerLvl2 epochs.
+func TestTrainerLM_fit(t *testing.T) {
+	const (
+		iterations = 50
+		epochs     = 5000
+		learnRate  = 0.5
+	)
+	rng := rand.New(rand.NewSource(1))
+	data := calibrationCurve(rng, 50, 0.02)
+
+	nn := neurus.NewNetworkOptimized([]int{1, 4, 1}, func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		new(neurus.MeanSquaredError), rand.NewSource(1))
+	setup := nn.Export()
+	setup[0].Activation = &neurus.ActivationSetup{Kind: "tanh"}
+	nn.Import(setup, nil)
+	tr := neurus.NewTrainerLM(nn)
+	var cost float64
+	var err error
+	for i := 0; i < iterations && err == nil; i++ {
+		cost, err = tr.Train(data)
+	}
+	lmCost := 2 * cost / float64(len(data)) // Mean of squared errors.
+
+	lvl2 := neurus.NewNetworkLvl2(neurus.Sigmoid, neurus.SigmoidDerivative, 1, 4, 1)
+	trainer := neurus.NewTrainerFromNetworkLvl2(lvl2)
+	for epoch := 0; epoch < epochs; epoch++ {
+		trainer.Train(lvl2, data, learnRate)
+	}
+	lvl2Cost := lvl2.Cost(data)
+
+	t.Logf("LM mean squared error after %d iterations: %g, TrainerLvl2 after %d epochs: %g", iterations, lmCost, epochs, lvl2Cost)
+	if lmCost > 2*0.02*0.02 {
+		t.Errorf("LM mean squared error %g not near noise level", lmCost)
+	}
+	if lmCost >= lvl2Cost {
+		t.Errorf("LM mean squared error %g not lower than TrainerLvl2's %g", lmCost, lvl2Cost)
+	}
+}
+
+func TestNewTrainerLM_cost(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for cost other than MeanSquaredError")
+		}
+	}()
+	nn := neurus.NewNetworkOptimized([]int{1, 2, 1}, func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		new(neurus.CrossEntropy), rand.NewSource(1))
+	neurus.NewTrainerLM(nn)
+}
@@ -0,0 +1,180 @@
+package mnist
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// IDX is the file format of the original MNIST distribution and of datasets
+// following its layout such as Fashion-MNIST, KMNIST and EMNIST. A file holds
+// a single array of unsigned bytes: a magic number encoding the element type
+// and number of dimensions, the size of each dimension as a big endian uint32
+// and the elements in row-major order.
+
+const idxUbyte = 0x08
+
+// maxIDXSize limits the allocation made for an IDX array of corrupt dimensions.
+const maxIDXSize = 1 << 30
+
+// ReadIDX reads an IDX array of unsigned bytes from r, which may be gzip compressed.
+// It returns the size of each dimension and the elements in row-major order.
+func ReadIDX(r io.Reader) (dims []int, data []byte, err error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gzr.Close()
+		r = gzr
+	} else {
+		r = br
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("reading IDX header: %w", err)
+	}
+	if header[0] != 0 || header[1] != 0 {
+		return nil, nil, errors.New("not an IDX file")
+	}
+	if header[2] != idxUbyte {
+		return nil, nil, fmt.Errorf("unsupported IDX element type %#x, only unsigned bytes are supported", header[2])
+	}
+	sizes := make([]uint32, header[3])
+	if err := binary.Read(r, binary.BigEndian, sizes); err != nil {
+		return nil, nil, fmt.Errorf("reading IDX dimensions: %w", err)
+	}
+	dims = make([]int, len(sizes))
+	n := 1
+	for i, size := range sizes {
+		dims[i] = int(size)
+		if size != 0 && n > maxIDXSize/int(size) {
+			return nil, nil, errors.New("IDX array too large")
+		}
+		n *= int(size)
+	}
+	data = make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, fmt.Errorf("reading IDX data: %w", err)
+	}
+	return dims, data, nil
+}
+
+// WriteIDX writes the row-major array of unsigned bytes data with the given
+// dimensions to w in IDX format. Wrap w with a gzip.Writer to compress it.
+func WriteIDX(w io.Writer, dims []int, data []byte) error {
+	if len(dims) > math.MaxUint8 {
+		return errors.New("too many IDX dimensions")
+	}
+	header := make([]byte, 4+4*len(dims))
+	header[2] = idxUbyte
+	header[3] = byte(len(dims))
+	n := 1
+	for i, size := range dims {
+		if size < 0 || uint64(size) > math.MaxUint32 {
+			return fmt.Errorf("invalid IDX dimension size %d", size)
+		}
+		binary.BigEndian.PutUint32(header[4+4*i:], uint32(size))
+		n *= size
+	}
+	if n != len(data) {
+		return fmt.Errorf("IDX data length %d mismatches dimensions %v", len(data), dims)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadImages reads 28x28 images and their labels from IDX image and label files
+// such as train-images-idx3-ubyte and train-labels-idx1-ubyte, gzipped or not.
+// Pixel values are scaled to lie between 0 and 1 and stored with the same layout
+// as the images returned by Load so they may be used in their place. Labels
+// are kept as is, so those of datasets with more than 10 classes such as EMNIST
+// are converted with neurus.MNISTClassesToDatapoints rather than MNISTToDatapoints.
+func ReadImages(images, labels io.Reader) ([]Image, error) {
+	dims, pixels, err := ReadIDX(images)
+	if err != nil {
+		return nil, err
+	}
+	if len(dims) != 3 || dims[1] != imgSize || dims[2] != imgSize {
+		return nil, fmt.Errorf("got IDX image dimensions %v, want [n 28 28]", dims)
+	}
+	labelDims, nums, err := ReadIDX(labels)
+	if err != nil {
+		return nil, err
+	}
+	if len(labelDims) != 1 || labelDims[0] != dims[0] {
+		return nil, fmt.Errorf("got IDX label dimensions %v for %d images", labelDims, dims[0])
+	}
+	imgs := make([]Image, dims[0])
+	for k := range imgs {
+		img := pixels[k*PixelCount : (k+1)*PixelCount]
+		for row := 0; row < imgSize; row++ {
+			for col := 0; col < imgSize; col++ {
+				imgs[k].Data[col*imgSize+row] = float32(img[row*imgSize+col]) / 255
+			}
+		}
+		imgs[k].Num = nums[k]
+	}
+	return imgs, nil
+}
+
+// WriteImages writes imgs to images and labels in IDX format so that ReadImages
+// reads them back. Pixel values are rounded to the nearest of 256 gray levels.
+func WriteImages(images, labels io.Writer, imgs []Image) error {
+	pixels := make([]byte, len(imgs)*PixelCount)
+	nums := make([]byte, len(imgs))
+	for k := range imgs {
+		img := pixels[k*PixelCount : (k+1)*PixelCount]
+		for row := 0; row < imgSize; row++ {
+			for col := 0; col < imgSize; col++ {
+				v := math.Round(float64(imgs[k].Data[col*imgSize+row]) * 255)
+				img[row*imgSize+col] = uint8(math.Max(0, math.Min(255, v)))
+			}
+		}
+		nums[k] = imgs[k].Num
+	}
+	if err := WriteIDX(images, []int{len(imgs), imgSize, imgSize}, pixels); err != nil {
+		return err
+	}
+	return WriteIDX(labels, []int{len(imgs)}, nums)
+}
+
+// LoadIDX reads the images and labels of the IDX files at the given paths, i.e:
+//
+//	train, err := mnist.LoadIDX("train-images-idx3-ubyte.gz", "train-labels-idx1-ubyte.gz")
+func LoadIDX(imagesPath, labelsPath string) ([]Image, error) {
+	images, err := os.Open(imagesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer images.Close()
+	labels, err := os.Open(labelsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer labels.Close()
+	return ReadImages(images, labels)
+}
+
+// Images64 converts images to Image64 for use with float64 networks, i.e:
+//
+//	datapoints := neurus.MNISTToDatapoints(mnist.Images64(images))
+func Images64(images []Image) []Image64 {
+	images64 := make([]Image64, len(images))
+	for i := range images {
+		for j, v := range images[i].Data {
+			images64[i].Data[j] = float64(v)
+		}
+		images64[i].Num = images[i].Num
+	}
+	return images64
+}
@@ -0,0 +1,151 @@
+package mnist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// idxFixture returns IDX image and label files of two synthetic images. The
+// first has a white pixel at row 1, column 3 and the second a gray pixel at row 27, column 0.
+func idxFixture() (images, labels []byte) {
+	images = append([]byte{0, 0, 0x08, 3, 0, 0, 0, 2, 0, 0, 0, 28, 0, 0, 0, 28}, make([]byte, 2*PixelCount)...)
+	images[16+1*28+3] = 255
+	images[16+PixelCount+27*28] = 51
+	labels = []byte{0, 0, 0x08, 1, 0, 0, 0, 2, 7, 2}
+	return images, labels
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	gzw.Write(b)
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func TestReadImages(t *testing.T) {
+	images, labels := idxFixture()
+	for _, test := range []struct {
+		name           string
+		images, labels []byte
+	}{
+		{"raw", images, labels},
+		{"gzip", gzipBytes(images), gzipBytes(labels)},
+		{"mixed", gzipBytes(images), labels},
+	} {
+		imgs, err := ReadImages(bytes.NewReader(test.images), bytes.NewReader(test.labels))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(imgs) != 2 || imgs[0].Num != 7 || imgs[1].Num != 2 {
+			t.Fatalf("%s: got %d images", test.name, len(imgs))
+		}
+		var sum float32
+		for k := range imgs {
+			for _, v := range imgs[k].Data {
+				sum += v
+			}
+		}
+		if sum != 1+0.2 {
+			t.Errorf("%s: got pixel sum %g", test.name, sum)
+		}
+		// Pixels are laid out like the images of Load: At takes the column first.
+		if got := imgs[0].At(3, 1); got != (color.Gray{Y: 255}) {
+			t.Errorf("%s: got pixel %v at column 3, row 1", test.name, got)
+		}
+		if got := imgs[1].Data[0*imgSize+27]; got != 0.2 {
+			t.Errorf("%s: got pixel %g at column 0, row 27", test.name, got)
+		}
+	}
+}
+
+// TestReadImages_manyClasses reads labels of datasets with more than 10
+// classes, such as the 26 letters of EMNIST.
+func TestReadImages_manyClasses(t *testing.T) {
+	images, labels := idxFixture()
+	labels[len(labels)-1] = 25
+	imgs, err := ReadImages(bytes.NewReader(images), bytes.NewReader(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imgs[0].Num != 7 || imgs[1].Num != 25 {
+		t.Errorf("got labels %d and %d, want 7 and 25", imgs[0].Num, imgs[1].Num)
+	}
+}
+
+func TestWriteImages(t *testing.T) {
+	var images, labels bytes.Buffer
+	if err := WriteImages(&images, &labels, trainD[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if images.Len() != 16+10*PixelCount || labels.Len() != 8+10 {
+		t.Fatalf("got file sizes %d and %d", images.Len(), labels.Len())
+	}
+	imgs, err := ReadImages(&images, &labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range imgs {
+		if imgs[k].Num != trainD[k].Num {
+			t.Fatalf("image %d: got label %d, want %d", k, imgs[k].Num, trainD[k].Num)
+		}
+		for i, v := range imgs[k].Data {
+			if math.Abs(float64(v-trainD[k].Data[i])) > 0.5/255+1e-6 { // Rounding to gray levels.
+				t.Fatalf("image %d: got pixel %d of %g, want %g", k, i, v, trainD[k].Data[i])
+			}
+		}
+	}
+
+	// The synthetic fixture is written back byte for byte.
+	fixtureImages, fixtureLabels := idxFixture()
+	imgs, err = ReadImages(bytes.NewReader(fixtureImages), bytes.NewReader(fixtureLabels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images.Reset()
+	labels.Reset()
+	if err := WriteImages(&images, &labels, imgs); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(images.Bytes(), fixtureImages) || !bytes.Equal(labels.Bytes(), fixtureLabels) {
+		t.Error("written IDX files differ from fixture")
+	}
+}
+
+func TestReadIDX_errors(t *testing.T) {
+	images, labels := idxFixture()
+	for _, test := range []struct {
+		name           string
+		images, labels []byte
+	}{
+		{"empty", nil, labels},
+		{"bad magic", append([]byte{1}, images[1:]...), labels},
+		{"int32 elements", append([]byte{0, 0, 0x0c}, images[3:]...), labels},
+		{"truncated data", images[:len(images)-1], labels},
+		{"truncated gzip", gzipBytes(images)[:40], labels},
+		{"truncated dimensions", images[:10], labels},
+		{"label count", images, []byte{0, 0, 0x08, 1, 0, 0, 0, 1, 7}},
+		{"label dimensions", images, []byte{0, 0, 0x08, 2, 0, 0, 0, 2, 0, 0, 0, 1, 7, 2}},
+		{"image size", []byte{0, 0, 0x08, 3, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0}, []byte{0, 0, 0x08, 1, 0, 0, 0, 1, 7}},
+		{"too large", []byte{0, 0, 0x08, 3, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, labels},
+	} {
+		if _, err := ReadImages(bytes.NewReader(test.images), bytes.NewReader(test.labels)); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+	if err := WriteIDX(new(bytes.Buffer), []int{2, 3}, make([]byte, 5)); err == nil {
+		t.Error("expected error writing data of mismatched length")
+	}
+}
+
+func TestImages64(t *testing.T) {
+	images64 := Images64(trainD[:10])
+	for k := range images64 {
+		if images64[k] != train64D[k] {
+			t.Fatalf("image %d differs from Load64's", k)
+		}
+	}
+}
@@ -80,6 +80,35 @@ func (m Model2D) ColorModel() color.Model {
 	return color.RGBAModel
 }
 
+// MatrixPredictor is implemented by networks which predict the outputs of row-major
+// matrices of inputs, such as NetworkLvl0, NetworkOptimized and NEATNetwork.
+type MatrixPredictor interface {
+	Dims() (numIn, numOut int)
+	PredictMatrix(outputs, inputs []float64)
+}
+
+var (
+	_ MatrixPredictor = NetworkLvl0{}
+	_ MatrixPredictor = (*NetworkOptimized)(nil)
+)
+
+// PredictorClassifier returns a Model2D classifier which classifies points by the
+// index of the highest output of a predictor with two inputs.
+// The classifier reuses its buffers between calls and is not safe for concurrent use.
+func PredictorClassifier(p MatrixPredictor) func(x, y float64) int {
+	numIn, numOut := p.Dims()
+	if numIn != 2 {
+		panic("Model2D classifier requires two inputs")
+	}
+	buf := make([]float64, numIn+numOut)
+	inputs, outputs := buf[:numIn], buf[numIn:]
+	return func(x, y float64) int {
+		inputs[0], inputs[1] = x, y
+		p.PredictMatrix(outputs, inputs)
+		return maxf(outputs)
+	}
+}
+
 func maxf(s []float64) int {
 	idx := -1
 	max := math.Inf(-1)
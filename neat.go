@@ -0,0 +1,738 @@
+package neurus
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// This file contains NeuroEvolution of Augmenting Topologies (NEAT) in which not
+// only the weights but also the structure of networks is evolved. Networks start
+// out with every input connected to every output and grow hidden nodes and
+// connections by mutation. Genes carry innovation numbers so that genomes of
+// different structure may be aligned for crossover and compared for speciation,
+// which protects new structure while its weights are optimized.
+// See Stanley & Miikkulainen, Evolving Neural Networks through Augmenting Topologies.
+
+// NodeKind is the role of a node of a Genome.
+type NodeKind uint8
+
+const (
+	NodeInput NodeKind = iota
+	// NodeBias is a node whose value is always 1.
+	NodeBias
+	NodeHidden
+	NodeOutput
+)
+
+// NodeGene is a node of a Genome.
+type NodeGene struct {
+	ID   int
+	Kind NodeKind
+}
+
+// ConnectionGene is a weighted connection between two nodes of a Genome.
+type ConnectionGene struct {
+	In, Out int
+	Weight  float64
+	Enabled bool
+	// Innovation identifies the structural mutation which created the connection.
+	// Connections between the same nodes share the innovation number in all genomes.
+	Innovation int
+}
+
+// Genome describes the nodes and connections of a feed-forward network. The first
+// nodes are the inputs followed by the bias node and the outputs. Connections are
+// sorted by innovation number.
+type Genome struct {
+	Nodes       []NodeGene
+	Connections []ConnectionGene
+	// cost is the cost of the genome's network in its generation and fitness
+	// is positive and higher for lower costs.
+	cost    float64
+	fitness float64
+}
+
+// NumHidden returns the number of hidden nodes of the genome.
+func (g *Genome) NumHidden() (n int) {
+	for _, node := range g.Nodes {
+		if node.Kind == NodeHidden {
+			n++
+		}
+	}
+	return n
+}
+
+// NumEnabled returns the number of enabled connections of the genome.
+func (g *Genome) NumEnabled() (n int) {
+	for _, conn := range g.Connections {
+		if conn.Enabled {
+			n++
+		}
+	}
+	return n
+}
+
+func (g *Genome) clone() *Genome {
+	return &Genome{
+		Nodes:       append([]NodeGene{}, g.Nodes...),
+		Connections: append([]ConnectionGene{}, g.Connections...),
+		cost:        g.cost,
+		fitness:     g.fitness,
+	}
+}
+
+func (g *Genome) hasNode(id int) bool {
+	for _, node := range g.Nodes {
+		if node.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// connected reports whether enabled or disabled connections lead from node in to node out.
+func (g *Genome) connected(in, out int) bool {
+	visited := map[int]bool{in: true}
+	stack := []int{in}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n == out {
+			return true
+		}
+		for _, conn := range g.Connections {
+			if conn.In == n && !visited[conn.Out] {
+				visited[conn.Out] = true
+				stack = append(stack, conn.Out)
+			}
+		}
+	}
+	return false
+}
+
+// Network returns the network described by the genome. Nodes other than inputs
+// and the bias apply activation to the weighted sum of their enabled inputs.
+func (g *Genome) Network(activation func(float64) float64) *NEATNetwork {
+	nn := &NEATNetwork{activation: activation}
+	index := make(map[int]int, len(g.Nodes))
+	for i, node := range g.Nodes {
+		index[node.ID] = i
+		switch node.Kind {
+		case NodeInput:
+			nn.numIn++
+		case NodeBias:
+			nn.bias = i
+		case NodeOutput:
+			nn.outputs = append(nn.outputs, i)
+		}
+	}
+	// Sort nodes topologically so that every node is computed after its inputs.
+	incoming := make([][]neatLink, len(g.Nodes))
+	numPending := make([]int, len(g.Nodes))
+	outgoing := make([][]int, len(g.Nodes))
+	for _, conn := range g.Connections {
+		if !conn.Enabled {
+			continue
+		}
+		in, out := index[conn.In], index[conn.Out]
+		incoming[out] = append(incoming[out], neatLink{from: in, weight: conn.Weight})
+		outgoing[in] = append(outgoing[in], out)
+		numPending[out]++
+	}
+	var ready []int
+	for i, node := range g.Nodes {
+		if node.Kind == NodeInput || node.Kind == NodeBias {
+			ready = append(ready, i)
+		} else if numPending[i] == 0 {
+			nn.order = append(nn.order, neatNode{index: i})
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		for _, out := range outgoing[i] {
+			numPending[out]--
+			if numPending[out] == 0 {
+				nn.order = append(nn.order, neatNode{index: out, links: incoming[out]})
+				ready = append(ready, out)
+			}
+		}
+	}
+	if len(nn.order) != len(g.Nodes)-nn.numIn-1 {
+		panic("genome connections form a cycle")
+	}
+	nn.values = make([]float64, len(g.Nodes))
+	nn.outputValues = make([]float64, len(nn.outputs))
+	return nn
+}
+
+// NEATNetwork is the feed-forward network described by a Genome.
+type NEATNetwork struct {
+	numIn int
+	// bias and outputs are the indices of the bias and output nodes.
+	bias    int
+	outputs []int
+	// order holds the nodes computed from their inputs in topological order.
+	order      []neatNode
+	activation func(float64) float64
+	// values and outputValues are the buffers of CalculateOutputs.
+	values, outputValues []float64
+}
+
+var _ MatrixPredictor = (*NEATNetwork)(nil)
+
+type neatNode struct {
+	index int
+	links []neatLink
+}
+
+type neatLink struct {
+	from   int
+	weight float64
+}
+
+// Dims returns the input and output dimension of the network.
+func (nn *NEATNetwork) Dims() (numIn, numOut int) { return nn.numIn, len(nn.outputs) }
+
+// CalculateOutputs runs the inputs through the network and returns the output values.
+// The returned slice is reused by the network and overwritten by the next call.
+func (nn *NEATNetwork) CalculateOutputs(input []float64) []float64 {
+	nn.storeOutputs(nn.values, input, nn.outputValues)
+	return nn.outputValues
+}
+
+// storeOutputs computes the value of every node in values and stores the outputs in output.
+func (nn *NEATNetwork) storeOutputs(values, input, output []float64) {
+	if len(input) != nn.numIn {
+		panic("length of inputs mismatches network input length")
+	}
+	copy(values, input) // Input nodes come first.
+	values[nn.bias] = 1
+	for _, node := range nn.order {
+		var weightedInput float64
+		for _, link := range node.links {
+			weightedInput += values[link.from] * link.weight
+		}
+		values[node.index] = nn.activation(weightedInput)
+	}
+	for i, idx := range nn.outputs {
+		output[i] = values[idx]
+	}
+}
+
+// Classify runs the inputs through the network and returns index of output node with highest value.
+func (nn *NEATNetwork) Classify(expectedOutput, input []float64) (classification int, cost float64) {
+	outputs := nn.CalculateOutputs(input)
+	for nodeOut, activation := range outputs {
+		err := activation - expectedOutput[nodeOut]
+		cost += err * err
+	}
+	return maxIdx(math.Inf(-1), outputs), cost
+}
+
+// Cost calculates the mean cost of the training data as NetworkLvl0.Cost does.
+func (nn *NEATNetwork) Cost(trainingData []DataPoint) (totalCost float64) {
+	for _, datapoint := range trainingData {
+		_, cost := nn.Classify(datapoint.ExpectedOutput, datapoint.Input)
+		totalCost += cost
+	}
+	return totalCost / float64(len(trainingData))
+}
+
+// PredictBatch stores the outputs of the network for each row of inputs in the
+// corresponding row of outputs. The rows are split among GOMAXPROCS goroutines
+// when there are enough of them.
+func (nn *NEATNetwork) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := nn.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	if numPredictWorkers(len(inputs)) == 1 {
+		for s := range inputs {
+			nn.storeOutputs(nn.values, inputs[s], outputs[s])
+		}
+		return
+	}
+	nn.predict(len(inputs),
+		func(s int) []float64 { return inputs[s] },
+		func(s int) []float64 { return outputs[s] })
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (nn *NEATNetwork) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := nn.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	if numPredictWorkers(n) == 1 {
+		for s := 0; s < n; s++ {
+			nn.storeOutputs(nn.values, inputs[s*numIn:(s+1)*numIn], outputs[s*numOut:(s+1)*numOut])
+		}
+		return
+	}
+	nn.predict(n,
+		func(s int) []float64 { return inputs[s*numIn : (s+1)*numIn] },
+		func(s int) []float64 { return outputs[s*numOut : (s+1)*numOut] })
+}
+
+func (nn *NEATNetwork) predict(n int, input, output func(s int) []float64) {
+	numWorkers := numPredictWorkers(n)
+	// Each worker stores the node values in its own buffer.
+	values := make([][]float64, numWorkers)
+	for w := range values {
+		values[w] = make([]float64, len(nn.values))
+	}
+	parallelBlocks(n, numWorkers, func(w, start, end int) {
+		for s := start; s < end; s++ {
+			nn.storeOutputs(values[w], input(s), output(s))
+		}
+	})
+}
+
+// NEATConfig holds the parameters of a NEAT population.
+// Zero fields take the default values of the NEAT paper where applicable.
+type NEATConfig struct {
+	NumInputs, NumOutputs int
+	// PopulationSize defaults to 150.
+	PopulationSize int
+	// Activation is the activation function of hidden and output nodes.
+	// Defaults to Sigmoid.
+	Activation func(float64) float64
+
+	// ExcessCoeff, DisjointCoeff and WeightCoeff weigh the number of excess and
+	// disjoint genes and the mean weight difference of matching genes in the
+	// compatibility distance of two genomes. They default to 1, 1 and 0.4.
+	ExcessCoeff, DisjointCoeff, WeightCoeff float64
+	// CompatibilityThreshold is the largest compatibility distance of a genome
+	// to the representative of its species. Defaults to 3.
+	CompatibilityThreshold float64
+	// StagnationLimit is the number of generations a species may go without
+	// improving before it is removed. Defaults to 15.
+	StagnationLimit int
+	// SurvivalRate is the fraction of the best genomes of each species which
+	// reproduce. Defaults to 0.2.
+	SurvivalRate float64
+
+	// CrossoverRate is the probability of offspring having two parents. Defaults to 0.75.
+	CrossoverRate float64
+	// WeightMutationRate is the probability of mutating the weights of offspring.
+	// Defaults to 0.8.
+	WeightMutationRate float64
+	// WeightPerturbStdDev is the standard deviation of weight perturbations. Defaults to 0.5.
+	WeightPerturbStdDev float64
+	// WeightResetRate is the probability of replacing a mutated weight by a new
+	// random weight instead of perturbing it. Defaults to 0.1.
+	WeightResetRate float64
+	// AddNodeRate is the probability of splitting a connection with a new node.
+	// Defaults to 0.03.
+	AddNodeRate float64
+	// AddConnectionRate is the probability of adding a new connection. Defaults to 0.05.
+	AddConnectionRate float64
+}
+
+func (c *NEATConfig) setDefaults() {
+	if c.NumInputs <= 0 || c.NumOutputs <= 0 {
+		panic("NEAT requires inputs and outputs")
+	}
+	defaultInt := func(v *int, def int) {
+		if *v <= 0 {
+			*v = def
+		}
+	}
+	defaultFloat := func(v *float64, def float64) {
+		if *v <= 0 {
+			*v = def
+		}
+	}
+	defaultInt(&c.PopulationSize, 150)
+	defaultInt(&c.StagnationLimit, 15)
+	defaultFloat(&c.ExcessCoeff, 1)
+	defaultFloat(&c.DisjointCoeff, 1)
+	defaultFloat(&c.WeightCoeff, 0.4)
+	defaultFloat(&c.CompatibilityThreshold, 3)
+	defaultFloat(&c.SurvivalRate, 0.2)
+	defaultFloat(&c.CrossoverRate, 0.75)
+	defaultFloat(&c.WeightMutationRate, 0.8)
+	defaultFloat(&c.WeightPerturbStdDev, 0.5)
+	defaultFloat(&c.WeightResetRate, 0.1)
+	defaultFloat(&c.AddNodeRate, 0.03)
+	defaultFloat(&c.AddConnectionRate, 0.05)
+	if c.Activation == nil {
+		c.Activation = Sigmoid
+	}
+}
+
+// NEATObjective returns the cost of a network, lower being better. Costs may be
+// negative; only their differences within a generation affect the evolution. It is
+// called concurrently by NEAT.Step with a network of its own for each call.
+type NEATObjective func(nn *NEATNetwork) float64
+
+// NEAT is a population of genomes evolved with NeuroEvolution of Augmenting Topologies.
+type NEAT struct {
+	// Workers is the number of goroutines evaluating genomes.
+	// Defaults to runtime.GOMAXPROCS(0).
+	Workers int
+
+	config  NEATConfig
+	rng     *rand.Rand
+	genomes []*Genome
+	species []*neatSpecies
+	// innovations holds the innovation number of the connection between two nodes
+	// and splits the node created by splitting a connection of an innovation number.
+	innovations    map[[2]int]int
+	splits         map[int]int
+	nextInnovation int
+	nextNode       int
+	generation     int
+	best           *Genome
+	bestCost       float64
+	costs          []float64
+}
+
+type neatSpecies struct {
+	representative *Genome
+	members        []*Genome
+	bestCost       float64
+	// lastImproved is the generation in which bestCost was last improved.
+	lastImproved int
+}
+
+// NewNEAT returns a population of minimal genomes in which every input and the
+// bias is connected to every output with random weights.
+func NewNEAT(config NEATConfig, src rand.Source) *NEAT {
+	config.setDefaults()
+	p := &NEAT{
+		config:      config,
+		rng:         rand.New(src),
+		innovations: make(map[[2]int]int),
+		splits:      make(map[int]int),
+		nextNode:    config.NumInputs + 1 + config.NumOutputs,
+		bestCost:    math.Inf(1),
+	}
+	for i := 0; i < config.PopulationSize; i++ {
+		g := &Genome{}
+		for id := 0; id < p.nextNode; id++ {
+			kind := NodeOutput
+			switch {
+			case id < config.NumInputs:
+				kind = NodeInput
+			case id == config.NumInputs:
+				kind = NodeBias
+			}
+			g.Nodes = append(g.Nodes, NodeGene{ID: id, Kind: kind})
+		}
+		for in := 0; in <= config.NumInputs; in++ {
+			for out := config.NumInputs + 1; out < p.nextNode; out++ {
+				g.Connections = append(g.Connections, ConnectionGene{
+					In: in, Out: out, Weight: p.randomWeight(), Enabled: true, Innovation: p.innovation(in, out),
+				})
+			}
+		}
+		p.genomes = append(p.genomes, g)
+	}
+	return p
+}
+
+// Step evaluates every genome of the population with objective, then breeds the
+// next generation. It returns the lowest cost found so far.
+func (p *NEAT) Step(objective NEATObjective) (bestCost float64) {
+	p.costs = resize(p.costs, len(p.genomes))
+	parallelCosts(p.costs, p.Workers, func(i int) float64 {
+		return objective(p.genomes[i].Network(p.config.Activation))
+	})
+	// Fitness must be positive for fitness sharing. Costs are taken relative to
+	// the lowest cost of the generation so that fitness is in (0, 1] for costs of
+	// any sign, such as negated rewards.
+	minCost := math.Inf(1)
+	for _, cost := range p.costs {
+		minCost = math.Min(minCost, cost)
+	}
+	for i, g := range p.genomes {
+		g.cost = p.costs[i]
+		g.fitness = 1
+		if g.cost > minCost {
+			g.fitness = 1 / (1 + g.cost - minCost)
+		}
+		if p.costs[i] < p.bestCost {
+			p.bestCost = p.costs[i]
+			p.best = g.clone()
+		}
+	}
+	p.speciate()
+	p.reproduce()
+	p.generation++
+	return p.bestCost
+}
+
+// Best returns the genome with the lowest cost found so far and its cost.
+func (p *NEAT) Best() (*Genome, float64) { return p.best.clone(), p.bestCost }
+
+// BestNetwork returns the network of the genome with the lowest cost found so far.
+func (p *NEAT) BestNetwork() *NEATNetwork { return p.best.Network(p.config.Activation) }
+
+// Generation returns the number of generations evaluated.
+func (p *NEAT) Generation() int { return p.generation }
+
+// NumSpecies returns the number of species of the last evaluated generation.
+func (p *NEAT) NumSpecies() int { return len(p.species) }
+
+func (p *NEAT) randomWeight() float64 { return 2*p.rng.Float64() - 1 }
+
+// innovation returns the innovation number of the connection between nodes in and out.
+func (p *NEAT) innovation(in, out int) int {
+	key := [2]int{in, out}
+	if innov, ok := p.innovations[key]; ok {
+		return innov
+	}
+	p.nextInnovation++
+	p.innovations[key] = p.nextInnovation
+	return p.nextInnovation
+}
+
+// compatibility returns the compatibility distance of two genomes.
+func (p *NEAT) compatibility(a, b *Genome) float64 {
+	var matching, disjoint, excess int
+	var weightDiff float64
+	i, j := 0, 0
+	for i < len(a.Connections) && j < len(b.Connections) {
+		ia, ib := a.Connections[i].Innovation, b.Connections[j].Innovation
+		switch {
+		case ia == ib:
+			matching++
+			weightDiff += math.Abs(a.Connections[i].Weight - b.Connections[j].Weight)
+			i++
+			j++
+		case ia < ib:
+			disjoint++
+			i++
+		default:
+			disjoint++
+			j++
+		}
+	}
+	excess = len(a.Connections) - i + len(b.Connections) - j
+	n := len(a.Connections)
+	if len(b.Connections) > n {
+		n = len(b.Connections)
+	}
+	if n < 20 {
+		n = 1 // Small genomes are not normalized by size.
+	}
+	distance := (p.config.ExcessCoeff*float64(excess) + p.config.DisjointCoeff*float64(disjoint)) / float64(n)
+	if matching > 0 {
+		distance += p.config.WeightCoeff * weightDiff / float64(matching)
+	}
+	return distance
+}
+
+// speciate assigns each genome to the first species whose representative is
+// compatible or to a new species. Empty and stagnant species are removed.
+func (p *NEAT) speciate() {
+	for _, s := range p.species {
+		s.members = s.members[:0]
+	}
+	for _, g := range p.genomes {
+		var found *neatSpecies
+		for _, s := range p.species {
+			if p.compatibility(g, s.representative) < p.config.CompatibilityThreshold {
+				found = s
+				break
+			}
+		}
+		if found == nil {
+			found = &neatSpecies{representative: g, bestCost: math.Inf(1), lastImproved: p.generation}
+			p.species = append(p.species, found)
+		}
+		found.members = append(found.members, g)
+	}
+	species := p.species[:0]
+	for _, s := range p.species {
+		if len(s.members) == 0 {
+			continue
+		}
+		sort.SliceStable(s.members, func(i, j int) bool { return s.members[i].cost < s.members[j].cost })
+		if best := s.members[0].cost; best < s.bestCost {
+			s.bestCost = best
+			s.lastImproved = p.generation
+		}
+		species = append(species, s)
+	}
+	p.species = species
+	if len(p.species) > 1 {
+		// Keep the species with the best genome even when it stagnates.
+		sort.SliceStable(p.species, func(i, j int) bool { return p.species[i].bestCost < p.species[j].bestCost })
+		species := p.species[:1]
+		for _, s := range p.species[1:] {
+			if p.generation-s.lastImproved <= p.config.StagnationLimit {
+				species = append(species, s)
+			}
+		}
+		p.species = species
+	}
+	// A random member represents the species in the next generation.
+	for _, s := range p.species {
+		s.representative = s.members[p.rng.Intn(len(s.members))]
+	}
+}
+
+// reproduce replaces the population by the offspring of each species. Each species
+// gets offspring in proportion to the mean fitness of its members.
+func (p *NEAT) reproduce() {
+	var total float64
+	adjusted := make([]float64, len(p.species))
+	for i, s := range p.species {
+		for _, g := range s.members {
+			adjusted[i] += g.fitness
+		}
+		adjusted[i] /= float64(len(s.members)) // Fitness sharing.
+		total += adjusted[i]
+	}
+	// Distribute offspring by largest remainder so that the population size is kept.
+	size := p.config.PopulationSize
+	counts := make([]int, len(p.species))
+	remainders := make([]float64, len(p.species))
+	assigned := 0
+	for i := range p.species {
+		share := adjusted[i] / total * float64(size)
+		counts[i] = int(share)
+		remainders[i] = share - float64(counts[i])
+		assigned += counts[i]
+	}
+	order := sortedIndices(nil, remainders)
+	for k := len(order) - 1; assigned < size; k-- {
+		counts[order[k]]++
+		assigned++
+	}
+
+	next := make([]*Genome, 0, size)
+	for i, s := range p.species {
+		if counts[i] == 0 {
+			continue
+		}
+		// The champion of species with more than five members survives unchanged.
+		n := counts[i]
+		if len(s.members) > 5 {
+			next = append(next, s.members[0].clone())
+			n--
+		}
+		parents := s.members[:int(math.Max(1, math.Ceil(p.config.SurvivalRate*float64(len(s.members)))))]
+		for ; n > 0; n-- {
+			a := parents[p.rng.Intn(len(parents))]
+			var child *Genome
+			if p.rng.Float64() < p.config.CrossoverRate {
+				child = p.crossover(a, parents[p.rng.Intn(len(parents))])
+			} else {
+				child = a.clone()
+			}
+			p.mutate(child)
+			next = append(next, child)
+		}
+	}
+	p.genomes = next
+}
+
+// crossover returns the child of a and b. Matching genes are inherited randomly
+// and disjoint and excess genes from the fitter parent.
+func (p *NEAT) crossover(a, b *Genome) *Genome {
+	if b.fitness > a.fitness {
+		a, b = b, a
+	}
+	child := &Genome{Nodes: append([]NodeGene{}, a.Nodes...)}
+	j := 0
+	for _, conn := range a.Connections {
+		for j < len(b.Connections) && b.Connections[j].Innovation < conn.Innovation {
+			j++
+		}
+		if j < len(b.Connections) && b.Connections[j].Innovation == conn.Innovation {
+			other := b.Connections[j]
+			if p.rng.Intn(2) == 1 {
+				conn.Weight = other.Weight
+			}
+			if conn.Enabled != other.Enabled {
+				// Genes disabled in either parent are usually disabled.
+				conn.Enabled = p.rng.Float64() >= 0.75
+			}
+		}
+		child.Connections = append(child.Connections, conn)
+	}
+	return child
+}
+
+func (p *NEAT) mutate(g *Genome) {
+	if p.rng.Float64() < p.config.WeightMutationRate {
+		for i := range g.Connections {
+			if p.rng.Float64() < p.config.WeightResetRate {
+				g.Connections[i].Weight = p.randomWeight()
+			} else {
+				g.Connections[i].Weight += p.config.WeightPerturbStdDev * p.rng.NormFloat64()
+			}
+		}
+	}
+	if p.rng.Float64() < p.config.AddConnectionRate {
+		p.addConnection(g)
+	}
+	if p.rng.Float64() < p.config.AddNodeRate {
+		p.addNode(g)
+	}
+}
+
+// addConnection connects two unconnected nodes without creating a cycle.
+func (p *NEAT) addConnection(g *Genome) {
+	const attempts = 20
+	for try := 0; try < attempts; try++ {
+		in := g.Nodes[p.rng.Intn(len(g.Nodes))]
+		out := g.Nodes[p.rng.Intn(len(g.Nodes))]
+		if in.Kind == NodeOutput || out.Kind == NodeInput || out.Kind == NodeBias || in.ID == out.ID {
+			continue
+		}
+		exists := false
+		for _, conn := range g.Connections {
+			if conn.In == in.ID && conn.Out == out.ID {
+				exists = true
+				break
+			}
+		}
+		// A path from out to in would close a cycle.
+		if exists || g.connected(out.ID, in.ID) {
+			continue
+		}
+		g.addConnection(ConnectionGene{In: in.ID, Out: out.ID, Weight: p.randomWeight(), Enabled: true, Innovation: p.innovation(in.ID, out.ID)})
+		return
+	}
+}
+
+// addNode splits an enabled connection in two with a new node. The connection
+// into the new node has weight 1 and the connection out of it the weight of the
+// split connection so that the network initially behaves similarly.
+func (p *NEAT) addNode(g *Genome) {
+	var enabled []int
+	for i, conn := range g.Connections {
+		if conn.Enabled {
+			enabled = append(enabled, i)
+		}
+	}
+	if len(enabled) == 0 {
+		return
+	}
+	i := enabled[p.rng.Intn(len(enabled))]
+	split := g.Connections[i]
+	g.Connections[i].Enabled = false
+	// Genomes splitting the same connection get the same node unless it is in use.
+	id, ok := p.splits[split.Innovation]
+	if !ok || g.hasNode(id) {
+		id = p.nextNode
+		p.nextNode++
+		if !ok {
+			p.splits[split.Innovation] = id
+		}
+	}
+	g.Nodes = append(g.Nodes, NodeGene{ID: id, Kind: NodeHidden})
+	g.addConnection(ConnectionGene{In: split.In, Out: id, Weight: 1, Enabled: true, Innovation: p.innovation(split.In, id)})
+	g.addConnection(ConnectionGene{In: id, Out: split.Out, Weight: split.Weight, Enabled: true, Innovation: p.innovation(id, split.Out)})
+}
+
+// addConnection inserts conn keeping connections sorted by innovation number.
+func (g *Genome) addConnection(conn ConnectionGene) {
+	i := sort.Search(len(g.Connections), func(i int) bool { return g.Connections[i].Innovation >= conn.Innovation })
+	g.Connections = append(g.Connections, ConnectionGene{})
+	copy(g.Connections[i+1:], g.Connections[i:])
+	g.Connections[i] = conn
+}
@@ -0,0 +1,140 @@
+package neurus_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// TestNEAT_xor grows hidden nodes from minimal genomes until the exclusive or is
+// computed, which is impossible without hidden nodes.
+func TestNEAT_xor(t *testing.T) {
+	const generations = 300
+	p := neurus.NewNEAT(neurus.NEATConfig{NumInputs: 2, NumOutputs: 1}, rand.NewSource(1))
+	objective := func(nn *neurus.NEATNetwork) float64 { return nn.Cost(xorData) }
+	for p.Generation() < generations {
+		p.Step(objective)
+		if solvesXOR(p.BestNetwork()) {
+			break
+		}
+	}
+	best, cost := p.Best()
+	t.Logf("cost %g after %d generations with %d hidden nodes, %d connections and %d species",
+		cost, p.Generation(), best.NumHidden(), best.NumEnabled(), p.NumSpecies())
+	if !solvesXOR(p.BestNetwork()) {
+		t.Fatalf("XOR not learned after %d generations, cost %g", generations, cost)
+	}
+	if best.NumHidden() == 0 {
+		t.Error("XOR solved without hidden nodes")
+	}
+}
+
+// TestNEAT_negativeCosts checks costs below zero, such as negated rewards,
+// are evolved like the same costs shifted above zero.
+func TestNEAT_negativeCosts(t *testing.T) {
+	const (
+		generations = 30
+		shift       = 10
+	)
+	config := neurus.NEATConfig{NumInputs: 2, NumOutputs: 1}
+	p := neurus.NewNEAT(config, rand.NewSource(1))
+	shifted := neurus.NewNEAT(config, rand.NewSource(1))
+	for p.Generation() < generations {
+		cost := p.Step(func(nn *neurus.NEATNetwork) float64 { return nn.Cost(xorData) })
+		shiftedCost := shifted.Step(func(nn *neurus.NEATNetwork) float64 { return nn.Cost(xorData) - shift })
+		if math.Abs(shiftedCost+shift-cost) > 1e-9 || shifted.NumSpecies() != p.NumSpecies() {
+			t.Fatalf("generation %d: shifted cost %g and %d species, want %g and %d species",
+				p.Generation(), shiftedCost, shifted.NumSpecies(), cost-shift, p.NumSpecies())
+		}
+	}
+}
+
+func solvesXOR(nn *neurus.NEATNetwork) bool {
+	for _, dp := range xorData {
+		if math.Round(nn.CalculateOutputs(dp.Input)[0]) != dp.ExpectedOutput[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNEAT_twoD(t *testing.T) {
+	const generations = 300
+	rng := rand.New(rand.NewSource(1))
+	data := make([]neurus.DataPoint, 200)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		expected[basic2DClassifier(x, y)] = 1
+		data[i] = neurus.DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	p := neurus.NewNEAT(neurus.NEATConfig{NumInputs: 2, NumOutputs: 2}, rand.NewSource(1))
+	objective := func(nn *neurus.NEATNetwork) float64 { return nn.Cost(data) }
+	var cost float64
+	for p.Generation() < generations {
+		cost = p.Step(objective)
+	}
+	best, _ := p.Best()
+	classifier := neurus.PredictorClassifier(p.BestNetwork())
+	var correct int
+	for _, dp := range data {
+		if classifier(dp.Input[0], dp.Input[1]) == basic2DClassifier(dp.Input[0], dp.Input[1]) {
+			correct++
+		}
+	}
+	accuracy := float64(correct) / float64(len(data))
+	t.Logf("cost %g, accuracy %g with %d hidden nodes and %d connections", cost, accuracy, best.NumHidden(), best.NumEnabled())
+	if accuracy < 0.95 {
+		t.Errorf("accuracy %g below 0.95", accuracy)
+	}
+}
+
+func TestNEATNetwork_PredictMatrix(t *testing.T) {
+	// Inputs 0 and 1, bias 2, output 3 and hidden nodes 4 and 5 where node 5
+	// takes input from node 4 and a connection from node 0 to 3 is disabled.
+	g := neurus.Genome{
+		Nodes: []neurus.NodeGene{
+			{ID: 0, Kind: neurus.NodeInput}, {ID: 1, Kind: neurus.NodeInput}, {ID: 2, Kind: neurus.NodeBias},
+			{ID: 3, Kind: neurus.NodeOutput}, {ID: 5, Kind: neurus.NodeHidden}, {ID: 4, Kind: neurus.NodeHidden},
+		},
+		Connections: []neurus.ConnectionGene{
+			{In: 0, Out: 3, Weight: 3, Enabled: false, Innovation: 1},
+			{In: 1, Out: 3, Weight: 0.5, Enabled: true, Innovation: 2},
+			{In: 2, Out: 3, Weight: -0.25, Enabled: true, Innovation: 3},
+			{In: 0, Out: 4, Weight: 1, Enabled: true, Innovation: 4},
+			{In: 5, Out: 3, Weight: 2, Enabled: true, Innovation: 5},
+			{In: 4, Out: 5, Weight: -1.5, Enabled: true, Innovation: 6},
+			{In: 1, Out: 5, Weight: 0.75, Enabled: true, Innovation: 7},
+		},
+	}
+	nn := g.Network(math.Tanh)
+	if numIn, numOut := nn.Dims(); numIn != 2 || numOut != 1 {
+		t.Fatalf("got dims %d, %d", numIn, numOut)
+	}
+	rng := rand.New(rand.NewSource(1))
+	const n = 100
+	inputs := make([]float64, 2*n)
+	for i := range inputs {
+		inputs[i] = 2*rng.Float64() - 1
+	}
+	outputs := make([]float64, n)
+	nn.PredictMatrix(outputs, inputs)
+	for s := 0; s < n; s++ {
+		x0, x1 := inputs[2*s], inputs[2*s+1]
+		h4 := math.Tanh(x0)
+		h5 := math.Tanh(-1.5*h4 + 0.75*x1)
+		want := math.Tanh(0.5*x1 - 0.25 + 2*h5)
+		if got := nn.CalculateOutputs(inputs[2*s : 2*s+2])[0]; math.Abs(got-want) > 1e-15 {
+			t.Fatalf("row %d: CalculateOutputs got %g, want %g", s, got, want)
+		}
+		if got := outputs[s]; math.Abs(got-want) > 1e-15 {
+			t.Fatalf("row %d: PredictMatrix got %g, want %g", s, got, want)
+		}
+	}
+	classifier := neurus.PredictorClassifier(nn)
+	if allocs := testing.AllocsPerRun(100, func() { classifier(0.5, -0.5) }); allocs != 0 {
+		t.Errorf("classifier allocated %v times per point", allocs)
+	}
+}
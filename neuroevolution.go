@@ -0,0 +1,642 @@
+package neurus
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// This file contains training without gradients by neuroevolution.
+// As TrainerLvl0 shows, training only requires computing the cost of the
+// network: a population of candidate weights and biases is evaluated and the
+// best candidates guide the next generation. Unlike the trainers of the other
+// levels the cost needs not be differentiable, so networks with Step activations
+// or objectives given by a reward signal may be trained.
+
+// EvolvableNetwork is a network which may be trained with CostObjective.
+// *NetworkLvl0, NetworkLvl2 and NetworkLvl3 are EvolvableNetworks. A
+// NetworkOptimized is adapted with EvolvableOptimized.
+type EvolvableNetwork interface {
+	Export() []LayerSetup
+	Import(setup []LayerSetup)
+	Cost(data []DataPoint) float64
+}
+
+// EvolvableOptimized returns nn as an EvolvableNetwork. Setups are imported with
+// the activation functions created by fn, or with those stored in the setups
+// if fn is nil. The cost is the mean of nn.Cost over the data computed in
+// ModeInference. Networks evaluated concurrently must not share a CostFunc.
+func EvolvableOptimized(nn *NetworkOptimized, fn func() ActivationFunc) EvolvableNetwork {
+	return evolvableOptimized{nn: nn, fn: fn}
+}
+
+type evolvableOptimized struct {
+	nn *NetworkOptimized
+	fn func() ActivationFunc
+}
+
+func (e evolvableOptimized) Export() []LayerSetup { return e.nn.Export() }
+
+func (e evolvableOptimized) Import(setup []LayerSetup) { e.nn.Import(setup, e.fn) }
+
+func (e evolvableOptimized) Cost(data []DataPoint) (totalCost float64) {
+	prevMode := e.nn.mode
+	e.nn.mode = ModeInference
+	defer func() { e.nn.mode = prevMode }()
+	for _, dp := range data {
+		e.nn.Cost.CalculateFromInputs(e.nn.StoreOutputs(dp.Input), dp.ExpectedOutput, 1)
+		totalCost += e.nn.Cost.TotalCost()
+	}
+	return totalCost / float64(len(data))
+}
+
+// Objective returns the cost of a network with the weights and biases of setup.
+// Lower costs are better. Objectives are called concurrently by Evolution.
+type Objective func(setup []LayerSetup) float64
+
+// CostObjective returns an Objective which imports setup into a network created
+// by newNetwork and returns its Cost over data. A network is created for each
+// concurrent evaluation and reused by later evaluations. Unlike in a sync.Pool
+// the networks are never dropped, so the number of calls to newNetwork does not
+// depend on garbage collection.
+func CostObjective(newNetwork func() EvolvableNetwork, data []DataPoint) Objective {
+	var (
+		mu   sync.Mutex
+		idle []EvolvableNetwork
+	)
+	return func(setup []LayerSetup) float64 {
+		mu.Lock()
+		var nn EvolvableNetwork
+		if len(idle) > 0 {
+			nn, idle = idle[len(idle)-1], idle[:len(idle)-1]
+		}
+		mu.Unlock()
+		if nn == nil {
+			nn = newNetwork()
+		}
+		nn.Import(setup)
+		cost := nn.Cost(data)
+		mu.Lock()
+		idle = append(idle, nn)
+		mu.Unlock()
+		return cost
+	}
+}
+
+// Strategy generates the candidates of each generation of an Evolution as
+// parameter vectors and adapts to their costs. A vector holds the weights of each
+// layer row by row followed by its biases, layer after layer.
+type Strategy interface {
+	// Init starts the strategy around the parameter vector x0.
+	Init(x0 []float64, rng *rand.Rand)
+	// Ask returns the candidates of the next generation. They
+	// are owned by the strategy and valid until the next call to Tell.
+	Ask(rng *rand.Rand) [][]float64
+	// Tell passes the cost of each candidate returned by the last call to Ask.
+	Tell(costs []float64, rng *rand.Rand)
+}
+
+// Evolution trains the weights and biases of a network by evolving a population
+// of candidates generated by a Strategy. Candidates are evaluated by an Objective
+// in parallel goroutines. Given the same random source the result does not
+// depend on the number of goroutines.
+type Evolution struct {
+	// Workers is the number of goroutines evaluating candidates.
+	// Defaults to runtime.GOMAXPROCS(0).
+	Workers int
+
+	objective   Objective
+	strategy    Strategy
+	template    []LayerSetup
+	rng         *rand.Rand
+	best        []float64
+	bestCost    float64
+	generation  int
+	evaluations int
+	costs       []float64
+}
+
+// NewEvolution returns an Evolution of the weights and biases of initial, as
+// returned by the Export method of a network. Other layer settings such as the
+// activation are copied unchanged to every candidate.
+func NewEvolution(initial []LayerSetup, objective Objective, strategy Strategy, src rand.Source) *Evolution {
+	ev := &Evolution{
+		objective: objective,
+		strategy:  strategy,
+		template:  initial,
+		rng:       rand.New(src),
+		best:      flattenSetup(nil, initial),
+		bestCost:  math.Inf(1),
+	}
+	strategy.Init(append([]float64{}, ev.best...), ev.rng)
+	return ev
+}
+
+// Step evaluates a generation of candidates and returns the lowest cost found so far.
+func (ev *Evolution) Step() (bestCost float64) {
+	candidates := ev.strategy.Ask(ev.rng)
+	ev.costs = resize(ev.costs, len(candidates))
+	parallelCosts(ev.costs, ev.Workers, func(i int) float64 {
+		return ev.objective(unflattenSetup(ev.template, candidates[i]))
+	})
+	for i, cost := range ev.costs {
+		if cost < ev.bestCost {
+			ev.bestCost = cost
+			copy(ev.best, candidates[i])
+		}
+	}
+	ev.strategy.Tell(ev.costs, ev.rng)
+	ev.generation++
+	ev.evaluations += len(candidates)
+	return ev.bestCost
+}
+
+// parallelCosts stores cost(i) in costs[i] for every index of costs using the given
+// number of goroutines, or GOMAXPROCS goroutines if workers is not positive.
+// NaN costs are replaced by +Inf so that costs may be sorted.
+func parallelCosts(costs []float64, workers int, cost func(i int) float64) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(costs) {
+					return
+				}
+				c := cost(i)
+				if math.IsNaN(c) {
+					c = math.Inf(1)
+				}
+				costs[i] = c
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Best returns the weights and biases with the lowest cost found so far and the cost.
+func (ev *Evolution) Best() (setup []LayerSetup, cost float64) {
+	return unflattenSetup(ev.template, ev.best), ev.bestCost
+}
+
+// Generation returns the number of generations evaluated.
+func (ev *Evolution) Generation() int { return ev.generation }
+
+// Evaluations returns the number of times the objective was called.
+func (ev *Evolution) Evaluations() int { return ev.evaluations }
+
+// flattenSetup stores the weights of each layer of setup row by row followed by
+// its biases in dst, reusing its capacity, and returns it.
+func flattenSetup(dst []float64, setup []LayerSetup) []float64 {
+	dst = dst[:0]
+	for _, layer := range setup {
+		for _, weights := range layer.Weights {
+			dst = append(dst, weights...)
+		}
+		dst = append(dst, layer.Biases...)
+	}
+	return dst
+}
+
+// unflattenSetup returns a copy of template with the weights and biases of x,
+// flattened as by flattenSetup.
+func unflattenSetup(template []LayerSetup, x []float64) []LayerSetup {
+	setup := make([]LayerSetup, len(template))
+	for i, layer := range template {
+		setup[i] = layer
+		setup[i].Weights = make([][]float64, len(layer.Weights))
+		for j := range layer.Weights {
+			setup[i].Weights[j] = append([]float64{}, x[:len(layer.Weights[j])]...)
+			x = x[len(layer.Weights[j]):]
+		}
+		setup[i].Biases = append([]float64{}, x[:len(layer.Biases)]...)
+		x = x[len(layer.Biases):]
+	}
+	if len(x) != 0 {
+		panic("length of parameter vector mismatches layer setup")
+	}
+	return setup
+}
+
+// sortedIndices returns the indices of costs from lowest to highest cost in idx,
+// reusing its capacity. Ties keep index order so that results are reproducible.
+func sortedIndices(idx []int, costs []float64) []int {
+	idx = resize(idx, len(costs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return costs[idx[i]] < costs[idx[j]] })
+	return idx
+}
+
+// MuLambdaES is a (μ, λ) evolution strategy. Each generation λ offspring are
+// created by adding normally distributed noise to randomly chosen parents and
+// the μ best offspring replace the parents. Each candidate carries its own noise
+// standard deviation which is mutated along with it, adapting the step size.
+// Zero fields take their default values.
+type MuLambdaES struct {
+	// Mu is the number of parents. Defaults to 5.
+	Mu int
+	// Lambda is the number of offspring per generation, at least Mu. Defaults to 30.
+	Lambda int
+	// Sigma is the initial noise standard deviation. Defaults to 0.3.
+	Sigma float64
+
+	parents, offspring [][]float64
+	sigmas, offSigmas  []float64
+	order              []int
+}
+
+func (es *MuLambdaES) Init(x0 []float64, rng *rand.Rand) {
+	if es.Mu <= 0 {
+		es.Mu = 5
+	}
+	if es.Lambda <= 0 {
+		es.Lambda = 30
+	}
+	if es.Lambda < es.Mu {
+		panic("MuLambdaES requires Lambda >= Mu")
+	}
+	if es.Sigma <= 0 {
+		es.Sigma = 0.3
+	}
+	es.parents = make([][]float64, es.Mu)
+	es.sigmas = make([]float64, es.Mu)
+	for i := range es.parents {
+		es.parents[i] = append([]float64{}, x0...)
+		es.sigmas[i] = es.Sigma
+	}
+	es.offspring = make([][]float64, es.Lambda)
+	for i := range es.offspring {
+		es.offspring[i] = make([]float64, len(x0))
+	}
+	es.offSigmas = make([]float64, es.Lambda)
+}
+
+func (es *MuLambdaES) Ask(rng *rand.Rand) [][]float64 {
+	// Learning rate of the log-normal step size mutation.
+	tau := 1 / math.Sqrt(float64(len(es.parents[0])))
+	for k, child := range es.offspring {
+		p := rng.Intn(es.Mu)
+		sigma := es.sigmas[p] * math.Exp(tau*rng.NormFloat64())
+		for i, v := range es.parents[p] {
+			child[i] = v + sigma*rng.NormFloat64()
+		}
+		es.offSigmas[k] = sigma
+	}
+	return es.offspring
+}
+
+func (es *MuLambdaES) Tell(costs []float64, rng *rand.Rand) {
+	es.order = sortedIndices(es.order, costs)
+	for i := range es.parents {
+		copy(es.parents[i], es.offspring[es.order[i]])
+		es.sigmas[i] = es.offSigmas[es.order[i]]
+	}
+}
+
+// Genetic is a genetic algorithm. Each generation the Elite best candidates
+// survive unchanged and the rest of the population is replaced by children of
+// parents chosen by tournament selection. A child takes each weight and bias from
+// either parent at random (uniform crossover) and some of its values are mutated
+// by adding normally distributed noise. Zero fields take their default values.
+type Genetic struct {
+	// PopulationSize defaults to 50.
+	PopulationSize int
+	// Elite is the number of best candidates kept each generation. Defaults to 2.
+	Elite int
+	// TournamentSize is the number of candidates competing to be a parent. Defaults to 3.
+	TournamentSize int
+	// CrossoverRate is the probability of a child having two parents
+	// instead of being a copy of one. Defaults to 0.9.
+	CrossoverRate float64
+	// MutationRate is the probability of mutating each value of a child. Defaults to 0.1.
+	MutationRate float64
+	// MutationStdDev is the standard deviation of mutations and of the
+	// initial population around the initial candidate. Defaults to 0.3.
+	MutationStdDev float64
+
+	population, next [][]float64
+	costs            []float64
+	order            []int
+}
+
+func (ga *Genetic) Init(x0 []float64, rng *rand.Rand) {
+	if ga.PopulationSize <= 0 {
+		ga.PopulationSize = 50
+	}
+	if ga.Elite <= 0 {
+		ga.Elite = 2
+	}
+	if ga.Elite > ga.PopulationSize {
+		panic("Genetic requires Elite <= PopulationSize")
+	}
+	if ga.TournamentSize <= 0 {
+		ga.TournamentSize = 3
+	}
+	if ga.CrossoverRate <= 0 {
+		ga.CrossoverRate = 0.9
+	}
+	if ga.MutationRate <= 0 {
+		ga.MutationRate = 0.1
+	}
+	if ga.MutationStdDev <= 0 {
+		ga.MutationStdDev = 0.3
+	}
+	ga.population = make([][]float64, ga.PopulationSize)
+	ga.next = make([][]float64, ga.PopulationSize)
+	for i := range ga.population {
+		ga.population[i] = append([]float64{}, x0...)
+		ga.next[i] = make([]float64, len(x0))
+		if i == 0 {
+			continue // Keep the initial candidate.
+		}
+		for j := range ga.population[i] {
+			ga.population[i][j] += ga.MutationStdDev * rng.NormFloat64()
+		}
+	}
+}
+
+func (ga *Genetic) Ask(rng *rand.Rand) [][]float64 {
+	return ga.population
+}
+
+func (ga *Genetic) Tell(costs []float64, rng *rand.Rand) {
+	ga.costs = append(ga.costs[:0], costs...)
+	ga.order = sortedIndices(ga.order, costs)
+	for i := 0; i < ga.Elite; i++ {
+		copy(ga.next[i], ga.population[ga.order[i]])
+	}
+	for _, child := range ga.next[ga.Elite:] {
+		a := ga.population[ga.tournament(rng)]
+		copy(child, a)
+		if rng.Float64() < ga.CrossoverRate {
+			b := ga.population[ga.tournament(rng)]
+			for j := range child {
+				if rng.Intn(2) == 1 {
+					child[j] = b[j]
+				}
+			}
+		}
+		for j := range child {
+			if rng.Float64() < ga.MutationRate {
+				child[j] += ga.MutationStdDev * rng.NormFloat64()
+			}
+		}
+	}
+	ga.population, ga.next = ga.next, ga.population
+}
+
+// tournament returns the index of the best of TournamentSize random candidates.
+func (ga *Genetic) tournament(rng *rand.Rand) int {
+	best := rng.Intn(len(ga.population))
+	for i := 1; i < ga.TournamentSize; i++ {
+		if c := rng.Intn(len(ga.population)); ga.costs[c] < ga.costs[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// CMAES is the covariance matrix adaptation evolution strategy. Candidates are
+// drawn from a multivariate normal distribution whose mean, step size and
+// covariance matrix are adapted to the best candidates of each generation, so
+// that it learns the scaling and correlations of the parameters. Its cost grows
+// with the cube of the number of parameters, so it suits small networks.
+// Zero fields take their default values. See Hansen, The CMA Evolution Strategy: A Tutorial.
+type CMAES struct {
+	// Lambda is the number of candidates per generation.
+	// Defaults to 4+3ln(n) for n parameters.
+	Lambda int
+	// Sigma is the step size, adapted every generation. Defaults to 0.3.
+	Sigma float64
+
+	n, mu                                    int
+	weights                                  []float64
+	muEff, cSigma, dSigma, cc, c1, cMu, chiN float64
+	mean, pSigma, pc                         []float64
+	// c is the covariance matrix, b holds its eigenvectors as columns
+	// and d the square roots of its eigenvalues.
+	c, b, d        []float64
+	candidates, ys [][]float64
+	z, tmp         []float64
+	order          []int
+	generation     int
+	eigenGen       int
+}
+
+func (es *CMAES) Init(x0 []float64, rng *rand.Rand) {
+	n := len(x0)
+	nf := float64(n)
+	es.n = n
+	if es.Lambda <= 0 {
+		es.Lambda = 4 + int(3*math.Log(nf))
+	}
+	if es.Lambda < 2 {
+		panic("CMAES requires Lambda >= 2")
+	}
+	if es.Sigma <= 0 {
+		es.Sigma = 0.3
+	}
+	es.mu = es.Lambda / 2
+	es.weights = make([]float64, es.mu)
+	var sum, sumSq float64
+	for i := range es.weights {
+		es.weights[i] = math.Log(float64(es.Lambda+1)/2) - math.Log(float64(i+1))
+		sum += es.weights[i]
+	}
+	for i := range es.weights {
+		es.weights[i] /= sum
+		sumSq += es.weights[i] * es.weights[i]
+	}
+	es.muEff = 1 / sumSq
+	es.cSigma = (es.muEff + 2) / (nf + es.muEff + 5)
+	es.dSigma = 1 + 2*math.Max(0, math.Sqrt((es.muEff-1)/(nf+1))-1) + es.cSigma
+	es.cc = (4 + es.muEff/nf) / (nf + 4 + 2*es.muEff/nf)
+	es.c1 = 2 / ((nf+1.3)*(nf+1.3) + es.muEff)
+	es.cMu = math.Min(1-es.c1, 2*(es.muEff-2+1/es.muEff)/((nf+2)*(nf+2)+es.muEff))
+	es.chiN = math.Sqrt(nf) * (1 - 1/(4*nf) + 1/(21*nf*nf))
+
+	es.mean = append([]float64{}, x0...)
+	es.pSigma = make([]float64, n)
+	es.pc = make([]float64, n)
+	es.c = make([]float64, n*n)
+	es.b = make([]float64, n*n)
+	es.d = make([]float64, n)
+	for i := 0; i < n; i++ {
+		es.c[i*n+i] = 1
+		es.b[i*n+i] = 1
+		es.d[i] = 1
+	}
+	es.candidates = make([][]float64, es.Lambda)
+	es.ys = make([][]float64, es.Lambda)
+	for k := range es.candidates {
+		es.candidates[k] = make([]float64, n)
+		es.ys[k] = make([]float64, n)
+	}
+	es.z = make([]float64, n)
+	es.tmp = make([]float64, n)
+}
+
+func (es *CMAES) Ask(rng *rand.Rand) [][]float64 {
+	n := es.n
+	for k, x := range es.candidates {
+		// y = B·D·z is drawn from N(0, C).
+		for i := range es.z {
+			es.z[i] = es.d[i] * rng.NormFloat64()
+		}
+		y := es.ys[k]
+		for i := 0; i < n; i++ {
+			var sum float64
+			for j := 0; j < n; j++ {
+				sum += es.b[i*n+j] * es.z[j]
+			}
+			y[i] = sum
+			x[i] = es.mean[i] + es.Sigma*y[i]
+		}
+	}
+	return es.candidates
+}
+
+func (es *CMAES) Tell(costs []float64, rng *rand.Rand) {
+	n := es.n
+	nf := float64(n)
+	es.generation++
+	es.order = sortedIndices(es.order, costs)
+	// Weighted mean of the steps of the best candidates.
+	yw := es.z
+	fillZeros(yw)
+	for i, w := range es.weights {
+		y := es.ys[es.order[i]]
+		for j := range yw {
+			yw[j] += w * y[j]
+		}
+	}
+	for j := range es.mean {
+		es.mean[j] += es.Sigma * yw[j]
+	}
+
+	// Step size path uses C^(-1/2)·yw = B·D⁻¹·Bᵀ·yw.
+	for i := 0; i < n; i++ {
+		var sum float64
+		for j := 0; j < n; j++ {
+			sum += es.b[j*n+i] * yw[j]
+		}
+		es.tmp[i] = sum / es.d[i]
+	}
+	cs := math.Sqrt(es.cSigma * (2 - es.cSigma) * es.muEff)
+	var normPSigma float64
+	for i := 0; i < n; i++ {
+		var sum float64
+		for j := 0; j < n; j++ {
+			sum += es.b[i*n+j] * es.tmp[j]
+		}
+		es.pSigma[i] = (1-es.cSigma)*es.pSigma[i] + cs*sum
+		normPSigma += es.pSigma[i] * es.pSigma[i]
+	}
+	normPSigma = math.Sqrt(normPSigma)
+
+	// Stall the covariance path when the step size path is long.
+	hSigma := 0.0
+	if normPSigma/math.Sqrt(1-math.Pow(1-es.cSigma, 2*float64(es.generation)))/es.chiN < 1.4+2/(nf+1) {
+		hSigma = 1
+	}
+	ccs := math.Sqrt(es.cc * (2 - es.cc) * es.muEff)
+	for i := range es.pc {
+		es.pc[i] = (1-es.cc)*es.pc[i] + hSigma*ccs*yw[i]
+	}
+
+	// Rank-one and rank-μ updates of the covariance matrix.
+	decay := 1 - es.c1 - es.cMu + es.c1*(1-hSigma)*es.cc*(2-es.cc)
+	for i := 0; i < n; i++ {
+		row := es.c[i*n : (i+1)*n]
+		for j := i; j < n; j++ {
+			var rankMu float64
+			for k, w := range es.weights {
+				y := es.ys[es.order[k]]
+				rankMu += w * y[i] * y[j]
+			}
+			row[j] = decay*row[j] + es.c1*es.pc[i]*es.pc[j] + es.cMu*rankMu
+			es.c[j*n+i] = row[j]
+		}
+	}
+	es.Sigma *= math.Exp(es.cSigma / es.dSigma * (normPSigma/es.chiN - 1))
+
+	// Decompose C only every few generations since it costs O(n³).
+	if float64(es.generation-es.eigenGen) > float64(es.Lambda)/(es.c1+es.cMu)/nf/10 {
+		es.eigenGen = es.generation
+		a := append([]float64{}, es.c...)
+		symmetricEigen(a, es.d, es.b)
+		for i, v := range es.d {
+			es.d[i] = math.Sqrt(math.Max(v, 1e-20))
+		}
+	}
+}
+
+// symmetricEigen stores the eigenvalues of the symmetric n×n row-major matrix a in
+// vals and the corresponding eigenvectors as the columns of vecs using the cyclic
+// Jacobi method. a is overwritten.
+func symmetricEigen(a, vals, vecs []float64) {
+	n := len(vals)
+	fillZeros(vecs)
+	for i := 0; i < n; i++ {
+		vecs[i*n+i] = 1
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		var off, diag float64
+		for i := 0; i < n; i++ {
+			diag += a[i*n+i] * a[i*n+i]
+			for j := i + 1; j < n; j++ {
+				off += a[i*n+j] * a[i*n+j]
+			}
+		}
+		if off <= 1e-30*diag {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				apq := a[p*n+q]
+				if apq == 0 {
+					continue
+				}
+				// Rotate by the angle zeroing a[p][q].
+				theta := (a[q*n+q] - a[p*n+p]) / (2 * apq)
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k*n+p], a[k*n+q]
+					a[k*n+p] = c*akp - s*akq
+					a[k*n+q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p*n+k], a[q*n+k]
+					a[p*n+k] = c*apk - s*aqk
+					a[q*n+k] = s*apk + c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vecs[k*n+p], vecs[k*n+q]
+					vecs[k*n+p] = c*vkp - s*vkq
+					vecs[k*n+q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	for i := range vals {
+		vals[i] = a[i*n+i]
+	}
+}
@@ -0,0 +1,228 @@
+package neurus_test
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+var xorData = []neurus.DataPoint{
+	{Input: []float64{0, 0}, ExpectedOutput: []float64{0}},
+	{Input: []float64{0, 1}, ExpectedOutput: []float64{1}},
+	{Input: []float64{1, 0}, ExpectedOutput: []float64{1}},
+	{Input: []float64{1, 1}, ExpectedOutput: []float64{0}},
+}
+
+var strategies = []struct {
+	name string
+	new  func() neurus.Strategy
+}{
+	{name: "mulambda", new: func() neurus.Strategy { return &neurus.MuLambdaES{} }},
+	{name: "cmaes", new: func() neurus.Strategy { return &neurus.CMAES{} }},
+	{name: "genetic", new: func() neurus.Strategy { return &neurus.Genetic{} }},
+}
+
+func newStepNetwork(layerSizes ...int) func() neurus.EvolvableNetwork {
+	return func() neurus.EvolvableNetwork {
+		nn := neurus.NewNetworkLvl0(neurus.Step, layerSizes...)
+		return &nn
+	}
+}
+
+// TestEvolution_xorStep trains a network of Step activations, whose gradient
+// is zero almost everywhere, to compute the exclusive or of its inputs. The cost
+// has plateaus on which a search may get stuck so the search is restarted from
+// other initial parameters a few times, as is common in practice.
+func TestEvolution_xorStep(t *testing.T) {
+	const (
+		restarts    = 5
+		generations = 100
+	)
+	for _, strategy := range strategies {
+		t.Run(strategy.name, func(t *testing.T) {
+			newNetwork := newStepNetwork(2, 4, 1)
+			var (
+				setup []neurus.LayerSetup
+				cost  float64
+			)
+			for seed := int64(1); seed <= restarts; seed++ {
+				initial := randomSetup(rand.New(rand.NewSource(seed)), 2, 4, 1)
+				ev := neurus.NewEvolution(initial, neurus.CostObjective(newNetwork, xorData), strategy.new(), rand.NewSource(seed))
+				for ev.Generation() < generations && ev.Step() > 0 {
+				}
+				setup, cost = ev.Best()
+				t.Logf("start %d: cost %g after %d generations, %d evaluations", seed, cost, ev.Generation(), ev.Evaluations())
+				if cost == 0 {
+					break
+				}
+			}
+			if cost != 0 {
+				t.Fatalf("XOR not learned, cost %g", cost)
+			}
+			nn := newNetwork()
+			nn.Import(setup)
+			if got := nn.Cost(xorData); got != 0 {
+				t.Errorf("imported best setup has cost %g", got)
+			}
+		})
+	}
+}
+
+func TestEvolution_twoDStep(t *testing.T) {
+	const generations = 300
+	rng := rand.New(rand.NewSource(1))
+	data := make([]neurus.DataPoint, 200)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		expected[basic2DClassifier(x, y)] = 1
+		data[i] = neurus.DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	for _, strategy := range strategies {
+		t.Run(strategy.name, func(t *testing.T) {
+			initial := randomSetup(rand.New(rand.NewSource(1)), 2, 4, 2)
+			objective := neurus.CostObjective(newStepNetwork(2, 4, 2), data)
+			initialCost := objective(initial)
+			ev := neurus.NewEvolution(initial, objective, strategy.new(), rand.NewSource(1))
+			for ev.Generation() < generations {
+				ev.Step()
+			}
+			_, cost := ev.Best()
+			t.Logf("cost %g after %d generations from %g", cost, ev.Generation(), initialCost)
+			// A misclassified point costs 2, one with both outputs set costs 1.
+			if cost > 0.25 {
+				t.Errorf("cost %g, more than one in 8 points misclassified", cost)
+			}
+		})
+	}
+}
+
+// TestCostObjective_reuse checks networks outlive garbage collections, since
+// creating them draws from the global random source.
+func TestCostObjective_reuse(t *testing.T) {
+	newNetwork := newStepNetwork(2, 4, 1)
+	created := 0
+	objective := neurus.CostObjective(func() neurus.EvolvableNetwork {
+		created++
+		return newNetwork()
+	}, xorData)
+	initial := randomSetup(rand.New(rand.NewSource(1)), 2, 4, 1)
+	for i := 0; i < 3; i++ {
+		objective(initial)
+		// A sync.Pool drops its objects after two collections.
+		runtime.GC()
+		runtime.GC()
+	}
+	if created != 1 {
+		t.Errorf("created %d networks for sequential evaluations", created)
+	}
+}
+
+// TestEvolution_optimized evolves a NetworkOptimized through EvolvableOptimized.
+func TestEvolution_optimized(t *testing.T) {
+	const generations = 100
+	rng := rand.New(rand.NewSource(1))
+	data := make([]neurus.DataPoint, 100)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		expected[basic2DClassifier(x, y)] = 1
+		data[i] = neurus.DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	activation := func() neurus.ActivationFunc { return new(neurus.Sigmd) }
+	newNetwork := func() neurus.EvolvableNetwork {
+		nn := neurus.NewNetworkOptimized([]int{2, 6, 2}, activation, &neurus.MeanSquaredError{}, rand.NewSource(1))
+		return neurus.EvolvableOptimized(nn, activation)
+	}
+	objective := neurus.CostObjective(newNetwork, data)
+	initial := newNetwork().Export()
+	initialCost := objective(initial)
+	ev := neurus.NewEvolution(initial, objective, &neurus.CMAES{}, rand.NewSource(1))
+	for ev.Generation() < generations {
+		ev.Step()
+	}
+	best, cost := ev.Best()
+	t.Logf("cost %g after %d generations from %g", cost, ev.Generation(), initialCost)
+	if cost > initialCost/2 {
+		t.Errorf("cost %g not much lower than initial cost %g", cost, initialCost)
+	}
+	// The best setup must import into a NetworkOptimized with the same cost.
+	if got := objective(best); got != cost {
+		t.Errorf("imported best setup cost %g, want %g", got, cost)
+	}
+}
+
+// TestCMAES_ellipsoid minimizes a quadratic whose axes are scaled by a factor of 1000,
+// which requires learning the covariance of the parameters.
+func TestCMAES_ellipsoid(t *testing.T) {
+	initial := randomSetup(rand.New(rand.NewSource(1)), 2, 2, 1)
+	ellipsoid := func(setup []neurus.LayerSetup) (cost float64) {
+		var x []float64
+		for _, layer := range setup {
+			for _, weights := range layer.Weights {
+				x = append(x, weights...)
+			}
+			x = append(x, layer.Biases...)
+		}
+		for i, v := range x {
+			scale := math.Pow(1e3, float64(i)/float64(len(x)-1))
+			cost += scale * scale * v * v
+		}
+		return cost
+	}
+	ev := neurus.NewEvolution(initial, ellipsoid, &neurus.CMAES{}, rand.NewSource(1))
+	for ev.Generation() < 1000 && ev.Step() > 1e-12 {
+	}
+	_, cost := ev.Best()
+	t.Logf("cost %g after %d generations", cost, ev.Generation())
+	if cost > 1e-12 {
+		t.Errorf("cost %g not minimized", cost)
+	}
+}
+
+// TestEvolution_workers checks that the results do not depend on the number of
+// goroutines evaluating candidates.
+func TestEvolution_workers(t *testing.T) {
+	var costs []float64
+	for _, workers := range []int{1, 4} {
+		for _, strategy := range strategies {
+			initial := randomSetup(rand.New(rand.NewSource(1)), 2, 4, 1)
+			ev := neurus.NewEvolution(initial, neurus.CostObjective(newStepNetwork(2, 4, 1), xorData), strategy.new(), rand.NewSource(1))
+			ev.Workers = workers
+			for i := 0; i < 5; i++ {
+				ev.Step()
+			}
+			setup, cost := ev.Best()
+			costs = append(costs, cost, setup[0].Weights[1][2], setup[1].Biases[0])
+		}
+	}
+	half := len(costs) / 2
+	for i := 0; i < half; i++ {
+		if costs[i] != costs[half+i] {
+			t.Fatalf("results with 1 and 4 workers differ: %v, %v", costs[:half], costs[half:])
+		}
+	}
+}
+
+// randomSetup returns the setup of a network with weights and biases drawn
+// uniformly from [-1, 1) by rng.
+func randomSetup(rng *rand.Rand, layerSizes ...int) []neurus.LayerSetup {
+	setup := make([]neurus.LayerSetup, len(layerSizes)-1)
+	for i := range setup {
+		setup[i].Weights = make([][]float64, layerSizes[i])
+		for nodeIn := range setup[i].Weights {
+			setup[i].Weights[nodeIn] = make([]float64, layerSizes[i+1])
+			for nodeOut := range setup[i].Weights[nodeIn] {
+				setup[i].Weights[nodeIn][nodeOut] = 2*rng.Float64() - 1
+			}
+		}
+		setup[i].Biases = make([]float64, layerSizes[i+1])
+		for nodeOut := range setup[i].Biases {
+			setup[i].Biases[nodeOut] = 2*rng.Float64() - 1
+		}
+	}
+	return setup
+}
@@ -1,27 +1,76 @@
 package neurus
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 
 	"github.com/soypat/neurus/mnist"
+	"golang.org/x/exp/constraints"
 )
 
+// MNISTToDatapoints returns data points of the images with the digit labels one-hot
+// encoded as 10 expected outputs. The inputs share memory with the images. It panics
+// if a label is 10 or more, see MNISTClassesToDatapoints.
 func MNISTToDatapoints(images []mnist.Image64) []DataPoint {
+	return MNISTClassesToDatapoints(images, 10)
+}
+
+// MNISTToDatapoints32 is the float32 counterpart of MNISTToDatapoints. The data
+// points share memory with the images so no conversion to float64 takes place.
+func MNISTToDatapoints32(images []mnist.Image) []DataPointOf[float32] {
+	return MNISTClassesToDatapoints32(images, 10)
+}
+
+// MNISTClassesToDatapoints is like MNISTToDatapoints for images labeled with one
+// of numClasses classes, such as those of the EMNIST datasets read with mnist.ReadImages.
+// It panics if a label is not less than numClasses.
+func MNISTClassesToDatapoints(images []mnist.Image64, numClasses int) []DataPoint {
 	datapoints := make([]DataPoint, len(images))
-	eoutputs := make([]float64, len(images)*10) // contiguous representation in memory, might make access slightly faster.
+	eoutputs := make([]float64, len(images)*numClasses) // contiguous representation in memory, might make access slightly faster.
+	for i := range datapoints {
+		checkLabel(i, int(images[i].Num), numClasses)
+		datapoints[i].Input = images[i].Data[:]
+		eidx := i * numClasses
+		datapoints[i].ExpectedOutput = eoutputs[eidx : eidx+numClasses]
+		datapoints[i].ExpectedOutput[images[i].Num] = 1
+	}
+	return datapoints
+}
+
+// MNISTClassesToDatapoints32 is the float32 counterpart of MNISTClassesToDatapoints.
+func MNISTClassesToDatapoints32(images []mnist.Image, numClasses int) []DataPointOf[float32] {
+	datapoints := make([]DataPointOf[float32], len(images))
+	eoutputs := make([]float32, len(images)*numClasses)
 	for i := range datapoints {
+		checkLabel(i, int(images[i].Num), numClasses)
 		datapoints[i].Input = images[i].Data[:]
-		eidx := i * 10
-		datapoints[i].ExpectedOutput = eoutputs[eidx : eidx+10]
+		eidx := i * numClasses
+		datapoints[i].ExpectedOutput = eoutputs[eidx : eidx+numClasses]
 		datapoints[i].ExpectedOutput[images[i].Num] = 1
 	}
 	return datapoints
 }
 
-type DataPoint struct {
-	Input          []float64
-	ExpectedOutput []float64
+func checkLabel(image, label, numClasses int) {
+	if label >= numClasses {
+		panic(fmt.Sprintf("image %d label %d not less than number of classes %d", image, label, numClasses))
+	}
+}
+
+type DataPoint = DataPointOf[float64]
+
+// DataPointOf is a data point of a network computing with floats of type T.
+type DataPointOf[T constraints.Float] struct {
+	Input          []T
+	ExpectedOutput []T
+}
+
+// SequenceDataPoint is a sequence of inputs ordered in time along with the
+// expected output of the model after each time step.
+type SequenceDataPoint struct {
+	Inputs          [][]float64
+	ExpectedOutputs [][]float64
 }
 
 // randomSlice returns a slice with random floats of values
@@ -29,18 +78,20 @@ type DataPoint struct {
 //	r*a + b
 //
 // where r is a random float between 0 and 1.
-func randomSlice(n int, a, b float64, rng *rand.Rand) []float64 {
-	slice := make([]float64, n)
+func randomSlice[T constraints.Float](n int, a, b float64, rng *rand.Rand) []T {
+	slice := make([]T, n)
 	for i := range slice {
 		// Get random value between -1 and +1
-		slice[i] = rand.Float64()*a + b
+		slice[i] = T(rand.Float64()*a + b)
 	}
 	return slice
 }
 
 // Activation Functions below:
 
-func step(f float64) float64 {
+// Step is the Heaviside step function. Its derivative is zero almost everywhere
+// so networks using it are trained without gradients, see Evolution.
+func Step(f float64) float64 {
 	if f < 0 {
 		return 0
 	}
@@ -72,44 +123,72 @@ func MaxReLU(maxReturnedValue float64) func(float64) float64 {
 	}
 }
 
-type ActivationFunc interface {
-	CalculateFromInputs(inputs []float64, stride int)
-	Activate(index int) float64
-	Derivative(index int) float64
+type ActivationFunc = ActivationFuncOf[float64]
+
+// ActivationFuncOf is an activation function of a network computing with floats of type T.
+type ActivationFuncOf[T constraints.Float] interface {
+	CalculateFromInputs(inputs []T, stride int)
+	Activate(index int) T
+	Derivative(index int) T
+}
+
+// ActivationJacobianOf is implemented by activation functions whose outputs each
+// depend on every input, such as SoftMax. Derivative only returns the diagonal of
+// their Jacobian, so layers backpropagate through Backward instead.
+type ActivationJacobianOf[T constraints.Float] interface {
+	ActivationFuncOf[T]
+	// Backward stores in dx the partial derivatives of the cost with respect to the
+	// inputs of the last call to CalculateFromInputs given the partial derivatives dy
+	// with respect to the activations. dx and dy may be the same slice.
+	Backward(dy, dx []T)
 }
 
-var _ ActivationFunc = &SoftMax{}
+var _ ActivationJacobianOf[float64] = &SoftMax{}
 
-type SoftMax struct {
-	expInputs []float64
-	expSum    float64
+type SoftMax = SoftMaxOf[float64]
+
+type SoftMaxOf[T constraints.Float] struct {
+	expInputs []T
+	expSum    T
+	n         int
 }
 
-func (s *SoftMax) CalculateFromInputs(inputs []float64, stride int) {
+func (s *SoftMaxOf[T]) CalculateFromInputs(inputs []T, stride int) {
 	if stride != 1 {
 		panic("bad or unsupported stride")
 	}
 	if len(inputs) > len(s.expInputs) {
-		s.expInputs = make([]float64, len(inputs))
+		s.expInputs = make([]T, len(inputs))
 	}
-	var expSum float64
+	// Shift inputs by their maximum so that exp does not overflow.
+	// The shift cancels out in the quotient of Activate.
+	maxInput := T(math.Inf(-1))
 	for i := 0; i < len(inputs); i += stride {
-		exp := math.Exp(inputs[i])
+		if inputs[i] > maxInput {
+			maxInput = inputs[i]
+		}
+	}
+	var expSum T
+	for i := 0; i < len(inputs); i += stride {
+		exp := T(math.Exp(float64(inputs[i] - maxInput)))
 		expSum += exp
 		s.expInputs[i] = exp
 	}
 	s.expSum = expSum
+	s.n = len(inputs)
 }
 
-func (s *SoftMax) Activate(index int) float64 {
-	if index <= 0 {
+func (s *SoftMaxOf[T]) Activate(index int) T {
+	if index < 0 {
 		panic("bad index")
 	}
 	return s.expInputs[index] / s.expSum
 }
 
-func (s *SoftMax) Derivative(index int) float64 {
-	if index <= 0 {
+// Derivative returns the partial derivative of output index with respect to
+// input index. Outputs also depend on the other inputs, see Backward.
+func (s *SoftMaxOf[T]) Derivative(index int) T {
+	if index < 0 {
 		panic("bad index")
 	}
 	expSum := s.expSum
@@ -117,33 +196,50 @@ func (s *SoftMax) Derivative(index int) float64 {
 	return (expInput*expSum - expInput*expInput) / (expSum * expSum)
 }
 
+// Backward implements ActivationJacobianOf. The partial derivative of output i with
+// respect to input j is a[i]*(δij - a[j]), which gives dx[j] = a[j]*(dy[j] - Σ dy[i]*a[i]).
+func (s *SoftMaxOf[T]) Backward(dy, dx []T) {
+	if len(dy) != s.n || len(dx) != s.n {
+		panic("length mismatches last CalculateFromInputs")
+	}
+	var dot T
+	for i := range dy {
+		dot += dy[i] * s.expInputs[i] / s.expSum
+	}
+	for i := range dx {
+		dx[i] = s.expInputs[i] / s.expSum * (dy[i] - dot)
+	}
+}
+
 var _ ActivationFunc = &Relu{}
 
-type Sigmd struct {
-	output []float64
+type Sigmd = SigmdOf[float64]
+
+type SigmdOf[T constraints.Float] struct {
+	output []T
 }
 
-func (sigmoid *Sigmd) CalculateFromInputs(inputs []float64, stride int) {
+func (sigmoid *SigmdOf[T]) CalculateFromInputs(inputs []T, stride int) {
 	if stride != 1 {
 		panic("bad or unsupported stride")
 	}
 	if len(inputs) > len(sigmoid.output) {
-		sigmoid.output = make([]float64, len(inputs))
+		sigmoid.output = make([]T, len(inputs))
 	}
 
 	for i := 0; i < len(inputs); i += stride {
-		sigmoid.output[i] = 1.0 / (1 + math.Exp(-inputs[i]))
+		sigmoid.output[i] = T(1.0 / (1 + math.Exp(-float64(inputs[i]))))
 	}
 }
 
-func (sigmoid *Sigmd) Activate(index int) float64 {
+func (sigmoid *SigmdOf[T]) Activate(index int) T {
 	if index < 0 {
 		panic("bad index")
 	}
 	return sigmoid.output[index]
 }
 
-func (sigmoid *Sigmd) Derivative(index int) float64 {
+func (sigmoid *SigmdOf[T]) Derivative(index int) T {
 	if index < 0 {
 		panic("bad index")
 	}
@@ -151,90 +247,160 @@ func (sigmoid *Sigmd) Derivative(index int) float64 {
 	return sig * (1 - sig)
 }
 
-type Relu struct {
-	maxes      []float64
-	Inflection float64
+type Tanh = TanhOf[float64]
+
+type TanhOf[T constraints.Float] struct {
+	output []T
 }
 
-func (relu *Relu) CalculateFromInputs(inputs []float64, stride int) {
+func (tanh *TanhOf[T]) CalculateFromInputs(inputs []T, stride int) {
 	if stride != 1 {
 		panic("bad or unsupported stride")
 	}
-	if len(inputs) > len(relu.maxes) {
-		relu.maxes = make([]float64, len(inputs))
+	if len(inputs) > len(tanh.output) {
+		tanh.output = make([]T, len(inputs))
 	}
-	inf := relu.Inflection
 	for i := 0; i < len(inputs); i += stride {
-		relu.maxes[i] = math.Max(inf, inputs[i])
+		tanh.output[i] = T(math.Tanh(float64(inputs[i])))
 	}
 }
 
-func (relu *Relu) Activate(index int) float64 {
+func (tanh *TanhOf[T]) Activate(index int) T {
 	if index < 0 {
 		panic("bad index")
 	}
-	return relu.maxes[index]
+	return tanh.output[index]
+}
+
+func (tanh *TanhOf[T]) Derivative(index int) T {
+	if index < 0 {
+		panic("bad index")
+	}
+	th := tanh.output[index]
+	return 1 - th*th
 }
 
-func (relu *Relu) Derivative(index int) float64 {
+// Identity is the identity activation function for layers with linear outputs.
+type Identity = IdentityOf[float64]
+
+type IdentityOf[T constraints.Float] struct {
+	inputs []T
+}
+
+func (id *IdentityOf[T]) CalculateFromInputs(inputs []T, stride int) {
+	if stride != 1 {
+		panic("bad or unsupported stride")
+	}
+	if len(inputs) > len(id.inputs) {
+		id.inputs = make([]T, len(inputs))
+	}
+	copy(id.inputs, inputs)
+}
+
+func (id *IdentityOf[T]) Activate(index int) T {
+	if index < 0 {
+		panic("bad index")
+	}
+	return id.inputs[index]
+}
+
+func (id *IdentityOf[T]) Derivative(index int) T {
 	if index < 0 {
 		panic("bad index")
 	}
-	return oneZero[b2u8(math.Signbit(relu.maxes[index]))]
+	return 1
+}
+
+type Relu = ReluOf[float64]
+
+type ReluOf[T constraints.Float] struct {
+	maxes      []T
+	Inflection T
+}
+
+func (relu *ReluOf[T]) CalculateFromInputs(inputs []T, stride int) {
+	if stride != 1 {
+		panic("bad or unsupported stride")
+	}
+	if len(inputs) > len(relu.maxes) {
+		relu.maxes = make([]T, len(inputs))
+	}
+	inf := float64(relu.Inflection)
+	for i := 0; i < len(inputs); i += stride {
+		relu.maxes[i] = T(math.Max(inf, float64(inputs[i])))
+	}
 }
 
-var oneZero = [2]float64{1, 0}
+func (relu *ReluOf[T]) Activate(index int) T {
+	if index < 0 {
+		panic("bad index")
+	}
+	return relu.maxes[index]
+}
 
-func b2u8(b bool) uint8 {
-	if b {
+func (relu *ReluOf[T]) Derivative(index int) T {
+	if index < 0 {
+		panic("bad index")
+	}
+	// Outputs are clamped to Inflection for inputs at or below it.
+	if relu.maxes[index] > relu.Inflection {
 		return 1
 	}
 	return 0
 }
 
-type CostFunc interface {
-	CalculateFromInputs(predicted, expected []float64, stride int)
-	TotalCost() float64
-	Derivative(index int) float64
+type CostFunc = CostFuncOf[float64]
+
+// CostFuncOf is a cost function of a network computing with floats of type T.
+type CostFuncOf[T constraints.Float] interface {
+	CalculateFromInputs(predicted, expected []T, stride int)
+	TotalCost() T
+	Derivative(index int) T
 }
 
-type CrossEntropy struct {
-	cost       float64
-	derivative []float64
+type CrossEntropy = CrossEntropyOf[float64]
+
+type CrossEntropyOf[T constraints.Float] struct {
+	cost       T
+	derivative []T
 }
 
-func (cross *CrossEntropy) CalculateFromInputs(pred, expected []float64, stride int) {
+func (cross *CrossEntropyOf[T]) CalculateFromInputs(pred, expected []T, stride int) {
 	if stride != 1 {
 		panic("bad or unsupported stride")
 	}
 	if len(pred) > len(cross.derivative) {
-		cross.derivative = make([]float64, len(pred))
+		cross.derivative = make([]T, len(pred))
 	}
-	var cost float64
+	var cost T
 	for i := 0; i < len(pred); i += stride {
+		// C = -y*ln(x) - (1-y)*ln(1-x), skipping terms with a zero factor
+		// so that confident correct predictions do not produce NaN.
 		var v float64
 		x := pred[i]
 		y := expected[i]
-		if y >= 1 {
-			v = -math.Log(x)
-		} else {
-			v = -math.Log(1 - x)
+		if y != 0 {
+			v -= float64(y) * math.Log(float64(x))
 		}
-		cost += numOrZero(v)
+		if y != 1 {
+			v -= float64(1-y) * math.Log(float64(1-x))
+		}
+		cost += T(numOrZero(v))
 		if x == 0 || x == 1 {
 			cross.derivative[i] = 0
 		} else {
-			cross.derivative[i] = -(x + y) / (x * (x - 1))
+			// dC/dx = -y/x + (1-y)/(1-x)
+			cross.derivative[i] = (x - y) / (x * (1 - x))
 		}
 	}
 	cross.cost = cost
 }
 
-func (cross *CrossEntropy) TotalCost() float64 {
+func (cross *CrossEntropyOf[T]) TotalCost() T {
 	return cross.cost
 }
 
-func (cross *CrossEntropy) Derivative(index int) float64 {
+func (cross *CrossEntropyOf[T]) Derivative(index int) T {
 	return cross.derivative[index]
 }
 
@@ -245,19 +411,21 @@ func numOrZero(v float64) float64 {
 	return v
 }
 
-type MeanSquaredError struct {
-	derivative []float64
-	cost       float64
+type MeanSquaredError = MeanSquaredErrorOf[float64]
+
+type MeanSquaredErrorOf[T constraints.Float] struct {
+	derivative []T
+	cost       T
 }
 
-func (mse *MeanSquaredError) CalculateFromInputs(pred, expected []float64, stride int) {
+func (mse *MeanSquaredErrorOf[T]) CalculateFromInputs(pred, expected []T, stride int) {
 	if stride != 1 {
 		panic("bad or unsupported stride")
 	}
 	if len(pred) > len(mse.derivative) {
-		mse.derivative = make([]float64, len(pred))
+		mse.derivative = make([]T, len(pred))
 	}
-	var cost float64
+	var cost T
 	for i := 0; i < len(pred); i += stride {
 		iErr := pred[i] - expected[i]
 		cost += iErr * iErr
@@ -266,17 +434,26 @@ func (mse *MeanSquaredError) CalculateFromInputs(pred, expected []float64, strid
 	mse.cost = cost / 2
 }
 
-func (mse *MeanSquaredError) TotalCost() float64 {
+func (mse *MeanSquaredErrorOf[T]) TotalCost() T {
 	return mse.cost
 }
 
-func (mse *MeanSquaredError) Derivative(index int) float64 {
+func (mse *MeanSquaredErrorOf[T]) Derivative(index int) T {
 	return mse.derivative[index]
 }
 
 type LayerSetup struct {
 	Weights [][]float64 `json:"weights"`
 	Biases  []float64   `json:"biases"`
+	// Dropout is the dropout rate applied to the layer's activations during training.
+	Dropout float64 `json:"dropout,omitempty"`
+	// Norm holds the normalization parameters of the layer's weighted inputs.
+	Norm *NormSetup `json:"norm,omitempty"`
+	// Activation is the layer's activation function.
+	Activation *ActivationSetup `json:"activation,omitempty"`
+	// Preprocessor transforms the inputs of the network. Only the first layer
+	// of a NetworkOptimized may have one.
+	Preprocessor *LayerSpec `json:"preprocessor,omitempty"`
 }
 
 func (ls LayerSetup) Dims() (numNodesIn, numNodesOut int) {
@@ -0,0 +1,495 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Normalizer normalizes the weighted inputs of a layer before they are passed
+// through the activation function. Each normalized value is scaled and shifted
+// by the learned parameters gamma and beta:
+//
+//	y = gamma * (z - mean) / sqrt(variance + epsilon) + beta
+//
+// Normalizers are created with NewBatchNorm and NewLayerNorm and
+// attached to a layer with NetworkOptimized.SetNormalization. Both normalizers
+// also implement Layer so they may be placed between layers of a Sequential model.
+type Normalizer = NormalizerOf[float64]
+
+// NormalizerOf is a Normalizer of a network computing with floats of type T.
+type NormalizerOf[T constraints.Float] interface {
+	// forward normalizes each row of z into out and stores the
+	// normalized values before scale and shift in xhat.
+	forward(z, xhat, out [][]T, mode Mode)
+	// inference normalizes a single row of weighted inputs z into out.
+	inference(z, out []T)
+	// backward receives the partial derivatives of the cost with respect
+	// to the normalizer outputs in dout and writes the derivatives with respect
+	// to the inputs to dz. dout and dz may be the same rows. Gradients of gamma and beta
+	// are accumulated.
+	backward(dout, xhat, dz [][]T)
+	applyGradients(learnRate, momentum float64)
+	// updateRunningStats folds the statistics of the last training batch into
+	// the statistics used for inference. It is called once per training step.
+	updateRunningStats()
+	export() *NormSetup
+	size() int
+	// Params returns the scale (gamma) and shift (beta) parameters.
+	Params() []ParamOf[T]
+}
+
+// NormSetup is the serialized form of a Normalizer.
+type NormSetup struct {
+	// Kind is either "batch" or "layer".
+	Kind    string    `json:"kind"`
+	Gamma   []float64 `json:"gamma"`
+	Beta    []float64 `json:"beta"`
+	Epsilon float64   `json:"epsilon"`
+	// Running statistics and their momentum are only used by batch normalization.
+	RunningMean []float64 `json:"runningMean,omitempty"`
+	RunningVar  []float64 `json:"runningVar,omitempty"`
+	Momentum    float64   `json:"momentum,omitempty"`
+}
+
+const (
+	normKindBatch = "batch"
+	normKindLayer = "layer"
+
+	defaultNormEpsilon  = 1e-5
+	defaultNormMomentum = 0.1
+)
+
+// normalizerFromSetup creates a Normalizer from its serialized form.
+func normalizerFromSetup[T constraints.Float](setup NormSetup) (NormalizerOf[T], error) {
+	if len(setup.Gamma) == 0 || len(setup.Beta) != len(setup.Gamma) {
+		return nil, errors.New("normalization scale and shift length mismatch")
+	}
+	if setup.Epsilon < 0 {
+		return nil, errors.New("negative normalization epsilon")
+	}
+	params := newNormParams[T](len(setup.Gamma))
+	copy(params.gamma, convertSlice[T](setup.Gamma))
+	copy(params.beta, convertSlice[T](setup.Beta))
+	params.epsilon = T(setup.Epsilon)
+	switch setup.Kind {
+	case normKindBatch:
+		bn := &BatchNormOf[T]{
+			normParams:  params,
+			runningMean: convertSlice[T](setup.RunningMean),
+			runningVar:  convertSlice[T](setup.RunningVar),
+			momentum:    T(setup.Momentum),
+		}
+		if len(bn.runningMean) != bn.size() || len(bn.runningVar) != bn.size() {
+			return nil, errors.New("batch normalization running statistics length mismatch")
+		}
+		if setup.Momentum < 0 || setup.Momentum > 1 {
+			return nil, errors.New("batch normalization momentum must be in [0, 1]")
+		}
+		return bn, nil
+	case normKindLayer:
+		return &LayerNormOf[T]{normParams: params}, nil
+	}
+	return nil, errors.New("unknown normalization kind: " + setup.Kind)
+}
+
+// normParams are the learned scale and shift parameters shared by
+// all normalizers.
+type normParams[T constraints.Float] struct {
+	gamma, beta         []T
+	gradGamma, gradBeta []T
+	velGamma, velBeta   []T
+	epsilon             T
+	// Buffers used when the normalizer is used as a Layer.
+	xhat, out, dz            []T
+	zRows, xhatRows, outRows [][]T
+	dyRows, dzRows           [][]T
+}
+
+func newNormParams[T constraints.Float](numNodes int) normParams[T] {
+	p := normParams[T]{
+		gamma:     make([]T, numNodes),
+		beta:      make([]T, numNodes),
+		gradGamma: make([]T, numNodes),
+		gradBeta:  make([]T, numNodes),
+		velGamma:  make([]T, numNodes),
+		velBeta:   make([]T, numNodes),
+		epsilon:   defaultNormEpsilon,
+	}
+	fillOnes(p.gamma)
+	return p
+}
+
+func (p *normParams[T]) size() int { return len(p.gamma) }
+
+func (p *normParams[T]) Dims() (numIn, numOut int) { return p.size(), p.size() }
+
+func (p *normParams[T]) Params() []ParamOf[T] {
+	return []ParamOf[T]{
+		{Value: p.gamma, Grad: p.gradGamma},
+		{Value: p.beta, Grad: p.gradBeta},
+	}
+}
+
+// layerForward implements Layer.Forward for norm.
+func (p *normParams[T]) layerForward(norm NormalizerOf[T], x []T, mode Mode) []T {
+	p.xhat = resize(p.xhat, len(x))
+	p.out = resize(p.out, len(x))
+	p.zRows = rowsOf(p.zRows, x, p.size())
+	p.xhatRows = rowsOf(p.xhatRows, p.xhat, p.size())
+	p.outRows = rowsOf(p.outRows, p.out, p.size())
+	norm.forward(p.zRows, p.xhatRows, p.outRows, mode)
+	return p.out
+}
+
+// layerBackward implements Layer.Backward for norm.
+func (p *normParams[T]) layerBackward(norm NormalizerOf[T], dy []T) []T {
+	if len(dy) != len(p.out) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	p.dz = resize(p.dz, len(dy))
+	p.dyRows = rowsOf(p.dyRows, dy, p.size())
+	p.dzRows = rowsOf(p.dzRows, p.dz, p.size())
+	norm.backward(p.dyRows, p.xhatRows, p.dzRows)
+	return p.dz
+}
+
+// unmarshalNormalizer decodes a NormSetup of the given kind.
+func unmarshalNormalizer[T constraints.Float](b []byte, kind string) (NormalizerOf[T], error) {
+	var setup NormSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return nil, err
+	}
+	if setup.Kind != kind {
+		return nil, errors.New("expected " + kind + " normalization, got " + setup.Kind)
+	}
+	return normalizerFromSetup[T](setup)
+}
+
+// updateRunningStats does nothing for normalizers without running statistics.
+func (p *normParams[T]) updateRunningStats() {}
+
+func (p *normParams[T]) applyGradients(learnRate, momentum float64) {
+	lr, mom := T(learnRate), T(momentum)
+	for i := range p.gamma {
+		p.velGamma[i] = p.velGamma[i]*mom - p.gradGamma[i]*lr
+		p.gamma[i] += p.velGamma[i]
+		p.gradGamma[i] = 0
+		p.velBeta[i] = p.velBeta[i]*mom - p.gradBeta[i]*lr
+		p.beta[i] += p.velBeta[i]
+		p.gradBeta[i] = 0
+	}
+}
+
+// BatchNorm normalizes each node's weighted input using the mean and
+// variance over the training batch. Running estimates of the mean and variance
+// are kept during training and used in place of batch statistics during inference.
+// They are updated with the statistics of the last training batch when gradients
+// are applied, so forward passes which do not end in a training step, such as
+// those of GradientCheck, TrainerLM or LBFGS, leave them unchanged.
+type BatchNorm = BatchNormOf[float64]
+
+// BatchNormOf is a BatchNorm of a network computing with floats of type T.
+type BatchNormOf[T constraints.Float] struct {
+	normParams[T]
+	runningMean []T
+	runningVar  []T
+	// momentum is the weight of the current batch statistics when
+	// updating the running statistics.
+	momentum T
+	// invStd caches 1/sqrt(variance+epsilon) of the last forward batch.
+	invStd []T
+	// batchMean and batchVar hold the statistics of the last training batch
+	// until updateRunningStats, with batchVar the unbiased variance estimate.
+	batchMean, batchVar []T
+	pending             bool
+	// frozen is set when the last forward batch used the running statistics.
+	frozen bool
+}
+
+var (
+	_ Normalizer = (*BatchNorm)(nil)
+	_ Layer      = (*BatchNorm)(nil)
+)
+
+// NewBatchNorm returns a batch normalizer for a layer with numNodes outputs.
+func NewBatchNorm(numNodes int) *BatchNorm {
+	return NewBatchNormOf[float64](numNodes)
+}
+
+// NewBatchNormOf returns a batch normalizer for a layer of a NetworkOptimizedOf[T].
+func NewBatchNormOf[T constraints.Float](numNodes int) *BatchNormOf[T] {
+	bn := &BatchNormOf[T]{
+		normParams:  newNormParams[T](numNodes),
+		runningMean: make([]T, numNodes),
+		runningVar:  make([]T, numNodes),
+		momentum:    defaultNormMomentum,
+	}
+	fillOnes(bn.runningVar)
+	return bn
+}
+
+func (bn *BatchNormOf[T]) forward(z, xhat, out [][]T, mode Mode) {
+	if len(bn.invStd) != bn.size() {
+		bn.invStd = make([]T, bn.size())
+		bn.batchMean = make([]T, bn.size())
+		bn.batchVar = make([]T, bn.size())
+	}
+	bn.frozen = mode != ModeTrain
+	if bn.frozen {
+		for j := range bn.invStd {
+			bn.invStd[j] = invSqrt(bn.runningVar[j] + bn.epsilon)
+		}
+		for s := range z {
+			for j, v := range z[s] {
+				xhat[s][j] = (v - bn.runningMean[j]) * bn.invStd[j]
+				out[s][j] = bn.gamma[j]*xhat[s][j] + bn.beta[j]
+			}
+		}
+		return
+	}
+	n := T(len(z))
+	for j := 0; j < bn.size(); j++ {
+		var mean T
+		for s := range z {
+			mean += z[s][j]
+		}
+		mean /= n
+		var variance T
+		for s := range z {
+			d := z[s][j] - mean
+			variance += d * d
+		}
+		variance /= n
+		invStd := invSqrt(variance + bn.epsilon)
+		bn.invStd[j] = invStd
+		for s := range z {
+			xhat[s][j] = (z[s][j] - mean) * invStd
+			out[s][j] = bn.gamma[j]*xhat[s][j] + bn.beta[j]
+		}
+		// Running variance uses the unbiased estimate of the variance.
+		if n > 1 {
+			variance *= n / (n - 1)
+		}
+		bn.batchMean[j] = mean
+		bn.batchVar[j] = variance
+	}
+	bn.pending = true
+}
+
+func (bn *BatchNormOf[T]) updateRunningStats() {
+	if !bn.pending {
+		return
+	}
+	for j := range bn.runningMean {
+		bn.runningMean[j] += bn.momentum * (bn.batchMean[j] - bn.runningMean[j])
+		bn.runningVar[j] += bn.momentum * (bn.batchVar[j] - bn.runningVar[j])
+	}
+	bn.pending = false
+}
+
+func (bn *BatchNormOf[T]) inference(z, out []T) {
+	for j := range z {
+		xhat := (z[j] - bn.runningMean[j]) * invSqrt(bn.runningVar[j]+bn.epsilon)
+		out[j] = bn.gamma[j]*xhat + bn.beta[j]
+	}
+}
+
+func (bn *BatchNormOf[T]) backward(dout, xhat, dz [][]T) {
+	n := T(len(dout))
+	for j := 0; j < bn.size(); j++ {
+		var sumDout, sumDoutXhat T
+		for s := range dout {
+			sumDout += dout[s][j]
+			sumDoutXhat += dout[s][j] * xhat[s][j]
+		}
+		bn.gradBeta[j] += sumDout
+		bn.gradGamma[j] += sumDoutXhat
+		if bn.frozen {
+			// Running statistics are constants so normalization is an affine transformation.
+			scale := bn.gamma[j] * bn.invStd[j]
+			for s := range dout {
+				dz[s][j] = scale * dout[s][j]
+			}
+			continue
+		}
+		// Derivative of the cost with respect to the weighted inputs
+		// taking into account their contribution to the batch mean and variance.
+		scale := bn.gamma[j] * bn.invStd[j] / n
+		for s := range dout {
+			dz[s][j] = scale * (n*dout[s][j] - sumDout - xhat[s][j]*sumDoutXhat)
+		}
+	}
+}
+
+func (bn *BatchNormOf[T]) Forward(x []T, mode Mode) []T {
+	return bn.layerForward(bn, x, mode)
+}
+
+func (bn *BatchNormOf[T]) Backward(dy []T) []T {
+	return bn.layerBackward(bn, dy)
+}
+
+// MarshalJSON encodes the normalizer as a NormSetup.
+func (bn *BatchNormOf[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bn.export())
+}
+
+// UnmarshalJSON decodes a batch normalizer encoded with MarshalJSON.
+func (bn *BatchNormOf[T]) UnmarshalJSON(b []byte) error {
+	norm, err := unmarshalNormalizer[T](b, normKindBatch)
+	if err != nil {
+		return err
+	}
+	*bn = *norm.(*BatchNormOf[T])
+	return nil
+}
+
+func (bn *BatchNormOf[T]) export() *NormSetup {
+	return &NormSetup{
+		Kind:        normKindBatch,
+		Gamma:       convertSlice[float64](bn.gamma),
+		Beta:        convertSlice[float64](bn.beta),
+		Epsilon:     float64(bn.epsilon),
+		RunningMean: convertSlice[float64](bn.runningMean),
+		RunningVar:  convertSlice[float64](bn.runningVar),
+		Momentum:    float64(bn.momentum),
+	}
+}
+
+// LayerNorm normalizes the weighted inputs of a layer using the mean
+// and variance over the nodes of a single data point. It behaves identically
+// during training and inference.
+type LayerNorm = LayerNormOf[float64]
+
+// LayerNormOf is a LayerNorm of a network computing with floats of type T.
+type LayerNormOf[T constraints.Float] struct {
+	normParams[T]
+	// invStd caches 1/sqrt(variance+epsilon) of each data point of the last training batch.
+	invStd []T
+}
+
+var (
+	_ Normalizer = (*LayerNorm)(nil)
+	_ Layer      = (*LayerNorm)(nil)
+)
+
+// NewLayerNorm returns a layer normalizer for a layer with numNodes outputs.
+func NewLayerNorm(numNodes int) *LayerNorm {
+	return NewLayerNormOf[float64](numNodes)
+}
+
+// NewLayerNormOf returns a layer normalizer for a layer of a NetworkOptimizedOf[T].
+func NewLayerNormOf[T constraints.Float](numNodes int) *LayerNormOf[T] {
+	return &LayerNormOf[T]{normParams: newNormParams[T](numNodes)}
+}
+
+func (ln *LayerNormOf[T]) forward(z, xhat, out [][]T, mode Mode) {
+	if len(ln.invStd) < len(z) {
+		ln.invStd = make([]T, len(z))
+	}
+	for s := range z {
+		ln.invStd[s] = ln.normalize(z[s], xhat[s])
+		for j, xh := range xhat[s] {
+			out[s][j] = ln.gamma[j]*xh + ln.beta[j]
+		}
+	}
+}
+
+// normalize stores the normalized values of z in xhat and returns 1/sqrt(variance+epsilon).
+func (ln *LayerNormOf[T]) normalize(z, xhat []T) (invStd T) {
+	n := T(len(z))
+	var mean T
+	for _, v := range z {
+		mean += v
+	}
+	mean /= n
+	var variance T
+	for _, v := range z {
+		d := v - mean
+		variance += d * d
+	}
+	variance /= n
+	invStd = invSqrt(variance + ln.epsilon)
+	for j, v := range z {
+		xhat[j] = (v - mean) * invStd
+	}
+	return invStd
+}
+
+func (ln *LayerNormOf[T]) inference(z, out []T) {
+	ln.normalize(z, out)
+	for j, xh := range out {
+		out[j] = ln.gamma[j]*xh + ln.beta[j]
+	}
+}
+
+func (ln *LayerNormOf[T]) backward(dout, xhat, dz [][]T) {
+	n := T(ln.size())
+	for s := range dout {
+		// Gradient with respect to the normalized values: dxhat = dout*gamma.
+		var sumDxhat, sumDxhatXhat T
+		for j, d := range dout[s] {
+			ln.gradBeta[j] += d
+			ln.gradGamma[j] += d * xhat[s][j]
+			dxhat := d * ln.gamma[j]
+			sumDxhat += dxhat
+			sumDxhatXhat += dxhat * xhat[s][j]
+		}
+		scale := ln.invStd[s] / n
+		for j, d := range dout[s] {
+			dxhat := d * ln.gamma[j]
+			dz[s][j] = scale * (n*dxhat - sumDxhat - xhat[s][j]*sumDxhatXhat)
+		}
+	}
+}
+
+func (ln *LayerNormOf[T]) Forward(x []T, mode Mode) []T {
+	return ln.layerForward(ln, x, mode)
+}
+
+func (ln *LayerNormOf[T]) Backward(dy []T) []T {
+	return ln.layerBackward(ln, dy)
+}
+
+// MarshalJSON encodes the normalizer as a NormSetup.
+func (ln *LayerNormOf[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ln.export())
+}
+
+// UnmarshalJSON decodes a layer normalizer encoded with MarshalJSON.
+func (ln *LayerNormOf[T]) UnmarshalJSON(b []byte) error {
+	norm, err := unmarshalNormalizer[T](b, normKindLayer)
+	if err != nil {
+		return err
+	}
+	*ln = *norm.(*LayerNormOf[T])
+	return nil
+}
+
+func (ln *LayerNormOf[T]) export() *NormSetup {
+	return &NormSetup{
+		Kind:    normKindLayer,
+		Gamma:   convertSlice[float64](ln.gamma),
+		Beta:    convertSlice[float64](ln.beta),
+		Epsilon: float64(ln.epsilon),
+	}
+}
+
+// updateLayerRunningStats updates the running statistics of the layers keeping
+// them after a training step of a Sequential or Graph.
+func updateLayerRunningStats(layers []Layer) {
+	for _, layer := range layers {
+		if l, ok := layer.(interface{ updateRunningStats() }); ok {
+			l.updateRunningStats()
+		}
+	}
+}
+
+// invSqrt returns 1/sqrt(v).
+func invSqrt[T constraints.Float](v T) T {
+	return T(1 / math.Sqrt(float64(v)))
+}
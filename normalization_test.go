@@ -0,0 +1,144 @@
+package neurus
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNormalization_gradientCheck(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		newNorm func(numNodes int) Normalizer
+	}{
+		{name: "batch", newNorm: func(n int) Normalizer { return NewBatchNorm(n) }},
+		{name: "layer", newNorm: func(n int) Normalizer { return NewLayerNorm(n) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			nn := NewNetworkOptimized([]int{3, 5, 4, 2},
+				func() ActivationFunc { return new(Sigmd) },
+				&MeanSquaredError{}, rand.NewSource(1))
+			nn.SetNormalization(0, test.newNorm(5))
+			nn.SetNormalization(1, test.newNorm(4))
+			nn.SetMode(ModeTrain)
+			// Move scale and shift away from their identity values.
+			for _, layer := range nn.layers[:2] {
+				p := normParamsOf(layer.norm)
+				for j := range p.gamma {
+					p.gamma[j] = 0.5 + rng.Float64()
+					p.beta[j] = rng.Float64() - 0.5
+				}
+			}
+			data := make([]DataPoint, 6)
+			for s := range data {
+				data[s] = DataPoint{
+					Input:          []float64{rng.Float64(), rng.Float64(), rng.Float64()},
+					ExpectedOutput: []float64{rng.Float64(), rng.Float64()},
+				}
+			}
+			learnData := make([][]layerLearnData[float64], len(nn.layers))
+			for i, layer := range nn.layers {
+				learnData[i] = make([]layerLearnData[float64], len(data))
+				for s := range data {
+					learnData[i][s] = newLayerLearnData[float64](layer.Dims())
+				}
+			}
+			cost := func() (total float64) {
+				nn.forwardBatch(data, learnData)
+				for s := range data {
+					nn.Cost.CalculateFromInputs(learnData[len(nn.layers)-1][s].activations, data[s].ExpectedOutput, 1)
+					total += nn.Cost.TotalCost()
+				}
+				return total
+			}
+			nn.updateBatchGradients(data, learnData)
+
+			const h = 1e-6
+			check := func(label string, params, grads []float64) {
+				for i := range params {
+					orig := params[i]
+					params[i] = orig + h
+					costPlus := cost()
+					params[i] = orig - h
+					costMinus := cost()
+					params[i] = orig
+					numeric := (costPlus - costMinus) / (2 * h)
+					if relErr(numeric, grads[i]) > 1e-5 {
+						t.Errorf("%s[%d]: analytic %g, numeric %g", label, i, grads[i], numeric)
+					}
+				}
+			}
+			for _, layer := range nn.layers {
+				check("weights", layer.weights, layer.costGradientW)
+				check("biases", layer.biases, layer.costGradientB)
+				if layer.norm != nil {
+					p := normParamsOf(layer.norm)
+					check("gamma", p.gamma, p.gradGamma)
+					check("beta", p.beta, p.gradBeta)
+				}
+			}
+		})
+	}
+}
+
+// TestBatchNorm_runningStats checks running statistics change with training
+// steps only, not with the forward passes of a gradient check.
+func TestBatchNorm_runningStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]DataPoint, 8)
+	for s := range data {
+		data[s] = DataPoint{
+			Input:          []float64{rng.Float64(), rng.Float64()},
+			ExpectedOutput: []float64{rng.Float64(), rng.Float64()},
+		}
+	}
+	nn := NewNetworkOptimized([]int{2, 4, 2},
+		func() ActivationFunc { return new(Sigmd) },
+		&MeanSquaredError{}, rand.NewSource(1))
+	nnNorm := NewBatchNorm(4)
+	nn.SetNormalization(0, nnNorm)
+	nn.SetMode(ModeTrain)
+	seqNorm := NewBatchNorm(4)
+	seq := NewSequential(&MeanSquaredError{},
+		NewLayerOptimized(2, 4, new(Tanh), rng), seqNorm, NewLayerOptimized(4, 2, new(Sigmd), rng))
+	seq.SetMode(ModeTrain)
+	for _, test := range []struct {
+		name  string
+		norm  *BatchNorm
+		model GradientModel
+		learn func()
+	}{
+		{"optimized", nnNorm, nn.GradientModel(), func() { nn.Learn(data, 0.1, 0, 0.9) }},
+		{"sequential", seqNorm, seq, func() { seq.Learn(data, 0.1, 0, 0.9) }},
+	} {
+		mean := append([]float64{}, test.norm.runningMean...)
+		variance := append([]float64{}, test.norm.runningVar...)
+		GradientCheck(test.model, data, 1e-6)
+		if !equalSlices(test.norm.runningMean, mean) || !equalSlices(test.norm.runningVar, variance) {
+			t.Errorf("%s: gradient check changed running statistics", test.name)
+		}
+		test.learn()
+		if equalSlices(test.norm.runningMean, mean) || equalSlices(test.norm.runningVar, variance) {
+			t.Errorf("%s: training step did not update running statistics", test.name)
+		}
+	}
+}
+
+func normParamsOf(norm Normalizer) *normParams[float64] {
+	switch n := norm.(type) {
+	case *BatchNorm:
+		return &n.normParams
+	case *LayerNorm:
+		return &n.normParams
+	}
+	panic("unknown normalizer")
+}
+
+func relErr(a, b float64) float64 {
+	diff := math.Abs(a - b)
+	if diff < 1e-9 {
+		return 0
+	}
+	return diff / math.Max(math.Abs(a), math.Abs(b))
+}
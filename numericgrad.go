@@ -0,0 +1,76 @@
+package neurus
+
+import "runtime"
+
+// This file contains helpers for the finite difference gradients of the Level 0
+// and Level 1 trainers. Every partial derivative costs one or two evaluations of
+// the cost, so the parameters are split among goroutines. Level 0 workers perturb
+// the parameters of their own copy of the network.
+
+// finiteDifference returns the finite difference approximation of the derivative
+// of cost with respect to the parameter v, which is perturbed by h. The forward
+// difference (C(v+h) - C(v)) / h uses originalCost as C(v) and the central
+// difference is (C(v+h) - C(v-h)) / 2h. v is restored exactly so that the
+// gradient of a parameter does not depend on the parameters computed before it.
+func finiteDifference(cost func() float64, v *float64, h, originalCost float64, central bool) float64 {
+	orig := *v
+	*v = orig + h
+	plus := cost()
+	var diff float64
+	if central {
+		*v = orig - h
+		diff = (plus - cost()) / (2 * h)
+	} else {
+		diff = (plus - originalCost) / h
+	}
+	*v = orig
+	return diff
+}
+
+// gradientBlockSize is the number of parameters whose finite differences a
+// Level 0 goroutine computes before taking the next block. Each parameter costs
+// whole evaluations of the cost, so blocks are much smaller than predictBlockSize
+// to balance the work among goroutines.
+const gradientBlockSize = 4
+
+// gradientBlockSizeLvl1 is gradientBlockSize for Level 1, whose parameters only
+// cost the layers downstream of their node. Networks with fewer parameters
+// than a block have their gradients computed serially.
+const gradientBlockSizeLvl1 = 512
+
+// numGradientWorkers returns the number of goroutines computing finite
+// differences of numParams parameters in blocks of blockSize. Positive
+// workers replaces GOMAXPROCS as the upper bound.
+func numGradientWorkers(numParams, blockSize, workers int) int {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	numBlocks := (numParams + blockSize - 1) / blockSize
+	if numBlocks < workers {
+		workers = numBlocks
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// parallelGradients splits the parameters [0, numParams) into blocks of
+// blockSize and calls work for each block from numWorkers goroutines.
+func parallelGradients(numParams, blockSize, numWorkers int, work func(worker, start, end int)) {
+	parallelRanges(numParams, blockSize, numWorkers, work)
+}
+
+// paramRange returns the range [lo, hi) of the indices in [start, end) of the
+// numParams parameters of a layer whose first parameter has index offset.
+// lo and hi are relative to the layer and lo >= hi when they don't intersect.
+func paramRange(offset, numParams, start, end int) (lo, hi int) {
+	lo, hi = start-offset, end-offset
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > numParams {
+		hi = numParams
+	}
+	return lo, hi
+}
@@ -0,0 +1,158 @@
+package neurus
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestTrainer_workers checks the Level 0 and Level 1 trainers compute the same
+// parameters whether gradients are computed serially or in parallel.
+func TestTrainer_workers(t *testing.T) {
+	// Level 1 blocks are larger so its network has more parameters.
+	layerSizes0 := []int{2, 16, 8, 2}  // 210 parameters split in 53 blocks.
+	layerSizes1 := []int{2, 32, 32, 2} // 1218 parameters split in 3 blocks.
+	rng := rand.New(rand.NewSource(1))
+	data := make([]DataPoint, 20)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		if -x*x+0.5 > y {
+			expected[1] = 1
+		} else {
+			expected[0] = 1
+		}
+		data[i] = DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	for _, central := range []bool{false, true} {
+		serial0 := randomNetworkLvl0(rand.New(rand.NewSource(1)), layerSizes0...)
+		parallel0 := serial0.clone()
+		serial1 := randomNetworkLvl1(rand.New(rand.NewSource(1)), layerSizes1...)
+		parallel1 := randomNetworkLvl1(rand.New(rand.NewSource(1)), layerSizes1...)
+		serialTr0 := NewTrainerFromNetworkLvl0(serial0)
+		serialTr0.Workers, serialTr0.CentralDifference = 1, central
+		parallelTr0 := NewTrainerFromNetworkLvl0(parallel0)
+		parallelTr0.Workers, parallelTr0.CentralDifference = 4, central
+		serialTr1 := NewTrainerFromNetworkLvl1(serial1)
+		serialTr1.Workers, serialTr1.CentralDifference = 1, central
+		parallelTr1 := NewTrainerFromNetworkLvl1(parallel1)
+		parallelTr1.Workers, parallelTr1.CentralDifference = 4, central
+		for i := 0; i < 5; i++ {
+			serialTr0.TrainLvl0(serial0, data, 1e-4, 0.5)
+			parallelTr0.TrainLvl0(parallel0, data, 1e-4, 0.5)
+			serialTr1.Train(serial1, data, 1e-4, 0.5)
+			parallelTr1.Train(parallel1, data, 1e-4, 0.5)
+		}
+		for i := range serial0.layers {
+			if !equalSlices(parallel0.layers[i].biases, serial0.layers[i].biases) {
+				t.Fatalf("central=%v: Level 0 layer %d biases mismatch", central, i)
+			}
+			for nodeIn := range serial0.layers[i].weights {
+				if !equalSlices(parallel0.layers[i].weights[nodeIn], serial0.layers[i].weights[nodeIn]) {
+					t.Fatalf("central=%v: Level 0 layer %d weights of input %d mismatch", central, i, nodeIn)
+				}
+			}
+		}
+		for i := range serial1.layers {
+			if !equalSlices(parallel1.layers[i].biases, serial1.layers[i].biases) {
+				t.Fatalf("central=%v: Level 1 layer %d biases mismatch", central, i)
+			}
+			for nodeIn := range serial1.layers[i].weights {
+				if !equalSlices(parallel1.layers[i].weights[nodeIn], serial1.layers[i].weights[nodeIn]) {
+					t.Fatalf("central=%v: Level 1 layer %d weights of input %d mismatch", central, i, nodeIn)
+				}
+			}
+		}
+	}
+}
+
+func TestFiniteDifference(t *testing.T) {
+	const h = 1e-3
+	v := 1.0
+	cost := func() float64 { return v * v * v }
+	forward := finiteDifference(cost, &v, h, cost(), false)
+	central := finiteDifference(cost, &v, h, cost(), true)
+	if v != 1 {
+		t.Fatalf("parameter not restored, got %g", v)
+	}
+	// The derivative of v³ at 1 is 3. The error of forward differences is
+	// proportional to h and the error of central differences to h².
+	if err := math.Abs(forward - 3); math.Abs(err-3*h) > 1e-6 {
+		t.Errorf("forward difference error %g, want %g", err, 3*h)
+	}
+	if err := math.Abs(central - 3); math.Abs(err-h*h) > 1e-8 {
+		t.Errorf("central difference error %g, want %g", err, h*h)
+	}
+}
+
+func BenchmarkTrainerLvl0(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	nn := randomNetworkLvl0(rng, 784, 16, 10)
+	data := make([]DataPoint, 10)
+	for i := range data {
+		data[i] = DataPoint{Input: make([]float64, 784), ExpectedOutput: make([]float64, 10)}
+		for j := range data[i].Input {
+			data[i].Input[j] = rng.Float64()
+		}
+		data[i].ExpectedOutput[rng.Intn(10)] = 1
+	}
+	for _, workers := range []int{1, 0} {
+		name := "serial"
+		if workers == 0 {
+			name = "parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			tr := NewTrainerFromNetworkLvl0(nn)
+			tr.Workers = workers
+			for i := 0; i < b.N; i++ {
+				tr.TrainLvl0(nn, data, 1e-4, 0.05)
+			}
+		})
+	}
+}
+
+// randomNetworkLvl0 returns a network of Sigmoid activations with parameters
+// drawn from rng, leaving the global source untouched.
+func randomNetworkLvl0(rng *rand.Rand, layerSizes ...int) NetworkLvl0 {
+	nn := NetworkLvl0{layers: make([]LayerLvl0, len(layerSizes)-1)}
+	for i := range nn.layers {
+		numNodesIn, numNodesOut := layerSizes[i], layerSizes[i+1]
+		layer := LayerLvl0{
+			weights:            make([][]float64, numNodesIn),
+			biases:             make([]float64, numNodesOut),
+			activationFunction: Sigmoid,
+			activations:        make([]float64, numNodesOut),
+		}
+		for nodeIn := range layer.weights {
+			layer.weights[nodeIn] = make([]float64, numNodesOut)
+			for nodeOut := range layer.weights[nodeIn] {
+				layer.weights[nodeIn][nodeOut] = rng.NormFloat64() / math.Sqrt(float64(numNodesIn))
+			}
+		}
+		for nodeOut := range layer.biases {
+			layer.biases[nodeOut] = rng.NormFloat64()
+		}
+		nn.layers[i] = layer
+	}
+	return nn
+}
+
+func randomNetworkLvl1(rng *rand.Rand, layerSizes ...int) NetworkLvl1 {
+	nn := NetworkLvl1{layers: make([]LayerLvl1, len(layerSizes)-1)}
+	for i, layer := range randomNetworkLvl0(rng, layerSizes...).layers {
+		nn.layers[i] = LayerLvl1(layer)
+	}
+	return nn
+}
+
+func equalSlices(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
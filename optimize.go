@@ -0,0 +1,339 @@
+package neurus
+
+import (
+	"math"
+
+	"github.com/soypat/neurus/internal/kernels"
+)
+
+// This file contains full-batch optimizers which treat a model as a function
+// of its flattened parameters, see FlattenParams. They converge in far fewer
+// iterations than stochastic gradient descent on small networks and datasets,
+// at the cost of evaluating the whole dataset on every step.
+
+// OptimizeResult describes the outcome of a full-batch optimization.
+type OptimizeResult struct {
+	// Cost is the mean cost over the data of the final parameters.
+	Cost float64
+	// Iterations is the number of steps taken.
+	Iterations int
+	// Evaluations is the number of times the cost and gradient were computed.
+	Evaluations int
+	// Converged is set if the gradient norm fell below the tolerance.
+	Converged bool
+}
+
+// LBFGS minimizes the mean cost of a model with the limited-memory
+// Broyden–Fletcher–Goldfarb–Shanno quasi-Newton method using a line search
+// satisfying the strong Wolfe conditions.
+type LBFGS struct {
+	// Memory is the number of past updates kept to approximate the
+	// inverse Hessian. Defaults to 10.
+	Memory int
+	// MaxIterations defaults to 100.
+	MaxIterations int
+	// GradTol is the gradient norm below which the minimum is considered found.
+	GradTol float64
+}
+
+// Minimize runs L-BFGS on the mean cost of model over data starting from the
+// model's current parameters, which hold the best parameters found on return.
+// Models with a ResetState method have their state reset before each evaluation.
+func (opt LBFGS) Minimize(model GradientModel, data []DataPoint) OptimizeResult {
+	memory, maxIterations := opt.Memory, opt.MaxIterations
+	if memory <= 0 {
+		memory = 10
+	}
+	if maxIterations <= 0 {
+		maxIterations = 100
+	}
+	obj := newObjective(model, data)
+	n := len(obj.x)
+	var (
+		s, y  = make([][]float64, 0, memory), make([][]float64, 0, memory)
+		rho   = make([]float64, 0, memory)
+		alpha = make([]float64, memory)
+		d     = make([]float64, n)
+		res   OptimizeResult
+	)
+	f, g := obj.start()
+	for res.Iterations < maxIterations {
+		if norm(g) <= opt.GradTol {
+			res.Converged = true
+			break
+		}
+		// Two-loop recursion computes the search direction d = -H·g.
+		copy(d, g)
+		for i := len(s) - 1; i >= 0; i-- {
+			alpha[i] = rho[i] * kernels.Dot(s[i], d)
+			kernels.Axpy(-alpha[i], y[i], d)
+		}
+		gamma := 1 / norm(g) // Scale the first step to unit length.
+		if k := len(s) - 1; k >= 0 {
+			gamma = kernels.Dot(s[k], y[k]) / kernels.Dot(y[k], y[k])
+		}
+		scale(gamma, d)
+		for i := range s {
+			beta := rho[i] * kernels.Dot(y[i], d)
+			kernels.Axpy(alpha[i]-beta, s[i], d)
+		}
+		scale(-1, d)
+
+		xPrev, gPrev := append([]float64{}, obj.x...), append([]float64{}, g...)
+		var ok bool
+		f, g, ok = obj.lineSearch(f, g, d, 1, 0.9)
+		res.Iterations++
+		if !ok {
+			break
+		}
+		// Store the update discarding the oldest, reusing its memory.
+		var sk, yk []float64
+		if len(s) == memory {
+			sk, yk = s[0], y[0]
+			s, y, rho = append(s[:0], s[1:]...), append(y[:0], y[1:]...), append(rho[:0], rho[1:]...)
+		} else {
+			sk, yk = make([]float64, n), make([]float64, n)
+		}
+		for i := range sk {
+			sk[i] = obj.x[i] - xPrev[i]
+			yk[i] = g[i] - gPrev[i]
+		}
+		if sy := kernels.Dot(sk, yk); sy > 1e-10 {
+			// Skip updates which would make the approximation not positive definite.
+			s, y, rho = append(s, sk), append(y, yk), append(rho, 1/sy)
+		}
+	}
+	return obj.finish(f, res)
+}
+
+// ConjugateGradient minimizes the mean cost of a model with the nonlinear
+// conjugate gradient method of Polak and Ribière, restarting along the steepest
+// descent direction when the search direction is not a descent direction.
+type ConjugateGradient struct {
+	// MaxIterations defaults to 100.
+	MaxIterations int
+	// GradTol is the gradient norm below which the minimum is considered found.
+	GradTol float64
+}
+
+// Minimize runs conjugate gradient on the mean cost of model over data starting from
+// the model's current parameters, which hold the best parameters found on return.
+// Models with a ResetState method have their state reset before each evaluation.
+func (opt ConjugateGradient) Minimize(model GradientModel, data []DataPoint) OptimizeResult {
+	maxIterations := opt.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 100
+	}
+	obj := newObjective(model, data)
+	n := len(obj.x)
+	var (
+		d     = make([]float64, n)
+		gPrev = make([]float64, n)
+		res   OptimizeResult
+	)
+	f, g := obj.start()
+	copy(d, g)
+	scale(-1, d)
+	step := 1 / norm(g)
+	for res.Iterations < maxIterations {
+		if norm(g) <= opt.GradTol {
+			res.Converged = true
+			break
+		}
+		gd := kernels.Dot(g, d)
+		copy(gPrev, g)
+		var ok bool
+		f, g, ok = obj.lineSearch(f, g, d, step, 0.1)
+		res.Iterations++
+		if !ok {
+			break
+		}
+		// Polak–Ribière with nonnegative beta, which restarts automatically.
+		beta := (kernels.Dot(g, g) - kernels.Dot(g, gPrev)) / kernels.Dot(gPrev, gPrev)
+		if beta < 0 || res.Iterations%n == 0 {
+			beta = 0
+		}
+		kernels.ScaledAdd(-1, g, beta, d)
+		gdNext := kernels.Dot(g, d)
+		if gdNext >= 0 {
+			// Not a descent direction: restart along steepest descent.
+			copy(d, g)
+			scale(-1, d)
+			gdNext = -kernels.Dot(g, g)
+		}
+		// Expect the first order change of the cost to match the previous step's.
+		step = math.Min(1, 1.01*obj.lastStep*gd/gdNext)
+	}
+	return obj.finish(f, res)
+}
+
+// objective is the mean cost of a model as a function of its flattened parameters.
+type objective struct {
+	model    GradientModel
+	data     []DataPoint
+	params   []Param
+	resetter interface{ ResetState() }
+	// x holds the parameters of the last accepted step.
+	x []float64
+	// lastStep is the length of the last accepted step along the search direction.
+	lastStep    float64
+	evaluations int
+	// g holds the gradient at x.
+	g []float64
+	// xTrial and gTrial are scratch buffers for line search evaluations.
+	xTrial, gTrial []float64
+}
+
+func newObjective(model GradientModel, data []DataPoint) *objective {
+	params := model.Params()
+	obj := &objective{model: model, data: data, params: params}
+	obj.resetter, _ = model.(interface{ ResetState() })
+	obj.x = FlattenParams(nil, params)
+	n := len(obj.x)
+	obj.g, obj.xTrial, obj.gTrial = make([]float64, n), make([]float64, n), make([]float64, n)
+	return obj
+}
+
+// start evaluates the objective at the initial parameters.
+func (obj *objective) start() (f float64, g []float64) {
+	f, g = obj.evaluate(obj.x)
+	copy(obj.g, g)
+	return f, obj.g
+}
+
+// evaluate returns the mean cost and its gradient at x. The gradient
+// is valid until the next call to evaluate.
+func (obj *objective) evaluate(x []float64) (f float64, g []float64) {
+	obj.evaluations++
+	UnflattenParams(obj.params, x)
+	zeroParamGrads(obj.params)
+	if obj.resetter != nil {
+		obj.resetter.ResetState()
+	}
+	n := float64(len(obj.data))
+	f = obj.model.UpdateGradients(obj.data) / n
+	g = FlattenGrads(obj.gTrial, obj.params)
+	scale(1/n, g)
+	return f, g
+}
+
+// lineSearch finds a step along direction d from obj.x with cost f and gradient g
+// which satisfies the strong Wolfe conditions with curvature parameter c2, starting
+// with step. On success obj.x is moved and the new cost and gradient are returned.
+func (obj *objective) lineSearch(f float64, g, d []float64, step, c2 float64) (float64, []float64, bool) {
+	gd := kernels.Dot(g, d)
+	if !(gd < 0) {
+		return f, g, false
+	}
+	a, fa, ok := obj.wolfe(f, gd, d, step, c2)
+	if !ok {
+		return f, g, false
+	}
+	// The gradient of the last evaluation, at a, is overwritten by the next one.
+	copy(obj.g, obj.gTrial)
+	kernels.Axpy(a, d, obj.x)
+	obj.lastStep = a
+	return fa, obj.g, true
+}
+
+// wolfe returns a step a along d satisfying the strong Wolfe conditions and
+// its cost. The last evaluation of the objective is at the step on success.
+// See Nocedal & Wright, Numerical Optimization, algorithms 3.5 and 3.6.
+func (obj *objective) wolfe(f, gd float64, d []float64, a, c2 float64) (float64, float64, bool) {
+	const (
+		c1       = 1e-4
+		maxEvals = 20
+	)
+	// eval returns the cost and directional derivative at obj.x + a·d.
+	eval := func(a float64) (float64, float64) {
+		copy(obj.xTrial, obj.x)
+		kernels.Axpy(a, d, obj.xTrial)
+		fa, ga := obj.evaluate(obj.xTrial)
+		return fa, kernels.Dot(ga, d)
+	}
+	// Bracket a step satisfying the conditions between lo and hi, where
+	// lo satisfies the sufficient decrease condition with the lowest cost.
+	lo, fLo, dLo := 0.0, f, gd
+	var hi, fHi, dHi float64
+	bracketed := false
+	for i := 0; i < maxEvals && !bracketed; i++ {
+		fa, da := eval(a)
+		switch {
+		case isNaNOrInf(fa) || fa > f+c1*a*gd || fa >= fLo:
+			hi, fHi, dHi = a, fa, da
+			bracketed = true
+		case math.Abs(da) <= -c2*gd:
+			return a, fa, true
+		case da >= 0:
+			hi, fHi, dHi = lo, fLo, dLo
+			lo, fLo, dLo = a, fa, da
+			bracketed = true
+		default:
+			lo, fLo, dLo = a, fa, da
+			a *= 2
+		}
+	}
+	if !bracketed {
+		return 0, f, false
+	}
+	// Zoom into the bracket.
+	for i := 0; i < maxEvals; i++ {
+		a = cubicMin(lo, fLo, dLo, hi, fHi, dHi)
+		// Fall back to bisection if the minimizer is too close to the bracket's ends.
+		if width := math.Abs(hi - lo); math.IsNaN(a) || math.Abs(a-lo) < 0.1*width || math.Abs(a-hi) < 0.1*width {
+			a = (lo + hi) / 2
+		}
+		fa, da := eval(a)
+		if isNaNOrInf(fa) || fa > f+c1*a*gd || fa >= fLo {
+			hi, fHi, dHi = a, fa, da
+			continue
+		}
+		if math.Abs(da) <= -c2*gd {
+			return a, fa, true
+		}
+		if da*(hi-lo) >= 0 {
+			hi, fHi, dHi = lo, fLo, dLo
+		}
+		lo, fLo, dLo = a, fa, da
+	}
+	if lo > 0 {
+		// Settle for the lowest cost found, which satisfies sufficient decrease.
+		fa, _ := eval(lo)
+		return lo, fa, true
+	}
+	return 0, f, false
+}
+
+// cubicMin returns the minimizer of the cubic interpolating the values and
+// derivatives at a and b. It returns NaN if the cubic has no minimizer.
+func cubicMin(a, fa, da, b, fb, db float64) float64 {
+	d1 := da + db - 3*(fa-fb)/(a-b)
+	disc := d1*d1 - da*db
+	if disc < 0 {
+		return math.NaN()
+	}
+	d2 := math.Sqrt(disc)
+	if b < a {
+		d2 = -d2
+	}
+	return b - (b-a)*(db+d2-d1)/(db-da+2*d2)
+}
+
+// finish stores the accepted parameters in the model and zeroes its gradients.
+func (obj *objective) finish(f float64, res OptimizeResult) OptimizeResult {
+	UnflattenParams(obj.params, obj.x)
+	zeroParamGrads(obj.params)
+	res.Cost = f
+	res.Evaluations = obj.evaluations
+	return res
+}
+
+func norm(x []float64) float64 {
+	return math.Sqrt(kernels.Dot(x, x))
+}
+
+func scale(alpha float64, x []float64) {
+	for i := range x {
+		x[i] *= alpha
+	}
+}
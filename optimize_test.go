@@ -0,0 +1,144 @@
+package neurus_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// rosenbrock is the Rosenbrock function (1-x)² + 100(y-x²)² with its minimum
+// at (1, 1) as a GradientModel. Its cost ignores the data.
+type rosenbrock struct {
+	value, grad []float64
+}
+
+func (r *rosenbrock) Params() []neurus.Param {
+	return []neurus.Param{{Value: r.value, Grad: r.grad}}
+}
+
+func (r *rosenbrock) UpdateGradients(data []neurus.DataPoint) (totalCost float64) {
+	x, y := r.value[0], r.value[1]
+	n := float64(len(data))
+	r.grad[0] += n * (-2*(1-x) - 400*x*(y-x*x))
+	r.grad[1] += n * 200 * (y - x*x)
+	return n * ((1-x)*(1-x) + 100*(y-x*x)*(y-x*x))
+}
+
+func TestMinimize_rosenbrock(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		minimize func(neurus.GradientModel, []neurus.DataPoint) neurus.OptimizeResult
+	}{
+		{name: "lbfgs", minimize: neurus.LBFGS{MaxIterations: 200, GradTol: 1e-8}.Minimize},
+		{name: "cg", minimize: neurus.ConjugateGradient{MaxIterations: 1000, GradTol: 1e-8}.Minimize},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := &rosenbrock{value: []float64{-1.2, 1}, grad: make([]float64, 2)}
+			res := test.minimize(r, make([]neurus.DataPoint, 2))
+			if !res.Converged {
+				t.Errorf("did not converge: %+v", res)
+			}
+			if math.Abs(r.value[0]-1) > 1e-5 || math.Abs(r.value[1]-1) > 1e-5 {
+				t.Errorf("minimum at %v, want [1 1]", r.value)
+			}
+			if r.grad[0] != 0 || r.grad[1] != 0 {
+				t.Error("gradients not zeroed")
+			}
+			t.Logf("%+v", res)
+		})
+	}
+}
+
+func TestFlattenParams(t *testing.T) {
+	seq := neurus.NewSequential(new(neurus.MeanSquaredError),
+		neurus.NewLayerOptimized(3, 4, new(neurus.Sigmd), rand.New(rand.NewSource(1))),
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rand.New(rand.NewSource(2))),
+	)
+	params := seq.Params()
+	if n := neurus.NumParams(params); n != 3*4+4+4*2+2 {
+		t.Fatalf("got %d parameter values", n)
+	}
+	x := neurus.FlattenParams(nil, params)
+	if x[0] != params[0].Value[0] || x[len(x)-1] != params[len(params)-1].Value[1] {
+		t.Error("parameters not flattened in order")
+	}
+	for i := range x {
+		x[i] = float64(i)
+	}
+	neurus.UnflattenParams(params, x)
+	if got := neurus.FlattenParams(make([]float64, 1), params); !equalFloats(got, x) {
+		t.Errorf("round trip got %v, want %v", got, x)
+	}
+	params[1].Grad[2] = 5
+	if g := neurus.FlattenGrads(nil, params); g[len(params[0].Value)+2] != 5 {
+		t.Error("gradients not flattened in order")
+	}
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestMinimize_twoD compares full-batch optimizers with stochastic gradient
+// descent on a small network, which needs thousands of epochs to train.
+func TestMinimize_twoD(t *testing.T) {
+	const (
+		iterations = 200
+		epochs     = 5000
+	)
+	// Weights and data are drawn from rng so that the test does not depend
+	// on the randomly seeded global source.
+	rng := rand.New(rand.NewSource(1))
+	trainData := random2DData(rng, 400)
+	initial := randomSetup(rng, 2, 2, 2, 2)
+	newNetwork := func() *neurus.NetworkOptimized {
+		nn := neurus.NewNetworkOptimized([]int{2, 2, 2, 2}, func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+			new(neurus.MeanSquaredError), rand.NewSource(1))
+		nn.Import(initial, func() neurus.ActivationFunc { return new(neurus.Sigmd) })
+		return nn
+	}
+	meanCost := func(nn *neurus.NetworkOptimized) float64 {
+		// UpdateGradients leaves gradients behind which are not used.
+		return nn.GradientModel().UpdateGradients(trainData) / float64(len(trainData))
+	}
+
+	sgd := newNetwork()
+	initialCost := meanCost(sgd)
+	params := neurus.NewHyperParameters([]int{2, 2, 2, 2})
+	for epoch := 0; epoch < epochs; epoch++ {
+		startIdx := rng.Intn(len(trainData) - params.MiniBatchSize)
+		sgd.Learn(trainData[startIdx:startIdx+params.MiniBatchSize], params.LearnRateInitial, 0, params.Momentum)
+	}
+	sgdCost := meanCost(sgd)
+	t.Logf("initial cost %f, SGD cost after %d epochs: %f", initialCost, epochs, sgdCost)
+
+	for _, test := range []struct {
+		name     string
+		minimize func(neurus.GradientModel, []neurus.DataPoint) neurus.OptimizeResult
+	}{
+		{name: "lbfgs", minimize: neurus.LBFGS{MaxIterations: iterations}.Minimize},
+		{name: "cg", minimize: neurus.ConjugateGradient{MaxIterations: iterations}.Minimize},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nn := newNetwork()
+			res := test.minimize(nn.GradientModel(), trainData)
+			if cost := meanCost(nn); math.Abs(cost-res.Cost) > 1e-12 {
+				t.Errorf("network cost %f mismatches result %f", cost, res.Cost)
+			}
+			if res.Cost >= sgdCost {
+				t.Errorf("cost %f after %d iterations not lower than SGD's %f", res.Cost, res.Iterations, sgdCost)
+			}
+			t.Logf("%+v", res)
+		})
+	}
+}
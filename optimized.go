@@ -1,32 +1,131 @@
 package neurus
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 
+	"github.com/soypat/neurus/internal/kernels"
 	"golang.org/x/exp/constraints"
-	"golang.org/x/exp/slices"
 )
 
-type NetworkOptimized struct {
-	layers         []LayerOptimized
-	Cost           CostFunc
+type NetworkOptimized = NetworkOptimizedOf[float64]
+
+// NetworkOptimizedOf is a NetworkOptimized computing with floats of type T.
+// A NetworkOptimizedOf[float32] halves the memory used by its parameters and
+// can be trained directly on float32 data such as that of MNISTToDatapoints32.
+type NetworkOptimizedOf[T constraints.Float] struct {
+	layers         []LayerOptimizedOf[T]
+	Cost           CostFuncOf[T]
 	rng            *rand.Rand
-	batchLearnData [][]layerLearnData
+	batchLearnData [][]layerLearnData[T]
+	mode           Mode
+	// pre transforms the inputs of StoreOutputs and of predictors. It is nil
+	// when the network has no preprocessor.
+	pre *preprocessorOf[T]
+	// predictors are the predictors of PredictBatch and PredictMatrix,
+	// kept until the layers change.
+	predictors []*PredictorOf[T]
+}
+
+// Mode selects the behaviour of network features which act differently
+// during training and inference, such as dropout.
+type Mode uint8
+
+const (
+	// ModeInference is the default mode. Outputs are deterministic.
+	ModeInference Mode = iota
+	// ModeTrain enables stochastic training features such as dropout.
+	ModeTrain
+)
+
+// SetMode sets the network mode used by StoreOutputs and Classify. Learn
+// always runs in ModeTrain and restores the previous mode before returning.
+func (nn *NetworkOptimizedOf[T]) SetMode(mode Mode) {
+	if mode != ModeInference && mode != ModeTrain {
+		panic("invalid mode")
+	}
+	nn.mode = mode
+}
+
+// Mode returns the current mode of the network.
+func (nn *NetworkOptimizedOf[T]) Mode() Mode { return nn.mode }
+
+// SetDropout sets the inverted dropout rate applied to the activations of
+// the layer at index layerIdx while training. During training each activation
+// is zeroed with probability rate and the surviving activations are scaled by 1/(1-rate)
+// so that no rescaling is needed at inference. A rate of 0 disables dropout.
+func (nn *NetworkOptimizedOf[T]) SetDropout(layerIdx int, rate float64) {
+	if rate < 0 || rate >= 1 || math.IsNaN(rate) {
+		panic("dropout rate must be in [0, 1)")
+	}
+	nn.layers[layerIdx].dropout = rate
+	nn.predictors = nil
 }
 
-func (nn *NetworkOptimized) Dims() (numIn, numOut int) {
+// SetNormalization normalizes the weighted inputs of the layer at index
+// layerIdx before they are passed through its activation function.
+// Passing a nil Normalizer removes normalization from the layer.
+func (nn *NetworkOptimizedOf[T]) SetNormalization(layerIdx int, norm NormalizerOf[T]) {
+	_, numNodesOut := nn.layers[layerIdx].Dims()
+	if norm != nil && norm.size() != numNodesOut {
+		panic("normalizer size mismatches layer output size")
+	}
+	nn.layers[layerIdx].norm = norm
+	nn.predictors = nil
+}
+
+// SetPreprocessor sets a fitted preprocessor which transforms the inputs of
+// StoreOutputs, Classify, predictors and batch prediction, so they take raw
+// inputs of the length returned by Dims. Learn and gradient computations still
+// take transformed inputs, such as those returned by TransformInputs. The
+// preprocessor is exported with the first layer and must be registered with
+// RegisterLayer for Export to succeed. Passing nil removes the preprocessor.
+func (nn *NetworkOptimizedOf[T]) SetPreprocessor(p Preprocessor) {
+	nn.predictors = nil
+	if p == nil {
+		nn.pre = nil
+		return
+	}
+	numIn, _ := nn.layers[0].Dims()
+	if _, numOut := p.Dims(); numOut != numIn {
+		panic("preprocessor output length mismatches first layer input length")
+	}
+	nn.pre = &preprocessorOf[T]{p: p}
+}
+
+// Preprocessor returns the preprocessor of the network or nil if it has none.
+func (nn *NetworkOptimizedOf[T]) Preprocessor() Preprocessor {
+	if nn.pre == nil {
+		return nil
+	}
+	return nn.pre.p
+}
+
+// Dims returns the input and output length of the network. The input length
+// is that of the preprocessor when the network has one.
+func (nn *NetworkOptimizedOf[T]) Dims() (numIn, numOut int) {
 	numIn, _ = nn.layers[0].Dims()
+	if nn.pre != nil {
+		numIn, _ = nn.pre.p.Dims()
+	}
 	_, numOut = nn.layers[len(nn.layers)-1].Dims()
 	return numIn, numOut
 }
 
 func NewNetworkOptimized(layerSizes []int, fn func() ActivationFunc, cost CostFunc, src rand.Source) *NetworkOptimized {
+	return NewNetworkOptimizedOf(layerSizes, fn, cost, src)
+}
+
+// NewNetworkOptimizedOf returns a NetworkOptimizedOf[T] with randomized weights and biases.
+func NewNetworkOptimizedOf[T constraints.Float](layerSizes []int, fn func() ActivationFuncOf[T], cost CostFuncOf[T], src rand.Source) *NetworkOptimizedOf[T] {
 	numLayers := len(layerSizes) - 1
 	rng := rand.New(src)
-	nn := &NetworkOptimized{
+	nn := &NetworkOptimizedOf[T]{
 		rng:    rng,
-		layers: make([]LayerOptimized, numLayers),
+		layers: make([]LayerOptimizedOf[T], numLayers),
 		Cost:   cost,
 	}
 
@@ -39,48 +138,84 @@ func NewNetworkOptimized(layerSizes []int, fn func() ActivationFunc, cost CostFu
 	return nn
 }
 
-func (nn *NetworkOptimized) Import(layers []LayerSetup, fn func() ActivationFunc) {
+// Import replaces the network's layers and preprocessor with the serialized layers.
+// If fn is nil the activation functions stored in the layer setups are used.
+func (nn *NetworkOptimizedOf[T]) Import(layers []LayerSetup, fn func() ActivationFuncOf[T]) {
 	nn.layers = nil
-	for _, layer := range layers {
-		numNodesIn, numNodesOut := layer.Dims()
-		weights := make([]float64, numNodesIn*numNodesOut)
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-				weights[nodeOut*numNodesIn+nodeIn] = layer.Weights[nodeIn][nodeOut]
+	nn.batchLearnData = nil
+	nn.predictors = nil
+	nn.pre = nil
+	for i, layer := range layers {
+		if layer.Preprocessor != nil && i > 0 {
+			panic("preprocessor of a layer other than the first")
+		}
+		var act ActivationFuncOf[T]
+		if fn != nil {
+			act = fn()
+		} else if layer.Activation != nil {
+			var err error
+			act, err = unmarshalActivation[T](*layer.Activation)
+			if err != nil {
+				panic(err)
 			}
+		} else {
+			panic("nil activation constructor and no activation in layer setup")
+		}
+		lo, err := layerFromSetup(layer, act)
+		if err != nil {
+			panic(err)
 		}
-		lo := newLayerOptimized(numNodesIn, numNodesOut, fn(), rand.New(rand.NewSource(1)))
-		lo.weights = weights
-		lo.biases = slices.Clone(layer.Biases)
 		nn.layers = append(nn.layers, lo)
 	}
+	if len(layers) > 0 && layers[0].Preprocessor != nil {
+		layer, err := UnmarshalLayer(*layers[0].Preprocessor)
+		if err != nil {
+			panic(err)
+		}
+		p, ok := layer.(Preprocessor)
+		if !ok {
+			panic("layer is not a preprocessor: " + layers[0].Preprocessor.Kind)
+		}
+		nn.SetPreprocessor(p)
+	}
 }
 
-func (nn *NetworkOptimized) Export() (exported []LayerSetup) {
+// Export returns the serialized layers of the network. It panics if the
+// network's preprocessor is not registered with RegisterLayer.
+func (nn *NetworkOptimizedOf[T]) Export() (exported []LayerSetup) {
 	for _, layer := range nn.layers {
-		numNodesIn, numNodesOut := layer.Dims()
-		weights := make([][]float64, numNodesIn)
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			weights[nodeIn] = make([]float64, numNodesOut)
-			for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-				weights[nodeIn][nodeOut] = layer.weights[layer.getWeightIdx(nodeIn, nodeOut)]
-			}
+		setup := layer.export()
+		if act, err := marshalActivation(layer.activationFunction); err == nil {
+			setup.Activation = act
+		}
+		exported = append(exported, setup)
+	}
+	if nn.pre != nil {
+		spec, err := MarshalLayer(nn.pre.p)
+		if err != nil {
+			panic(err)
 		}
-		exported = append(exported, LayerSetup{
-			Weights: weights,
-			Biases:  slices.Clone(layer.biases),
-		})
+		exported[0].Preprocessor = &spec
 	}
 	return exported
 }
 
-func (nn *NetworkOptimized) Classify(inputs []float64) (prediction int, outputs []float64) {
+// Classify returns the index of the largest output and the outputs of the network.
+// The returned outputs are owned by the last layer and overwritten by the next
+// call to Classify or StoreOutputs; copy them to keep them. The network's
+// activation functions keep state between calls so Classify must not be called
+// concurrently. Use NewPredictor for concurrent inference.
+func (nn *NetworkOptimizedOf[T]) Classify(inputs []T) (prediction int, outputs []T) {
 	outputs = nn.StoreOutputs(inputs)
-	index := maxIdx(math.Inf(-1), outputs)
+	index := maxIdx(T(math.Inf(-1)), outputs)
 	return index, outputs
 }
 
-func (nn *NetworkOptimized) StoreOutputs(firstInputs []float64) []float64 {
+// StoreOutputs runs firstInputs through the preprocessor, if the network has one,
+// and the layers of the network and returns the activations of
+// the last layer. Like those of Classify the returned activations are owned by the
+// last layer and overwritten by the next call.
+func (nn *NetworkOptimizedOf[T]) StoreOutputs(firstInputs []T) []T {
 	numIn, _ := nn.Dims()
 	switch {
 	case len(firstInputs) != numIn:
@@ -88,190 +223,569 @@ func (nn *NetworkOptimized) StoreOutputs(firstInputs []float64) []float64 {
 	}
 	var (
 		inputs      = firstInputs
-		activations []float64
+		activations []T
 	)
-	for i := 0; i < len(nn.layers); i++ {
-		_, activations = nn.layers[i].StoreOutputs(inputs)
+	if nn.pre != nil {
+		inputs = nn.pre.forward(inputs)
+	}
+	for i := range nn.layers {
+		layer := &nn.layers[i]
+		_, activations = layer.StoreOutputs(inputs)
+		if nn.mode == ModeTrain && layer.dropout > 0 {
+			mask := layer.storeBuf[3*len(activations):]
+			fillDropoutMask(mask, layer.dropout, nn.random())
+			applyMask(activations, mask)
+		}
 		inputs = activations // Next layer takes activations as inputs.
 	}
 	return activations
 }
 
-func (nn *NetworkOptimized) Learn(trainingData []DataPoint, learnRate, regularization, momentum float64) {
-	if nn.batchLearnData == nil || len(nn.batchLearnData) != len(trainingData) {
-		nn.batchLearnData = make([][]layerLearnData, len(trainingData))
-		for i := range nn.batchLearnData {
-			nn.batchLearnData[i] = make([]layerLearnData, len(nn.layers))
-			for j := range nn.batchLearnData[i] {
-				layer := nn.layers[j]
-				nn.batchLearnData[i][j] = newLayerLearnData(layer.Dims())
-			}
-		}
-	}
-	for i := range trainingData {
-		nn.UpdateGradients(trainingData[i], nn.batchLearnData[i])
+// random returns the network's random number generator. Networks created
+// with Import or declared as zero values are seeded lazily.
+func (nn *NetworkOptimizedOf[T]) random() *rand.Rand {
+	if nn.rng == nil {
+		nn.rng = rand.New(rand.NewSource(1))
 	}
+	return nn.rng
+}
+
+func (nn *NetworkOptimizedOf[T]) Learn(trainingData []DataPointOf[T], learnRate, regularization, momentum float64) {
+	prevMode := nn.mode
+	nn.mode = ModeTrain
+	defer func() { nn.mode = prevMode }()
+	nn.updateBatchGradients(trainingData, nn.learnData(len(trainingData)))
 	invNlayers := 1 / float64(len(trainingData))
 	for i := 0; i < len(nn.layers); i++ {
 		nn.layers[i].ApplyGradients(invNlayers*learnRate, regularization, momentum)
 	}
 }
 
-func (nn *NetworkOptimized) UpdateGradients(data DataPoint, learnData []layerLearnData) {
-	// Feed data through network and store weights.
-	input := data.Input
-	for i, layer := range nn.layers {
-		weights, activations := layer.StoreOutputs(input)
-		// Store result data to learnData structure.
-		ni := copy(learnData[i].inputs, input)
-		na := copy(learnData[i].activations, activations)
-		n := copy(learnData[i].weightedInputs, weights)
-		if n == 0 || n != len(weights) || n != len(learnData[i].weightedInputs) || len(activations) != na || ni != len(input) {
-			panic("bad length")
+// learnData returns the network's learn data for a batch of n data points,
+// indexed by layer first and data point second. It is reused between calls.
+func (nn *NetworkOptimizedOf[T]) learnData(n int) [][]layerLearnData[T] {
+	if nn.batchLearnData == nil || len(nn.batchLearnData[0]) != n {
+		nn.batchLearnData = make([][]layerLearnData[T], len(nn.layers))
+		for i, layer := range nn.layers {
+			nn.batchLearnData[i] = make([]layerLearnData[T], n)
+			for j := range nn.batchLearnData[i] {
+				nn.batchLearnData[i][j] = newLayerLearnData[T](layer.Dims())
+			}
 		}
-		// New input is activation from previous layer.
-		input = activations
 	}
+	return nn.batchLearnData
+}
 
-	// Begin backpropagation.
+// UpdateGradients accumulates the cost gradients of a single data point.
+// learnData holds the learn data of each layer for the data point.
+// Batch normalized layers use the running statistics when the network is
+// not in training mode.
+func (nn *NetworkOptimizedOf[T]) UpdateGradients(data DataPointOf[T], learnData []layerLearnData[T]) {
+	batchLearnData := make([][]layerLearnData[T], len(learnData))
+	for i := range learnData {
+		batchLearnData[i] = learnData[i : i+1]
+	}
+	nn.updateBatchGradients([]DataPointOf[T]{data}, batchLearnData)
+}
+
+// updateBatchGradients accumulates the cost gradients of a batch of data and
+// returns its total cost. learnData is indexed by layer first and data point second.
+func (nn *NetworkOptimizedOf[T]) updateBatchGradients(data []DataPointOf[T], learnData [][]layerLearnData[T]) (totalCost T) {
+	nn.forwardBatch(data, learnData)
+	return nn.backwardBatch(data, learnData)
+}
+
+// forwardBatch feeds the data through the network one layer at a time
+// and stores the values needed for backpropagation in learnData.
+// Layers are processed for the whole batch before moving on to the next layer
+// so that batch normalization can use statistics of the entire batch.
+func (nn *NetworkOptimizedOf[T]) forwardBatch(data []DataPointOf[T], learnData [][]layerLearnData[T]) {
+	for i := range nn.layers {
+		rows := learnData[i]
+		for s := range data {
+			input := data[s].Input
+			if i > 0 {
+				// New input is activation from previous layer.
+				input = learnData[i-1][s].activations
+			}
+			if copy(rows[s].inputs, input) != len(input) || len(input) != len(rows[s].inputs) {
+				panic("bad length")
+			}
+		}
+		nn.layers[i].forwardRows(rows, nn.mode, nn.random())
+	}
+}
+
+// backwardBatch backpropagates the cost of each data point through the network
+// and accumulates the gradients of every layer. It returns the total cost of the data.
+func (nn *NetworkOptimizedOf[T]) backwardBatch(data []DataPointOf[T], learnData [][]layerLearnData[T]) (totalCost T) {
 	outputLayerIdx := len(nn.layers) - 1
-	outputLayer := nn.layers[outputLayerIdx]
-	outputLearnData := learnData[outputLayerIdx]
-	// Calculate Output layer node values by evaluating partial derivatives
-	// for nodes: cost wrt activation and activation wrt weighted input.
-	nn.Cost.CalculateFromInputs(outputLearnData.activations, data.ExpectedOutput, 1)
-	for i := 0; i < len(outputLearnData.nodeValues); i++ {
-		activationDerivative := outputLayer.activationFunction.Derivative(i)
-		outputLearnData.nodeValues[i] = nn.Cost.Derivative(i) * activationDerivative
+	for s := range data {
+		outputLearnData := learnData[outputLayerIdx][s]
+		// Output layer node values start out as the partial derivatives
+		// of the cost with respect to the activations.
+		nn.Cost.CalculateFromInputs(outputLearnData.activations, data[s].ExpectedOutput, 1)
+		totalCost += nn.Cost.TotalCost()
+		for i := 0; i < len(outputLearnData.nodeValues); i++ {
+			outputLearnData.nodeValues[i] = nn.Cost.Derivative(i)
+		}
 	}
-	outputLayer.UpdateGradients(outputLearnData)
 
 	// Update gradients of Output layer though backpropagation.
-	for i := outputLayerIdx - 1; i >= 0; i-- {
-		layerLearnData := learnData[i]
-		hiddenLayer := nn.layers[i]
-		_, numNodesOut := hiddenLayer.Dims()
-		oldLayer := nn.layers[i+1]
-		oldLayerLearnData := learnData[i+1]
-		// Calculate hidden layer node values
-		// This is an array containing for each node:
-		// the partial derivative of the cost with respect to the weighted input
-		for newNodeIdx := 0; newNodeIdx < numNodesOut; newNodeIdx++ {
-			var newNodeValue float64
-			for oldNodeIdx := 0; oldNodeIdx < len(oldLayerLearnData.nodeValues); oldNodeIdx++ {
-				weightedInputDerivative := oldLayer.weights[oldLayer.getWeightIdx(newNodeIdx, oldNodeIdx)]
-				newNodeValue += weightedInputDerivative * oldLayerLearnData.nodeValues[oldNodeIdx]
-			}
-			newNodeValue *= hiddenLayer.activationFunction.Derivative(newNodeIdx)
-			layerLearnData.nodeValues[newNodeIdx] = newNodeValue
+	for i := outputLayerIdx; i >= 0; i-- {
+		layer := &nn.layers[i]
+		layer.backwardRows(learnData[i])
+		if i == 0 {
+			break
+		}
+		// Calculate previous layer node values with respect to its activations.
+		for s := range data {
+			layer.storeInputGradients(learnData[i][s].nodeValues, learnData[i-1][s].nodeValues)
 		}
-		// Finally Update gradients.
-		hiddenLayer.UpdateGradients(layerLearnData)
 	}
+	return totalCost
 }
 
-type LayerOptimized struct {
+type LayerOptimized = LayerOptimizedOf[float64]
+
+// LayerOptimizedOf is a LayerOptimized computing with floats of type T.
+type LayerOptimizedOf[T constraints.Float] struct {
 	numNodesIn         int
-	weights            []float64
-	weightVelocities   []float64
-	costGradientW      []float64
-	biases             []float64
-	costGradientB      []float64
-	biasVelocities     []float64
-	activationFunction ActivationFunc
+	weights            []T
+	weightVelocities   []T
+	costGradientW      []T
+	biases             []T
+	costGradientB      []T
+	biasVelocities     []T
+	activationFunction ActivationFuncOf[T]
+	// dropout is the probability of zeroing an activation during training.
+	dropout float64
+	// norm normalizes the weighted inputs before activation. May be nil.
+	norm NormalizerOf[T]
+
+	// The fields below are only used when the layer is used as a Layer.
+	// rng is used to generate dropout masks.
+	rng *rand.Rand
+	// rows holds the learn data of each row of the last batch passed to Forward.
+	rows []layerLearnData[T]
+	// outputs and inputGradients are the row-major matrices returned by Forward and Backward.
+	outputs        []T
+	inputGradients []T
+
+	// storeBuf is the workspace of StoreOutputs holding the weighted inputs,
+	// activations, normalized weighted inputs and dropout mask of a single row.
+	storeBuf []T
+	// normRows holds the rows passed to the normalizer by forwardRows and backwardRows.
+	normRows [3][][]T
 }
 
-func newLayerOptimized(numNodesIn, numNodesOut int, act ActivationFunc, rng *rand.Rand) LayerOptimized {
+var _ Layer = (*LayerOptimized)(nil)
+
+// NewLayerOptimized returns a fully connected layer with randomized weights and biases
+// which implements Layer.
+func NewLayerOptimized(numNodesIn, numNodesOut int, act ActivationFunc, rng *rand.Rand) *LayerOptimized {
+	return NewLayerOptimizedOf(numNodesIn, numNodesOut, act, rng)
+}
+
+// NewLayerOptimizedOf returns a fully connected layer computing with floats of type T.
+func NewLayerOptimizedOf[T constraints.Float](numNodesIn, numNodesOut int, act ActivationFuncOf[T], rng *rand.Rand) *LayerOptimizedOf[T] {
+	layer := newLayerOptimized(numNodesIn, numNodesOut, act, rng)
+	return &layer
+}
+
+func newLayerOptimized[T constraints.Float](numNodesIn, numNodesOut int, act ActivationFuncOf[T], rng *rand.Rand) LayerOptimizedOf[T] {
 	sizeW := numNodesIn * numNodesOut
 	invSqrtNumNodesIn := 1 / math.Sqrt(float64(numNodesIn))
-	nn := LayerOptimized{
+	nn := LayerOptimizedOf[T]{
 		numNodesIn:         numNodesIn,
-		weights:            randomSlice(sizeW, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, rng),
-		costGradientW:      make([]float64, sizeW),
-		weightVelocities:   make([]float64, sizeW),
-		biases:             randomSlice(numNodesOut, 2, -1, rng),
-		costGradientB:      make([]float64, numNodesOut),
+		weights:            randomSlice[T](sizeW, 2*invSqrtNumNodesIn, -invSqrtNumNodesIn, rng),
+		costGradientW:      make([]T, sizeW),
+		weightVelocities:   make([]T, sizeW),
+		biases:             randomSlice[T](numNodesOut, 2, -1, rng),
+		costGradientB:      make([]T, numNodesOut),
+		biasVelocities:     make([]T, numNodesOut),
 		activationFunction: act,
+		rng:                rng,
 	}
 	return nn
 }
 
+// layerFromSetup creates a layer from its serialized form.
+func layerFromSetup[T constraints.Float](setup LayerSetup, act ActivationFuncOf[T]) (LayerOptimizedOf[T], error) {
+	numNodesIn, numNodesOut := setup.Dims()
+	if numNodesIn == 0 || numNodesOut == 0 {
+		return LayerOptimizedOf[T]{}, errors.New("layer setup without weights or biases")
+	}
+	for nodeIn, w := range setup.Weights {
+		if len(w) != numNodesOut {
+			return LayerOptimizedOf[T]{}, fmt.Errorf("layer setup has %d weights for input %d and %d biases", len(w), nodeIn, numNodesOut)
+		}
+	}
+	if setup.Dropout < 0 || setup.Dropout >= 1 {
+		return LayerOptimizedOf[T]{}, errors.New("dropout rate must be in [0, 1)")
+	}
+	weights := make([]T, numNodesIn*numNodesOut)
+	for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
+		for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
+			weights[nodeOut*numNodesIn+nodeIn] = T(setup.Weights[nodeIn][nodeOut])
+		}
+	}
+	lo := newLayerOptimized(numNodesIn, numNodesOut, act, rand.New(rand.NewSource(1)))
+	lo.weights = weights
+	lo.biases = convertSlice[T](setup.Biases)
+	lo.dropout = setup.Dropout
+	if setup.Norm != nil {
+		norm, err := normalizerFromSetup[T](*setup.Norm)
+		if err != nil {
+			return LayerOptimizedOf[T]{}, err
+		}
+		if norm.size() != numNodesOut {
+			return LayerOptimizedOf[T]{}, errors.New("normalizer size mismatches layer output size")
+		}
+		lo.norm = norm
+	}
+	return lo, nil
+}
+
+// export returns the serialized form of the layer excluding its activation function.
+func (layer LayerOptimizedOf[T]) export() LayerSetup {
+	numNodesIn, numNodesOut := layer.Dims()
+	weights := make([][]float64, numNodesIn)
+	for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
+		weights[nodeIn] = make([]float64, numNodesOut)
+		for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
+			weights[nodeIn][nodeOut] = float64(layer.weights[layer.getWeightIdx(nodeIn, nodeOut)])
+		}
+	}
+	setup := LayerSetup{
+		Weights: weights,
+		Biases:  convertSlice[float64](layer.biases),
+		Dropout: layer.dropout,
+	}
+	if layer.norm != nil {
+		setup.Norm = layer.norm.export()
+	}
+	return setup
+}
+
+// MarshalJSON encodes the layer as a LayerSetup including its activation function,
+// which must be registered with RegisterActivation.
+func (layer *LayerOptimizedOf[T]) MarshalJSON() ([]byte, error) {
+	setup := layer.export()
+	act, err := marshalActivation(layer.activationFunction)
+	if err != nil {
+		return nil, err
+	}
+	setup.Activation = act
+	return json.Marshal(setup)
+}
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (layer *LayerOptimizedOf[T]) UnmarshalJSON(b []byte) error {
+	var setup LayerSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Activation == nil {
+		return errors.New("missing layer activation")
+	}
+	act, err := unmarshalActivation[T](*setup.Activation)
+	if err != nil {
+		return err
+	}
+	lo, err := layerFromSetup(setup, act)
+	if err != nil {
+		return err
+	}
+	*layer = lo
+	return nil
+}
+
+func (layer *LayerOptimizedOf[T]) Forward(x []T, mode Mode) []T {
+	numNodesIn, numNodesOut := layer.Dims()
+	n := batchSize(x, numNodesIn)
+	if len(layer.rows) != n {
+		layer.outputs = make([]T, n*numNodesOut)
+		layer.inputGradients = make([]T, n*numNodesIn)
+		layer.rows = make([]layerLearnData[T], n)
+		for s := range layer.rows {
+			layer.rows[s] = newLayerLearnData[T](numNodesIn, numNodesOut)
+			// Store activations contiguously so they can be returned as a matrix.
+			layer.rows[s].activations = layer.outputs[s*numNodesOut : (s+1)*numNodesOut]
+		}
+	}
+	for s := range layer.rows {
+		copy(layer.rows[s].inputs, x[s*numNodesIn:])
+	}
+	if layer.rng == nil {
+		layer.rng = rand.New(rand.NewSource(1))
+	}
+	layer.forwardRows(layer.rows, mode, layer.rng)
+	return layer.outputs
+}
+
+func (layer *LayerOptimizedOf[T]) Backward(dy []T) []T {
+	numNodesIn, numNodesOut := layer.Dims()
+	if len(dy) != len(layer.outputs) {
+		panic("output gradient length mismatches last forward batch")
+	}
+	for s := range layer.rows {
+		copy(layer.rows[s].nodeValues, dy[s*numNodesOut:])
+	}
+	layer.backwardRows(layer.rows)
+	for s := range layer.rows {
+		layer.storeInputGradients(layer.rows[s].nodeValues, layer.inputGradients[s*numNodesIn:(s+1)*numNodesIn])
+	}
+	return layer.inputGradients
+}
+
+func (layer *LayerOptimizedOf[T]) Params() []ParamOf[T] {
+	params := []ParamOf[T]{
+		{Value: layer.weights, Grad: layer.costGradientW, Decay: true},
+		{Value: layer.biases, Grad: layer.costGradientB},
+	}
+	if layer.norm != nil {
+		params = append(params, layer.norm.Params()...)
+	}
+	return params
+}
+
 //go:inline
-func (l LayerOptimized) getWeightIdx(nodeIn, nodeOut int) int {
+func (l LayerOptimizedOf[T]) getWeightIdx(nodeIn, nodeOut int) int {
 	return nodeOut*l.numNodesIn + nodeIn
 }
 
-func (l LayerOptimized) Dims() (input, output int) {
+// weightRow returns the weights of the connections to node nodeOut.
+// Weights are stored row-major with a row per output node.
+func (l LayerOptimizedOf[T]) weightRow(nodeOut int) []T {
+	return l.weights[nodeOut*l.numNodesIn : (nodeOut+1)*l.numNodesIn]
+}
+
+// gradientRow returns the cost gradient of the weights of node nodeOut.
+func (l LayerOptimizedOf[T]) gradientRow(nodeOut int) []T {
+	return l.costGradientW[nodeOut*l.numNodesIn : (nodeOut+1)*l.numNodesIn]
+}
+
+func (l LayerOptimizedOf[T]) Dims() (input, output int) {
 	return l.numNodesIn, len(l.biases)
 }
 
 // StoreOutputs stores the result of passing inputs through the layer in weightedInputs
-// and activations. It is the equivalent of CalculateOutputs
-func (layer LayerOptimized) StoreOutputs(inputs []float64) (weightOut, activations []float64) {
-	numNodesIn, numNodesOut := layer.Dims()
-	x := make([]float64, 2*numNodesOut)
+// and activations. It is the equivalent of CalculateOutputs. The returned slices are
+// owned by the layer and overwritten by the next call.
+func (layer *LayerOptimizedOf[T]) StoreOutputs(inputs []T) (weightOut, activations []T) {
+	_, numNodesOut := layer.Dims()
+	layer.storeBuf = resize(layer.storeBuf, 4*numNodesOut)
+	return layer.inference(inputs, layer.storeBuf, layer.activationFunction)
+}
+
+// inference passes inputs through the layer using act as the activation function.
+// The weighted inputs and activations are stored in buf, which must have a
+// length of at least three times the layer's output length. The layer's
+// parameters are only read, so inference may be called concurrently as long
+// as each call uses its own buf and act.
+func (layer LayerOptimizedOf[T]) inference(inputs, buf []T, act ActivationFuncOf[T]) (weightOut, activations []T) {
+	_, numNodesOut := layer.Dims()
+	x := buf
 	weightOut = x[:numNodesOut]
-	activations = x[numNodesOut:]
+	activations = x[numNodesOut : 2*numNodesOut]
+	layer.storeWeightedInputs(inputs, weightOut)
+	preActivation := weightOut
+	if layer.norm != nil {
+		preActivation = x[2*numNodesOut : 3*numNodesOut]
+		layer.norm.inference(weightOut, preActivation)
+	}
+	storeActivations(act, preActivation, activations, nil)
+	return weightOut, activations
+}
+
+// inferenceBatch is the batched counterpart of inference for the row-major matrix
+// of inputs x. buf must have a length of at least three times the length of the
+// output matrix. Each weight row is applied to every input row before moving on
+// to the next node, so that the weights are loaded from memory once per batch.
+func (layer LayerOptimizedOf[T]) inferenceBatch(x, buf []T, act ActivationFuncOf[T]) (activations []T) {
+	numNodesIn, numNodesOut := layer.Dims()
+	n := batchSize(x, numNodesIn)
+	size := n * numNodesOut
+	weightOut := buf[:size]
+	activations = buf[size : 2*size]
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-		weightedIn := layer.biases[nodeOut]
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			weightedIn += inputs[nodeIn] * layer.weights[layer.getWeightIdx(nodeIn, nodeOut)]
+		weights := layer.weightRow(nodeOut)
+		for s := 0; s < n; s++ {
+			weightedIn := layer.biases[nodeOut] + kernels.Dot(x[s*numNodesIn:(s+1)*numNodesIn], weights)
+			if isNaNOrInf(weightedIn) {
+				panic("NaN/Inf in weight calculation")
+			}
+			weightOut[s*numNodesOut+nodeOut] = weightedIn
 		}
-		weightOut[nodeOut] = weightedIn
-		if math.IsNaN(weightedIn) || math.IsInf(weightedIn, 0) {
+	}
+	preActivation := weightOut
+	if layer.norm != nil {
+		preActivation = buf[2*size : 3*size]
+	}
+	for s := 0; s < n; s++ {
+		row := preActivation[s*numNodesOut : (s+1)*numNodesOut]
+		if layer.norm != nil {
+			layer.norm.inference(weightOut[s*numNodesOut:(s+1)*numNodesOut], row)
+		}
+		storeActivations(act, row, activations[s*numNodesOut:(s+1)*numNodesOut], nil)
+	}
+	return activations
+}
+
+// storeWeightedInputs stores the weighted sum of the inputs plus bias of each node in weightOut.
+func (layer LayerOptimizedOf[T]) storeWeightedInputs(inputs, weightOut []T) {
+	copy(weightOut, layer.biases)
+	kernels.Gemv(layer.weights, inputs, weightOut)
+	for _, weightedIn := range weightOut {
+		if isNaNOrInf(weightedIn) {
 			panic("NaN/Inf in weight calculation")
 		}
 	}
+}
 
-	// Apply activation function.
-	layer.activationFunction.CalculateFromInputs(weightOut, 1)
+// storeActivations applies the activation function to preActivation and stores
+// the result in activations. If derivatives is not nil the derivative of the
+// activation function is stored in it.
+func storeActivations[T constraints.Float](act ActivationFuncOf[T], preActivation, activations, derivatives []T) {
+	act.CalculateFromInputs(preActivation, 1)
 	for i := range activations {
-		activation := layer.activationFunction.Activate(i)
-		if math.IsNaN(activation) || math.IsInf(activation, 0) {
+		activation := act.Activate(i)
+		if isNaNOrInf(activation) {
 			panic("NaN/Inf activation value")
 		}
 		activations[i] = activation
 	}
-	return weightOut, activations
+	for i := range derivatives {
+		derivatives[i] = act.Derivative(i)
+	}
 }
 
-// ApplyGradients a.k.a ApplyAllGradients
-func (layer LayerOptimized) ApplyGradients(learnRate, regularization, momentum float64) {
-	weightDecay := 1 - regularization*learnRate
+// forwardRows passes the inputs stored in each row through the layer and stores
+// the values needed for backpropagation in the rows.
+func (layer *LayerOptimizedOf[T]) forwardRows(rows []layerLearnData[T], mode Mode, rng *rand.Rand) {
+	for s := range rows {
+		layer.storeWeightedInputs(rows[s].inputs, rows[s].weightedInputs)
+	}
+	if layer.norm != nil {
+		z := layer.learnDataRows(0, rows, func(ld layerLearnData[T]) []T { return ld.weightedInputs })
+		xhat := layer.learnDataRows(1, rows, func(ld layerLearnData[T]) []T { return ld.xhat })
+		out := layer.learnDataRows(2, rows, func(ld layerLearnData[T]) []T { return ld.normalized })
+		layer.norm.forward(z, xhat, out, mode)
+	}
+	for s := range rows {
+		ld := rows[s]
+		preActivation := ld.weightedInputs
+		if layer.norm != nil {
+			preActivation = ld.normalized
+		}
+		storeActivations(layer.activationFunction, preActivation, ld.activations, ld.activationDerivatives)
+		if mode == ModeTrain && layer.dropout > 0 {
+			fillDropoutMask(ld.dropoutMask, layer.dropout, rng)
+		} else {
+			fillOnes(ld.dropoutMask)
+		}
+		applyMask(ld.activations, ld.dropoutMask)
+	}
+}
 
-	for i, weight := range layer.weights {
-		velocity := layer.weightVelocities[i]*momentum - layer.costGradientW[i]*learnRate
-		layer.weightVelocities[i] = velocity
-		layer.weights[i] = weight*weightDecay + velocity
-		// Set gradients to zero on finish to prepare for next learn iteration.
-		layer.costGradientW[i] = 0
+// backwardRows expects the node values of each row to hold the partial
+// derivatives of the cost with respect to the layer's activations.
+// On return node values hold the partial derivatives of the cost with respect
+// to the weighted inputs and the layer's gradients have been accumulated.
+func (layer *LayerOptimizedOf[T]) backwardRows(rows []layerLearnData[T]) {
+	jacobian, isJacobian := layer.activationFunction.(ActivationJacobianOf[T])
+	for s := range rows {
+		ld := rows[s]
+		if isJacobian {
+			// Chain rule through the dropout mask and then through the full Jacobian
+			// of the activation, which is evaluated again at the row's inputs.
+			applyMask(ld.nodeValues, ld.dropoutMask)
+			preActivation := ld.weightedInputs
+			if layer.norm != nil {
+				preActivation = ld.normalized
+			}
+			jacobian.CalculateFromInputs(preActivation, 1)
+			jacobian.Backward(ld.nodeValues, ld.nodeValues)
+			continue
+		}
+		for i := range ld.nodeValues {
+			// Chain rule through the dropout mask: dropped nodes receive no gradient.
+			ld.nodeValues[i] *= ld.activationDerivatives[i] * ld.dropoutMask[i]
+		}
 	}
+	if layer.norm != nil {
+		// Node values so far are derivatives with respect to the normalized
+		// weighted inputs. Propagate them through the normalization.
+		nodeValues := layer.learnDataRows(0, rows, func(ld layerLearnData[T]) []T { return ld.nodeValues })
+		xhat := layer.learnDataRows(1, rows, func(ld layerLearnData[T]) []T { return ld.xhat })
+		layer.norm.backward(nodeValues, xhat, nodeValues)
+	}
+	for s := range rows {
+		layer.UpdateGradients(rows[s])
+	}
+}
 
-	for i, biasVelocity := range layer.biasVelocities {
-		velocity := biasVelocity*momentum - layer.costGradientB[i]*learnRate
-		layer.biasVelocities[i] = velocity
-		layer.biases[i] += velocity
-		layer.costGradientB[i] = 0 // Zero out gradients.
+// storeInputGradients calculates the partial derivatives of the cost with respect
+// to the layer inputs given the layer's node values and stores them in dst.
+func (layer LayerOptimizedOf[T]) storeInputGradients(nodeValues, dst []T) {
+	// The derivative of a weighted input with respect to an input is the weight
+	// of their connection, so the input gradients are the transposed weights times the node values.
+	fillZeros(dst)
+	kernels.GemvT(layer.weights, nodeValues, dst)
+}
+
+// learnDataRows returns the slice selected by field of each row. The result is
+// stored in the layer's row buffer at index buf which is reused between calls.
+func (layer *LayerOptimizedOf[T]) learnDataRows(buf int, rows []layerLearnData[T], field func(layerLearnData[T]) []T) [][]T {
+	selected := resize(layer.normRows[buf], len(rows))
+	layer.normRows[buf] = selected
+	for s := range rows {
+		selected[s] = field(rows[s])
 	}
+	return selected
 }
 
-func (layer LayerOptimized) UpdateGradients(learnData layerLearnData) {
-	numNodesIn, numNodesOut := layer.Dims()
+// ApplyGradients a.k.a ApplyAllGradients
+func (layer LayerOptimizedOf[T]) ApplyGradients(learnRate, regularization, momentum float64) {
+	weightDecay := T(1 - regularization*learnRate)
+	lr, mom := T(learnRate), T(momentum)
+
+	// velocity = velocity*momentum - gradient*learnRate
+	kernels.ScaledAdd(-lr, layer.costGradientW, mom, layer.weightVelocities)
+	// weight = weight*weightDecay + velocity
+	kernels.ScaledAdd(1, layer.weightVelocities, weightDecay, layer.weights)
+	// Set gradients to zero on finish to prepare for next learn iteration.
+	fillZeros(layer.costGradientW)
+
+	kernels.ScaledAdd(-lr, layer.costGradientB, mom, layer.biasVelocities)
+	kernels.Axpy(1, layer.biasVelocities, layer.biases)
+	fillZeros(layer.costGradientB) // Zero out gradients.
+	if layer.norm != nil {
+		layer.norm.applyGradients(learnRate, momentum)
+		layer.norm.updateRunningStats()
+	}
+}
+
+// updateRunningStats updates the running statistics of the layer's normalizer.
+func (layer *LayerOptimizedOf[T]) updateRunningStats() {
+	if layer.norm != nil {
+		layer.norm.updateRunningStats()
+	}
+}
 
+func (layer LayerOptimizedOf[T]) UpdateGradients(learnData layerLearnData[T]) {
+	// Update cost gradient with respect to biases. The derivative of
+	// a weighted input with respect to its bias is 1.
+	kernels.Axpy(1, learnData.nodeValues, layer.costGradientB)
+	_, numNodesOut := layer.Dims()
 	for nodeOut := 0; nodeOut < numNodesOut; nodeOut++ {
-		nodeValue := learnData.nodeValues[nodeOut]
-		// Update cost gradient with respect to biases.
-		derivativeCostWrtBias := 1 * nodeValue
-		layer.costGradientB[nodeOut] += derivativeCostWrtBias
-		// Update cost gradient with respect to weights.
-		for nodeIn := 0; nodeIn < numNodesIn; nodeIn++ {
-			// Evaluate the partial derivative: cost with respect to weight of current connection.
-			derivativeCostWrtWeight := learnData.inputs[nodeIn] * nodeValue
-			// The costGradientW array stores these partial derivatives for each weight.
-			// Note: the derivative is being added to the array here because ultimately we want
-			// to calculate the average gradient across all the data in the training batch
-			layer.costGradientW[layer.getWeightIdx(nodeIn, nodeOut)] += derivativeCostWrtWeight
-		}
+		// The partial derivative of the cost with respect to the weight of a
+		// connection is the connection's input times the node value.
+		// Note: the derivative is being added to the gradient here because ultimately we want
+		// to calculate the average gradient across all the data in the training batch
+		kernels.Axpy(learnData.nodeValues[nodeOut], learnData.inputs, layer.gradientRow(nodeOut))
 	}
 }
 
@@ -302,21 +816,68 @@ func NewHyperParameters(layerSizes []int) HyperParameters {
 	return h
 }
 
-type layerLearnData struct {
-	inputs         []float64
-	weightedInputs []float64
-	activations    []float64
-	nodeValues     []float64
+type layerLearnData[T constraints.Float] struct {
+	inputs         []T
+	weightedInputs []T
+	activations    []T
+	nodeValues     []T
+	// dropoutMask holds the scaling applied to each activation during the
+	// forward pass: 0 for dropped nodes and 1/(1-dropout) for kept nodes.
+	dropoutMask []T
+	// activationDerivatives holds the derivative of the activation function
+	// evaluated at each node during the forward pass.
+	activationDerivatives []T
+	// xhat and normalized hold the normalized weighted inputs before and after
+	// scale and shift. Only used by layers with normalization.
+	xhat       []T
+	normalized []T
 }
 
-func newLayerLearnData(numNodesIn, numNodesOut int) layerLearnData {
+func newLayerLearnData[T constraints.Float](numNodesIn, numNodesOut int) layerLearnData[T] {
 	// Do a single slab allocation for performance reasons.
 	// slabAlloc := make([]float64, numNodesOut*3)
-	return layerLearnData{
-		weightedInputs: make([]float64, numNodesOut),
-		activations:    make([]float64, numNodesOut),
-		nodeValues:     make([]float64, numNodesOut),
-		inputs:         make([]float64, numNodesIn),
+	return layerLearnData[T]{
+		weightedInputs: make([]T, numNodesOut),
+		activations:    make([]T, numNodesOut),
+		nodeValues:     make([]T, numNodesOut),
+		inputs:         make([]T, numNodesIn),
+		dropoutMask:    make([]T, numNodesOut),
+
+		activationDerivatives: make([]T, numNodesOut),
+		xhat:                  make([]T, numNodesOut),
+		normalized:            make([]T, numNodesOut),
+	}
+}
+
+// fillDropoutMask fills mask with an inverted dropout mask where each
+// element is zeroed with probability rate.
+func fillDropoutMask[T constraints.Float](mask []T, rate float64, rng *rand.Rand) {
+	keep := 1 - rate
+	scale := T(1 / keep)
+	for i := range mask {
+		if rng.Float64() < keep {
+			mask[i] = scale
+		} else {
+			mask[i] = 0
+		}
+	}
+}
+
+func applyMask[T constraints.Float](activations, mask []T) {
+	for i := range activations {
+		activations[i] *= mask[i]
+	}
+}
+
+func fillOnes[T constraints.Float](s []T) {
+	for i := range s {
+		s[i] = 1
+	}
+}
+
+func fillZeros[T constraints.Float](s []T) {
+	for i := range s {
+		s[i] = 0
 	}
 }
 
@@ -331,3 +892,19 @@ func maxIdx[T constraints.Ordered](lowerBound T, slice []T) int {
 	}
 	return index
 }
+
+// convertSlice returns a copy of s converted to type To.
+func convertSlice[To, From constraints.Float](s []From) []To {
+	if s == nil {
+		return nil
+	}
+	converted := make([]To, len(s))
+	for i, v := range s {
+		converted[i] = To(v)
+	}
+	return converted
+}
+
+func isNaNOrInf[T constraints.Float](v T) bool {
+	return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+}
@@ -1,14 +1,17 @@
 package neurus_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"image/png"
+	"math"
 	"math/rand"
 	"os"
 	"testing"
 
 	"github.com/soypat/neurus"
+	"github.com/soypat/neurus/mnist"
 )
 
 func TestNetworkOptimized_Import(t *testing.T) {
@@ -115,3 +118,269 @@ var basic2DClassifier = func(x, y float64) int {
 	}
 	return 0
 }
+
+// random2DData is like Model2D.Generate2DData for the basic2DClassifier with
+// points drawn from rng instead of the global source.
+func random2DData(rng *rand.Rand, n int) []neurus.DataPoint {
+	data := make([]neurus.DataPoint, n)
+	for i := range data {
+		x, y := rng.Float64(), rng.Float64()
+		expected := make([]float64, 2)
+		expected[basic2DClassifier(x, y)] = 1
+		data[i] = neurus.DataPoint{Input: []float64{x, y}, ExpectedOutput: expected}
+	}
+	return data
+}
+
+func TestNetworkOptimized_exportNormalization(t *testing.T) {
+	nn := neurus.NewNetworkOptimized([]int{2, 4, 2},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	nn.SetNormalization(0, neurus.NewBatchNorm(4))
+	nn.SetNormalization(1, neurus.NewLayerNorm(2))
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	for i := 0; i < 20; i++ {
+		nn.Learn(m.Generate2DData(8), 0.1, 0, 0.9)
+	}
+	imported := roundTrip(t, nn, []float64{0.2, 0.6}, []float64{-0.4, 0.1}).(*neurus.NetworkOptimized)
+	for i, kind := range []string{"batch", "layer"} {
+		if norm := imported.Export()[i].Norm; norm == nil || norm.Kind != kind {
+			t.Errorf("layer %d: %s normalization not imported", i, kind)
+		}
+	}
+}
+
+func TestNetworkOptimized_dropout(t *testing.T) {
+	const rate = 0.5
+	activation := func() neurus.ActivationFunc { return new(neurus.Sigmd) }
+	nn := neurus.NewNetworkOptimized([]int{2, 64, 2}, activation, &neurus.MeanSquaredError{}, rand.NewSource(1))
+	nn.SetDropout(0, rate)
+	input := []float64{0.3, 0.7}
+
+	// Inference mode must be deterministic.
+	_, out := nn.Classify(input)
+	want := append([]float64{}, out...)
+	for i := 0; i < 10; i++ {
+		_, got := nn.Classify(input)
+		for j := range got {
+			if got[j] != want[j] {
+				t.Fatalf("inference output changed between calls: got %v, want %v", got, want)
+			}
+		}
+	}
+
+	// Train mode should mask activations and thus vary the output.
+	nn.SetMode(neurus.ModeTrain)
+	varied := false
+	for i := 0; i < 10 && !varied; i++ {
+		_, got := nn.Classify(input)
+		varied = got[0] != want[0] || got[1] != want[1]
+	}
+	if !varied {
+		t.Error("train mode output did not vary with dropout enabled")
+	}
+
+	// Learn must restore the previous mode.
+	nn.SetMode(neurus.ModeInference)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	nn.Learn(m.Generate2DData(10), 0.05, 0, 0.9)
+	if nn.Mode() != neurus.ModeInference {
+		t.Error("Learn did not restore inference mode")
+	}
+	exported := nn.Export()
+	if exported[0].Dropout != rate || exported[1].Dropout != 0 {
+		t.Errorf("dropout rates not exported: %v, %v", exported[0].Dropout, exported[1].Dropout)
+	}
+}
+
+func TestNetworkOptimizedOf_float32(t *testing.T) {
+	// Weights and data are drawn from rng so that the test does not depend
+	// on the randomly seeded global source.
+	rng := rand.New(rand.NewSource(1))
+	nn64 := neurus.NewNetworkOptimized([]int{2, 8, 4, 2},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	nn64.Import(randomSetup(rng, 2, 8, 4, 2), func() neurus.ActivationFunc { return new(neurus.Sigmd) })
+	nn64.SetNormalization(1, neurus.NewLayerNorm(4))
+	// Start out from the same weights. Activations are imported from the setups.
+	var nn32 neurus.NetworkOptimizedOf[float32]
+	nn32.Import(nn64.Export(), nil)
+	nn32.Cost = &neurus.MeanSquaredErrorOf[float32]{}
+
+	for i := 0; i < 200; i++ {
+		batch64 := random2DData(rng, 10)
+		batch32 := make([]neurus.DataPointOf[float32], len(batch64))
+		for j, dp := range batch64 {
+			batch32[j] = neurus.DataPointOf[float32]{
+				Input:          []float32{float32(dp.Input[0]), float32(dp.Input[1])},
+				ExpectedOutput: []float32{float32(dp.ExpectedOutput[0]), float32(dp.ExpectedOutput[1])},
+			}
+		}
+		nn64.Learn(batch64, 0.5, 0, 0.9)
+		nn32.Learn(batch32, 0.5, 0, 0.9)
+	}
+	for _, input := range [][]float64{{0.1, 0.2}, {0.5, 0.9}, {0.8, 0.1}} {
+		class64, out64 := nn64.Classify(input)
+		class32, out32 := nn32.Classify([]float32{float32(input[0]), float32(input[1])})
+		if class64 != class32 {
+			t.Errorf("input %v: float32 class %d, float64 class %d", input, class32, class64)
+		}
+		for i := range out64 {
+			if diff := math.Abs(out64[i] - float64(out32[i])); diff > 1e-3 {
+				t.Errorf("input %v: float32 output %v, float64 output %v", input, out32, out64)
+			}
+		}
+	}
+}
+
+func TestNetworkOptimizedOf_mnist(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping MNIST training in short mode")
+	}
+	const (
+		numTrain  = 2000
+		numTest   = 500
+		batchSize = 10
+		epochs    = 5
+	)
+	_, test32, _ := mnist.Load()
+	_, test64, _ := mnist.Load64()
+	train32 := neurus.MNISTToDatapoints32(test32[:numTrain])
+	train64 := neurus.MNISTToDatapoints(test64[:numTrain])
+	nn64 := neurus.NewNetworkOptimized([]int{mnist.PixelCount, 32, 10},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	var nn32 neurus.NetworkOptimizedOf[float32]
+	nn32.Import(nn64.Export(), nil)
+	nn32.Cost = &neurus.MeanSquaredErrorOf[float32]{}
+	for epoch := 0; epoch < epochs; epoch++ {
+		for i := 0; i+batchSize <= numTrain; i += batchSize {
+			nn64.Learn(train64[i:i+batchSize], 1, 0, 0)
+			nn32.Learn(train32[i:i+batchSize], 1, 0, 0)
+		}
+	}
+	var correct32, correct64, agree int
+	for i := numTrain; i < numTrain+numTest; i++ {
+		class64, _ := nn64.Classify(test64[i].Data[:])
+		class32, _ := nn32.Classify(test32[i].Data[:])
+		if class64 == int(test64[i].Num) {
+			correct64++
+		}
+		if class32 == int(test32[i].Num) {
+			correct32++
+		}
+		if class32 == class64 {
+			agree++
+		}
+	}
+	t.Logf("accuracy float32 %d/%d, float64 %d/%d, agreement %d/%d", correct32, numTest, correct64, numTest, agree, numTest)
+	if correct32 < numTest*3/4 {
+		t.Errorf("float32 accuracy too low: %d/%d", correct32, numTest)
+	}
+	if diff := correct32 - correct64; diff > numTest/50 || diff < -numTest/50 {
+		t.Errorf("float32 accuracy %d/%d differs from float64 accuracy %d/%d", correct32, numTest, correct64, numTest)
+	}
+}
+
+// TestMNISTClassesToDatapoints converts images with labels of more than 10
+// classes read from IDX files.
+func TestMNISTClassesToDatapoints(t *testing.T) {
+	const numClasses = 26
+	var images, labels bytes.Buffer
+	written := make([]mnist.Image, 3)
+	for i, num := range []uint8{0, 12, 25} {
+		written[i].Num = num
+		written[i].Data[i] = 1
+	}
+	if err := mnist.WriteImages(&images, &labels, written); err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := mnist.ReadImages(&images, &labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data32 := neurus.MNISTClassesToDatapoints32(imgs, numClasses)
+	data64 := neurus.MNISTClassesToDatapoints(mnist.Images64(imgs), numClasses)
+	for i, img := range imgs {
+		if len(data32[i].ExpectedOutput) != numClasses || len(data64[i].ExpectedOutput) != numClasses {
+			t.Fatalf("image %d: got %d and %d expected outputs, want %d", i, len(data32[i].ExpectedOutput), len(data64[i].ExpectedOutput), numClasses)
+		}
+		for class := 0; class < numClasses; class++ {
+			want := 0.0
+			if class == int(img.Num) {
+				want = 1
+			}
+			if data64[i].ExpectedOutput[class] != want || float64(data32[i].ExpectedOutput[class]) != want {
+				t.Errorf("image %d labeled %d: expected output %d is not %g", i, img.Num, class, want)
+			}
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic converting labels above 9 to 10 classes")
+		}
+	}()
+	neurus.MNISTToDatapoints32(imgs)
+}
+
+func TestNetworkOptimized_allocs(t *testing.T) {
+	activation := func() neurus.ActivationFunc { return new(neurus.Sigmd) }
+	nn := neurus.NewNetworkOptimized([]int{2, 16, 8, 2}, activation, &neurus.MeanSquaredError{}, rand.NewSource(1))
+	nn.SetNormalization(0, neurus.NewBatchNorm(16))
+	nn.SetNormalization(1, neurus.NewLayerNorm(8))
+	nn.SetDropout(0, 0.2)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	batch := m.Generate2DData(10)
+	input := batch[0].Input
+	// First calls allocate the workspaces.
+	nn.Learn(batch, 0.1, 0, 0.9)
+	nn.Classify(input)
+
+	if allocs := testing.AllocsPerRun(100, func() { nn.Classify(input) }); allocs != 0 {
+		t.Errorf("Classify allocated %v times per call", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { nn.Learn(batch, 0.1, 0, 0.9) }); allocs != 0 {
+		t.Errorf("Learn allocated %v times per call", allocs)
+	}
+	nn.SetMode(neurus.ModeTrain)
+	if allocs := testing.AllocsPerRun(100, func() { nn.Classify(input) }); allocs != 0 {
+		t.Errorf("Classify in train mode allocated %v times per call", allocs)
+	}
+}
+
+func BenchmarkNetworkOptimized_Classify(b *testing.B) {
+	nn, data := newBenchmarkNetworkOptimized(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nn.Classify(data[0].Input)
+	}
+}
+
+func BenchmarkNetworkOptimized_Learn(b *testing.B) {
+	nn, data := newBenchmarkNetworkOptimized(32)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nn.Learn(data, 0.1, 0, 0.9)
+	}
+}
+
+// newBenchmarkNetworkOptimized returns an MNIST sized network
+// and numData data points with random inputs.
+func newBenchmarkNetworkOptimized(numData int) (*neurus.NetworkOptimized, []neurus.DataPoint) {
+	rng := rand.New(rand.NewSource(1))
+	nn := neurus.NewNetworkOptimized([]int{mnist.PixelCount, 32, 10},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rng)
+	data := make([]neurus.DataPoint, numData)
+	for i := range data {
+		data[i].Input = make([]float64, mnist.PixelCount)
+		for j := range data[i].Input {
+			data[i].Input[j] = rng.Float64()
+		}
+		data[i].ExpectedOutput = make([]float64, 10)
+		data[i].ExpectedOutput[rng.Intn(10)] = 1
+	}
+	return nn, data
+}
@@ -12,3 +12,45 @@ func TestNetworkOptimized_small(t *testing.T) {
 		&MeanSquaredError{}, rand.NewSource(1))
 	_ = nn
 }
+
+func TestNetworkOptimized_dropoutBackprop(t *testing.T) {
+	nn := NewNetworkOptimized([]int{3, 8, 2},
+		func() ActivationFunc { return new(Sigmd) },
+		&MeanSquaredError{}, rand.NewSource(1))
+	nn.SetDropout(0, 0.5)
+	nn.SetMode(ModeTrain)
+	learnData := make([]layerLearnData[float64], len(nn.layers))
+	for i, layer := range nn.layers {
+		learnData[i] = newLayerLearnData[float64](layer.Dims())
+	}
+	dp := DataPoint{Input: []float64{0.1, 0.5, 0.9}, ExpectedOutput: []float64{1, 0}}
+	nn.UpdateGradients(dp, learnData)
+
+	hidden := nn.layers[0]
+	numIn, numOut := hidden.Dims()
+	dropped := 0
+	for nodeOut := 0; nodeOut < numOut; nodeOut++ {
+		mask := learnData[0].dropoutMask[nodeOut]
+		if mask != 0 && mask != 2 {
+			t.Fatalf("unexpected mask value %v for rate 0.5", mask)
+		}
+		if mask != 0 {
+			continue
+		}
+		dropped++
+		if learnData[0].activations[nodeOut] != 0 {
+			t.Errorf("dropped node %d has nonzero activation", nodeOut)
+		}
+		if hidden.costGradientB[nodeOut] != 0 {
+			t.Errorf("dropped node %d has nonzero bias gradient", nodeOut)
+		}
+		for nodeIn := 0; nodeIn < numIn; nodeIn++ {
+			if hidden.costGradientW[hidden.getWeightIdx(nodeIn, nodeOut)] != 0 {
+				t.Errorf("dropped node %d has nonzero weight gradient", nodeOut)
+			}
+		}
+	}
+	if dropped == 0 || dropped == numOut {
+		t.Errorf("expected some but not all nodes dropped, got %d/%d", dropped, numOut)
+	}
+}
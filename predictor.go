@@ -0,0 +1,224 @@
+package neurus
+
+import (
+	"math"
+
+	"golang.org/x/exp/constraints"
+	"golang.org/x/exp/slices"
+)
+
+type Predictor = PredictorOf[float64]
+
+// PredictorOf runs inference on the parameters of a NetworkOptimizedOf[T].
+// Activation functions keep state between calls, so a network must not be
+// used for inference by more than one goroutine at a time. A Predictor holds
+// its own activation functions and buffers while sharing the network's
+// parameters, which are only read. Many predictors may therefore run in
+// parallel on the same network, each of them used by a single goroutine.
+// Predictors are cheap to create and are well suited for a sync.Pool:
+//
+//	pool := sync.Pool{New: func() any { return nn.NewPredictor() }}
+//	p := pool.Get().(*neurus.Predictor)
+//	class, _ := p.Classify(input)
+//	pool.Put(p)
+//
+// Predictors always run in ModeInference. Parameter updates by Learn are
+// visible to existing predictors but must not happen while they are in use.
+// Predictors created before a call to Import, SetNormalization, SetDropout or
+// SetPreprocessor keep using the previous layers and preprocessor.
+type PredictorOf[T constraints.Float] struct {
+	layers []LayerOptimizedOf[T]
+	acts   []ActivationFuncOf[T]
+	// pre is the predictor's copy of the network's preprocessor, if any.
+	pre *preprocessorOf[T]
+	// bufs holds the weighted inputs and activations of each layer.
+	bufs [][]T
+	// rows holds the input and output rows gathered by PredictBatch.
+	rows []T
+	// shared is true when the predictor uses the network's activation functions
+	// and preprocessor.
+	shared bool
+}
+
+// NewPredictor returns a Predictor for the network. The activation functions
+// and preprocessor of the network are duplicated using the registries of
+// RegisterActivation and RegisterLayer so NewPredictor panics if their types
+// are not registered.
+func (nn *NetworkOptimizedOf[T]) NewPredictor() *PredictorOf[T] {
+	p, err := nn.newPredictor(true)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// newPredictor returns a predictor for the network. If cloneActivations is false
+// the predictor uses the network's own activation functions and preprocessor.
+func (nn *NetworkOptimizedOf[T]) newPredictor(cloneActivations bool) (*PredictorOf[T], error) {
+	p := &PredictorOf[T]{
+		layers: slices.Clone(nn.layers),
+		acts:   make([]ActivationFuncOf[T], len(nn.layers)),
+		bufs:   make([][]T, len(nn.layers)),
+		pre:    nn.pre,
+		shared: !cloneActivations,
+	}
+	if nn.pre != nil && cloneActivations {
+		var err error
+		p.pre, err = nn.pre.clone()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, layer := range p.layers {
+		act := layer.activationFunction
+		if cloneActivations {
+			var err error
+			act, err = cloneActivation(act)
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.acts[i] = act
+		_, numNodesOut := layer.Dims()
+		p.bufs[i] = make([]T, 3*numNodesOut)
+	}
+	return p, nil
+}
+
+// PredictBatch stores the outputs of the network for each row of inputs in the
+// corresponding row of outputs. The rows are split in blocks among GOMAXPROCS
+// goroutines, each with its own Predictor. Networks whose activations are
+// not registered with RegisterActivation are predicted by a single goroutine.
+// The predictors are kept by the network for later calls until Import,
+// SetNormalization or SetDropout change its layers. PredictBatch always runs in
+// ModeInference and must not be called concurrently with other methods of the network.
+func (nn *NetworkOptimizedOf[T]) PredictBatch(outputs, inputs [][]T) {
+	numIn, numOut := nn.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	predictors := nn.batchPredictors(len(inputs))
+	if len(predictors) == 1 {
+		predictors[0].predictRows(outputs, inputs)
+		return
+	}
+	parallelBlocks(len(inputs), len(predictors), func(w, start, end int) {
+		predictors[w].predictRows(outputs[start:end], inputs[start:end])
+	})
+}
+
+// predictRows is PredictBatch on a single goroutine. The rows are gathered into
+// a matrix of inputs and outputs.
+func (p *PredictorOf[T]) predictRows(outputs, inputs [][]T) {
+	numIn, numOut := p.Dims()
+	numRows := len(inputs)
+	p.rows = resize(p.rows, numRows*(numIn+numOut))
+	x, y := p.rows[:numRows*numIn], p.rows[numRows*numIn:]
+	for s := range inputs {
+		copy(x[s*numIn:], inputs[s])
+	}
+	p.PredictMatrix(y, x)
+	for s := range outputs {
+		copy(outputs[s], y[s*numOut:])
+	}
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (nn *NetworkOptimizedOf[T]) PredictMatrix(outputs, inputs []T) {
+	numIn, numOut := nn.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	predictors := nn.batchPredictors(n)
+	if len(predictors) == 1 {
+		predictors[0].PredictMatrix(outputs, inputs)
+		return
+	}
+	parallelBlocks(n, len(predictors), func(w, start, end int) {
+		predictors[w].PredictMatrix(outputs[start*numOut:end*numOut], inputs[start*numIn:end*numIn])
+	})
+}
+
+// batchPredictors returns the predictors of the goroutines predicting n rows.
+// Predictors are created as needed and kept in nn.predictors for later calls.
+func (nn *NetworkOptimizedOf[T]) batchPredictors(n int) []*PredictorOf[T] {
+	numWorkers := numPredictWorkers(n)
+	if len(nn.predictors) == 1 && nn.predictors[0].shared {
+		return nn.predictors
+	}
+	for len(nn.predictors) < numWorkers {
+		p, err := nn.newPredictor(true)
+		if err != nil {
+			// Unregistered activations and preprocessors can't be cloned so a
+			// single predictor shares those of the network.
+			p, _ = nn.newPredictor(false)
+			nn.predictors = []*PredictorOf[T]{p}
+			return nn.predictors
+		}
+		nn.predictors = append(nn.predictors, p)
+	}
+	return nn.predictors[:numWorkers]
+}
+
+// Dims returns the input and output length of the predictor's network.
+func (p *PredictorOf[T]) Dims() (numIn, numOut int) {
+	numIn, _ = p.layers[0].Dims()
+	if p.pre != nil {
+		numIn, _ = p.pre.p.Dims()
+	}
+	_, numOut = p.layers[len(p.layers)-1].Dims()
+	return numIn, numOut
+}
+
+// StoreOutputs passes inputs through the network and returns the outputs.
+// The returned slice is owned by the predictor and overwritten by the next call.
+func (p *PredictorOf[T]) StoreOutputs(inputs []T) []T {
+	numIn, _ := p.Dims()
+	if len(inputs) != numIn {
+		panic("length of inputs mismatches first layer expected input length")
+	}
+	if p.pre != nil {
+		inputs = p.pre.forward(inputs)
+	}
+	for i := range p.layers {
+		_, inputs = p.layers[i].inference(inputs, p.bufs[i], p.acts[i])
+	}
+	return inputs
+}
+
+// PredictMatrix stores the outputs of the network for each row of the row-major matrix
+// inputs in the corresponding row of the row-major matrix outputs. The rows are
+// passed through the layers in blocks using the batched layer kernels.
+func (p *PredictorOf[T]) PredictMatrix(outputs, inputs []T) {
+	numIn, numOut := p.Dims()
+	n := checkMatrix(outputs, inputs, numIn, numOut)
+	for start := 0; start < n; start += predictBlockSize {
+		end := start + predictBlockSize
+		if end > n {
+			end = n
+		}
+		x := inputs[start*numIn : end*numIn]
+		if p.pre != nil {
+			x = p.pre.forward(x)
+		}
+		for i, layer := range p.layers {
+			_, numNodesOut := layer.Dims()
+			p.bufs[i] = resize(p.bufs[i], 3*(end-start)*numNodesOut)
+			x = layer.inferenceBatch(x, p.bufs[i], p.acts[i])
+		}
+		copy(outputs[start*numOut:end*numOut], x)
+	}
+}
+
+// Classify returns the index of the largest output and the outputs of the network.
+// The returned slice is owned by the predictor and overwritten by the next call.
+func (p *PredictorOf[T]) Classify(inputs []T) (prediction int, outputs []T) {
+	outputs = p.StoreOutputs(inputs)
+	return maxIdx(T(math.Inf(-1)), outputs), outputs
+}
+
+// cloneActivation returns a new activation function of the same registered
+// kind and configuration as act which does not share state with act.
+func cloneActivation[T constraints.Float](act ActivationFuncOf[T]) (ActivationFuncOf[T], error) {
+	setup, err := marshalActivation(act)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalActivation[T](*setup)
+}
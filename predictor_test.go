@@ -0,0 +1,112 @@
+package neurus_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func TestPredictor_concurrent(t *testing.T) {
+	const numGoroutines = 8
+	nn := neurus.NewNetworkOptimized([]int{2, 8, 8, 2},
+		func() neurus.ActivationFunc { return &neurus.Relu{Inflection: 0.01} },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	nn.SetNormalization(0, neurus.NewBatchNorm(8))
+	nn.SetNormalization(1, neurus.NewLayerNorm(8))
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	for i := 0; i < 50; i++ {
+		nn.Learn(m.Generate2DData(10), 0.05, 0, 0.9)
+	}
+	data := m.Generate2DData(200)
+	want := make([][]float64, len(data))
+	for i, dp := range data {
+		_, out := nn.Classify(dp.Input)
+		want[i] = append([]float64{}, out...)
+	}
+
+	pool := sync.Pool{New: func() any { return nn.NewPredictor() }}
+	var wg sync.WaitGroup
+	errs := make(chan error, numGoroutines)
+	for g := 0; g < numGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range data {
+				// Goroutines classify the data in different orders.
+				idx := (i + g*len(data)/numGoroutines) % len(data)
+				p := pool.Get().(*neurus.Predictor)
+				_, got := p.Classify(data[idx].Input)
+				for j := range got {
+					if got[j] != want[idx][j] {
+						errs <- fmt.Errorf("data %d: got %v, want %v", idx, got, want[idx])
+						pool.Put(p)
+						return
+					}
+				}
+				pool.Put(p)
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPredictor_unregisteredActivation(t *testing.T) {
+	nn := neurus.NewNetworkOptimized([]int{2, 2},
+		func() neurus.ActivationFunc { return new(unregisteredActivation) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unregistered activation")
+		}
+	}()
+	nn.NewPredictor()
+}
+
+type unregisteredActivation struct{ neurus.Sigmd }
+
+func ExamplePredictor_http() {
+	nn := neurus.NewNetworkOptimized([]int{2, 4, 2},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	pool := sync.Pool{New: func() any { return nn.NewPredictor() }}
+	// The handler classifies the comma separated point in the query
+	// and may be called concurrently.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input []float64
+		for _, field := range strings.Split(r.URL.Query().Get("point"), ",") {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			input = append(input, v)
+		}
+		if len(input) != 2 {
+			http.Error(w, "expected 2 coordinates", http.StatusBadRequest)
+			return
+		}
+		p := pool.Get().(*neurus.Predictor)
+		defer pool.Put(p)
+		class, _ := p.Classify(input)
+		fmt.Fprintln(w, class)
+	})
+	for _, query := range []string{"/?point=0.5,0.2", "/?point=0.5"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", query, nil))
+		fmt.Println(query, rec.Code)
+	}
+	// Output:
+	// /?point=0.5,0.2 200
+	// /?point=0.5 400
+}
@@ -0,0 +1,556 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+
+	"golang.org/x/exp/constraints"
+)
+
+// This file contains feature preprocessing. Preprocessors are layers whose
+// parameters are fitted to the training inputs instead of learned by gradient
+// descent. Placed first in a Sequential or Graph, or set as the preprocessor of a
+// NetworkOptimized, they are serialized with the model by Export so that inference
+// applies exactly the transform used in training.
+
+// Preprocessor is a Layer transforming input features with parameters estimated
+// by Fit. Fit must be called before the preprocessor's dimensions are known,
+// so before building a model containing it.
+type Preprocessor interface {
+	Layer
+	// Fit estimates the parameters of the transform from the input rows.
+	Fit(inputs [][]float64)
+}
+
+var (
+	_ Preprocessor = (*Standardizer)(nil)
+	_ Preprocessor = (*MinMaxScaler)(nil)
+	_ Preprocessor = (*PCAWhitening)(nil)
+	_ Preprocessor = (*OneHot)(nil)
+	_ Preprocessor = (*Pipeline)(nil)
+)
+
+// FitInputs fits p to the inputs of data.
+func FitInputs(p Preprocessor, data []DataPoint) {
+	rows := make([][]float64, len(data))
+	for i, dp := range data {
+		rows[i] = dp.Input
+	}
+	p.Fit(rows)
+}
+
+// TransformInputs returns a copy of data with inputs transformed by p. Expected
+// outputs are shared with data. It is used to preprocess the data of networks
+// which can't hold a Preprocessor such as NetworkLvl2, in which case p must be
+// serialized separately with MarshalLayer and applied to inputs at inference.
+func TransformInputs(p Preprocessor, data []DataPoint) Dataset {
+	if len(data) == 0 {
+		return nil
+	}
+	numIn, numOut := p.Dims()
+	y := p.Forward(batchInputs(nil, data, numIn), ModeInference)
+	inputs := append([]float64{}, y...)
+	transformed := make(Dataset, len(data))
+	for i, dp := range data {
+		transformed[i] = DataPoint{Input: inputs[i*numOut : (i+1)*numOut], ExpectedOutput: dp.ExpectedOutput}
+	}
+	return transformed
+}
+
+// preprocessorOf applies a Preprocessor, which computes with float64, to the
+// inputs of a network computing with T.
+type preprocessorOf[T constraints.Float] struct {
+	p Preprocessor
+	// in and out hold the inputs and outputs of p converted from and to T.
+	// Float64 networks don't need them.
+	in  []float64
+	out []T
+}
+
+// forward returns the rows of inputs transformed by the preprocessor in ModeInference.
+func (pre *preprocessorOf[T]) forward(inputs []T) []T {
+	x, ok := any(inputs).([]float64)
+	if !ok {
+		pre.in = resize(pre.in, len(inputs))
+		for i, v := range inputs {
+			pre.in[i] = float64(v)
+		}
+		x = pre.in
+	}
+	y := pre.p.Forward(x, ModeInference)
+	if outputs, ok := any(y).([]T); ok {
+		return outputs
+	}
+	pre.out = resize(pre.out, len(y))
+	for i, v := range y {
+		pre.out[i] = T(v)
+	}
+	return pre.out
+}
+
+// clone returns a preprocessorOf with a copy of the preprocessor, which does
+// not share buffers with it. The preprocessor must be registered with RegisterLayer.
+func (pre *preprocessorOf[T]) clone() (*preprocessorOf[T], error) {
+	spec, err := MarshalLayer(pre.p)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := UnmarshalLayer(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &preprocessorOf[T]{p: layer.(Preprocessor)}, nil
+}
+
+// checkFitRows panics if inputs are empty or of differing lengths and returns their length.
+func checkFitRows(inputs [][]float64) int {
+	if len(inputs) == 0 {
+		panic("no inputs to fit")
+	}
+	n := len(inputs[0])
+	for _, row := range inputs {
+		if len(row) != n {
+			panic("inputs of differing lengths")
+		}
+	}
+	return n
+}
+
+// columnMeans returns the mean of each column of inputs.
+func columnMeans(inputs [][]float64) []float64 {
+	mean := make([]float64, len(inputs[0]))
+	for _, row := range inputs {
+		for j, v := range row {
+			mean[j] += v
+		}
+	}
+	for j := range mean {
+		mean[j] /= float64(len(inputs))
+	}
+	return mean
+}
+
+// Standardizer shifts and scales each feature to zero mean and unit variance.
+type Standardizer struct {
+	Mean []float64 `json:"mean"`
+	// StdDev holds the standard deviation of each feature, or 1 for constant features.
+	StdDev []float64 `json:"stdDev"`
+	out    []float64
+	dx     []float64
+}
+
+// NewStandardizer returns a Standardizer to be fitted with Fit.
+func NewStandardizer() *Standardizer { return &Standardizer{} }
+
+func (s *Standardizer) Fit(inputs [][]float64) {
+	checkFitRows(inputs)
+	s.Mean = columnMeans(inputs)
+	s.StdDev = make([]float64, len(s.Mean))
+	for _, row := range inputs {
+		for j, v := range row {
+			d := v - s.Mean[j]
+			s.StdDev[j] += d * d
+		}
+	}
+	for j, sum := range s.StdDev {
+		s.StdDev[j] = math.Sqrt(sum / float64(len(inputs)))
+		if s.StdDev[j] == 0 {
+			s.StdDev[j] = 1
+		}
+	}
+}
+
+func (s *Standardizer) Dims() (numIn, numOut int) { return len(s.Mean), len(s.Mean) }
+
+func (s *Standardizer) Params() []Param { return nil }
+
+func (s *Standardizer) Forward(x []float64, mode Mode) []float64 {
+	n := len(s.Mean)
+	batchSize(x, n)
+	s.out = resize(s.out, len(x))
+	for i, v := range x {
+		s.out[i] = (v - s.Mean[i%n]) / s.StdDev[i%n]
+	}
+	return s.out
+}
+
+func (s *Standardizer) Backward(dy []float64) []float64 {
+	n := len(s.Mean)
+	s.dx = resize(s.dx, len(dy))
+	for i, v := range dy {
+		s.dx[i] = v / s.StdDev[i%n]
+	}
+	return s.dx
+}
+
+// MinMaxScaler scales each feature linearly from its range in the fitted
+// inputs to [0, 1]. Constant features are mapped to 0.
+type MinMaxScaler struct {
+	Min []float64 `json:"min"`
+	Max []float64 `json:"max"`
+	out []float64
+	dx  []float64
+}
+
+// NewMinMaxScaler returns a MinMaxScaler to be fitted with Fit.
+func NewMinMaxScaler() *MinMaxScaler { return &MinMaxScaler{} }
+
+func (s *MinMaxScaler) Fit(inputs [][]float64) {
+	checkFitRows(inputs)
+	s.Min = append([]float64{}, inputs[0]...)
+	s.Max = append([]float64{}, inputs[0]...)
+	for _, row := range inputs {
+		for j, v := range row {
+			s.Min[j] = math.Min(s.Min[j], v)
+			s.Max[j] = math.Max(s.Max[j], v)
+		}
+	}
+}
+
+// scale returns the width of the range of feature j.
+func (s *MinMaxScaler) scale(j int) float64 {
+	if width := s.Max[j] - s.Min[j]; width > 0 {
+		return width
+	}
+	return 1
+}
+
+func (s *MinMaxScaler) Dims() (numIn, numOut int) { return len(s.Min), len(s.Min) }
+
+func (s *MinMaxScaler) Params() []Param { return nil }
+
+func (s *MinMaxScaler) Forward(x []float64, mode Mode) []float64 {
+	n := len(s.Min)
+	batchSize(x, n)
+	s.out = resize(s.out, len(x))
+	for i, v := range x {
+		s.out[i] = (v - s.Min[i%n]) / s.scale(i%n)
+	}
+	return s.out
+}
+
+func (s *MinMaxScaler) Backward(dy []float64) []float64 {
+	n := len(s.Min)
+	s.dx = resize(s.dx, len(dy))
+	for i, v := range dy {
+		s.dx[i] = v / s.scale(i%n)
+	}
+	return s.dx
+}
+
+// PCAWhitening projects the centered features onto their principal components
+// and scales each component to unit variance, so that the outputs are
+// uncorrelated. Keeping fewer components than features reduces the dimension
+// of the inputs to the directions of highest variance.
+type PCAWhitening struct {
+	// NumComponents is the number of components kept, or zero to keep all.
+	NumComponents int `json:"numComponents"`
+	// Epsilon is added to the variance of each component before scaling,
+	// which limits the amplification of components of tiny variance.
+	Epsilon float64   `json:"epsilon"`
+	Mean    []float64 `json:"mean"`
+	// Components holds the scaled principal components in order of decreasing
+	// variance as the rows of a row-major matrix with a column per feature.
+	Components []float64 `json:"components"`
+	out        []float64
+	dx         []float64
+}
+
+// NewPCAWhitening returns a PCAWhitening keeping numComponents components,
+// or all if numComponents is zero, to be fitted with Fit.
+func NewPCAWhitening(numComponents int, epsilon float64) *PCAWhitening {
+	if numComponents < 0 || epsilon < 0 {
+		panic("invalid PCA whitening parameters")
+	}
+	return &PCAWhitening{NumComponents: numComponents, Epsilon: epsilon}
+}
+
+func (p *PCAWhitening) Fit(inputs [][]float64) {
+	n := checkFitRows(inputs)
+	if p.NumComponents > n {
+		panic("more components than features")
+	}
+	p.Mean = columnMeans(inputs)
+	cov := make([]float64, n*n)
+	for _, row := range inputs {
+		for i := 0; i < n; i++ {
+			di := row[i] - p.Mean[i]
+			for j := i; j < n; j++ {
+				cov[i*n+j] += di * (row[j] - p.Mean[j])
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			cov[i*n+j] /= float64(len(inputs))
+			cov[j*n+i] = cov[i*n+j]
+		}
+	}
+	vals := make([]float64, n)
+	vecs := make([]float64, n*n)
+	symmetricEigen(cov, vals, vecs)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return vals[order[a]] > vals[order[b]] })
+	numComponents := p.NumComponents
+	if numComponents == 0 {
+		numComponents = n
+	}
+	p.Components = make([]float64, numComponents*n)
+	for c, k := range order[:numComponents] {
+		// Rounding may leave the variance of degenerate components slightly negative.
+		scale := 1 / math.Sqrt(math.Max(vals[k], 0)+p.Epsilon)
+		for j := 0; j < n; j++ {
+			p.Components[c*n+j] = vecs[j*n+k] * scale
+		}
+	}
+}
+
+func (p *PCAWhitening) Dims() (numIn, numOut int) {
+	if len(p.Mean) == 0 {
+		return 0, 0
+	}
+	return len(p.Mean), len(p.Components) / len(p.Mean)
+}
+
+func (p *PCAWhitening) Params() []Param { return nil }
+
+func (p *PCAWhitening) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := p.Dims()
+	numRows := batchSize(x, numIn)
+	p.out = resize(p.out, numRows*numOut)
+	for s := 0; s < numRows; s++ {
+		row := x[s*numIn : (s+1)*numIn]
+		for c := 0; c < numOut; c++ {
+			component := p.Components[c*numIn : (c+1)*numIn]
+			var sum float64
+			for j, v := range row {
+				sum += component[j] * (v - p.Mean[j])
+			}
+			p.out[s*numOut+c] = sum
+		}
+	}
+	return p.out
+}
+
+func (p *PCAWhitening) Backward(dy []float64) []float64 {
+	numIn, numOut := p.Dims()
+	numRows := batchSize(dy, numOut)
+	p.dx = resize(p.dx, numRows*numIn)
+	fillZeros(p.dx)
+	for s := 0; s < numRows; s++ {
+		dx := p.dx[s*numIn : (s+1)*numIn]
+		for c, g := range dy[s*numOut : (s+1)*numOut] {
+			for j, w := range p.Components[c*numIn : (c+1)*numIn] {
+				dx[j] += w * g
+			}
+		}
+	}
+	return p.dx
+}
+
+// OneHot replaces each categorical input column by an indicator feature per
+// category seen by Fit, in place. Other columns are passed through unchanged.
+// Categories not seen by Fit are encoded as all indicators being zero.
+//
+//	input:  [x0, color, x2]       with color in {3, 7}
+//	output: [x0, color==3, color==7, x2]
+type OneHot struct {
+	// NumIn is the length of the input rows.
+	NumIn int `json:"numIn"`
+	// Columns holds the indices of the categorical columns in increasing order.
+	Columns []int `json:"columns"`
+	// Categories holds the sorted category values of each categorical column.
+	Categories [][]float64 `json:"categories"`
+	out        []float64
+	dx         []float64
+}
+
+// NewOneHot returns a OneHot encoder of the categorical columns to be fitted with Fit.
+func NewOneHot(columns ...int) *OneHot {
+	columns = append([]int{}, columns...)
+	sort.Ints(columns)
+	for i, col := range columns {
+		if col < 0 || i > 0 && columns[i-1] == col {
+			panic("invalid or repeated one-hot column")
+		}
+	}
+	return &OneHot{Columns: columns}
+}
+
+func (o *OneHot) Fit(inputs [][]float64) {
+	o.NumIn = checkFitRows(inputs)
+	if len(o.Columns) > 0 && o.Columns[len(o.Columns)-1] >= o.NumIn {
+		panic("one-hot column out of range")
+	}
+	o.Categories = make([][]float64, len(o.Columns))
+	for c, col := range o.Columns {
+		seen := make(map[float64]bool)
+		for _, row := range inputs {
+			if !seen[row[col]] {
+				seen[row[col]] = true
+				o.Categories[c] = append(o.Categories[c], row[col])
+			}
+		}
+		sort.Float64s(o.Categories[c])
+	}
+}
+
+func (o *OneHot) Dims() (numIn, numOut int) {
+	numOut = o.NumIn - len(o.Columns)
+	for _, categories := range o.Categories {
+		numOut += len(categories)
+	}
+	return o.NumIn, numOut
+}
+
+func (o *OneHot) Params() []Param { return nil }
+
+func (o *OneHot) Forward(x []float64, mode Mode) []float64 {
+	numIn, numOut := o.Dims()
+	numRows := batchSize(x, numIn)
+	o.out = resize(o.out, numRows*numOut)
+	fillZeros(o.out)
+	for s := 0; s < numRows; s++ {
+		out := o.out[s*numOut : (s+1)*numOut]
+		c := 0
+		for j, v := range x[s*numIn : (s+1)*numIn] {
+			if c < len(o.Columns) && o.Columns[c] == j {
+				categories := o.Categories[c]
+				if k := sort.SearchFloat64s(categories, v); k < len(categories) && categories[k] == v {
+					out[k] = 1
+				}
+				out = out[len(categories):]
+				c++
+				continue
+			}
+			out[0] = v
+			out = out[1:]
+		}
+	}
+	return o.out
+}
+
+func (o *OneHot) Backward(dy []float64) []float64 {
+	numIn, numOut := o.Dims()
+	numRows := batchSize(dy, numOut)
+	o.dx = resize(o.dx, numRows*numIn)
+	for s := 0; s < numRows; s++ {
+		dyRow := dy[s*numOut : (s+1)*numOut]
+		c := 0
+		for j := 0; j < numIn; j++ {
+			if c < len(o.Columns) && o.Columns[c] == j {
+				// Categories are not differentiable.
+				o.dx[s*numIn+j] = 0
+				dyRow = dyRow[len(o.Categories[c]):]
+				c++
+				continue
+			}
+			o.dx[s*numIn+j] = dyRow[0]
+			dyRow = dyRow[1:]
+		}
+	}
+	return o.dx
+}
+
+// Pipeline chains preprocessors, each transforming the outputs of the previous one.
+type Pipeline struct {
+	stages []Preprocessor
+}
+
+// NewPipeline returns a Pipeline of the stages in order.
+func NewPipeline(stages ...Preprocessor) *Pipeline {
+	if len(stages) == 0 {
+		panic("no pipeline stages")
+	}
+	return &Pipeline{stages: stages}
+}
+
+// Stages returns the preprocessors of the pipeline.
+func (p *Pipeline) Stages() []Preprocessor { return p.stages }
+
+// Fit fits each stage to the inputs transformed by the stages before it.
+func (p *Pipeline) Fit(inputs [][]float64) {
+	numIn := checkFitRows(inputs)
+	var rows [][]float64
+	for i, stage := range p.stages {
+		stage.Fit(inputs)
+		if i == len(p.stages)-1 {
+			break
+		}
+		x := make([]float64, 0, len(inputs)*numIn)
+		for _, row := range inputs {
+			x = append(x, row...)
+		}
+		y := stage.Forward(x, ModeInference)
+		numIn = len(y) / len(inputs)
+		rows = rowsOf(rows, y, numIn)
+		inputs = rows
+	}
+}
+
+func (p *Pipeline) Dims() (numIn, numOut int) {
+	numIn, _ = p.stages[0].Dims()
+	_, numOut = p.stages[len(p.stages)-1].Dims()
+	return numIn, numOut
+}
+
+func (p *Pipeline) Params() []Param { return nil }
+
+func (p *Pipeline) Forward(x []float64, mode Mode) []float64 {
+	for _, stage := range p.stages {
+		x = stage.Forward(x, mode)
+	}
+	return x
+}
+
+func (p *Pipeline) Backward(dy []float64) []float64 {
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		dy = p.stages[i].Backward(dy)
+	}
+	return dy
+}
+
+// MarshalJSON encodes the stages as LayerSpecs. All stage types must be
+// registered with RegisterLayer.
+func (p *Pipeline) MarshalJSON() ([]byte, error) {
+	specs := make([]LayerSpec, len(p.stages))
+	for i, stage := range p.stages {
+		spec, err := MarshalLayer(stage)
+		if err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+	return json.Marshal(specs)
+}
+
+// UnmarshalJSON decodes a pipeline encoded with MarshalJSON.
+func (p *Pipeline) UnmarshalJSON(b []byte) error {
+	var specs []LayerSpec
+	err := json.Unmarshal(b, &specs)
+	if err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		return errors.New("no pipeline stages")
+	}
+	stages := make([]Preprocessor, len(specs))
+	for i, spec := range specs {
+		layer, err := UnmarshalLayer(spec)
+		if err != nil {
+			return err
+		}
+		stage, ok := layer.(Preprocessor)
+		if !ok {
+			return errors.New("pipeline stage is not a preprocessor: " + spec.Kind)
+		}
+		stages[i] = stage
+	}
+	p.stages = stages
+	return nil
+}
@@ -0,0 +1,268 @@
+package neurus_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+// tabularRows returns rows of correlated features of different scales followed
+// by a categorical column holding one of the values 10, 20 or 30.
+func tabularRows(rng *rand.Rand, n int) [][]float64 {
+	rows := make([][]float64, n)
+	for i := range rows {
+		a, b := rng.NormFloat64(), rng.NormFloat64()
+		rows[i] = []float64{1000 + 300*a, 0.01 * (a + 0.5*b), 5, float64(10 * (1 + rng.Intn(3)))}
+	}
+	return rows
+}
+
+// transformRows passes rows through p and returns the transformed rows.
+func transformRows(p neurus.Preprocessor, rows [][]float64) [][]float64 {
+	var data []neurus.DataPoint
+	for _, row := range rows {
+		data = append(data, neurus.DataPoint{Input: row})
+	}
+	var transformed [][]float64
+	for _, dp := range neurus.TransformInputs(p, data) {
+		transformed = append(transformed, dp.Input)
+	}
+	return transformed
+}
+
+// columnStats returns the mean, variance, minimum and maximum of column j.
+func columnStats(rows [][]float64, j int) (mean, variance, min, max float64) {
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, row := range rows {
+		mean += row[j]
+		min = math.Min(min, row[j])
+		max = math.Max(max, row[j])
+	}
+	mean /= float64(len(rows))
+	for _, row := range rows {
+		variance += (row[j] - mean) * (row[j] - mean)
+	}
+	return mean, variance / float64(len(rows)), min, max
+}
+
+func TestStandardizer(t *testing.T) {
+	rows := tabularRows(rand.New(rand.NewSource(1)), 500)
+	s := neurus.NewStandardizer()
+	s.Fit(rows)
+	transformed := transformRows(s, rows)
+	for j := 0; j < 4; j++ {
+		mean, variance, _, _ := columnStats(transformed, j)
+		wantVariance := 1.0
+		if j == 2 {
+			wantVariance = 0 // Constant column.
+		}
+		if math.Abs(mean) > 1e-9 || math.Abs(variance-wantVariance) > 1e-9 {
+			t.Errorf("column %d: got mean %g and variance %g", j, mean, variance)
+		}
+	}
+}
+
+func TestMinMaxScaler(t *testing.T) {
+	rows := tabularRows(rand.New(rand.NewSource(1)), 500)
+	s := neurus.NewMinMaxScaler()
+	s.Fit(rows)
+	transformed := transformRows(s, rows)
+	for j := 0; j < 4; j++ {
+		_, _, min, max := columnStats(transformed, j)
+		wantMax := 1.0
+		if j == 2 {
+			wantMax = 0 // Constant column.
+		}
+		if min != 0 || math.Abs(max-wantMax) > 1e-12 {
+			t.Errorf("column %d: got range [%g, %g]", j, min, max)
+		}
+	}
+}
+
+func TestPCAWhitening(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rows := make([][]float64, 1000)
+	for i := range rows {
+		a, b, c := rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()
+		rows[i] = []float64{3*a + 1, a + 0.5*b - 2, 0.1*c + b}
+	}
+	pca := neurus.NewPCAWhitening(0, 0)
+	pca.Fit(rows)
+	transformed := transformRows(pca, rows)
+	// The covariance of the whitened features is the identity.
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var cov float64
+			for _, row := range transformed {
+				cov += row[i] * row[j]
+			}
+			cov /= float64(len(rows))
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(cov-want) > 1e-9 {
+				t.Errorf("covariance of components %d and %d: got %g, want %g", i, j, cov, want)
+			}
+		}
+	}
+
+	reduced := neurus.NewPCAWhitening(2, 1e-5)
+	reduced.Fit(rows)
+	if numIn, numOut := reduced.Dims(); numIn != 3 || numOut != 2 {
+		t.Errorf("got dims %d, %d, want 3, 2", numIn, numOut)
+	}
+	// The kept components are those of highest variance, scaled slightly less
+	// because of epsilon.
+	for c := 0; c < 2; c++ {
+		for j := 0; j < 3; j++ {
+			if got, want := reduced.Components[c*3+j], pca.Components[c*3+j]; math.Abs(math.Abs(got)-math.Abs(want)) > 1e-4 {
+				t.Errorf("component %d feature %d: got %g, want %g", c, j, got, want)
+			}
+		}
+	}
+}
+
+func TestOneHot(t *testing.T) {
+	rows := [][]float64{
+		{1.5, 7, 0, 2},
+		{2.5, 3, 1, 2},
+		{3.5, 7, 0, 4},
+	}
+	o := neurus.NewOneHot(3, 1)
+	o.Fit(rows)
+	if numIn, numOut := o.Dims(); numIn != 4 || numOut != 6 {
+		t.Fatalf("got dims %d, %d, want 4, 6", numIn, numOut)
+	}
+	got := transformRows(o, append(rows, []float64{4.5, 5, 1, 4}))
+	want := [][]float64{
+		{1.5, 0, 1, 0, 1, 0},
+		{2.5, 1, 0, 1, 1, 0},
+		{3.5, 0, 1, 0, 0, 1},
+		{4.5, 0, 0, 1, 0, 1}, // Category 5 was not seen by Fit.
+	}
+	for i := range want {
+		if !equalFloats(got[i], want[i]) {
+			t.Errorf("row %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	dx := o.Backward([]float64{1, 2, 3, 4, 5, 6})
+	if !equalFloats(dx, []float64{1, 0, 4, 0}) {
+		t.Errorf("got input gradient %v", dx)
+	}
+}
+
+// TestPipeline_sequential trains a model whose preprocessing pipeline is its
+// first layer, then checks the imported model transforms raw inputs identically.
+func TestPipeline_sequential(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rows := tabularRows(rng, 200)
+	data := make(neurus.Dataset, len(rows))
+	for i, row := range rows {
+		// The class depends on the numeric features and the category.
+		class := 0
+		if row[0]+1e5*row[1] > 1000+30*(row[3]-20) {
+			class = 1
+		}
+		expected := make([]float64, 2)
+		expected[class] = 1
+		data[i] = neurus.DataPoint{Input: row, ExpectedOutput: expected}
+	}
+	pipeline := neurus.NewPipeline(neurus.NewOneHot(3), neurus.NewStandardizer(), neurus.NewPCAWhitening(0, 1e-6))
+	neurus.FitInputs(pipeline, data)
+	if numIn, numOut := pipeline.Dims(); numIn != 4 || numOut != 6 {
+		t.Fatalf("got pipeline dims %d, %d, want 4, 6", numIn, numOut)
+	}
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		pipeline,
+		neurus.NewLayerOptimized(6, 8, new(neurus.Tanh), rng),
+		neurus.NewLayerOptimized(8, 2, new(neurus.Sigmd), rng),
+	)
+	initialCost := seq.UpdateGradients(data) / float64(len(data))
+	batches := data.Batches(20, rand.NewSource(1))
+	for epoch := 0; epoch < 50; epoch++ {
+		for batches.Next() {
+			seq.Learn(batches.Batch(), 0.5, 0, 0.9)
+		}
+	}
+	finalCost := seq.UpdateGradients(data) / float64(len(data))
+	t.Logf("cost %g → %g", initialCost, finalCost)
+	if finalCost > initialCost/4 {
+		t.Errorf("cost %g not much lower than initial cost %g", finalCost, initialCost)
+	}
+
+	inputs := make([][]float64, len(data))
+	for i, dp := range data {
+		inputs[i] = dp.Input
+	}
+	roundTrip(t, seq, inputs...)
+}
+
+// TestPipeline_networkOptimized exports a pipeline as the preprocessor of a
+// NetworkOptimized trained on its outputs and checks every way of predicting
+// from raw inputs applies it.
+func TestPipeline_networkOptimized(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rows := tabularRows(rng, 50)
+	pipeline := neurus.NewPipeline(neurus.NewOneHot(3), neurus.NewStandardizer())
+	pipeline.Fit(rows)
+	_, numFeatures := pipeline.Dims()
+	nn := neurus.NewNetworkOptimized([]int{numFeatures, 4, 2},
+		func() neurus.ActivationFunc { return new(neurus.Sigmd) },
+		&neurus.MeanSquaredError{}, rand.NewSource(1))
+	want := make([][]float64, len(rows))
+	for i, row := range transformRows(pipeline, rows) {
+		want[i] = append([]float64{}, nn.StoreOutputs(row)...)
+	}
+	nn.SetPreprocessor(pipeline)
+	if numIn, _ := nn.Dims(); numIn != len(rows[0]) {
+		t.Fatalf("got %d inputs, want %d raw features", numIn, len(rows[0]))
+	}
+	b, err := json.Marshal(nn.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var setup []neurus.LayerSetup
+	if err := json.Unmarshal(b, &setup); err != nil {
+		t.Fatal(err)
+	}
+	var imported neurus.NetworkOptimized
+	imported.Import(setup, nil)
+	if _, ok := imported.Preprocessor().(*neurus.Pipeline); !ok {
+		t.Fatalf("imported preprocessor %T", imported.Preprocessor())
+	}
+	p := imported.NewPredictor()
+	outputs := make([][]float64, len(rows))
+	for i := range outputs {
+		outputs[i] = make([]float64, 2)
+	}
+	imported.PredictBatch(outputs, rows)
+	for i, row := range rows {
+		if got := imported.StoreOutputs(row); !equalFloats(got, want[i]) {
+			t.Fatalf("row %d: StoreOutputs got %v, want %v", i, got, want[i])
+		}
+		if got := p.StoreOutputs(row); !equalFloats(got, want[i]) {
+			t.Fatalf("row %d: Predictor got %v, want %v", i, got, want[i])
+		}
+		if !equalFloats(outputs[i], want[i]) {
+			t.Fatalf("row %d: PredictBatch got %v, want %v", i, outputs[i], want[i])
+		}
+	}
+	// Float32 networks convert inputs to and from the preprocessor's float64.
+	var nn32 neurus.NetworkOptimizedOf[float32]
+	nn32.Import(setup, nil)
+	for i, row := range rows {
+		row32 := make([]float32, len(row))
+		for j, v := range row {
+			row32[j] = float32(v)
+		}
+		for j, got := range nn32.StoreOutputs(row32) {
+			if math.Abs(float64(got)-want[i][j]) > 1e-5 {
+				t.Fatalf("row %d: float32 output %d is %g, want %g", i, j, got, want[i][j])
+			}
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package neurus
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// Recurrent is a Layer which carries a hidden state between time steps.
+// The rows of the matrix passed to Forward are interpreted as consecutive
+// time steps of a single sequence which continue from the state left by
+// the previous call to Forward. Backward propagates the gradients through
+// the time steps of the last call to Forward only, so feeding a long sequence
+// in chunks of k rows results in backpropagation through time truncated to k steps.
+type Recurrent interface {
+	Layer
+	// ResetState zeroes the hidden state so that the next call
+	// to Forward starts a new sequence.
+	ResetState()
+}
+
+var (
+	_ Recurrent = (*RNN)(nil)
+	_ Recurrent = (*LSTM)(nil)
+	_ Recurrent = (*GRU)(nil)
+)
+
+// recurrentCell holds the parameters and state shared by the recurrent layers.
+// A cell with g gates computes the weighted inputs of all gates at once:
+//
+//	W*x + b    (g*size values)
+//	U*h        (g*size values)
+//
+// where h is the hidden state of the previous time step.
+type recurrentCell struct {
+	numIn int
+	size  int
+	gates int
+	act   ActivationFunc
+	w     []float64 // Input weights indexed by [gate*size+node][input].
+	u     []float64 // State weights indexed by [gate*size+node][state].
+	b     []float64
+	gradW []float64
+	gradU []float64
+	gradB []float64
+	state []float64 // Hidden state after the last time step.
+
+	// Buffers of the last forward pass.
+	x  []float64
+	hs []float64 // Hidden states of each time step preceded by the initial state.
+	// Buffers of the last backward pass.
+	dx     []float64
+	dh     []float64
+	dhPrev []float64
+	daW    []float64
+	daU    []float64
+}
+
+func newRecurrentCell(numIn, size, gates int, act ActivationFunc, rng *rand.Rand) recurrentCell {
+	if numIn <= 0 || size <= 0 {
+		panic("invalid recurrent layer dimensions")
+	}
+	invSqrtSize := 1 / math.Sqrt(float64(size))
+	gs := gates * size
+	return recurrentCell{
+		numIn:  numIn,
+		size:   size,
+		gates:  gates,
+		act:    act,
+		w:      randomSlice[float64](gs*numIn, 2*invSqrtSize, -invSqrtSize, rng),
+		u:      randomSlice[float64](gs*size, 2*invSqrtSize, -invSqrtSize, rng),
+		b:      make([]float64, gs),
+		gradW:  make([]float64, gs*numIn),
+		gradU:  make([]float64, gs*size),
+		gradB:  make([]float64, gs),
+		state:  make([]float64, size),
+		dh:     make([]float64, size),
+		dhPrev: make([]float64, size),
+		daW:    make([]float64, gs),
+		daU:    make([]float64, gs),
+	}
+}
+
+func (cell *recurrentCell) Dims() (numIn, numOut int) { return cell.numIn, cell.size }
+
+func (cell *recurrentCell) Params() []Param {
+	return []Param{
+		{Value: cell.w, Grad: cell.gradW, Decay: true},
+		{Value: cell.u, Grad: cell.gradU, Decay: true},
+		{Value: cell.b, Grad: cell.gradB},
+	}
+}
+
+// startForward stores the inputs of a forward pass and prepares the hidden
+// state buffer. It returns the number of time steps in x.
+func (cell *recurrentCell) startForward(x []float64) (steps int) {
+	steps = batchSize(x, cell.numIn)
+	cell.x = append(cell.x[:0], x...)
+	cell.hs = resize(cell.hs, (steps+1)*cell.size)
+	copy(cell.hs, cell.state)
+	return steps
+}
+
+// endForward stores the last hidden state and returns the hidden state of every time step.
+func (cell *recurrentCell) endForward() []float64 {
+	copy(cell.state, cell.hs[len(cell.hs)-cell.size:])
+	return cell.hs[cell.size:]
+}
+
+// startBackward checks the output gradient length and zeroes the gradient buffers.
+// It returns the number of time steps of the last forward pass.
+func (cell *recurrentCell) startBackward(dy []float64) (steps int) {
+	steps = len(cell.hs)/cell.size - 1
+	if len(dy) != steps*cell.size {
+		panic("output gradient length mismatches last forward batch")
+	}
+	cell.dx = resize(cell.dx, steps*cell.numIn)
+	for i := range cell.dx {
+		cell.dx[i] = 0
+	}
+	for i := range cell.dhPrev {
+		cell.dhPrev[i] = 0
+	}
+	return steps
+}
+
+// step returns the input, previous hidden state and hidden state of time step t.
+func (cell *recurrentCell) step(t int) (x, hPrev, h []float64) {
+	n := cell.size
+	return cell.x[t*cell.numIn : (t+1)*cell.numIn], cell.hs[t*n : (t+1)*n], cell.hs[(t+1)*n : (t+2)*n]
+}
+
+// weighInput stores W*x + b in dst.
+func (cell *recurrentCell) weighInput(dst, x []float64) {
+	for j := range dst {
+		sum := cell.b[j]
+		weights := cell.w[j*cell.numIn : (j+1)*cell.numIn]
+		for i, v := range x {
+			sum += weights[i] * v
+		}
+		dst[j] = sum
+	}
+}
+
+// weighState stores U*h in dst.
+func (cell *recurrentCell) weighState(dst, h []float64) {
+	for j := range dst {
+		var sum float64
+		weights := cell.u[j*cell.size : (j+1)*cell.size]
+		for i, v := range h {
+			sum += weights[i] * v
+		}
+		dst[j] = sum
+	}
+}
+
+// backwardStep accumulates the parameter gradients of a time step given the partial
+// derivatives of the cost with respect to W*x + b (cell.daW) and U*h (cell.daU).
+// The input gradients are added to dx and the previous hidden state gradients to cell.dhPrev.
+func (cell *recurrentCell) backwardStep(x, hPrev, dx []float64) {
+	for j, da := range cell.daW {
+		if da == 0 {
+			continue
+		}
+		cell.gradB[j] += da
+		weights := cell.w[j*cell.numIn : (j+1)*cell.numIn]
+		grads := cell.gradW[j*cell.numIn : (j+1)*cell.numIn]
+		for i, v := range x {
+			grads[i] += da * v
+			dx[i] += da * weights[i]
+		}
+	}
+	for j, da := range cell.daU {
+		if da == 0 {
+			continue
+		}
+		weights := cell.u[j*cell.size : (j+1)*cell.size]
+		grads := cell.gradU[j*cell.size : (j+1)*cell.size]
+		for i, v := range hPrev {
+			grads[i] += da * v
+			cell.dhPrev[i] += da * weights[i]
+		}
+	}
+}
+
+// nextHiddenGradient sets cell.dh to the partial derivatives of the cost with respect to
+// the hidden state of time step t, which is the sum of the output gradient and the
+// gradient flowing back from time step t+1.
+func (cell *recurrentCell) nextHiddenGradient(dy []float64, t int) {
+	n := cell.size
+	for i := range cell.dh {
+		cell.dh[i] = dy[t*n+i] + cell.dhPrev[i]
+		cell.dhPrev[i] = 0
+	}
+}
+
+// activate applies the cell's activation function to z and stores
+// the activations in out and the derivatives in derivatives.
+func (cell *recurrentCell) activate(out, derivatives, z []float64) {
+	cell.act.CalculateFromInputs(z, 1)
+	for i := range z {
+		out[i] = cell.act.Activate(i)
+		derivatives[i] = cell.act.Derivative(i)
+	}
+}
+
+type recurrentSetup struct {
+	NumIn        int              `json:"numIn"`
+	Size         int              `json:"size"`
+	InputWeights []float64        `json:"inputWeights"`
+	StateWeights []float64        `json:"stateWeights"`
+	Biases       []float64        `json:"biases"`
+	Activation   *ActivationSetup `json:"activation"`
+}
+
+func (cell *recurrentCell) marshalJSON() ([]byte, error) {
+	act, err := marshalActivation(cell.act)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(recurrentSetup{
+		NumIn:        cell.numIn,
+		Size:         cell.size,
+		InputWeights: cell.w,
+		StateWeights: cell.u,
+		Biases:       cell.b,
+		Activation:   act,
+	})
+}
+
+// unmarshalRecurrent decodes a layer encoded with marshalJSON using newLayer
+// to create the layer and returning its cell for the parameters to be copied into.
+func unmarshalRecurrent(b []byte, newLayer func(numIn, size int, act ActivationFunc) *recurrentCell) error {
+	var setup recurrentSetup
+	err := json.Unmarshal(b, &setup)
+	if err != nil {
+		return err
+	}
+	if setup.Activation == nil {
+		return errors.New("missing recurrent layer activation")
+	}
+	if setup.NumIn <= 0 || setup.Size <= 0 {
+		return errors.New("recurrent layer dimensions must be positive")
+	}
+	act, err := unmarshalActivation[float64](*setup.Activation)
+	if err != nil {
+		return err
+	}
+	cell := newLayer(setup.NumIn, setup.Size, act)
+	if len(setup.InputWeights) != len(cell.w) || len(setup.StateWeights) != len(cell.u) || len(setup.Biases) != len(cell.b) {
+		return errors.New("recurrent layer parameter length mismatch")
+	}
+	copy(cell.w, setup.InputWeights)
+	copy(cell.u, setup.StateWeights)
+	copy(cell.b, setup.Biases)
+	return nil
+}
+
+// RNN is a vanilla recurrent layer. The hidden state at time step t is
+//
+//	h[t] = act(W*x[t] + U*h[t-1] + b)
+//
+// and is also the output of the layer.
+type RNN struct {
+	recurrentCell
+	derivatives []float64
+	z           []float64
+}
+
+// NewRNN returns a vanilla recurrent layer with size hidden nodes and randomized weights.
+func NewRNN(numIn, size int, act ActivationFunc, rng *rand.Rand) *RNN {
+	return &RNN{
+		recurrentCell: newRecurrentCell(numIn, size, 1, act, rng),
+		z:             make([]float64, size),
+	}
+}
+
+func (rnn *RNN) ResetState() {
+	for i := range rnn.state {
+		rnn.state[i] = 0
+	}
+}
+
+func (rnn *RNN) Forward(x []float64, mode Mode) []float64 {
+	steps := rnn.startForward(x)
+	rnn.derivatives = resize(rnn.derivatives, steps*rnn.size)
+	for t := 0; t < steps; t++ {
+		xt, hPrev, h := rnn.step(t)
+		rnn.weighInput(rnn.z, xt)
+		rnn.weighState(rnn.daU, hPrev) // daU used as scratch space.
+		for i := range rnn.z {
+			rnn.z[i] += rnn.daU[i]
+		}
+		rnn.activate(h, rnn.derivatives[t*rnn.size:(t+1)*rnn.size], rnn.z)
+	}
+	return rnn.endForward()
+}
+
+func (rnn *RNN) Backward(dy []float64) []float64 {
+	steps := rnn.startBackward(dy)
+	for t := steps - 1; t >= 0; t-- {
+		rnn.nextHiddenGradient(dy, t)
+		derivatives := rnn.derivatives[t*rnn.size : (t+1)*rnn.size]
+		for i, dh := range rnn.dh {
+			rnn.daW[i] = dh * derivatives[i]
+		}
+		copy(rnn.daU, rnn.daW)
+		xt, hPrev, _ := rnn.step(t)
+		rnn.backwardStep(xt, hPrev, rnn.dx[t*rnn.numIn:(t+1)*rnn.numIn])
+	}
+	return rnn.dx
+}
+
+// MarshalJSON encodes the layer's configuration and parameters. The activation
+// function must be registered with RegisterActivation.
+func (rnn *RNN) MarshalJSON() ([]byte, error) { return rnn.marshalJSON() }
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (rnn *RNN) UnmarshalJSON(b []byte) error {
+	return unmarshalRecurrent(b, func(numIn, size int, act ActivationFunc) *recurrentCell {
+		*rnn = *NewRNN(numIn, size, act, rand.New(rand.NewSource(1)))
+		return &rnn.recurrentCell
+	})
+}
+
+// LSTM is a long short-term memory recurrent layer. It keeps a cell state c
+// alongside the hidden state h which are updated at time step t by
+//
+//	i = σ(Wi*x[t] + Ui*h[t-1] + bi)  input gate
+//	f = σ(Wf*x[t] + Uf*h[t-1] + bf)  forget gate
+//	g = act(Wg*x[t] + Ug*h[t-1] + bg) candidate cell state
+//	o = σ(Wo*x[t] + Uo*h[t-1] + bo)  output gate
+//	c[t] = f*c[t-1] + i*g
+//	h[t] = o*act(c[t])
+//
+// where σ is the sigmoid function. act is usually the hyperbolic tangent (Tanh).
+type LSTM struct {
+	recurrentCell
+	cell []float64 // Cell state after the last time step.
+	cs   []float64 // Cell states of each time step preceded by the initial cell state.
+	// Gate activations indexed by [step][gate][node] with gates ordered i, f, g, o.
+	gateValues []float64
+	// Derivatives of the candidate activation.
+	gDerivatives []float64
+	// Activation of the cell state and its derivatives.
+	actC            []float64
+	actCDerivatives []float64
+	dc              []float64
+}
+
+// NewLSTM returns a LSTM layer with size hidden nodes and randomized weights.
+// The forget gate biases are initialized to 1 so that the cell state is remembered
+// by default.
+func NewLSTM(numIn, size int, act ActivationFunc, rng *rand.Rand) *LSTM {
+	lstm := &LSTM{
+		recurrentCell: newRecurrentCell(numIn, size, 4, act, rng),
+		cell:          make([]float64, size),
+		dc:            make([]float64, size),
+	}
+	for i := size; i < 2*size; i++ {
+		lstm.b[i] = 1
+	}
+	return lstm
+}
+
+func (lstm *LSTM) ResetState() {
+	for i := range lstm.state {
+		lstm.state[i] = 0
+		lstm.cell[i] = 0
+	}
+}
+
+func (lstm *LSTM) Forward(x []float64, mode Mode) []float64 {
+	n := lstm.size
+	steps := lstm.startForward(x)
+	lstm.cs = resize(lstm.cs, (steps+1)*n)
+	copy(lstm.cs, lstm.cell)
+	lstm.gateValues = resize(lstm.gateValues, steps*4*n)
+	lstm.gDerivatives = resize(lstm.gDerivatives, steps*n)
+	lstm.actC = resize(lstm.actC, steps*n)
+	lstm.actCDerivatives = resize(lstm.actCDerivatives, steps*n)
+	for t := 0; t < steps; t++ {
+		xt, hPrev, h := lstm.step(t)
+		cPrev, c := lstm.cs[t*n:(t+1)*n], lstm.cs[(t+1)*n:(t+2)*n]
+		z := lstm.gateValues[t*4*n : (t+1)*4*n]
+		lstm.weighInput(z, xt)
+		lstm.weighState(lstm.daU, hPrev) // daU used as scratch space.
+		for i := range z {
+			z[i] += lstm.daU[i]
+		}
+		gi, gf, gg, gout := z[:n], z[n:2*n], z[2*n:3*n], z[3*n:]
+		for i := 0; i < n; i++ {
+			gi[i] = Sigmoid(gi[i])
+			gf[i] = Sigmoid(gf[i])
+			gout[i] = Sigmoid(gout[i])
+		}
+		lstm.activate(gg, lstm.gDerivatives[t*n:(t+1)*n], gg)
+		for i := range c {
+			c[i] = gf[i]*cPrev[i] + gi[i]*gg[i]
+		}
+		actC := lstm.actC[t*n : (t+1)*n]
+		lstm.activate(actC, lstm.actCDerivatives[t*n:(t+1)*n], c)
+		for i := range h {
+			h[i] = gout[i] * actC[i]
+		}
+	}
+	copy(lstm.cell, lstm.cs[steps*n:])
+	return lstm.endForward()
+}
+
+func (lstm *LSTM) Backward(dy []float64) []float64 {
+	n := lstm.size
+	steps := lstm.startBackward(dy)
+	for i := range lstm.dc {
+		lstm.dc[i] = 0
+	}
+	for t := steps - 1; t >= 0; t-- {
+		lstm.nextHiddenGradient(dy, t)
+		z := lstm.gateValues[t*4*n : (t+1)*4*n]
+		gi, gf, gg, gout := z[:n], z[n:2*n], z[2*n:3*n], z[3*n:]
+		cPrev := lstm.cs[t*n : (t+1)*n]
+		actC := lstm.actC[t*n : (t+1)*n]
+		actCDerivatives := lstm.actCDerivatives[t*n : (t+1)*n]
+		gDerivatives := lstm.gDerivatives[t*n : (t+1)*n]
+		for i, dh := range lstm.dh {
+			dc := lstm.dc[i] + dh*gout[i]*actCDerivatives[i]
+			lstm.daW[i] = dc * gg[i] * gi[i] * (1 - gi[i])
+			lstm.daW[n+i] = dc * cPrev[i] * gf[i] * (1 - gf[i])
+			lstm.daW[2*n+i] = dc * gi[i] * gDerivatives[i]
+			lstm.daW[3*n+i] = dh * actC[i] * gout[i] * (1 - gout[i])
+			lstm.dc[i] = dc * gf[i] // Flows back to the previous time step.
+		}
+		copy(lstm.daU, lstm.daW)
+		xt, hPrev, _ := lstm.step(t)
+		lstm.backwardStep(xt, hPrev, lstm.dx[t*lstm.numIn:(t+1)*lstm.numIn])
+	}
+	return lstm.dx
+}
+
+// MarshalJSON encodes the layer's configuration and parameters. The activation
+// function must be registered with RegisterActivation.
+func (lstm *LSTM) MarshalJSON() ([]byte, error) { return lstm.marshalJSON() }
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (lstm *LSTM) UnmarshalJSON(b []byte) error {
+	return unmarshalRecurrent(b, func(numIn, size int, act ActivationFunc) *recurrentCell {
+		*lstm = *NewLSTM(numIn, size, act, rand.New(rand.NewSource(1)))
+		return &lstm.recurrentCell
+	})
+}
+
+// GRU is a gated recurrent unit layer. The hidden state at time step t is
+//
+//	z = σ(Wz*x[t] + Uz*h[t-1] + bz)     update gate
+//	r = σ(Wr*x[t] + Ur*h[t-1] + br)     reset gate
+//	n = act(Wn*x[t] + r*(Un*h[t-1]) + bn) candidate hidden state
+//	h[t] = (1-z)*n + z*h[t-1]
+//
+// where σ is the sigmoid function. act is usually the hyperbolic tangent (Tanh).
+type GRU struct {
+	recurrentCell
+	// Gate activations indexed by [step][gate][node] with gates ordered z, r, n.
+	gateValues []float64
+	// Derivatives of the candidate activation.
+	nDerivatives []float64
+	// Un*h[t-1] of each time step.
+	uhn []float64
+	uh  []float64
+}
+
+// NewGRU returns a GRU layer with size hidden nodes and randomized weights.
+func NewGRU(numIn, size int, act ActivationFunc, rng *rand.Rand) *GRU {
+	return &GRU{
+		recurrentCell: newRecurrentCell(numIn, size, 3, act, rng),
+		uh:            make([]float64, 3*size),
+	}
+}
+
+func (gru *GRU) ResetState() {
+	for i := range gru.state {
+		gru.state[i] = 0
+	}
+}
+
+func (gru *GRU) Forward(x []float64, mode Mode) []float64 {
+	n := gru.size
+	steps := gru.startForward(x)
+	gru.gateValues = resize(gru.gateValues, steps*3*n)
+	gru.nDerivatives = resize(gru.nDerivatives, steps*n)
+	gru.uhn = resize(gru.uhn, steps*n)
+	for t := 0; t < steps; t++ {
+		xt, hPrev, h := gru.step(t)
+		z := gru.gateValues[t*3*n : (t+1)*3*n]
+		gru.weighInput(z, xt)
+		gru.weighState(gru.uh, hPrev)
+		gz, gr, gn := z[:n], z[n:2*n], z[2*n:]
+		uhn := gru.uhn[t*n : (t+1)*n]
+		copy(uhn, gru.uh[2*n:])
+		for i := 0; i < n; i++ {
+			gz[i] = Sigmoid(gz[i] + gru.uh[i])
+			gr[i] = Sigmoid(gr[i] + gru.uh[n+i])
+			gn[i] += gr[i] * uhn[i]
+		}
+		gru.activate(gn, gru.nDerivatives[t*n:(t+1)*n], gn)
+		for i := range h {
+			h[i] = (1-gz[i])*gn[i] + gz[i]*hPrev[i]
+		}
+	}
+	return gru.endForward()
+}
+
+func (gru *GRU) Backward(dy []float64) []float64 {
+	n := gru.size
+	steps := gru.startBackward(dy)
+	for t := steps - 1; t >= 0; t-- {
+		gru.nextHiddenGradient(dy, t)
+		xt, hPrev, _ := gru.step(t)
+		z := gru.gateValues[t*3*n : (t+1)*3*n]
+		gz, gr, gn := z[:n], z[n:2*n], z[2*n:]
+		nDerivatives := gru.nDerivatives[t*n : (t+1)*n]
+		uhn := gru.uhn[t*n : (t+1)*n]
+		for i, dh := range gru.dh {
+			dn := dh * (1 - gz[i]) * nDerivatives[i]
+			dr := dn * uhn[i]
+			gru.daW[i] = dh * (hPrev[i] - gn[i]) * gz[i] * (1 - gz[i])
+			gru.daW[n+i] = dr * gr[i] * (1 - gr[i])
+			gru.daW[2*n+i] = dn
+			gru.daU[i] = gru.daW[i]
+			gru.daU[n+i] = gru.daW[n+i]
+			gru.daU[2*n+i] = dn * gr[i]
+		}
+		gru.backwardStep(xt, hPrev, gru.dx[t*gru.numIn:(t+1)*gru.numIn])
+		// The hidden state also flows directly to the next time step through the update gate.
+		for i, dh := range gru.dh {
+			gru.dhPrev[i] += dh * gz[i]
+		}
+	}
+	return gru.dx
+}
+
+// MarshalJSON encodes the layer's configuration and parameters. The activation
+// function must be registered with RegisterActivation.
+func (gru *GRU) MarshalJSON() ([]byte, error) { return gru.marshalJSON() }
+
+// UnmarshalJSON decodes a layer encoded with MarshalJSON.
+func (gru *GRU) UnmarshalJSON(b []byte) error {
+	return unmarshalRecurrent(b, func(numIn, size int, act ActivationFunc) *recurrentCell {
+		*gru = *NewGRU(numIn, size, act, rand.New(rand.NewSource(1)))
+		return &gru.recurrentCell
+	})
+}
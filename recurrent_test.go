@@ -0,0 +1,155 @@
+package neurus_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func newRecurrentLayers(rng *rand.Rand, numIn, size int) []neurus.Recurrent {
+	return []neurus.Recurrent{
+		neurus.NewRNN(numIn, size, new(neurus.Tanh), rng),
+		neurus.NewLSTM(numIn, size, new(neurus.Tanh), rng),
+		neurus.NewGRU(numIn, size, new(neurus.Tanh), rng),
+	}
+}
+
+func randomSequence(rng *rand.Rand, steps, numIn, numOut int) neurus.SequenceDataPoint {
+	var seq neurus.SequenceDataPoint
+	for t := 0; t < steps; t++ {
+		input := make([]float64, numIn)
+		for i := range input {
+			input[i] = 2*rng.Float64() - 1
+		}
+		expected := make([]float64, numOut)
+		for i := range expected {
+			expected[i] = rng.Float64()
+		}
+		seq.Inputs = append(seq.Inputs, input)
+		seq.ExpectedOutputs = append(seq.ExpectedOutputs, expected)
+	}
+	return seq
+}
+
+func sequenceSteps(seq neurus.SequenceDataPoint) []neurus.DataPoint {
+	steps := make([]neurus.DataPoint, len(seq.Inputs))
+	for t := range steps {
+		steps[t] = neurus.DataPoint{Input: seq.Inputs[t], ExpectedOutput: seq.ExpectedOutputs[t]}
+	}
+	return steps
+}
+
+func TestRecurrent_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, layer := range newRecurrentLayers(rng, 3, 4) {
+		model := neurus.NewSequential(&neurus.MeanSquaredError{},
+			layer,
+			neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+		)
+		steps := sequenceSteps(randomSequence(rng, 6, 3, 2))
+		t.Run(fmt.Sprintf("%T", layer), func(t *testing.T) {
+			checkGradients(t, model, steps)
+		})
+	}
+}
+
+func TestRecurrent_truncatedBPTT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := randomSequence(rng, 9, 2, 2)
+	for _, layer := range newRecurrentLayers(rng, 2, 3) {
+		model := neurus.NewSequential(&neurus.MeanSquaredError{},
+			layer,
+			neurus.NewLayerOptimized(3, 2, new(neurus.Sigmd), rng),
+		)
+		// The cost must not depend on the truncation since the hidden state
+		// is carried over between chunks.
+		fullCost := model.UpdateSequenceGradients(data, 0)
+		var fullGrads []float64
+		for _, p := range layer.Params() {
+			fullGrads = append(fullGrads, p.Grad...)
+		}
+		model.ApplyGradients(0, 0, 0) // Zero gradients.
+		truncatedCost := model.UpdateSequenceGradients(data, 4)
+		if math.Abs(fullCost-truncatedCost) > 1e-12 {
+			t.Errorf("%T: truncated cost %g mismatches full cost %g", layer, truncatedCost, fullCost)
+		}
+		// Truncated gradients must approximate but differ from full BPTT gradients.
+		var truncatedGrads []float64
+		for _, p := range layer.Params() {
+			truncatedGrads = append(truncatedGrads, p.Grad...)
+		}
+		differ := false
+		for i := range fullGrads {
+			differ = differ || fullGrads[i] != truncatedGrads[i]
+		}
+		if !differ {
+			t.Errorf("%T: truncated gradients equal full BPTT gradients", layer)
+		}
+		model.ApplyGradients(0, 0, 0)
+		// A truncation longer than the sequence is full BPTT.
+		model.UpdateSequenceGradients(data, 100)
+		k := 0
+		for _, p := range layer.Params() {
+			for _, g := range p.Grad {
+				if g != fullGrads[k] {
+					t.Fatalf("%T: gradient mismatch for truncation longer than sequence", layer)
+				}
+				k++
+			}
+		}
+	}
+}
+
+func TestRecurrent_learnSequences(t *testing.T) {
+	// Learn to output the input of the previous time step.
+	rng := rand.New(rand.NewSource(1))
+	var sequences []neurus.SequenceDataPoint
+	for i := 0; i < 20; i++ {
+		var seq neurus.SequenceDataPoint
+		prev := 0.0
+		for t := 0; t < 10; t++ {
+			v := float64(rng.Intn(2))
+			seq.Inputs = append(seq.Inputs, []float64{v})
+			seq.ExpectedOutputs = append(seq.ExpectedOutputs, []float64{prev})
+			prev = v
+		}
+		sequences = append(sequences, seq)
+	}
+	for _, layer := range newRecurrentLayers(rng, 1, 4) {
+		model := neurus.NewSequential(&neurus.MeanSquaredError{},
+			layer,
+			neurus.NewLayerOptimized(4, 1, new(neurus.Sigmd), rng),
+		)
+		cost := func() (cost float64) {
+			for _, seq := range sequences {
+				cost += model.UpdateSequenceGradients(seq, 0)
+			}
+			model.ApplyGradients(0, 0, 0)
+			return cost
+		}
+		startCost := cost()
+		for epoch := 0; epoch < 300; epoch++ {
+			model.LearnSequences(sequences, 5, 0.5, 0, 0.9)
+		}
+		endCost := cost()
+		if endCost > startCost/10 {
+			t.Errorf("%T: cost went from %g to %g", layer, startCost, endCost)
+		}
+	}
+}
+
+func TestRecurrent_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := randomSequence(rng, 5, 3, 2)
+	for _, layer := range newRecurrentLayers(rng, 3, 4) {
+		model := neurus.NewSequential(&neurus.MeanSquaredError{},
+			layer,
+			neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+		)
+		model.LearnSequences([]neurus.SequenceDataPoint{data}, 2, 0.5, 0, 0)
+		roundTrip(t, model, data.Inputs...)
+	}
+}
@@ -0,0 +1,324 @@
+package neurus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Sequential is a model made up of a stack of layers in which the outputs
+// of each layer are the inputs of the next. Unlike NetworkOptimized the layers
+// may be of any type implementing Layer.
+type Sequential struct {
+	layers []Layer
+	Cost   CostFunc
+	mode   Mode
+	// optimizer holds the momentum of each parameter set returned by the layers.
+	optimizer momentumSGD
+	// inputs and dy are the batch input and output gradient matrices used during training.
+	// inputs is also used to gather the rows passed to PredictBatch.
+	inputs []float64
+	dy     []float64
+	// steps holds the time steps of a sequence chunk as data points.
+	steps []DataPoint
+}
+
+// NewSequential creates a Sequential model from layers. It panics if the output
+// length of a layer mismatches the input length of the following layer.
+func NewSequential(cost CostFunc, layers ...Layer) *Sequential {
+	err := checkSequentialDims(layers)
+	if err != nil {
+		panic(err)
+	}
+	return &Sequential{layers: layers, Cost: cost}
+}
+
+func checkSequentialDims(layers []Layer) error {
+	if len(layers) == 0 {
+		return errors.New("no layers")
+	}
+	for i := 1; i < len(layers); i++ {
+		_, numOut := layers[i-1].Dims()
+		numIn, _ := layers[i].Dims()
+		if numIn != numOut {
+			return fmt.Errorf("layer %d input length %d mismatches previous layer output length %d", i, numIn, numOut)
+		}
+	}
+	return nil
+}
+
+// Layers returns the layers of the model.
+func (seq *Sequential) Layers() []Layer { return seq.layers }
+
+// Params returns the trainable parameters of every layer of the model.
+func (seq *Sequential) Params() []Param { return layerParams(seq.layers) }
+
+// Dims returns the input and output dimension of the model.
+func (seq *Sequential) Dims() (numIn, numOut int) {
+	numIn, _ = seq.layers[0].Dims()
+	_, numOut = seq.layers[len(seq.layers)-1].Dims()
+	return numIn, numOut
+}
+
+// SetMode sets the model mode used by Forward, StoreOutputs and Classify. Learn
+// always runs in ModeTrain and restores the previous mode before returning.
+func (seq *Sequential) SetMode(mode Mode) {
+	if mode != ModeInference && mode != ModeTrain {
+		panic("invalid mode")
+	}
+	seq.mode = mode
+}
+
+// Mode returns the current mode of the model.
+func (seq *Sequential) Mode() Mode { return seq.mode }
+
+// Forward passes a batch of inputs stored as a row-major matrix through
+// the model and returns the outputs of the last layer. Recurrent layers
+// process the rows as consecutive time steps, see Recurrent. The returned slice is owned
+// by the last layer and is valid until the next call to Forward.
+func (seq *Sequential) Forward(x []float64) []float64 {
+	for _, layer := range seq.layers {
+		x = layer.Forward(x, seq.mode)
+	}
+	return x
+}
+
+// StoreOutputs runs a single input through the model and returns the output values.
+func (seq *Sequential) StoreOutputs(input []float64) []float64 {
+	numIn, _ := seq.Dims()
+	if len(input) != numIn {
+		panic("length of inputs mismatches fist layer expected input length")
+	}
+	return seq.Forward(input)
+}
+
+// Classify runs the inputs through the model and returns index of output node with highest value.
+func (seq *Sequential) Classify(inputs []float64) (prediction int, outputs []float64) {
+	outputs = seq.StoreOutputs(inputs)
+	index := maxIdx(math.Inf(-1), outputs)
+	return index, outputs
+}
+
+// PredictBatch stores the outputs of the model for each row of inputs in the
+// corresponding row of outputs. The rows are passed through the model as a single
+// batch in ModeInference. Layers keep buffers between calls so unlike
+// NetworkOptimized.PredictBatch the rows are not split among goroutines. Recurrent layers process the
+// rows as consecutive time steps.
+func (seq *Sequential) PredictBatch(outputs, inputs [][]float64) {
+	numIn, numOut := seq.Dims()
+	checkBatch(outputs, inputs, numIn, numOut)
+	seq.inputs = resize(seq.inputs, len(inputs)*numIn)
+	for s, input := range inputs {
+		copy(seq.inputs[s*numIn:], input)
+	}
+	y := seq.predict(seq.inputs)
+	for s := range outputs {
+		copy(outputs[s], y[s*numOut:(s+1)*numOut])
+	}
+}
+
+// PredictMatrix is like PredictBatch for inputs and outputs stored as row-major matrices.
+func (seq *Sequential) PredictMatrix(outputs, inputs []float64) {
+	numIn, numOut := seq.Dims()
+	checkMatrix(outputs, inputs, numIn, numOut)
+	copy(outputs, seq.predict(inputs))
+}
+
+// predict passes the batch x through the model in ModeInference.
+func (seq *Sequential) predict(x []float64) []float64 {
+	prevMode := seq.mode
+	seq.mode = ModeInference
+	defer func() { seq.mode = prevMode }()
+	return seq.Forward(x)
+}
+
+// Learn performs a single gradient descent step with momentum over the training data.
+// The learning rate is averaged over the number of data points.
+func (seq *Sequential) Learn(trainingData []DataPoint, learnRate, regularization, momentum float64) {
+	prevMode := seq.mode
+	seq.mode = ModeTrain
+	defer func() { seq.mode = prevMode }()
+	seq.UpdateGradients(trainingData)
+	seq.ApplyGradients(learnRate/float64(len(trainingData)), regularization, momentum)
+}
+
+// UpdateGradients runs the data through the model as a single batch and backpropagates
+// the cost, accumulating the gradients of every layer's parameters. It returns the
+// total cost over the batch.
+func (seq *Sequential) UpdateGradients(data []DataPoint) (totalCost float64) {
+	numIn, numOut := seq.Dims()
+	seq.inputs = batchInputs(seq.inputs, data, numIn)
+	outputs := seq.Forward(seq.inputs)
+	seq.dy, totalCost = costGradients(seq.dy, seq.Cost, outputs, data, numOut)
+	dy := seq.dy
+	for i := len(seq.layers) - 1; i >= 0; i-- {
+		dy = seq.layers[i].Backward(dy)
+	}
+	return totalCost
+}
+
+// batchInputs stores the inputs of data as rows of a matrix reusing dst's capacity.
+func batchInputs(dst []float64, data []DataPoint, numIn int) []float64 {
+	dst = resize(dst, len(data)*numIn)
+	for s := range data {
+		if copy(dst[s*numIn:(s+1)*numIn], data[s].Input) != numIn || len(data[s].Input) != numIn {
+			panic("bad input length")
+		}
+	}
+	return dst
+}
+
+// costGradients stores the partial derivatives of the cost with respect to
+// the batch outputs in dy, reusing its capacity, and returns the total cost of the batch.
+func costGradients(dy []float64, cost CostFunc, outputs []float64, data []DataPoint, numOut int) (_ []float64, totalCost float64) {
+	dy = resize(dy, len(outputs))
+	for s := range data {
+		cost.CalculateFromInputs(outputs[s*numOut:(s+1)*numOut], data[s].ExpectedOutput, 1)
+		totalCost += cost.TotalCost()
+		for i := 0; i < numOut; i++ {
+			dy[s*numOut+i] = cost.Derivative(i)
+		}
+	}
+	return dy, totalCost
+}
+
+// ResetState zeroes the hidden state of every Recurrent layer of the model
+// so that the next input is processed as the start of a new sequence.
+func (seq *Sequential) ResetState() {
+	for _, layer := range seq.layers {
+		if r, ok := layer.(Recurrent); ok {
+			r.ResetState()
+		}
+	}
+}
+
+// LearnSequences performs a single gradient descent step with momentum over
+// the sequences using backpropagation through time truncated to truncation steps.
+// The learning rate is averaged over the total number of time steps.
+func (seq *Sequential) LearnSequences(sequences []SequenceDataPoint, truncation int, learnRate, regularization, momentum float64) {
+	prevMode := seq.mode
+	seq.mode = ModeTrain
+	defer func() { seq.mode = prevMode }()
+	totalSteps := 0
+	for _, s := range sequences {
+		seq.UpdateSequenceGradients(s, truncation)
+		totalSteps += len(s.Inputs)
+	}
+	seq.ApplyGradients(learnRate/float64(totalSteps), regularization, momentum)
+}
+
+// UpdateSequenceGradients resets the state of the model and runs the sequence
+// through it in chunks of truncation time steps, accumulating the gradients of
+// every layer's parameters. The hidden state is carried over between chunks
+// but gradients are not propagated across chunk boundaries. A truncation of
+// zero or less runs the whole sequence as a single chunk. It returns the total cost
+// over all time steps.
+func (seq *Sequential) UpdateSequenceGradients(data SequenceDataPoint, truncation int) (totalCost float64) {
+	if len(data.Inputs) != len(data.ExpectedOutputs) {
+		panic("sequence inputs and expected outputs length mismatch")
+	}
+	if truncation <= 0 {
+		truncation = len(data.Inputs)
+	}
+	seq.ResetState()
+	for start := 0; start < len(data.Inputs); start += truncation {
+		end := start + truncation
+		if end > len(data.Inputs) {
+			end = len(data.Inputs)
+		}
+		seq.steps = seq.steps[:0]
+		for t := start; t < end; t++ {
+			seq.steps = append(seq.steps, DataPoint{Input: data.Inputs[t], ExpectedOutput: data.ExpectedOutputs[t]})
+		}
+		totalCost += seq.UpdateGradients(seq.steps)
+	}
+	return totalCost
+}
+
+// ApplyGradients updates the parameters of every layer using gradient descent
+// with momentum and L2 regularization of parameters marked for decay.
+// Of sparse parameters only the marked rows are updated, including their
+// momentum and decay. Gradients are zeroed afterwards and the running statistics
+// of batch normalization are updated with those of the last training batch.
+func (seq *Sequential) ApplyGradients(learnRate, regularization, momentum float64) {
+	seq.optimizer.apply(layerParams(seq.layers), learnRate, regularization, momentum)
+	updateLayerRunningStats(seq.layers)
+}
+
+// layerParams returns the parameters of all layers.
+func layerParams(layers []Layer) []Param {
+	var params []Param
+	for _, layer := range layers {
+		params = append(params, layer.Params()...)
+	}
+	return params
+}
+
+// momentumSGD performs gradient descent with momentum over
+// parameters which are always passed in the same order.
+type momentumSGD struct {
+	// velocities holds the momentum of each parameter set.
+	velocities [][]float64
+}
+
+func (opt *momentumSGD) apply(params []Param, learnRate, regularization, momentum float64) {
+	weightDecay := 1 - regularization*learnRate
+	for k, param := range params {
+		if k == len(opt.velocities) {
+			opt.velocities = append(opt.velocities, make([]float64, len(param.Value)))
+		}
+		velocities := opt.velocities[k]
+		decay := 1.0
+		if param.Decay {
+			decay = weightDecay
+		}
+		update := func(start, end int) {
+			for i := start; i < end; i++ {
+				velocity := velocities[i]*momentum - param.Grad[i]*learnRate
+				velocities[i] = velocity
+				param.Value[i] = param.Value[i]*decay + velocity
+				param.Grad[i] = 0 // Zero out gradients.
+			}
+		}
+		if param.Sparse != nil {
+			rowSize := param.Sparse.RowSize
+			for _, row := range param.Sparse.Rows {
+				update(row*rowSize, (row+1)*rowSize)
+			}
+			param.Sparse.Reset()
+		} else {
+			update(0, len(param.Value))
+		}
+	}
+}
+
+// Export returns the serialized form of the model's layers. All layer types
+// must be registered with RegisterLayer.
+func (seq *Sequential) Export() ([]LayerSpec, error) {
+	specs := make([]LayerSpec, len(seq.layers))
+	for i, layer := range seq.layers {
+		spec, err := MarshalLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+	return specs, nil
+}
+
+// ImportSequential creates a Sequential model from layers serialized with Export.
+func ImportSequential(specs []LayerSpec, cost CostFunc) (*Sequential, error) {
+	layers := make([]Layer, len(specs))
+	for i, spec := range specs {
+		layer, err := UnmarshalLayer(spec)
+		if err != nil {
+			return nil, err
+		}
+		layers[i] = layer
+	}
+	err := checkSequentialDims(layers)
+	if err != nil {
+		return nil, err
+	}
+	return &Sequential{layers: layers, Cost: cost}, nil
+}
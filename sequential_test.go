@@ -0,0 +1,263 @@
+package neurus_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/neurus"
+)
+
+func TestSequential_matchesNetworkOptimized(t *testing.T) {
+	activation := func() neurus.ActivationFunc { return new(neurus.Sigmd) }
+	nn := neurus.NewNetworkOptimized([]int{2, 3, 2}, activation, &neurus.MeanSquaredError{}, rand.NewSource(1))
+	// Build the sequential model from the same parameters.
+	var layers []neurus.Layer
+	for _, setup := range nn.Export() {
+		b, _ := json.Marshal(setup)
+		layer, err := neurus.UnmarshalLayer(neurus.LayerSpec{Kind: "dense", Layer: b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, layer)
+	}
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{}, layers...)
+
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	trainData := m.Generate2DData(40)
+	for epoch := 0; epoch < 20; epoch++ {
+		batch := trainData[epoch%4*10 : epoch%4*10+10]
+		nn.Learn(batch, 0.5, 0.01, 0.9)
+		seq.Learn(batch, 0.5, 0.01, 0.9)
+	}
+	for _, dp := range trainData {
+		_, want := nn.Classify(dp.Input)
+		_, got := seq.Classify(dp.Input)
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > 1e-12 {
+				t.Fatalf("sequential output %v mismatches network output %v", got, want)
+			}
+		}
+	}
+}
+
+func TestSequential_exportImport(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(2, 8, &neurus.Relu{}, rng),
+		neurus.NewBatchNorm(8),
+		neurus.NewDropout(8, 0.2, rng),
+		neurus.NewLayerOptimized(8, 4, new(neurus.Sigmd), rng),
+		neurus.NewLayerNorm(4),
+		&scaleLayer{Scale: []float64{1, 2, 3, 4}},
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+	)
+	m := neurus.NewModel2D(2, basic2DClassifier)
+	trainData := m.Generate2DData(64)
+	for epoch := 0; epoch < 30; epoch++ {
+		seq.Learn(trainData[epoch%4*16:epoch%4*16+16], 0.1, 0, 0.9)
+	}
+	inputs := make([][]float64, len(trainData))
+	for i, dp := range trainData {
+		inputs[i] = dp.Input
+	}
+	roundTrip(t, seq, inputs...)
+}
+
+func TestSequential_gradientCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq := neurus.NewSequential(&neurus.MeanSquaredError{},
+		neurus.NewLayerOptimized(3, 5, new(neurus.Sigmd), rng),
+		neurus.NewBatchNorm(5),
+		neurus.NewLayerOptimized(5, 4, new(neurus.Sigmd), rng),
+		neurus.NewLayerNorm(4),
+		&scaleLayer{Scale: []float64{0.5, 1, 1.5, 2}},
+		neurus.NewLayerOptimized(4, 2, new(neurus.Sigmd), rng),
+	)
+	seq.SetMode(neurus.ModeTrain)
+	data := make([]neurus.DataPoint, 5)
+	for i := range data {
+		data[i] = neurus.DataPoint{
+			Input:          []float64{rng.Float64(), rng.Float64(), rng.Float64()},
+			ExpectedOutput: []float64{rng.Float64(), rng.Float64()},
+		}
+	}
+	checkGradients(t, seq, data)
+}
+
+// TestImportSequential_malformed checks layer specs of invalid dimensions are
+// rejected with an error instead of producing a model which panics when used.
+func TestImportSequential_malformed(t *testing.T) {
+	const (
+		sigmoid  = `"activation":{"kind":"sigmoid"}`
+		bn       = `{"kind":"batch","gamma":[1,1],"beta":[0,0],"epsilon":1e-5,"runningMean":[0,0],"runningVar":[1,1],"momentum":0.1}`
+		tanh     = `"activation":{"kind":"tanh"}`
+		identity = `{"weights":[[1,0],[0,1]],"biases":[0,0],"activation":{"kind":"identity"}}`
+	)
+	for _, test := range []struct {
+		name, kind, layer string
+	}{
+		{"ragged weights", "dense", `{"weights":[[1,2],[3]],"biases":[0,0],` + sigmoid + `}`},
+		{"missing bias", "dense", `{"weights":[[1,2]],"biases":[0],` + sigmoid + `}`},
+		{"no weights", "dense", `{"weights":[],"biases":[],` + sigmoid + `}`},
+		{"dropout rate", "dense", `{"weights":[[1,2]],"biases":[0,0],"dropout":1,` + sigmoid + `}`},
+		{"norm size", "dense", `{"weights":[[1,2,3]],"biases":[0,0,0],"norm":` + bn + `,` + sigmoid + `}`},
+		{"running stats", "batchnorm", `{"kind":"batch","gamma":[1,1],"beta":[0,0],"runningMean":[0],"runningVar":[1,1]}`},
+		{"norm shift", "layernorm", `{"kind":"layer","gamma":[1,1],"beta":[0]}`},
+		{"norm kind", "layernorm", `{"kind":"batch","gamma":[1],"beta":[0]}`},
+		{"conv kernel", "conv2d", `{"input":{"channels":1,"height":4,"width":4},"outChannels":1,"kernelSize":0,"stride":1,` + sigmoid + `}`},
+		{"conv channels", "conv2d", `{"input":{"channels":0,"height":4,"width":4},"outChannels":1,"kernelSize":2,"stride":1,` + sigmoid + `}`},
+		{"conv output channels", "conv2d", `{"input":{"channels":1,"height":4,"width":4},"outChannels":0,"kernelSize":2,"stride":1,` + sigmoid + `}`},
+		{"conv padding", "conv2d", `{"input":{"channels":1,"height":4,"width":4},"outChannels":1,"kernelSize":2,"stride":1,"padding":-1,` + sigmoid + `}`},
+		{"conv kernel too large", "conv2d", `{"input":{"channels":1,"height":4,"width":4},"outChannels":1,"kernelSize":5,"stride":1,` + sigmoid + `}`},
+		{"conv weights", "conv2d", `{"input":{"channels":1,"height":4,"width":4},"outChannels":1,"kernelSize":2,"stride":1,"weights":[1,2,3],"biases":[0],` + sigmoid + `}`},
+		{"max pool size", "maxpool2d", `{"input":{"channels":1,"height":4,"width":4},"size":0,"stride":2}`},
+		{"avg pool stride", "avgpool2d", `{"input":{"channels":1,"height":4,"width":4},"size":2,"stride":0}`},
+		{"pool too large", "maxpool2d", `{"input":{"channels":1,"height":4,"width":4},"size":5,"stride":1}`},
+		{"flatten shape", "flatten", `{"input":{"channels":1,"height":0,"width":4}}`},
+		{"dropout size", "dropout", `{"size":0,"rate":0.5}`},
+		{"dropout layer rate", "dropout", `{"size":2,"rate":1}`},
+		{"rnn size", "rnn", `{"numIn":2,"size":0,"inputWeights":[],"stateWeights":[],"biases":[],` + tanh + `}`},
+		{"lstm inputs", "lstm", `{"numIn":-1,"size":1,"inputWeights":[],"stateWeights":[1,1,1,1],"biases":[0,0,0,0],` + tanh + `}`},
+		{"gru weights", "gru", `{"numIn":1,"size":1,"inputWeights":[1],"stateWeights":[1],"biases":[0],` + tanh + `}`},
+		{"attention heads", "attention", `{"numHeads":3,"query":` + identity + `,"key":` + identity + `,"value":` + identity + `,"output":` + identity + `}`},
+		{"attention projection", "attention", `{"numHeads":1,"query":` + identity + `,"key":` + identity + `,"value":` + identity + `}`},
+		{"transformer layers", "transformer", `{"attention":{"numHeads":1,"query":` + identity + `,"key":` + identity + `,"value":` + identity + `,"output":` + identity + `}}`},
+		{"positional encoding size", "posencoding", `{"size":0}`},
+		{"embedding table", "embedding", `{"columns":[{"numCategories":2,"dim":2}],"numDense":0,"tables":[[1,2,3]]}`},
+		{"embedding inputs", "embedding", `{"columns":[],"numDense":0,"tables":[]}`},
+	} {
+		_, err := neurus.ImportSequential([]neurus.LayerSpec{{Kind: test.kind, Layer: json.RawMessage(test.layer)}}, &neurus.MeanSquaredError{})
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+// checkGradients compares the gradients accumulated by UpdateGradients
+// with central finite differences of the batch cost.
+func checkGradients(t *testing.T, model neurus.GradientModel, data []neurus.DataPoint) {
+	t.Helper()
+	for _, e := range neurus.GradientCheck(model, data, 1e-6) {
+		if e.AbsErr > 1e-6 && e.RelErr > 1e-5 {
+			t.Errorf("param %d[%d]: analytic %g, numeric %g", e.Param, e.Index, e.Analytic, e.Numeric)
+		}
+	}
+}
+
+// exportable is implemented by the models roundTrip can serialize.
+type exportable interface {
+	Dims() (numIn, numOut int)
+	PredictMatrix(outputs, inputs []float64)
+}
+
+// roundTrip exports model, passes the export through encoding/json and imports
+// it back. It fails the test unless the imported model predicts the same outputs
+// as model for the inputs, which are passed as a single batch after resetting the
+// state of recurrent layers. model must be a *neurus.Sequential, *neurus.Graph or
+// *neurus.NetworkOptimized. The imported model is returned.
+func roundTrip(t *testing.T, model exportable, inputs ...[]float64) exportable {
+	t.Helper()
+	decode := func(v, dst interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(b, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var imported exportable
+	switch m := model.(type) {
+	case *neurus.Sequential:
+		specs, err := m.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded []neurus.LayerSpec
+		decode(specs, &decoded)
+		imported, err = neurus.ImportSequential(decoded, &neurus.MeanSquaredError{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *neurus.Graph:
+		spec, err := m.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded neurus.GraphSpec
+		decode(spec, &decoded)
+		imported, err = neurus.ImportGraph(decoded, &neurus.MeanSquaredError{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *neurus.NetworkOptimized:
+		var decoded []neurus.LayerSetup
+		decode(m.Export(), &decoded)
+		nn := new(neurus.NetworkOptimized)
+		nn.Import(decoded, nil)
+		imported = nn
+	default:
+		t.Fatalf("cannot export %T", model)
+	}
+	if r, ok := model.(interface{ ResetState() }); ok {
+		r.ResetState()
+	}
+	numIn, numOut := model.Dims()
+	x := make([]float64, 0, len(inputs)*numIn)
+	for _, input := range inputs {
+		x = append(x, input...)
+	}
+	want := make([]float64, len(inputs)*numOut)
+	got := make([]float64, len(want))
+	model.PredictMatrix(want, x)
+	imported.PredictMatrix(got, x)
+	if !equalFloats(got, want) {
+		t.Fatalf("imported model output %v, want %v", got, want)
+	}
+	return imported
+}
+
+func init() {
+	neurus.RegisterLayer("test-scale", func() neurus.Layer { return new(scaleLayer) })
+}
+
+// scaleLayer is a custom layer which multiplies each input by a learned scale.
+type scaleLayer struct {
+	Scale []float64
+	grad  []float64
+	x     []float64
+	out   []float64
+	dx    []float64
+}
+
+func (l *scaleLayer) Dims() (int, int) { return len(l.Scale), len(l.Scale) }
+
+func (l *scaleLayer) Params() []neurus.Param {
+	if len(l.grad) != len(l.Scale) {
+		l.grad = make([]float64, len(l.Scale))
+	}
+	return []neurus.Param{{Value: l.Scale, Grad: l.grad}}
+}
+
+func (l *scaleLayer) Forward(x []float64, mode neurus.Mode) []float64 {
+	l.x = append(l.x[:0], x...)
+	l.out = append(l.out[:0], x...)
+	for i := range l.out {
+		l.out[i] *= l.Scale[i%len(l.Scale)]
+	}
+	return l.out
+}
+
+func (l *scaleLayer) Backward(dy []float64) []float64 {
+	l.Params()
+	l.dx = append(l.dx[:0], dy...)
+	for i := range dy {
+		j := i % len(l.Scale)
+		l.grad[j] += dy[i] * l.x[i]
+		l.dx[i] *= l.Scale[j]
+	}
+	return l.dx
+}